@@ -4,7 +4,7 @@ CREATE TABLE IF NOT EXISTS users (
     username VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
     email VARCHAR(255) UNIQUE NOT NULL,
-    role VARCHAR(50) NOT NULL CHECK (role IN ('seller', 'buyer')),
+    role VARCHAR(50) NOT NULL CHECK (role IN ('seller', 'buyer', 'admin')),
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
 );
@@ -27,6 +27,22 @@ CREATE TABLE IF NOT EXISTS api_services (
     -- 定价字段
     pricing_model VARCHAR(20) DEFAULT 'per_call' CHECK (pricing_model IN ('per_call', 'per_token')),
     price_per_token DECIMAL(10,6) DEFAULT 0.01,
+    -- 阶梯定价计划（JSON），为空时按上面的固定价格计费
+    pricing_plan JSONB,
+    -- 上游协议格式
+    api_format VARCHAR(20) DEFAULT 'openai' CHECK (api_format IN ('openai', 'anthropic')),
+    -- 上游池负载均衡策略
+    lb_strategy VARCHAR(30) DEFAULT 'weighted_round_robin' CHECK (lb_strategy IN ('weighted_round_robin', 'least_latency')),
+    -- 上游传输超时（毫秒），为空时使用平台默认值
+    connect_timeout_ms INTEGER CHECK (connect_timeout_ms >= 0),
+    response_header_timeout_ms INTEGER CHECK (response_header_timeout_ms >= 0),
+    idle_conn_timeout_ms INTEGER CHECK (idle_conn_timeout_ms >= 0),
+    -- 上游认证方式（JSON），为空时按协议格式和密钥格式自动检测
+    auth_scheme JSONB,
+    -- 计费策略（JSON）：可计费状态码、计费时限与 SLA 目标，为空时只有 2xx 响应计费
+    billing_policy JSONB,
+    -- 代理限流（JSON）：服务整体和每个订阅者的每分钟请求数与突发请求数，服务、订阅者、密钥和模型的每分钟 token 数，以及服务、订阅者和密钥的并发请求数，为空时不限制
+    rate_limits JSONB,
     total_calls BIGINT DEFAULT 0,
     subscriber_count INTEGER DEFAULT 0,
     features TEXT, -- JSON数组字符串
@@ -40,12 +56,18 @@ CREATE TABLE IF NOT EXISTS platform_api_keys (
     key_id SERIAL PRIMARY KEY,
     buyer_user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
     service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
-    platform_api_key VARCHAR(255) UNIQUE NOT NULL, -- The key buyer uses to access via platform
+    name VARCHAR(100) NOT NULL DEFAULT 'default', -- Key name within the subscription, e.g. ci / staging / prod
+    platform_api_key VARCHAR(255) UNIQUE, -- Legacy: AES-encrypted key awaiting backfill, NULL once key_hash is set
+    key_prefix VARCHAR(16), -- Public prefix of the key buyer uses to access via platform, used for lookup and masked display
+    key_hash CHAR(64) UNIQUE, -- Hex SHA-256 of the full key; the key itself is only shown to the buyer once
     is_active BOOLEAN DEFAULT TRUE,
-    expires_at TIMESTAMP WITH TIME ZONE, -- Optional: for keys with an expiration date
+    expires_at TIMESTAMP WITH TIME ZONE, -- Optional: for keys with an expiration date; set to the end of the grace period on rotation
+    revoked_at TIMESTAMP WITH TIME ZONE, -- Set when the buyer revokes the key
+    rotated_from_key_id INTEGER REFERENCES platform_api_keys(key_id) ON DELETE SET NULL, -- The key this one replaced on rotation
+    scopes JSONB, -- Optional access restrictions: allowed methods, path globs, models, max_tokens, source CIDRs; NULL means unrestricted
     created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
-    CONSTRAINT unique_buyer_service_subscription UNIQUE (buyer_user_id, service_id) -- Ensures one active key per buyer per service
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+    -- A subscription may hold several named keys, so there is no unique (buyer_user_id, service_id) constraint
 );
 
 -- Usage Logs Table: Records each API call made through the platform
@@ -65,10 +87,20 @@ CREATE TABLE IF NOT EXISTS usage_logs (
     input_tokens INTEGER DEFAULT 0, -- Number of input tokens used in the request
     output_tokens INTEGER DEFAULT 0, -- Number of output tokens generated in the response
     total_tokens INTEGER DEFAULT 0, -- Total tokens used (input + output)
+    cached_input_tokens INTEGER NOT NULL DEFAULT 0, -- Input tokens read from the prompt cache (included in input_tokens)
+    cache_write_tokens INTEGER NOT NULL DEFAULT 0, -- Input tokens written to the prompt cache (included in input_tokens)
+    reasoning_tokens INTEGER NOT NULL DEFAULT 0, -- Reasoning/thinking tokens (included in output_tokens)
+    audio_input_tokens INTEGER NOT NULL DEFAULT 0,
+    audio_output_tokens INTEGER NOT NULL DEFAULT 0,
+    image_input_tokens INTEGER NOT NULL DEFAULT 0,
+    image_output_tokens INTEGER NOT NULL DEFAULT 0,
     cost REAL NOT NULL DEFAULT 0,
+    cost_micros BIGINT NOT NULL DEFAULT 0, -- Exact cost in micro-USD (1 USD = 1,000,000)
+    zero_rated_reason VARCHAR(50), -- Why a call with token usage was not charged (status_not_billable, upstream_interrupted, latency_exceeded, circuit_open)
     model_name VARCHAR(100), -- AI model used (e.g., gpt-4, claude-3, etc.)
     request_size_bytes INTEGER DEFAULT 0, -- Size of request payload in bytes
-    response_size_bytes INTEGER DEFAULT 0 -- Size of response payload in bytes
+    response_size_bytes INTEGER DEFAULT 0, -- Size of response payload in bytes
+    request_id VARCHAR(64) -- Shared by all fallback attempts made for the same buyer request
 );
 
 -- Indexes for performance
@@ -78,14 +110,17 @@ CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
 CREATE INDEX IF NOT EXISTS idx_api_services_seller_user_id ON api_services(seller_user_id);
 CREATE INDEX IF NOT EXISTS idx_api_services_platform_proxy_prefix ON api_services(platform_proxy_prefix);
 
-CREATE INDEX IF NOT EXISTS idx_platform_api_keys_platform_api_key ON platform_api_keys(platform_api_key);
+CREATE INDEX IF NOT EXISTS idx_platform_api_keys_key_prefix ON platform_api_keys(key_prefix);
 CREATE INDEX IF NOT EXISTS idx_platform_api_keys_buyer_user_id ON platform_api_keys(buyer_user_id);
 CREATE INDEX IF NOT EXISTS idx_platform_api_keys_service_id ON platform_api_keys(service_id);
+CREATE INDEX IF NOT EXISTS idx_platform_api_keys_buyer_service ON platform_api_keys(buyer_user_id, service_id);
 
 CREATE INDEX IF NOT EXISTS idx_usage_logs_platform_api_key_id ON usage_logs(platform_api_key_id);
 CREATE INDEX IF NOT EXISTS idx_usage_logs_buyer_user_id ON usage_logs(buyer_user_id);
 CREATE INDEX IF NOT EXISTS idx_usage_logs_api_service_id ON usage_logs(api_service_id);
 CREATE INDEX IF NOT EXISTS idx_usage_logs_request_timestamp ON usage_logs(request_timestamp);
+CREATE INDEX IF NOT EXISTS idx_usage_logs_request_id ON usage_logs(request_id);
+CREATE INDEX IF NOT EXISTS idx_usage_logs_buyer_service_timestamp ON usage_logs(buyer_user_id, api_service_id, request_timestamp);
 
 -- Function to automatically update 'updated_at' timestamps
 CREATE OR REPLACE FUNCTION trigger_set_timestamp()
@@ -176,4 +211,399 @@ COMMENT ON COLUMN api_endpoints.parameters IS 'Array of parameter objects with n
 COMMENT ON COLUMN api_endpoints.examples IS 'Request and response examples in JSON format';
 
 COMMENT ON CONSTRAINT unique_buyer_service_subscription ON platform_api_keys IS 'Ensures that a buyer can only have one active platform API key (subscription) for a specific API service at any given time.';
-COMMENT ON COLUMN usage_logs.processing_time_ms IS 'Time in milliseconds it took for the platform to process and proxy the request, excluding network latency to/from the original seller API.';
\ No newline at end of file
+COMMENT ON COLUMN usage_logs.processing_time_ms IS 'Time in milliseconds it took for the platform to process and proxy the request, excluding network latency to/from the original seller API.';
+-- API Service Models Table: Model IDs served by each API service, used by the /v1 router
+CREATE TABLE IF NOT EXISTS api_service_models (
+    id SERIAL PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    model_id VARCHAR(255) NOT NULL, -- e.g., gpt-4o-mini, claude-3-haiku-20240307
+    context_window INTEGER DEFAULT 0 CHECK (context_window >= 0),
+    modalities TEXT[] DEFAULT ARRAY['text'],
+    input_price_per_1k DECIMAL(12,8) DEFAULT 0.0 CHECK (input_price_per_1k >= 0), -- 0 falls back to service pricing
+    output_price_per_1k DECIMAL(12,8) DEFAULT 0.0 CHECK (output_price_per_1k >= 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_service_model UNIQUE (service_id, model_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_service_models_model_id ON api_service_models(model_id);
+
+CREATE TRIGGER set_api_service_models_updated_at
+BEFORE UPDATE ON api_service_models
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Buyer Fallback Chains Table: Ordered services to try when an upstream call fails
+CREATE TABLE IF NOT EXISTS buyer_fallback_chains (
+    chain_id SERIAL PRIMARY KEY,
+    buyer_user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    model_id VARCHAR(255), -- Matches /v1 router requests for this model
+    service_id INTEGER REFERENCES api_services(service_id) ON DELETE CASCADE, -- Matches /proxy/v1/{service_id} requests
+    service_ids INTEGER[] NOT NULL, -- Ordered list of subscribed services to try
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fallback_chain_single_match CHECK ((model_id IS NULL) <> (service_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_buyer_fallback_chains_model ON buyer_fallback_chains(buyer_user_id, model_id) WHERE model_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_buyer_fallback_chains_service ON buyer_fallback_chains(buyer_user_id, service_id) WHERE service_id IS NOT NULL;
+
+CREATE TRIGGER set_buyer_fallback_chains_updated_at
+BEFORE UPDATE ON buyer_fallback_chains
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- API Service Upstreams Table: Pool of seller upstreams the proxy balances across
+CREATE TABLE IF NOT EXISTS api_service_upstreams (
+    upstream_id SERIAL PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    endpoint_url VARCHAR(2048) NOT NULL,
+    encrypted_api_key TEXT NOT NULL,
+    weight INTEGER NOT NULL DEFAULT 1 CHECK (weight > 0),
+    health_check_path VARCHAR(255), -- Probed with GET by the health checker, defaults to the endpoint URL itself
+    is_active BOOLEAN DEFAULT TRUE, -- Seller switch, inactive upstreams never receive traffic
+    is_healthy BOOLEAN DEFAULT TRUE, -- Maintained by the health checker
+    last_checked_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_service_upstreams_service_id ON api_service_upstreams(service_id);
+
+CREATE TRIGGER set_api_service_upstreams_updated_at
+BEFORE UPDATE ON api_service_upstreams
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+
+-- Buyer Wallets Table: Prepaid balances in micro-USD
+CREATE TABLE IF NOT EXISTS buyer_wallets (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    balance_micros BIGINT NOT NULL DEFAULT 0, -- Settled balance, usage settlement never debits it below zero
+    held_micros BIGINT NOT NULL DEFAULT 0 CHECK (held_micros >= 0), -- Sum of open holds, available = balance - held
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER set_buyer_wallets_updated_at
+BEFORE UPDATE ON buyer_wallets
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Wallet Top-ups Table: Credits added to buyer wallets
+CREATE TABLE IF NOT EXISTS wallet_topups (
+    topup_id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    amount_micros BIGINT NOT NULL CHECK (amount_micros > 0),
+    reference VARCHAR(255), -- External payment reference, unique per buyer so a payment is never credited twice
+    note TEXT,
+    created_by_user_id INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_topups_user_id ON wallet_topups(user_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wallet_topups_reference ON wallet_topups(user_id, reference) WHERE reference IS NOT NULL;
+
+-- Wallet Holds Table: Estimated cost reserved before each proxied call
+CREATE TABLE IF NOT EXISTS wallet_holds (
+    hold_id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    service_id INTEGER REFERENCES api_services(service_id) ON DELETE SET NULL,
+    request_id UUID,
+    amount_micros BIGINT NOT NULL CHECK (amount_micros > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'held' CHECK (status IN ('held', 'settled', 'released', 'expired')),
+    settled_micros BIGINT NOT NULL DEFAULT 0,
+    shortfall_micros BIGINT NOT NULL DEFAULT 0 CHECK (shortfall_micros >= 0), -- Actual cost the hold plus available balance could not cover, not debited
+    usage_log_id BIGINT REFERENCES usage_logs(log_id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    settled_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_holds_open ON wallet_holds(expires_at) WHERE status = 'held';
+CREATE INDEX IF NOT EXISTS idx_wallet_holds_user_id ON wallet_holds(user_id, created_at DESC);
+
+-- Ledger Accounts Table: Buyer wallets, seller earnings, platform revenue and platform cash
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    account_id BIGSERIAL PRIMARY KEY,
+    account_type VARCHAR(30) NOT NULL CHECK (account_type IN ('buyer_wallet', 'seller_earnings', 'platform_revenue', 'platform_cash')),
+    owner_user_id INTEGER REFERENCES users(user_id), -- Buyer or seller owning the account, NULL for platform accounts
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT ledger_account_owner CHECK ((owner_user_id IS NULL) = (account_type IN ('platform_revenue', 'platform_cash')))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_accounts_type_owner ON ledger_accounts(account_type, (COALESCE(owner_user_id, 0)));
+
+-- Ledger Entries Table: Immutable journal entries for billed calls, top-ups, refunds and payouts
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    entry_id BIGSERIAL PRIMARY KEY,
+    entry_type VARCHAR(30) NOT NULL CHECK (entry_type IN ('usage_charge', 'topup', 'refund', 'payout', 'opening_balance', 'sla_credit')),
+    reference_id BIGINT, -- usage_logs.log_id for usage_charge and refund, wallet_topups.topup_id for topup, payout_statements.statement_id for payout, sla_evaluations.evaluation_id for sla_credit
+    service_id INTEGER, -- Service of a usage charge, refund or SLA credit, used to itemize payout statements
+    description TEXT,
+    created_by_user_id INTEGER REFERENCES users(user_id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A billed call or top-up is journaled exactly once; refunds may be partial and repeated
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_entries_reference ON ledger_entries(entry_type, reference_id) WHERE entry_type IN ('usage_charge', 'topup');
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_type_reference ON ledger_entries(entry_type, reference_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_created_at ON ledger_entries(created_at);
+
+-- Ledger Postings Table: Signed amounts per account, summing to zero per entry
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    posting_id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES ledger_entries(entry_id),
+    account_id BIGINT NOT NULL REFERENCES ledger_accounts(account_id),
+    amount_micros BIGINT NOT NULL CHECK (amount_micros <> 0) -- Positive credits the account, negative debits it; postings of an entry sum to zero
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_entry_id ON ledger_postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account_id ON ledger_postings(account_id);
+
+-- Journal entries are append-only: corrections are made with new entries
+CREATE OR REPLACE FUNCTION ledger_reject_modification()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger records are immutable, % on % is not allowed', TG_OP, TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_entries_immutable
+BEFORE UPDATE OR DELETE ON ledger_entries
+FOR EACH ROW
+EXECUTE FUNCTION ledger_reject_modification();
+
+CREATE TRIGGER ledger_postings_immutable
+BEFORE UPDATE OR DELETE ON ledger_postings
+FOR EACH ROW
+EXECUTE FUNCTION ledger_reject_modification();
+
+-- Every entry must balance when its transaction commits
+CREATE OR REPLACE FUNCTION ledger_check_entry_balanced()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(amount_micros) FROM ledger_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_postings_balanced
+AFTER INSERT ON ledger_postings
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW
+EXECUTE FUNCTION ledger_check_entry_balanced();
+
+-- Commission Rates Table: Platform take rate set globally, per service category or per seller
+CREATE TABLE IF NOT EXISTS commission_rates (
+    rate_id SERIAL PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('global', 'category', 'seller')),
+    seller_user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+    category VARCHAR(100),
+    rate_bps INTEGER NOT NULL CHECK (rate_bps >= 0 AND rate_bps <= 10000), -- Basis points of the call cost kept by the platform
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT commission_rate_target CHECK (
+        (scope = 'global' AND seller_user_id IS NULL AND category IS NULL) OR
+        (scope = 'category' AND seller_user_id IS NULL AND category IS NOT NULL) OR
+        (scope = 'seller' AND seller_user_id IS NOT NULL AND category IS NULL)
+    )
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_commission_rates_target ON commission_rates(scope, (COALESCE(seller_user_id, 0)), (COALESCE(category, '')));
+
+CREATE TRIGGER set_commission_rates_updated_at
+BEFORE UPDATE ON commission_rates
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Payout Statements Table: Monthly seller payout statements
+CREATE TABLE IF NOT EXISTS payout_statements (
+    statement_id BIGSERIAL PRIMARY KEY,
+    seller_user_id INTEGER NOT NULL REFERENCES users(user_id),
+    period_start DATE NOT NULL, -- First day of the statement month
+    period_end DATE NOT NULL, -- First day of the following month (exclusive)
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    call_count BIGINT NOT NULL DEFAULT 0,
+    gross_micros BIGINT NOT NULL DEFAULT 0, -- Charged to buyers
+    commission_micros BIGINT NOT NULL DEFAULT 0, -- Kept by the platform, net of refunded commission
+    refunds_micros BIGINT NOT NULL DEFAULT 0, -- Refunded to buyers
+    net_payable_micros BIGINT NOT NULL DEFAULT 0, -- gross - commission - refunds
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid')),
+    payment_reference VARCHAR(255),
+    payout_entry_id BIGINT REFERENCES ledger_entries(entry_id),
+    paid_by_user_id INTEGER REFERENCES users(user_id),
+    paid_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_seller_statement_period UNIQUE (seller_user_id, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payout_statements_status ON payout_statements(status, period_start);
+
+-- Payout Statement Items Table: Statement lines itemized by service
+CREATE TABLE IF NOT EXISTS payout_statement_items (
+    statement_id BIGINT NOT NULL REFERENCES payout_statements(statement_id) ON DELETE CASCADE,
+    service_id INTEGER NOT NULL DEFAULT 0, -- 0 collects charges whose service is unknown
+    service_name VARCHAR(255) NOT NULL DEFAULT '',
+    call_count BIGINT NOT NULL DEFAULT 0,
+    gross_micros BIGINT NOT NULL DEFAULT 0,
+    commission_micros BIGINT NOT NULL DEFAULT 0,
+    refunds_micros BIGINT NOT NULL DEFAULT 0,
+    net_payable_micros BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (statement_id, service_id)
+);
+
+-- Invoices Table: Monthly buyer invoices and the credit notes that correct them
+CREATE SEQUENCE IF NOT EXISTS invoice_number_seq;
+CREATE SEQUENCE IF NOT EXISTS credit_note_number_seq;
+
+CREATE TABLE IF NOT EXISTS invoices (
+    invoice_id BIGSERIAL PRIMARY KEY,
+    invoice_type VARCHAR(20) NOT NULL DEFAULT 'invoice' CHECK (invoice_type IN ('invoice', 'credit_note')),
+    invoice_number VARCHAR(32) UNIQUE, -- Assigned when the document is issued, e.g. INV-2026-000001 or CN-2026-000001
+    buyer_user_id INTEGER NOT NULL REFERENCES users(user_id),
+    original_invoice_id BIGINT REFERENCES invoices(invoice_id), -- Invoice corrected by a credit note
+    period_start DATE NOT NULL, -- First day of the billed month
+    period_end DATE NOT NULL, -- First day of the following month (exclusive)
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    issuer_name VARCHAR(255) NOT NULL DEFAULT '',
+    issuer_tax_id VARCHAR(100) NOT NULL DEFAULT '',
+    buyer_name VARCHAR(255) NOT NULL DEFAULT '', -- Snapshot of the buyer at generation time
+    buyer_email VARCHAR(255) NOT NULL DEFAULT '',
+    subtotal_micros BIGINT NOT NULL DEFAULT 0, -- Sum of the line amounts
+    discount_micros BIGINT NOT NULL DEFAULT 0 CHECK (discount_micros >= 0),
+    tax_rate_bps INTEGER NOT NULL DEFAULT 0 CHECK (tax_rate_bps >= 0 AND tax_rate_bps <= 10000),
+    tax_micros BIGINT NOT NULL DEFAULT 0, -- (subtotal - discount) * tax rate
+    total_micros BIGINT NOT NULL DEFAULT 0, -- subtotal - discount + tax
+    status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'issued', 'paid', 'void')),
+    notes TEXT NOT NULL DEFAULT '',
+    payment_reference VARCHAR(255),
+    issued_at TIMESTAMP WITH TIME ZONE,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    voided_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT credit_note_has_original CHECK ((invoice_type = 'credit_note') = (original_invoice_id IS NOT NULL))
+);
+
+-- One live invoice per buyer and month; a voided draft can be generated again
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_buyer_period ON invoices(buyer_user_id, period_start)
+WHERE invoice_type = 'invoice' AND status <> 'void';
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status, period_start);
+CREATE INDEX IF NOT EXISTS idx_invoices_original_invoice_id ON invoices(original_invoice_id);
+
+CREATE TRIGGER set_invoices_updated_at
+BEFORE UPDATE ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Invoice Items Table: Invoice lines itemized by service and model
+CREATE TABLE IF NOT EXISTS invoice_items (
+    invoice_id BIGINT NOT NULL REFERENCES invoices(invoice_id) ON DELETE CASCADE,
+    line_no INTEGER NOT NULL,
+    service_id INTEGER NOT NULL DEFAULT 0, -- 0 for credit note lines and charges whose service is unknown
+    service_name VARCHAR(255) NOT NULL DEFAULT '',
+    model_name VARCHAR(100) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    call_count BIGINT NOT NULL DEFAULT 0,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    charged_micros BIGINT NOT NULL DEFAULT 0, -- Charged to the buyer in the period
+    refunded_micros BIGINT NOT NULL DEFAULT 0, -- Refunded to the buyer in the period
+    amount_micros BIGINT NOT NULL DEFAULT 0, -- charged - refunded
+    PRIMARY KEY (invoice_id, line_no)
+);
+
+-- Issued documents are immutable: only issued -> paid may change, corrections are made with credit notes
+CREATE OR REPLACE FUNCTION invoice_guard_modification()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF OLD.status = 'draft' THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' AND OLD.status = 'issued' AND NEW.status = 'paid'
+        AND (NEW.invoice_number, NEW.buyer_user_id, NEW.period_start, NEW.subtotal_micros, NEW.discount_micros,
+             NEW.tax_rate_bps, NEW.tax_micros, NEW.total_micros, NEW.notes, NEW.issued_at)
+            IS NOT DISTINCT FROM
+            (OLD.invoice_number, OLD.buyer_user_id, OLD.period_start, OLD.subtotal_micros, OLD.discount_micros,
+             OLD.tax_rate_bps, OLD.tax_micros, OLD.total_micros, OLD.notes, OLD.issued_at) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'invoice % is %, % is not allowed', OLD.invoice_id, OLD.status, TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER invoices_immutable
+BEFORE UPDATE OR DELETE ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION invoice_guard_modification();
+
+CREATE OR REPLACE FUNCTION invoice_items_guard_modification()
+RETURNS TRIGGER AS $$
+DECLARE
+    invoice_status VARCHAR(20);
+BEGIN
+    SELECT status INTO invoice_status FROM invoices
+    WHERE invoice_id = CASE WHEN TG_OP = 'DELETE' THEN OLD.invoice_id ELSE NEW.invoice_id END;
+    IF invoice_status IS NOT NULL AND invoice_status <> 'draft' THEN
+        RAISE EXCEPTION 'items of % invoice cannot be changed', invoice_status;
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER invoice_items_immutable
+BEFORE INSERT OR UPDATE OR DELETE ON invoice_items
+FOR EACH ROW
+EXECUTE FUNCTION invoice_items_guard_modification();
+
+-- SLA Evaluations Table: Monthly uptime and latency measured against each service's declared SLA, with the credits issued on breach
+CREATE TABLE IF NOT EXISTS sla_evaluations (
+    evaluation_id BIGSERIAL PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    seller_user_id INTEGER NOT NULL REFERENCES users(user_id),
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL, -- Exclusive
+    total_calls BIGINT NOT NULL DEFAULT 0,
+    failed_calls BIGINT NOT NULL DEFAULT 0, -- Calls answered with 5xx, timed out or unreachable upstream
+    uptime_bps INTEGER NOT NULL, -- Measured uptime in basis points
+    latency_p95_ms INTEGER NOT NULL DEFAULT 0, -- 95th percentile processing time of successful calls
+    uptime_target_bps INTEGER NOT NULL,
+    latency_p95_target_ms INTEGER NOT NULL DEFAULT 0,
+    credit_bps INTEGER NOT NULL, -- Share of the buyer's charges on the service credited back on breach
+    breached BOOLEAN NOT NULL DEFAULT FALSE,
+    credited_buyers INTEGER NOT NULL DEFAULT 0,
+    credited_micros BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (service_id, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sla_evaluations_seller ON sla_evaluations(seller_user_id, period_start DESC);
+
+-- Buyer Token Budgets Table: Tokens-per-minute budgets a buyer sets on a subscribed service
+CREATE TABLE IF NOT EXISTS buyer_token_budgets (
+    buyer_user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    token_budget JSONB NOT NULL, -- tokens_per_minute and model_tokens_per_minute shared by all of the buyer's keys on the service
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (buyer_user_id, service_id)
+);
+
+CREATE TRIGGER set_buyer_token_budgets_updated_at
+BEFORE UPDATE ON buyer_token_budgets
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
\ No newline at end of file
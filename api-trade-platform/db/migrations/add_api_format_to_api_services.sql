@@ -0,0 +1,12 @@
+-- Migration: Add api_format to api_services table
+-- Date: 2026-10-17
+-- Description: Declare the upstream protocol of each service so the proxy can translate OpenAI Chat Completions requests for Anthropic Messages upstreams
+
+ALTER TABLE api_services
+ADD COLUMN IF NOT EXISTS api_format VARCHAR(20) DEFAULT 'openai' CHECK (api_format IN ('openai', 'anthropic'));
+
+UPDATE api_services
+SET api_format = 'openai'
+WHERE api_format IS NULL;
+
+COMMENT ON COLUMN api_services.api_format IS 'Upstream API protocol: openai or anthropic';
@@ -0,0 +1,15 @@
+-- Migration: Add api_service_models table
+-- Date: 2026-10-17
+-- Description: Record which model IDs each API service serves so the /v1 router can pick a service by the request's model field
+
+CREATE TABLE IF NOT EXISTS api_service_models (
+    id SERIAL PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    model_id VARCHAR(255) NOT NULL, -- e.g., gpt-4o-mini, claude-3-haiku-20240307
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_service_model UNIQUE (service_id, model_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_service_models_model_id ON api_service_models(model_id);
+
+COMMENT ON TABLE api_service_models IS 'Model IDs served by each API service, used by the OpenAI-compatible /v1 router';
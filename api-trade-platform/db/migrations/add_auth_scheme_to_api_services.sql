@@ -0,0 +1,12 @@
+-- Migration: Add configurable upstream auth schemes to API services
+-- Date: 2026-10-17
+-- Description: Store how the proxy attaches the seller key (header template, query parameter, basic auth, HMAC signing, extra headers); NULL keeps the legacy auto-detection
+
+BEGIN;
+
+ALTER TABLE api_services
+ADD COLUMN IF NOT EXISTS auth_scheme JSONB;
+
+COMMENT ON COLUMN api_services.auth_scheme IS 'Upstream auth scheme, e.g. {"type":"header","header_name":"api-key","template":"{key}"}; NULL uses auto-detection by api_format and key prefix';
+
+COMMIT;
@@ -0,0 +1,39 @@
+-- Migration: Add service billing policies and SLA credits
+-- Date: 2026-10-17
+-- Description: Sellers declare which response status codes are billable, a billing latency limit and a monthly uptime/latency SLA; zero-rated calls record the reason, and monthly SLA evaluations credit buyers automatically when a service misses its target
+
+BEGIN;
+
+ALTER TABLE api_services ADD COLUMN IF NOT EXISTS billing_policy JSONB;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS zero_rated_reason VARCHAR(50);
+
+COMMENT ON COLUMN api_services.billing_policy IS 'Billable status codes, billing latency limit and SLA targets; NULL bills 2xx responses only';
+COMMENT ON COLUMN usage_logs.zero_rated_reason IS 'Why a call with token usage was not charged';
+
+ALTER TABLE ledger_entries DROP CONSTRAINT IF EXISTS ledger_entries_entry_type_check;
+ALTER TABLE ledger_entries ADD CONSTRAINT ledger_entries_entry_type_check
+    CHECK (entry_type IN ('usage_charge', 'topup', 'refund', 'payout', 'opening_balance', 'sla_credit'));
+
+CREATE TABLE IF NOT EXISTS sla_evaluations (
+    evaluation_id BIGSERIAL PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    seller_user_id INTEGER NOT NULL REFERENCES users(user_id),
+    period_start TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end TIMESTAMP WITH TIME ZONE NOT NULL, -- Exclusive
+    total_calls BIGINT NOT NULL DEFAULT 0,
+    failed_calls BIGINT NOT NULL DEFAULT 0, -- Calls answered with 5xx, timed out or unreachable upstream
+    uptime_bps INTEGER NOT NULL, -- Measured uptime in basis points
+    latency_p95_ms INTEGER NOT NULL DEFAULT 0, -- 95th percentile processing time of successful calls
+    uptime_target_bps INTEGER NOT NULL,
+    latency_p95_target_ms INTEGER NOT NULL DEFAULT 0,
+    credit_bps INTEGER NOT NULL, -- Share of the buyer's charges on the service credited back on breach
+    breached BOOLEAN NOT NULL DEFAULT FALSE,
+    credited_buyers INTEGER NOT NULL DEFAULT 0,
+    credited_micros BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (service_id, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_sla_evaluations_seller ON sla_evaluations(seller_user_id, period_start DESC);
+
+COMMIT;
@@ -0,0 +1,57 @@
+-- Migration: Add prepaid buyer wallets
+-- Date: 2026-10-17
+-- Description: Buyer wallet balances in micro-USD, top-up records credited by admins, and per-request holds reserved before proxying and settled with the actual cost
+
+BEGIN;
+
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users ADD CONSTRAINT users_role_check CHECK (role IN ('seller', 'buyer', 'admin'));
+
+CREATE TABLE IF NOT EXISTS buyer_wallets (
+    user_id INTEGER PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE,
+    balance_micros BIGINT NOT NULL DEFAULT 0, -- Settled balance, may dip below zero when a call costs more than its hold
+    held_micros BIGINT NOT NULL DEFAULT 0 CHECK (held_micros >= 0), -- Sum of open holds, available = balance - held
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER set_buyer_wallets_updated_at
+BEFORE UPDATE ON buyer_wallets
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+CREATE TABLE IF NOT EXISTS wallet_topups (
+    topup_id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    amount_micros BIGINT NOT NULL CHECK (amount_micros > 0),
+    reference VARCHAR(255), -- External payment reference, unique per buyer so a payment is never credited twice
+    note TEXT,
+    created_by_user_id INTEGER REFERENCES users(user_id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_topups_user_id ON wallet_topups(user_id, created_at DESC);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_wallet_topups_reference ON wallet_topups(user_id, reference) WHERE reference IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS wallet_holds (
+    hold_id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    service_id INTEGER REFERENCES api_services(service_id) ON DELETE SET NULL,
+    request_id UUID,
+    amount_micros BIGINT NOT NULL CHECK (amount_micros > 0),
+    status VARCHAR(20) NOT NULL DEFAULT 'held' CHECK (status IN ('held', 'settled', 'released', 'expired')),
+    settled_micros BIGINT NOT NULL DEFAULT 0,
+    usage_log_id BIGINT REFERENCES usage_logs(log_id) ON DELETE SET NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    settled_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_wallet_holds_open ON wallet_holds(expires_at) WHERE status = 'held';
+CREATE INDEX IF NOT EXISTS idx_wallet_holds_user_id ON wallet_holds(user_id, created_at DESC);
+
+COMMENT ON TABLE buyer_wallets IS 'Prepaid buyer balance; the proxy rejects calls with 402 when balance - held cannot cover the estimated cost';
+COMMENT ON TABLE wallet_holds IS 'Estimated cost reserved before a proxied call; settled with the actual usage_logs.cost, released on failure, expired by a background job';
+
+COMMIT;
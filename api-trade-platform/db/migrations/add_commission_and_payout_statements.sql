@@ -0,0 +1,70 @@
+-- Migration: Add platform commission rates and seller payout statements
+-- Date: 2026-10-17
+-- Description: Platform take rate set globally, per category or per seller; monthly seller payout statements itemized by service, built from the ledger and marked paid by admins
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS commission_rates (
+    rate_id SERIAL PRIMARY KEY,
+    scope VARCHAR(20) NOT NULL CHECK (scope IN ('global', 'category', 'seller')),
+    seller_user_id INTEGER REFERENCES users(user_id) ON DELETE CASCADE,
+    category VARCHAR(100),
+    rate_bps INTEGER NOT NULL CHECK (rate_bps >= 0 AND rate_bps <= 10000), -- Basis points of the call cost kept by the platform
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT commission_rate_target CHECK (
+        (scope = 'global' AND seller_user_id IS NULL AND category IS NULL) OR
+        (scope = 'category' AND seller_user_id IS NULL AND category IS NOT NULL) OR
+        (scope = 'seller' AND seller_user_id IS NOT NULL AND category IS NULL)
+    )
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_commission_rates_target ON commission_rates(scope, (COALESCE(seller_user_id, 0)), (COALESCE(category, '')));
+
+CREATE TRIGGER set_commission_rates_updated_at
+BEFORE UPDATE ON commission_rates
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+-- Service of a usage charge or refund, used to itemize payout statements
+ALTER TABLE ledger_entries
+ADD COLUMN IF NOT EXISTS service_id INTEGER;
+
+CREATE TABLE IF NOT EXISTS payout_statements (
+    statement_id BIGSERIAL PRIMARY KEY,
+    seller_user_id INTEGER NOT NULL REFERENCES users(user_id),
+    period_start DATE NOT NULL, -- First day of the statement month
+    period_end DATE NOT NULL, -- First day of the following month (exclusive)
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    call_count BIGINT NOT NULL DEFAULT 0,
+    gross_micros BIGINT NOT NULL DEFAULT 0, -- Charged to buyers
+    commission_micros BIGINT NOT NULL DEFAULT 0, -- Kept by the platform, net of refunded commission
+    refunds_micros BIGINT NOT NULL DEFAULT 0, -- Refunded to buyers
+    net_payable_micros BIGINT NOT NULL DEFAULT 0, -- gross - commission - refunds
+    status VARCHAR(20) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'paid')),
+    payment_reference VARCHAR(255),
+    payout_entry_id BIGINT REFERENCES ledger_entries(entry_id),
+    paid_by_user_id INTEGER REFERENCES users(user_id),
+    paid_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT unique_seller_statement_period UNIQUE (seller_user_id, period_start)
+);
+
+CREATE INDEX IF NOT EXISTS idx_payout_statements_status ON payout_statements(status, period_start);
+
+CREATE TABLE IF NOT EXISTS payout_statement_items (
+    statement_id BIGINT NOT NULL REFERENCES payout_statements(statement_id) ON DELETE CASCADE,
+    service_id INTEGER NOT NULL DEFAULT 0, -- 0 collects charges whose service is unknown
+    service_name VARCHAR(255) NOT NULL DEFAULT '',
+    call_count BIGINT NOT NULL DEFAULT 0,
+    gross_micros BIGINT NOT NULL DEFAULT 0,
+    commission_micros BIGINT NOT NULL DEFAULT 0,
+    refunds_micros BIGINT NOT NULL DEFAULT 0,
+    net_payable_micros BIGINT NOT NULL DEFAULT 0,
+    PRIMARY KEY (statement_id, service_id)
+);
+
+COMMENT ON TABLE commission_rates IS 'Platform take rate; the most specific match wins: seller, then service category, then global, then PLATFORM_COMMISSION_RATE_BPS';
+COMMENT ON TABLE payout_statements IS 'Monthly seller payout statements computed from usage_charge and refund ledger entries created in the period';
+
+COMMIT;
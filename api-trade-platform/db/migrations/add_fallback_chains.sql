@@ -0,0 +1,36 @@
+-- Migration: Add buyer fallback chains and usage log request ids
+-- Date: 2026-10-17
+-- Description: Let buyers define ordered fallback services per model or service, and link every proxy attempt of one buyer request through usage_logs.request_id
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS buyer_fallback_chains (
+    chain_id SERIAL PRIMARY KEY,
+    buyer_user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    model_id VARCHAR(255), -- Matches /v1 router requests for this model
+    service_id INTEGER REFERENCES api_services(service_id) ON DELETE CASCADE, -- Matches /proxy/v1/{service_id} requests
+    service_ids INTEGER[] NOT NULL, -- Ordered list of subscribed services to try
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fallback_chain_single_match CHECK ((model_id IS NULL) <> (service_id IS NULL))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_buyer_fallback_chains_model ON buyer_fallback_chains(buyer_user_id, model_id) WHERE model_id IS NOT NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_buyer_fallback_chains_service ON buyer_fallback_chains(buyer_user_id, service_id) WHERE service_id IS NOT NULL;
+
+CREATE TRIGGER set_buyer_fallback_chains_updated_at
+BEFORE UPDATE ON buyer_fallback_chains
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+ALTER TABLE usage_logs
+ADD COLUMN IF NOT EXISTS request_id VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_usage_logs_request_id ON usage_logs(request_id);
+
+COMMENT ON TABLE buyer_fallback_chains IS 'Ordered fallback services a buyer wants tried when the upstream fails with a retryable status or connection error';
+COMMENT ON COLUMN usage_logs.request_id IS 'Shared by all proxy attempts made for the same buyer request';
+
+COMMIT;
@@ -0,0 +1,116 @@
+-- Migration: Add buyer invoices and credit notes
+-- Date: 2026-10-17
+-- Description: Monthly buyer invoices frozen from ledger charges and refunds, itemized by service and model, with discount, tax and draft/issued/paid/void status; issued invoices are corrected with credit notes
+
+BEGIN;
+
+CREATE SEQUENCE IF NOT EXISTS invoice_number_seq;
+CREATE SEQUENCE IF NOT EXISTS credit_note_number_seq;
+
+CREATE TABLE IF NOT EXISTS invoices (
+    invoice_id BIGSERIAL PRIMARY KEY,
+    invoice_type VARCHAR(20) NOT NULL DEFAULT 'invoice' CHECK (invoice_type IN ('invoice', 'credit_note')),
+    invoice_number VARCHAR(32) UNIQUE, -- Assigned when the document is issued, e.g. INV-2026-000001 or CN-2026-000001
+    buyer_user_id INTEGER NOT NULL REFERENCES users(user_id),
+    original_invoice_id BIGINT REFERENCES invoices(invoice_id), -- Invoice corrected by a credit note
+    period_start DATE NOT NULL, -- First day of the billed month
+    period_end DATE NOT NULL, -- First day of the following month (exclusive)
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    issuer_name VARCHAR(255) NOT NULL DEFAULT '',
+    issuer_tax_id VARCHAR(100) NOT NULL DEFAULT '',
+    buyer_name VARCHAR(255) NOT NULL DEFAULT '', -- Snapshot of the buyer at generation time
+    buyer_email VARCHAR(255) NOT NULL DEFAULT '',
+    subtotal_micros BIGINT NOT NULL DEFAULT 0, -- Sum of the line amounts
+    discount_micros BIGINT NOT NULL DEFAULT 0 CHECK (discount_micros >= 0),
+    tax_rate_bps INTEGER NOT NULL DEFAULT 0 CHECK (tax_rate_bps >= 0 AND tax_rate_bps <= 10000),
+    tax_micros BIGINT NOT NULL DEFAULT 0, -- (subtotal - discount) * tax rate
+    total_micros BIGINT NOT NULL DEFAULT 0, -- subtotal - discount + tax
+    status VARCHAR(20) NOT NULL DEFAULT 'draft' CHECK (status IN ('draft', 'issued', 'paid', 'void')),
+    notes TEXT NOT NULL DEFAULT '',
+    payment_reference VARCHAR(255),
+    issued_at TIMESTAMP WITH TIME ZONE,
+    paid_at TIMESTAMP WITH TIME ZONE,
+    voided_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT credit_note_has_original CHECK ((invoice_type = 'credit_note') = (original_invoice_id IS NOT NULL))
+);
+
+-- One live invoice per buyer and month; a voided draft can be generated again
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invoices_buyer_period ON invoices(buyer_user_id, period_start)
+WHERE invoice_type = 'invoice' AND status <> 'void';
+CREATE INDEX IF NOT EXISTS idx_invoices_status ON invoices(status, period_start);
+CREATE INDEX IF NOT EXISTS idx_invoices_original_invoice_id ON invoices(original_invoice_id);
+
+CREATE TRIGGER set_invoices_updated_at
+BEFORE UPDATE ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+CREATE TABLE IF NOT EXISTS invoice_items (
+    invoice_id BIGINT NOT NULL REFERENCES invoices(invoice_id) ON DELETE CASCADE,
+    line_no INTEGER NOT NULL,
+    service_id INTEGER NOT NULL DEFAULT 0, -- 0 for credit note lines and charges whose service is unknown
+    service_name VARCHAR(255) NOT NULL DEFAULT '',
+    model_name VARCHAR(100) NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    call_count BIGINT NOT NULL DEFAULT 0,
+    total_tokens BIGINT NOT NULL DEFAULT 0,
+    charged_micros BIGINT NOT NULL DEFAULT 0, -- Charged to the buyer in the period
+    refunded_micros BIGINT NOT NULL DEFAULT 0, -- Refunded to the buyer in the period
+    amount_micros BIGINT NOT NULL DEFAULT 0, -- charged - refunded
+    PRIMARY KEY (invoice_id, line_no)
+);
+
+-- Issued documents are immutable: only issued -> paid may change, corrections are made with credit notes
+CREATE OR REPLACE FUNCTION invoice_guard_modification()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF OLD.status = 'draft' THEN
+        IF TG_OP = 'DELETE' THEN
+            RETURN OLD;
+        END IF;
+        RETURN NEW;
+    END IF;
+    IF TG_OP = 'UPDATE' AND OLD.status = 'issued' AND NEW.status = 'paid'
+        AND (NEW.invoice_number, NEW.buyer_user_id, NEW.period_start, NEW.subtotal_micros, NEW.discount_micros,
+             NEW.tax_rate_bps, NEW.tax_micros, NEW.total_micros, NEW.notes, NEW.issued_at)
+            IS NOT DISTINCT FROM
+            (OLD.invoice_number, OLD.buyer_user_id, OLD.period_start, OLD.subtotal_micros, OLD.discount_micros,
+             OLD.tax_rate_bps, OLD.tax_micros, OLD.total_micros, OLD.notes, OLD.issued_at) THEN
+        RETURN NEW;
+    END IF;
+    RAISE EXCEPTION 'invoice % is %, % is not allowed', OLD.invoice_id, OLD.status, TG_OP;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER invoices_immutable
+BEFORE UPDATE OR DELETE ON invoices
+FOR EACH ROW
+EXECUTE FUNCTION invoice_guard_modification();
+
+CREATE OR REPLACE FUNCTION invoice_items_guard_modification()
+RETURNS TRIGGER AS $$
+DECLARE
+    invoice_status VARCHAR(20);
+BEGIN
+    SELECT status INTO invoice_status FROM invoices
+    WHERE invoice_id = CASE WHEN TG_OP = 'DELETE' THEN OLD.invoice_id ELSE NEW.invoice_id END;
+    IF invoice_status IS NOT NULL AND invoice_status <> 'draft' THEN
+        RAISE EXCEPTION 'items of % invoice cannot be changed', invoice_status;
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER invoice_items_immutable
+BEFORE INSERT OR UPDATE OR DELETE ON invoice_items
+FOR EACH ROW
+EXECUTE FUNCTION invoice_items_guard_modification();
+
+COMMENT ON TABLE invoices IS 'Buyer invoices and credit notes; amounts are micro-USD and frozen once issued';
+
+COMMIT;
\ No newline at end of file
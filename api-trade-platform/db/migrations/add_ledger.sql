@@ -0,0 +1,112 @@
+-- Migration: Add double-entry ledger
+-- Date: 2026-10-17
+-- Description: Exact micro-USD call costs on usage_logs, ledger accounts for buyer wallets, seller earnings, platform revenue and platform cash, and immutable balanced journal entries for billed calls, top-ups, refunds and payouts
+
+BEGIN;
+
+ALTER TABLE usage_logs
+ADD COLUMN IF NOT EXISTS cost_micros BIGINT NOT NULL DEFAULT 0;
+
+UPDATE usage_logs SET cost_micros = ROUND(cost::numeric * 1000000) WHERE cost_micros = 0 AND cost > 0;
+
+COMMENT ON COLUMN usage_logs.cost_micros IS 'Exact cost of the call in micro-USD (1 USD = 1,000,000); cost is kept as a REAL approximation for existing reports';
+
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    account_id BIGSERIAL PRIMARY KEY,
+    account_type VARCHAR(30) NOT NULL CHECK (account_type IN ('buyer_wallet', 'seller_earnings', 'platform_revenue', 'platform_cash')),
+    owner_user_id INTEGER REFERENCES users(user_id), -- Buyer or seller owning the account, NULL for platform accounts
+    currency CHAR(3) NOT NULL DEFAULT 'USD',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT ledger_account_owner CHECK ((owner_user_id IS NULL) = (account_type IN ('platform_revenue', 'platform_cash')))
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_accounts_type_owner ON ledger_accounts(account_type, (COALESCE(owner_user_id, 0)));
+
+CREATE TABLE IF NOT EXISTS ledger_entries (
+    entry_id BIGSERIAL PRIMARY KEY,
+    entry_type VARCHAR(30) NOT NULL CHECK (entry_type IN ('usage_charge', 'topup', 'refund', 'payout', 'opening_balance')),
+    reference_id BIGINT, -- usage_logs.log_id for usage_charge and refund, wallet_topups.topup_id for topup
+    description TEXT,
+    created_by_user_id INTEGER REFERENCES users(user_id),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- A billed call or top-up is journaled exactly once; refunds may be partial and repeated
+CREATE UNIQUE INDEX IF NOT EXISTS idx_ledger_entries_reference ON ledger_entries(entry_type, reference_id) WHERE entry_type IN ('usage_charge', 'topup');
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_type_reference ON ledger_entries(entry_type, reference_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_entries_created_at ON ledger_entries(created_at);
+
+CREATE TABLE IF NOT EXISTS ledger_postings (
+    posting_id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES ledger_entries(entry_id),
+    account_id BIGINT NOT NULL REFERENCES ledger_accounts(account_id),
+    amount_micros BIGINT NOT NULL CHECK (amount_micros <> 0) -- Positive credits the account, negative debits it; postings of an entry sum to zero
+);
+
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_entry_id ON ledger_postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_ledger_postings_account_id ON ledger_postings(account_id);
+
+-- Journal entries are append-only: corrections are made with new entries
+CREATE OR REPLACE FUNCTION ledger_reject_modification()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'ledger records are immutable, % on % is not allowed', TG_OP, TG_TABLE_NAME;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_entries_immutable
+BEFORE UPDATE OR DELETE ON ledger_entries
+FOR EACH ROW
+EXECUTE FUNCTION ledger_reject_modification();
+
+CREATE TRIGGER ledger_postings_immutable
+BEFORE UPDATE OR DELETE ON ledger_postings
+FOR EACH ROW
+EXECUTE FUNCTION ledger_reject_modification();
+
+-- Every entry must balance when its transaction commits
+CREATE OR REPLACE FUNCTION ledger_check_entry_balanced()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF (SELECT SUM(amount_micros) FROM ledger_postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'ledger entry % is not balanced', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER ledger_postings_balanced
+AFTER INSERT ON ledger_postings
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW
+EXECUTE FUNCTION ledger_check_entry_balanced();
+
+-- Carry wallet balances that existed before the ledger as opening balances funded from platform cash
+DO $$
+DECLARE
+    wallet RECORD;
+    cash_account_id BIGINT;
+    wallet_account_id BIGINT;
+    new_entry_id BIGINT;
+BEGIN
+    INSERT INTO ledger_accounts (account_type) VALUES ('platform_cash')
+    ON CONFLICT (account_type, (COALESCE(owner_user_id, 0))) DO NOTHING;
+    SELECT account_id INTO cash_account_id FROM ledger_accounts WHERE account_type = 'platform_cash';
+
+    FOR wallet IN SELECT user_id, balance_micros FROM buyer_wallets WHERE balance_micros <> 0 LOOP
+        INSERT INTO ledger_accounts (account_type, owner_user_id) VALUES ('buyer_wallet', wallet.user_id)
+        ON CONFLICT (account_type, (COALESCE(owner_user_id, 0))) DO NOTHING;
+        SELECT account_id INTO wallet_account_id FROM ledger_accounts WHERE account_type = 'buyer_wallet' AND owner_user_id = wallet.user_id;
+
+        INSERT INTO ledger_entries (entry_type, description) VALUES ('opening_balance', 'Wallet balance before the ledger was introduced')
+        RETURNING entry_id INTO new_entry_id;
+        INSERT INTO ledger_postings (entry_id, account_id, amount_micros) VALUES
+            (new_entry_id, wallet_account_id, wallet.balance_micros),
+            (new_entry_id, cash_account_id, -wallet.balance_micros);
+    END LOOP;
+END $$;
+
+COMMENT ON TABLE ledger_accounts IS 'Ledger accounts; balance = SUM(ledger_postings.amount_micros), positive means the platform owes the owner';
+COMMENT ON TABLE ledger_entries IS 'Immutable journal entries, one per billed call, top-up, refund or payout';
+
+COMMIT;
@@ -0,0 +1,20 @@
+-- Migration: Allow multiple named platform API keys per subscription
+-- Date: 2026-10-17
+-- Description: Drop the one-key-per-subscription constraint and add key names, revocation and rotation tracking
+
+BEGIN;
+
+ALTER TABLE platform_api_keys DROP CONSTRAINT IF EXISTS unique_buyer_service_subscription;
+
+ALTER TABLE platform_api_keys
+ADD COLUMN IF NOT EXISTS name VARCHAR(100) NOT NULL DEFAULT 'default',
+ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMP WITH TIME ZONE,
+ADD COLUMN IF NOT EXISTS rotated_from_key_id INTEGER REFERENCES platform_api_keys(key_id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_platform_api_keys_buyer_service ON platform_api_keys(buyer_user_id, service_id);
+
+COMMENT ON COLUMN platform_api_keys.name IS 'Key name within the subscription, e.g. ci, staging, prod';
+COMMENT ON COLUMN platform_api_keys.revoked_at IS 'Set when the buyer revokes the key';
+COMMENT ON COLUMN platform_api_keys.rotated_from_key_id IS 'The key this one replaced; the old key stays valid until its expires_at grace deadline';
+
+COMMIT;
@@ -0,0 +1,14 @@
+-- Migration: Add tiered pricing plans to api_services
+-- Date: 2026-10-17
+-- Description: Optional JSON pricing plan (graduated or volume tiers, separate input/output token prices, monthly included quota) evaluated against the buyer's month-to-date usage; services without a plan keep their flat per_call/per_token price
+
+BEGIN;
+
+ALTER TABLE api_services ADD COLUMN IF NOT EXISTS pricing_plan JSONB;
+
+COMMENT ON COLUMN api_services.pricing_plan IS 'Tiered pricing plan; NULL means the flat pricing_model/price_per_call/price_per_token price applies';
+
+-- Month-to-date usage lookups for tier evaluation
+CREATE INDEX IF NOT EXISTS idx_usage_logs_buyer_service_timestamp ON usage_logs(buyer_user_id, api_service_id, request_timestamp);
+
+COMMIT;
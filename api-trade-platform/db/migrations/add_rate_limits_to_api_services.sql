@@ -0,0 +1,11 @@
+-- Migration: Add seller-defined rate limits to api_services
+-- Date: 2026-10-17
+-- Description: Requests-per-minute limits for the whole service and for each subscribing buyer, enforced on /proxy/v1 through the Redis rate limiter
+
+BEGIN;
+
+ALTER TABLE api_services ADD COLUMN IF NOT EXISTS rate_limits JSONB;
+
+COMMENT ON COLUMN api_services.rate_limits IS 'Proxy rate limits: requests_per_minute for the service and subscriber_requests_per_minute per buyer; NULL means unlimited';
+
+COMMIT;
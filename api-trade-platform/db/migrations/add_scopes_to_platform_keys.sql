@@ -0,0 +1,12 @@
+-- Migration: Add access scopes to platform API keys
+-- Date: 2026-10-17
+-- Description: Let buyers restrict a key to HTTP methods, path globs, models, a max_tokens ceiling and source IP CIDRs
+
+BEGIN;
+
+ALTER TABLE platform_api_keys
+ADD COLUMN IF NOT EXISTS scopes JSONB;
+
+COMMENT ON COLUMN platform_api_keys.scopes IS 'Access restrictions, e.g. {"allowed_methods":["POST"],"path_globs":["/chat/completions"],"allowed_models":["gpt-4o-mini"],"max_tokens":1024,"source_cidrs":["203.0.113.0/24"]}; NULL means unrestricted';
+
+COMMIT;
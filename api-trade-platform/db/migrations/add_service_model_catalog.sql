@@ -0,0 +1,19 @@
+-- Migration: Extend api_service_models into a seller-managed model catalog
+-- Date: 2026-10-17
+-- Description: Add context window, modalities and per-model input/output prices to api_service_models
+
+ALTER TABLE api_service_models
+ADD COLUMN IF NOT EXISTS context_window INTEGER DEFAULT 0 CHECK (context_window >= 0),
+ADD COLUMN IF NOT EXISTS modalities TEXT[] DEFAULT ARRAY['text'],
+ADD COLUMN IF NOT EXISTS input_price_per_1k DECIMAL(12,8) DEFAULT 0.0 CHECK (input_price_per_1k >= 0),
+ADD COLUMN IF NOT EXISTS output_price_per_1k DECIMAL(12,8) DEFAULT 0.0 CHECK (output_price_per_1k >= 0),
+ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP;
+
+CREATE TRIGGER set_api_service_models_updated_at
+BEFORE UPDATE ON api_service_models
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+COMMENT ON COLUMN api_service_models.context_window IS 'Maximum context window in tokens, 0 if not declared';
+COMMENT ON COLUMN api_service_models.input_price_per_1k IS 'Per-model price per 1K input tokens; 0 falls back to the service pricing';
+COMMENT ON COLUMN api_service_models.output_price_per_1k IS 'Per-model price per 1K output tokens; 0 falls back to the service pricing';
@@ -0,0 +1,36 @@
+-- Migration: Add upstream pools to API services
+-- Date: 2026-10-17
+-- Description: Let one API service balance across several seller upstreams (endpoint URL, encrypted key, weight) with health-check based ejection
+
+BEGIN;
+
+ALTER TABLE api_services
+ADD COLUMN IF NOT EXISTS lb_strategy VARCHAR(30) DEFAULT 'weighted_round_robin' CHECK (lb_strategy IN ('weighted_round_robin', 'least_latency'));
+
+CREATE TABLE IF NOT EXISTS api_service_upstreams (
+    upstream_id SERIAL PRIMARY KEY,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL DEFAULT '',
+    endpoint_url VARCHAR(2048) NOT NULL,
+    encrypted_api_key TEXT NOT NULL,
+    weight INTEGER NOT NULL DEFAULT 1 CHECK (weight > 0),
+    health_check_path VARCHAR(255), -- Probed with GET by the health checker, defaults to the endpoint URL itself
+    is_active BOOLEAN DEFAULT TRUE, -- Seller switch, inactive upstreams never receive traffic
+    is_healthy BOOLEAN DEFAULT TRUE, -- Maintained by the health checker
+    last_checked_at TIMESTAMP WITH TIME ZONE,
+    last_error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_service_upstreams_service_id ON api_service_upstreams(service_id);
+
+CREATE TRIGGER set_api_service_upstreams_updated_at
+BEFORE UPDATE ON api_service_upstreams
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+COMMENT ON TABLE api_service_upstreams IS 'Upstream pool of an API service; when empty the proxy uses api_services.original_endpoint_url';
+COMMENT ON COLUMN api_services.lb_strategy IS 'How the proxy picks an upstream from the pool: weighted_round_robin or least_latency';
+
+COMMIT;
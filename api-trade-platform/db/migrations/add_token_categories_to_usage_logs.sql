@@ -0,0 +1,19 @@
+-- Migration: Add per-category token counts to usage_logs
+-- Date: 2026-10-17
+-- Description: Cached, cache-write, reasoning, audio and image token counts parsed from OpenAI, Anthropic and Gemini usage blocks; they are subsets of input_tokens/output_tokens and can be priced separately in a service pricing plan
+
+BEGIN;
+
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS cached_input_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS cache_write_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS reasoning_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS audio_input_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS audio_output_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS image_input_tokens INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE usage_logs ADD COLUMN IF NOT EXISTS image_output_tokens INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN usage_logs.cached_input_tokens IS 'Input tokens read from the prompt cache (included in input_tokens)';
+COMMENT ON COLUMN usage_logs.cache_write_tokens IS 'Input tokens written to the prompt cache (included in input_tokens)';
+COMMENT ON COLUMN usage_logs.reasoning_tokens IS 'Reasoning/thinking tokens (included in output_tokens)';
+
+COMMIT;
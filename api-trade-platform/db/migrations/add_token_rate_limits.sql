@@ -0,0 +1,23 @@
+-- Migration: Add tokens-per-minute budgets
+-- Date: 2026-10-17
+-- Description: Buyer token budgets per subscribed service and per model; seller token budgets live in api_services.rate_limits and per-key budgets in platform_api_keys.scopes
+
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS buyer_token_budgets (
+    buyer_user_id INTEGER NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+    service_id INTEGER NOT NULL REFERENCES api_services(service_id) ON DELETE CASCADE,
+    token_budget JSONB NOT NULL, -- tokens_per_minute and model_tokens_per_minute shared by all of the buyer's keys on the service
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (buyer_user_id, service_id)
+);
+
+CREATE TRIGGER set_buyer_token_budgets_updated_at
+BEFORE UPDATE ON buyer_token_budgets
+FOR EACH ROW
+EXECUTE FUNCTION trigger_set_timestamp();
+
+COMMENT ON COLUMN api_services.rate_limits IS 'Rate limits: requests_per_minute and subscriber_requests_per_minute on /proxy/v1, plus tokens_per_minute, subscriber_tokens_per_minute, key_tokens_per_minute and model_tokens_per_minute; NULL means unlimited';
+
+COMMIT;
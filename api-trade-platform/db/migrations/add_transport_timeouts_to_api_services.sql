@@ -0,0 +1,16 @@
+-- Migration: Add per-service upstream transport timeouts
+-- Date: 2026-10-17
+-- Description: Let sellers tune connect, response header and idle connection timeouts for their upstream; NULL uses the platform defaults
+
+BEGIN;
+
+ALTER TABLE api_services
+ADD COLUMN IF NOT EXISTS connect_timeout_ms INTEGER CHECK (connect_timeout_ms >= 0),
+ADD COLUMN IF NOT EXISTS response_header_timeout_ms INTEGER CHECK (response_header_timeout_ms >= 0),
+ADD COLUMN IF NOT EXISTS idle_conn_timeout_ms INTEGER CHECK (idle_conn_timeout_ms >= 0);
+
+COMMENT ON COLUMN api_services.connect_timeout_ms IS 'Upstream TCP connect timeout, NULL or 0 uses UPSTREAM_CONNECT_TIMEOUT_SECONDS';
+COMMENT ON COLUMN api_services.response_header_timeout_ms IS 'Time to wait for upstream response headers, NULL or 0 uses UPSTREAM_RESPONSE_HEADER_TIMEOUT_SECONDS';
+COMMENT ON COLUMN api_services.idle_conn_timeout_ms IS 'How long idle keep-alive connections to the upstream are kept, NULL or 0 uses UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS';
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Migration: Record wallet settlement shortfalls
+-- Date: 2026-10-17
+-- Description: Usage settlement never debits a buyer wallet below zero; the part of a call's cost the hold and available balance could not cover is recorded on the hold
+
+BEGIN;
+
+ALTER TABLE wallet_holds
+ADD COLUMN IF NOT EXISTS shortfall_micros BIGINT NOT NULL DEFAULT 0 CHECK (shortfall_micros >= 0);
+
+COMMENT ON COLUMN wallet_holds.shortfall_micros IS 'Actual cost that exceeded the hold plus available balance and was not debited';
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- Migration: Store platform API keys as hash plus public prefix
+-- Date: 2026-10-17
+-- Description: Replace the stored platform_api_key secret with a SHA-256 key_hash and a short key_prefix used for lookup.
+--              Plaintext legacy keys are hashed here; AES-encrypted legacy keys cannot be decrypted in SQL and are
+--              backfilled at startup by PlatformKeyStore.BackfillLegacyPlatformAPIKeys using ENCRYPTION_KEY.
+
+BEGIN;
+
+ALTER TABLE platform_api_keys
+ADD COLUMN IF NOT EXISTS key_prefix VARCHAR(16),
+ADD COLUMN IF NOT EXISTS key_hash CHAR(64);
+
+ALTER TABLE platform_api_keys ALTER COLUMN platform_api_key DROP NOT NULL;
+
+-- 明文保存的历史密钥直接计算哈希，并清除明文
+UPDATE platform_api_keys
+SET key_prefix = LEFT(platform_api_key, 12),
+    key_hash = encode(sha256(convert_to(platform_api_key, 'UTF8')), 'hex'),
+    platform_api_key = NULL
+WHERE key_hash IS NULL AND platform_api_key LIKE 'pak\_%';
+
+DROP INDEX IF EXISTS idx_platform_api_keys_platform_api_key;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_platform_api_keys_key_hash ON platform_api_keys(key_hash);
+CREATE INDEX IF NOT EXISTS idx_platform_api_keys_key_prefix ON platform_api_keys(key_prefix);
+
+COMMENT ON COLUMN platform_api_keys.platform_api_key IS 'Legacy AES-encrypted key awaiting backfill, NULL once key_hash is set';
+COMMENT ON COLUMN platform_api_keys.key_prefix IS 'Public key prefix (first 12 characters) used for lookup and masked display';
+COMMENT ON COLUMN platform_api_keys.key_hash IS 'Hex SHA-256 of the full platform API key';
+
+COMMIT;
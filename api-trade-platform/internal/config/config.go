@@ -15,6 +15,8 @@ type Config struct {
 	DB_SSLMODE  string `mapstructure:"DB_SSLMODE"`
 
 	API_SERVER_PORT string `mapstructure:"API_SERVER_PORT"`
+	// 逗号分隔的可信反向代理 IP 或网段，只采信它们传来的 X-Forwarded-For；为空时使用连接的对端地址
+	TRUSTED_PROXIES string `mapstructure:"TRUSTED_PROXIES"`
 
 	JWT_SECRET_KEY        string `mapstructure:"JWT_SECRET_KEY"`
 	JWT_EXPIRATION_HOURS  int    `mapstructure:"JWT_EXPIRATION_HOURS"`
@@ -27,6 +29,55 @@ type Config struct {
 	REDIS_PASSWORD  string `mapstructure:"REDIS_PASSWORD"`
 	REDIS_DB        int    `mapstructure:"REDIS_DB"`
 	REDIS_POOL_SIZE int    `mapstructure:"REDIS_POOL_SIZE"`
+
+	// Upstream Health Check Configuration
+	UPSTREAM_HEALTH_CHECK_INTERVAL_SECONDS int `mapstructure:"UPSTREAM_HEALTH_CHECK_INTERVAL_SECONDS"`
+	UPSTREAM_HEALTH_CHECK_TIMEOUT_SECONDS  int `mapstructure:"UPSTREAM_HEALTH_CHECK_TIMEOUT_SECONDS"`
+	UPSTREAM_UNHEALTHY_THRESHOLD           int `mapstructure:"UPSTREAM_UNHEALTHY_THRESHOLD"`
+	UPSTREAM_HEALTHY_THRESHOLD             int `mapstructure:"UPSTREAM_HEALTHY_THRESHOLD"`
+
+	// Upstream Transport Configuration (per-service timeouts override these defaults)
+	UPSTREAM_CONNECT_TIMEOUT_SECONDS         int `mapstructure:"UPSTREAM_CONNECT_TIMEOUT_SECONDS"`
+	UPSTREAM_RESPONSE_HEADER_TIMEOUT_SECONDS int `mapstructure:"UPSTREAM_RESPONSE_HEADER_TIMEOUT_SECONDS"`
+	UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS       int `mapstructure:"UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS"`
+	UPSTREAM_MAX_IDLE_CONNS_PER_HOST         int `mapstructure:"UPSTREAM_MAX_IDLE_CONNS_PER_HOST"`
+
+	// Circuit Breaker Configuration (requires Redis, threshold 0 disables it)
+	CIRCUIT_BREAKER_FAILURE_THRESHOLD      int `mapstructure:"CIRCUIT_BREAKER_FAILURE_THRESHOLD"`
+	CIRCUIT_BREAKER_COOLDOWN_SECONDS       int `mapstructure:"CIRCUIT_BREAKER_COOLDOWN_SECONDS"`
+	CIRCUIT_BREAKER_HALF_OPEN_MAX_REQUESTS int `mapstructure:"CIRCUIT_BREAKER_HALF_OPEN_MAX_REQUESTS"`
+
+	// Buyer Wallet Configuration (disabled wallets let every call through without holds)
+	WALLET_ENABLED                     bool `mapstructure:"WALLET_ENABLED"`
+	WALLET_DEFAULT_HOLD_OUTPUT_TOKENS  int  `mapstructure:"WALLET_DEFAULT_HOLD_OUTPUT_TOKENS"`
+	WALLET_HOLD_TTL_SECONDS            int  `mapstructure:"WALLET_HOLD_TTL_SECONDS"`
+	WALLET_HOLD_SWEEP_INTERVAL_SECONDS int  `mapstructure:"WALLET_HOLD_SWEEP_INTERVAL_SECONDS"`
+
+	// Ledger Reconciliation Configuration (interval 0 disables the background job)
+	LEDGER_RECONCILE_INTERVAL_SECONDS int `mapstructure:"LEDGER_RECONCILE_INTERVAL_SECONDS"`
+	LEDGER_RECONCILE_WINDOW_HOURS     int `mapstructure:"LEDGER_RECONCILE_WINDOW_HOURS"`
+
+	// Platform Commission Configuration (basis points taken from each billed call when no commission rate is configured)
+	PLATFORM_COMMISSION_RATE_BPS int `mapstructure:"PLATFORM_COMMISSION_RATE_BPS"`
+
+	// Buyer Invoice Configuration (issuer details and the default tax rate are frozen into each generated draft)
+	INVOICE_ISSUER_NAME   string `mapstructure:"INVOICE_ISSUER_NAME"`
+	INVOICE_ISSUER_TAX_ID string `mapstructure:"INVOICE_ISSUER_TAX_ID"`
+	INVOICE_TAX_RATE_BPS  int    `mapstructure:"INVOICE_TAX_RATE_BPS"`
+	INVOICE_AUTO_ISSUE    bool   `mapstructure:"INVOICE_AUTO_ISSUE"`
+
+	// Token Rate Limit Configuration (requires Redis, budgets are set per service, subscription, key and model)
+	TPM_DEFAULT_OUTPUT_TOKENS int `mapstructure:"TPM_DEFAULT_OUTPUT_TOKENS"`
+
+	// Rate Limiter Fallback Configuration (in-process limits used while Redis is unavailable)
+	RATE_LIMIT_FALLBACK_PERCENT    int `mapstructure:"RATE_LIMIT_FALLBACK_PERCENT"`
+	RATE_LIMIT_REDIS_PROBE_SECONDS int `mapstructure:"RATE_LIMIT_REDIS_PROBE_SECONDS"`
+
+	// Concurrency Limit Configuration (requires Redis, limits are set per service, subscriber and key; queue depth 0 rejects instead of queueing)
+	CONCURRENCY_LEASE_TTL_SECONDS         int `mapstructure:"CONCURRENCY_LEASE_TTL_SECONDS"`
+	CONCURRENCY_QUEUE_MAX_DEPTH           int `mapstructure:"CONCURRENCY_QUEUE_MAX_DEPTH"`
+	CONCURRENCY_QUEUE_MAX_DEPTH_PER_BUYER int `mapstructure:"CONCURRENCY_QUEUE_MAX_DEPTH_PER_BUYER"`
+	CONCURRENCY_QUEUE_TIMEOUT_SECONDS     int `mapstructure:"CONCURRENCY_QUEUE_TIMEOUT_SECONDS"`
 }
 
 // LoadConfig 从指定路径的 .env 文件或环境变量中读取配置
@@ -37,6 +88,36 @@ func LoadConfig(path string) (config Config, err error) {
 
 	viper.AutomaticEnv() // 自动从环境变量中读取匹配的键
 
+	// 可选配置的默认值
+	viper.SetDefault("UPSTREAM_HEALTH_CHECK_INTERVAL_SECONDS", 30)
+	viper.SetDefault("UPSTREAM_HEALTH_CHECK_TIMEOUT_SECONDS", 5)
+	viper.SetDefault("UPSTREAM_UNHEALTHY_THRESHOLD", 3)
+	viper.SetDefault("UPSTREAM_HEALTHY_THRESHOLD", 2)
+	viper.SetDefault("UPSTREAM_CONNECT_TIMEOUT_SECONDS", 10)
+	viper.SetDefault("UPSTREAM_RESPONSE_HEADER_TIMEOUT_SECONDS", 600)
+	viper.SetDefault("UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS", 90)
+	viper.SetDefault("UPSTREAM_MAX_IDLE_CONNS_PER_HOST", 64)
+	viper.SetDefault("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5)
+	viper.SetDefault("CIRCUIT_BREAKER_COOLDOWN_SECONDS", 30)
+	viper.SetDefault("CIRCUIT_BREAKER_HALF_OPEN_MAX_REQUESTS", 1)
+	viper.SetDefault("WALLET_ENABLED", true)
+	viper.SetDefault("WALLET_DEFAULT_HOLD_OUTPUT_TOKENS", 4096)
+	viper.SetDefault("WALLET_HOLD_TTL_SECONDS", 3600)
+	viper.SetDefault("WALLET_HOLD_SWEEP_INTERVAL_SECONDS", 60)
+	viper.SetDefault("LEDGER_RECONCILE_INTERVAL_SECONDS", 3600)
+	viper.SetDefault("LEDGER_RECONCILE_WINDOW_HOURS", 24)
+	viper.SetDefault("PLATFORM_COMMISSION_RATE_BPS", 0)
+	viper.SetDefault("INVOICE_ISSUER_NAME", "API Trade Platform")
+	viper.SetDefault("INVOICE_TAX_RATE_BPS", 0)
+	viper.SetDefault("INVOICE_AUTO_ISSUE", false)
+	viper.SetDefault("TPM_DEFAULT_OUTPUT_TOKENS", 4096)
+	viper.SetDefault("RATE_LIMIT_FALLBACK_PERCENT", 50)
+	viper.SetDefault("RATE_LIMIT_REDIS_PROBE_SECONDS", 5)
+	viper.SetDefault("CONCURRENCY_LEASE_TTL_SECONDS", 60)
+	viper.SetDefault("CONCURRENCY_QUEUE_MAX_DEPTH", 100)
+	viper.SetDefault("CONCURRENCY_QUEUE_MAX_DEPTH_PER_BUYER", 20)
+	viper.SetDefault("CONCURRENCY_QUEUE_TIMEOUT_SECONDS", 30)
+
 	if err = viper.ReadInConfig(); err != nil {
 		// 如果 .env 文件不存在，可以忽略错误，因为可能直接使用环境变量
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
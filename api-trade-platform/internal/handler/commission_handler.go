@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// commissionRateBps 获取服务适用的抽成比例，没有配置或查询失败时使用平台默认比例
+func (h *BaseHandler) commissionRateBps(serviceID int64) int {
+	rateBps, found, err := h.commissionStore.ResolveRateBps(serviceID)
+	if err != nil {
+		fmt.Printf("Failed to resolve commission rate for service %d, using platform default: %v\n", serviceID, err)
+	}
+	if err != nil || !found {
+		rateBps = h.cfg.PLATFORM_COMMISSION_RATE_BPS
+	}
+	if rateBps < 0 {
+		return 0
+	}
+	if rateBps > 10000 {
+		return 10000
+	}
+	return rateBps
+}
+
+// ListCommissionRates godoc
+// @Summary 获取平台抽成比例 (List commission rates)
+// @Description 管理员查看所有抽成比例配置。计费时卖家比例优先，其次是服务分类比例，再次是全局比例，都没有时使用平台默认比例
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{rates=[]model.CommissionRate,default_rate_bps=int} "抽成比例列表 (Commission rates)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/commission-rates [get]
+func (h *BaseHandler) ListCommissionRates(c *gin.Context) {
+	rates, err := h.commissionStore.ListRates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list commission rates"})
+		return
+	}
+	if rates == nil {
+		rates = []*model.CommissionRate{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rates": rates, "default_rate_bps": h.cfg.PLATFORM_COMMISSION_RATE_BPS})
+}
+
+// UpsertCommissionRate godoc
+// @Summary 设置平台抽成比例 (Set commission rate)
+// @Description 管理员设置全局、服务分类或卖家的抽成比例，已有配置时覆盖。新比例只影响之后的计费调用
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body model.CommissionRateRequest true "抽成比例 (Commission rate)"
+// @Success 200 {object} object{rate=model.CommissionRate} "抽成比例 (Commission rate)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "卖家不存在 (Seller not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/commission-rates [put]
+func (h *BaseHandler) UpsertCommissionRate(c *gin.Context) {
+	var req model.CommissionRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	rate := &model.CommissionRate{Scope: req.Scope, RateBps: *req.RateBps}
+	switch req.Scope {
+	case model.CommissionScopeSeller:
+		if req.SellerUserID == 0 || req.Category != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Seller rates require seller_user_id and no category"})
+			return
+		}
+		seller, err := h.userStore.GetUserByID(req.SellerUserID)
+		if err != nil || seller == nil || seller.Role != "seller" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Seller not found"})
+			return
+		}
+		rate.SellerUserID = &req.SellerUserID
+	case model.CommissionScopeCategory:
+		if req.Category == "" || req.SellerUserID != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Category rates require category and no seller_user_id"})
+			return
+		}
+		rate.Category = req.Category
+	default:
+		if req.Category != "" || req.SellerUserID != 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Global rates take no seller_user_id or category"})
+			return
+		}
+	}
+
+	if err := h.commissionStore.UpsertRate(rate); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save commission rate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rate": rate})
+}
+
+// DeleteCommissionRate godoc
+// @Summary 删除平台抽成比例 (Delete commission rate)
+// @Description 管理员删除一条抽成比例配置，之后按下一级配置计费
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param rate_id path int true "抽成比例 ID (Commission rate ID)"
+// @Success 200 {object} object{message=string} "删除成功 (Deleted)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "配置不存在 (Rate not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/commission-rates/{rate_id} [delete]
+func (h *BaseHandler) DeleteCommissionRate(c *gin.Context) {
+	rateID, err := strconv.ParseInt(c.Param("rate_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rate ID"})
+		return
+	}
+
+	deleted, err := h.commissionStore.DeleteRate(rateID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete commission rate"})
+		return
+	}
+	if !deleted {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Commission rate not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Commission rate deleted successfully"})
+}
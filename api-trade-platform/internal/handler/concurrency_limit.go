@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/proxy"
+	"api-trade-platform/internal/redis"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// concurrencyQueueLane 服务对应的等待队列名称，也是 expvar 指标中的分组名
+func concurrencyQueueLane(serviceID int64) string {
+	return fmt.Sprintf("service:%d", serviceID)
+}
+
+// concurrencyLimits 汇总卖家为服务整体、每个订阅者和每个平台密钥设置的并发限制，服务整体的限制总是排在第一个
+func concurrencyLimits(route *model.ServiceRoute) []redis.ConcurrencyLimit {
+	key, service := route.PlatformKey, route.APIService
+	sellerLimits := service.RateLimits
+
+	var limits []redis.ConcurrencyLimit
+	if sellerLimits.MaxConcurrentRequests > 0 {
+		limits = append(limits, redis.ConcurrencyLimit{
+			Key:   fmt.Sprintf(redis.ConcurrencyKeyService, service.ServiceID),
+			Limit: int64(sellerLimits.MaxConcurrentRequests),
+		})
+	}
+	if sellerLimits.SubscriberMaxConcurrentRequests > 0 {
+		limits = append(limits, redis.ConcurrencyLimit{
+			Key:   fmt.Sprintf(redis.ConcurrencyKeySubscriber, key.BuyerUserID, service.ServiceID),
+			Limit: int64(sellerLimits.SubscriberMaxConcurrentRequests),
+		})
+	}
+	if sellerLimits.KeyMaxConcurrentRequests > 0 {
+		limits = append(limits, redis.ConcurrencyLimit{
+			Key:   fmt.Sprintf(redis.ConcurrencyKeyAPIKey, key.KeyID),
+			Limit: int64(sellerLimits.KeyMaxConcurrentRequests),
+		})
+	}
+	return limits
+}
+
+// acquireConcurrencyLease 转发前占用服务、订阅者和密钥的并发名额，名额不足时在服务的等待队列中按买家轮转排队
+// 未启用 Redis 或未设置并发限制时不占用；Redis 出错时放行请求。队列已满或等待超时返回 429。
+func (h *BaseHandler) acquireConcurrencyLease(c *gin.Context, route *model.ServiceRoute) (*redis.ConcurrencyLease, *proxyAttemptError) {
+	if h.concurrencyLimiter == nil {
+		return nil, nil
+	}
+	limits := concurrencyLimits(route)
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	serviceID := route.APIService.ServiceID
+	serviceLimited := route.APIService.RateLimits.MaxConcurrentRequests > 0
+
+	var lease *redis.ConcurrencyLease
+	acquire := func() (bool, bool) {
+		acquired, blocked, err := h.concurrencyLimiter.Acquire(limits)
+		if err != nil {
+			fmt.Printf("Failed to acquire concurrency lease for service %d: %v\n", serviceID, err)
+			return true, false
+		}
+		if acquired == nil {
+			// 服务整体名额已满时其他买家同样无法占用，本轮调度到此为止
+			return false, serviceLimited && blocked == 0
+		}
+		lease = acquired
+		return true, false
+	}
+
+	waited, err := h.concurrencyQueue.Wait(c.Request.Context(), concurrencyQueueLane(serviceID),
+		strconv.FormatInt(route.PlatformKey.BuyerUserID, 10), acquire)
+	if waited > 0 {
+		c.Header("X-Platform-Queue-Wait-Ms", strconv.FormatInt(waited.Milliseconds(), 10))
+	}
+
+	switch {
+	case err == nil:
+		return lease, nil
+	case errors.Is(err, proxy.ErrQueueFull):
+		return nil, &proxyAttemptError{
+			status:     http.StatusTooManyRequests,
+			body:       gin.H{"error": "Too many concurrent requests, wait queue is full", "code": "concurrency_queue_full", "retry_after": 1},
+			retryAfter: time.Second,
+		}
+	case errors.Is(err, proxy.ErrQueueTimeout):
+		return nil, &proxyAttemptError{
+			status:     http.StatusTooManyRequests,
+			body:       gin.H{"error": "Too many concurrent requests, timed out waiting in queue", "code": "concurrency_queue_timeout", "retry_after": 1},
+			retryAfter: time.Second,
+		}
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return nil, &proxyAttemptError{status: statusClientClosedRequest, body: gin.H{"error": "Client closed request"}}
+	default:
+		return nil, &proxyAttemptError{status: http.StatusInternalServerError, body: gin.H{"error": "Failed to acquire concurrency slot"}}
+	}
+}
+
+// releaseConcurrencyLease 释放并发名额，并唤醒服务等待队列中的请求
+func (h *BaseHandler) releaseConcurrencyLease(lease *redis.ConcurrencyLease, serviceID int64) {
+	if h.concurrencyLimiter == nil || lease == nil {
+		return
+	}
+	if err := h.concurrencyLimiter.Release(lease); err != nil {
+		fmt.Printf("Failed to release concurrency lease: %v\n", err)
+	}
+	h.concurrencyQueue.Notify(concurrencyQueueLane(serviceID))
+}
@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validateFallbackChainRequest 校验回退链请求：model_id 与 service_id 二选一，且链中的服务都已被买家订阅
+func (h *BaseHandler) validateFallbackChainRequest(buyerUserID int64, req *model.FallbackChainRequest) error {
+	req.ModelID = strings.TrimSpace(req.ModelID)
+	if (req.ModelID == "") == (req.ServiceID == nil) {
+		return fmt.Errorf("exactly one of model_id or service_id must be provided")
+	}
+
+	if req.ServiceID != nil {
+		subscribed, err := h.platformKeyStore.CheckSubscriptionExists(buyerUserID, *req.ServiceID)
+		if err != nil {
+			return err
+		}
+		if !subscribed {
+			return fmt.Errorf("service %d is not subscribed", *req.ServiceID)
+		}
+	}
+
+	seen := make(map[int64]bool)
+	for _, serviceID := range req.ServiceIDs {
+		if seen[serviceID] {
+			return fmt.Errorf("service %d appears more than once in service_ids", serviceID)
+		}
+		seen[serviceID] = true
+
+		subscribed, err := h.platformKeyStore.CheckSubscriptionExists(buyerUserID, serviceID)
+		if err != nil {
+			return err
+		}
+		if !subscribed {
+			return fmt.Errorf("service %d is not subscribed", serviceID)
+		}
+	}
+	return nil
+}
+
+// ListFallbackChains godoc
+// @Summary 获取回退链列表 (List fallback chains)
+// @Description 买家查看自己定义的所有回退链
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{chains=[]model.FallbackChain} "回退链列表 (Fallback chains)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/fallback-chains [get]
+func (h *BaseHandler) ListFallbackChains(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chains, err := h.fallbackChainStore.GetFallbackChainsByBuyerID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get fallback chains"})
+		return
+	}
+	if chains == nil {
+		chains = []*model.FallbackChain{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"chains": chains})
+}
+
+// CreateFallbackChain godoc
+// @Summary 创建回退链 (Create a fallback chain)
+// @Description 买家为某个模型（/v1 统一路由）或某个服务（/proxy/v1）定义按顺序尝试的已订阅服务列表。
+// @Description 上游连接失败、超时或返回 408/429/5xx 时代理会依次尝试链中的下一个服务。
+// @Tags Buyer
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body model.FallbackChainRequest true "回退链定义 (Fallback chain definition)"
+// @Success 201 {object} model.FallbackChain "创建成功 (Created)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/fallback-chains [post]
+func (h *BaseHandler) CreateFallbackChain(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req model.FallbackChainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if err := h.validateFallbackChainRequest(userID, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chain := &model.FallbackChain{
+		BuyerUserID: userID,
+		Name:        req.Name,
+		ModelID:     req.ModelID,
+		ServiceID:   req.ServiceID,
+		ServiceIDs:  req.ServiceIDs,
+		IsActive:    true,
+	}
+	if req.IsActive != nil {
+		chain.IsActive = *req.IsActive
+	}
+
+	if err := h.fallbackChainStore.CreateFallbackChain(chain); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create fallback chain"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, chain)
+}
+
+// UpdateFallbackChain godoc
+// @Summary 更新回退链 (Update a fallback chain)
+// @Description 买家更新自己定义的回退链
+// @Tags Buyer
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param chain_id path int true "回退链 ID (Fallback chain ID)"
+// @Param request body model.FallbackChainRequest true "回退链定义 (Fallback chain definition)"
+// @Success 200 {object} model.FallbackChain "更新成功 (Updated)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "回退链未找到 (Fallback chain not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/fallback-chains/{chain_id} [put]
+func (h *BaseHandler) UpdateFallbackChain(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chainID, err := strconv.ParseInt(c.Param("chain_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chain ID"})
+		return
+	}
+
+	var req model.FallbackChainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if err := h.validateFallbackChainRequest(userID, &req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	chain, err := h.fallbackChainStore.GetFallbackChainByID(chainID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get fallback chain"})
+		return
+	}
+	if chain == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fallback chain not found"})
+		return
+	}
+
+	chain.Name = req.Name
+	chain.ModelID = req.ModelID
+	chain.ServiceID = req.ServiceID
+	chain.ServiceIDs = req.ServiceIDs
+	if req.IsActive != nil {
+		chain.IsActive = *req.IsActive
+	}
+
+	if err := h.fallbackChainStore.UpdateFallbackChain(chain); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update fallback chain"})
+		return
+	}
+
+	c.JSON(http.StatusOK, chain)
+}
+
+// DeleteFallbackChain godoc
+// @Summary 删除回退链 (Delete a fallback chain)
+// @Description 买家删除自己定义的回退链
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Param chain_id path int true "回退链 ID (Fallback chain ID)"
+// @Success 200 {object} object{message=string} "删除成功 (Deleted)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "回退链未找到 (Fallback chain not found)"
+// @Router /api/v1/buyer/fallback-chains/{chain_id} [delete]
+func (h *BaseHandler) DeleteFallbackChain(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	chainID, err := strconv.ParseInt(c.Param("chain_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chain ID"})
+		return
+	}
+
+	if err := h.fallbackChainStore.DeleteFallbackChain(chainID, userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fallback chain not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Fallback chain deleted successfully"})
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func TestResolveChainRoutes(t *testing.T) {
+	primary := testRoute(t, 1, "https://primary.example.com")
+	chain := &model.FallbackChain{ChainID: 1, ServiceIDs: []int64{1, 3, 2, 3, 4, 5}}
+
+	lookups := make(map[int64]int)
+	routes := resolveChainRoutes([]*model.ServiceRoute{primary}, chain, func(serviceID int64) (*model.ServiceRoute, error) {
+		lookups[serviceID]++
+		switch serviceID {
+		case 4:
+			// 买家已取消该服务的订阅
+			return nil, nil
+		case 5:
+			return nil, errors.New("database unavailable")
+		}
+		return testRoute(t, serviceID, "https://fallback.example.com"), nil
+	})
+
+	// 主服务在前，链中的服务按顺序追加，重复、未订阅和查询失败的服务被跳过
+	var got []int64
+	for _, route := range routes {
+		got = append(got, route.APIService.ServiceID)
+	}
+	if want := []int64{1, 3, 2}; !slices.Equal(got, want) {
+		t.Errorf("routes = %v, want %v", got, want)
+	}
+	if lookups[1] != 0 || lookups[3] != 1 {
+		t.Errorf("lookups = %v, want the primary service skipped and service 3 looked up once", lookups)
+	}
+}
+
+func TestForwardWithFallbackTriesServicesInOrder(t *testing.T) {
+	h := newTestHandler(t)
+	unavailableServer, unavailableCalls := newTestUpstream(t, http.StatusServiceUnavailable)
+	// 已关闭的上游模拟连接失败
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedServer.Close()
+	okServer, okCalls := newTestUpstream(t, http.StatusOK)
+	unusedServer, unusedCalls := newTestUpstream(t, http.StatusOK)
+
+	routes := []*model.ServiceRoute{
+		testRoute(t, 1, unavailableServer.URL),
+		testRoute(t, 2, closedServer.URL),
+		testRoute(t, 3, okServer.URL),
+		testRoute(t, 4, unusedServer.URL),
+	}
+	c, w := testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	h.forwardWithFallback(c, routes, "/chat/completions", []byte(`{}`))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 from service 3", w.Code)
+	}
+	if atomic.LoadInt64(unavailableCalls) != 1 || atomic.LoadInt64(okCalls) != 1 || atomic.LoadInt64(unusedCalls) != 0 {
+		t.Errorf("calls = %d/%d/%d, want 1/1/0", atomic.LoadInt64(unavailableCalls), atomic.LoadInt64(okCalls), atomic.LoadInt64(unusedCalls))
+	}
+	if w.Header().Get("X-Platform-Request-ID") == "" {
+		t.Errorf("response has no X-Platform-Request-ID header")
+	}
+}
+
+func TestForwardWithFallbackStopsOnNonRetryableStatus(t *testing.T) {
+	h := newTestHandler(t)
+	badRequestServer, _ := newTestUpstream(t, http.StatusBadRequest)
+	okServer, okCalls := newTestUpstream(t, http.StatusOK)
+
+	// 买家请求本身有误时换一个服务也不会成功，直接返回上游的响应
+	c, w := testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	h.forwardWithFallback(c, []*model.ServiceRoute{testRoute(t, 1, badRequestServer.URL), testRoute(t, 2, okServer.URL)}, "/chat/completions", []byte(`{}`))
+	if w.Code != http.StatusBadRequest || atomic.LoadInt64(okCalls) != 0 {
+		t.Errorf("status = %d, fallback calls = %d, want 400 without trying the next service", w.Code, atomic.LoadInt64(okCalls))
+	}
+
+	// 最后一个服务的可重试状态码原样返回给买家
+	unavailableServer, _ := newTestUpstream(t, http.StatusServiceUnavailable)
+	badGatewayServer, _ := newTestUpstream(t, http.StatusBadGateway)
+	c, w = testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	h.forwardWithFallback(c, []*model.ServiceRoute{testRoute(t, 3, unavailableServer.URL), testRoute(t, 4, badGatewayServer.URL)}, "/chat/completions", []byte(`{}`))
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want the last service's 502", w.Code)
+	}
+}
@@ -4,16 +4,15 @@ import (
 	"api-trade-platform/internal/config"
 	"api-trade-platform/internal/middleware"
 	"api-trade-platform/internal/model" // Added for ErrorResponse and other models
+	"api-trade-platform/internal/proxy"
 	"api-trade-platform/internal/redis"
 	"api-trade-platform/internal/store/postgres"
 	"api-trade-platform/internal/utils"
-	"bytes"
+	"context"
 	"encoding/json"
+	"expvar"
 	"fmt"
-	"io"
 	"net/http"
-	"net/url"
-	"path"
 	"strconv"
 	"strings"
 	"time"
@@ -36,11 +35,27 @@ type BaseHandler struct {
 	usageLogStore   *postgres.UsageLogStore      // 使用日志存储
 	apiDocStore     *postgres.APIDocumentationStore // API文档存储
 	userAccountStore *postgres.UserAccountStore   // 用户账户设置存储
+	serviceModelStore *postgres.ServiceModelStore // 服务模型存储
+	fallbackChainStore *postgres.FallbackChainStore // 买家回退链存储
+	upstreamStore   *postgres.UpstreamStore      // 服务上游池存储
+	walletStore     *postgres.WalletStore        // 买家钱包存储
+	ledgerStore     *postgres.LedgerStore        // 复式记账账本存储
+	commissionStore *postgres.CommissionStore    // 平台抽成比例存储
+	payoutStore     *postgres.PayoutStore        // 卖家结算单存储
+	invoiceStore    *postgres.InvoiceStore       // 买家发票存储
+	slaStore        *postgres.SLAStore           // 服务 SLA 评估存储
+	// 上游池负载均衡与健康检查
+	balancer        *proxy.Balancer              // 上游负载均衡器
+	healthChecker   *proxy.HealthChecker         // 上游健康检查器
+	transportPool   *proxy.TransportPool         // 共享的上游 HTTP 连接池
+	concurrencyQueue *proxy.FairQueue            // 并发名额不足时按买家轮转的等待队列
 	// Redis 服务
 	redisClient     *redis.RedisClient           // Redis客户端
 	sessionService  *redis.SessionService        // 会话管理服务
 	cacheService    *redis.CacheService          // 缓存服务
-	rateLimiter     *redis.RateLimiter           // 限流服务
+	rateLimiter     *redis.FallbackRateLimiter   // 限流服务，Redis 不可用时降级为进程内限流
+	circuitBreaker  *redis.CircuitBreaker        // 上游熔断器（未启用时为 nil）
+	concurrencyLimiter *redis.ConcurrencyLimiter // 分布式并发限制（未启用 Redis 时为 nil）
 }
 
 // NewBaseHandler 创建一个新的 BaseHandler 实例
@@ -49,14 +64,47 @@ func NewBaseHandler(db *postgres.Store, cfg *config.Config, redisClient *redis.R
 	// 创建Redis服务实例（如果Redis可用）
 	var sessionService *redis.SessionService
 	var cacheService *redis.CacheService
-	var rateLimiter *redis.RateLimiter
+	var circuitBreaker *redis.CircuitBreaker
+	var concurrencyLimiter *redis.ConcurrencyLimiter
 	
 	if redisClient != nil {
 		sessionService = redis.NewSessionService(redisClient)
 		cacheService = redis.NewCacheService(redisClient)
-		rateLimiter = redis.NewRateLimiter(redisClient)
+		concurrencyLimiter = redis.NewConcurrencyLimiter(redisClient, time.Duration(cfg.CONCURRENCY_LEASE_TTL_SECONDS)*time.Second)
+		// 熔断阈值为0时不启用熔断
+		if cfg.CIRCUIT_BREAKER_FAILURE_THRESHOLD > 0 {
+			circuitBreaker = redis.NewCircuitBreaker(redisClient, redis.CircuitBreakerConfig{
+				FailureThreshold:    int64(cfg.CIRCUIT_BREAKER_FAILURE_THRESHOLD),
+				Cooldown:            time.Duration(cfg.CIRCUIT_BREAKER_COOLDOWN_SECONDS) * time.Second,
+				HalfOpenMaxRequests: int64(cfg.CIRCUIT_BREAKER_HALF_OPEN_MAX_REQUESTS),
+			})
+		}
 	}
+
+	// 限流在 Redis 不可用时降级为进程内限流；启动时 Redis 就不可用的，Redis 恢复后自动切换回来
+	limiterClient, limiterDegraded := redisClient, false
+	if limiterClient == nil && cfg.REDIS_HOST != "" {
+		limiterClient = redis.NewLazyRedisClient(cfg.REDIS_HOST, cfg.REDIS_PORT, cfg.REDIS_PASSWORD, cfg.REDIS_DB, cfg.REDIS_POOL_SIZE)
+		limiterDegraded = true
+	}
+	rateLimiter := redis.NewFallbackRateLimiter(limiterClient, redis.NewLocalRateLimiter(cfg.RATE_LIMIT_FALLBACK_PERCENT),
+		time.Duration(cfg.RATE_LIMIT_REDIS_PROBE_SECONDS)*time.Second, limiterDegraded)
+
+	upstreamStore := postgres.NewUpstreamStore(db)
+	healthChecker := proxy.NewHealthChecker(upstreamStore, proxy.HealthCheckConfig{
+		Interval:           time.Duration(cfg.UPSTREAM_HEALTH_CHECK_INTERVAL_SECONDS) * time.Second,
+		Timeout:            time.Duration(cfg.UPSTREAM_HEALTH_CHECK_TIMEOUT_SECONDS) * time.Second,
+		UnhealthyThreshold: cfg.UPSTREAM_UNHEALTHY_THRESHOLD,
+		HealthyThreshold:   cfg.UPSTREAM_HEALTHY_THRESHOLD,
+	})
 	
+	transportPool := proxy.NewTransportPool(proxy.TransportConfig{
+		ConnectTimeout:        time.Duration(cfg.UPSTREAM_CONNECT_TIMEOUT_SECONDS) * time.Second,
+		ResponseHeaderTimeout: time.Duration(cfg.UPSTREAM_RESPONSE_HEADER_TIMEOUT_SECONDS) * time.Second,
+		IdleConnTimeout:       time.Duration(cfg.UPSTREAM_IDLE_CONN_TIMEOUT_SECONDS) * time.Second,
+		MaxIdleConnsPerHost:   cfg.UPSTREAM_MAX_IDLE_CONNS_PER_HOST,
+	})
+
 	return &BaseHandler{
 		db:              db,
 		cfg:             cfg,
@@ -66,17 +114,68 @@ func NewBaseHandler(db *postgres.Store, cfg *config.Config, redisClient *redis.R
 		usageLogStore:   postgres.NewUsageLogStore(db),
 		apiDocStore:    postgres.NewAPIDocumentationStore(db.DB),
 		userAccountStore: postgres.NewUserAccountStore(db),
+		serviceModelStore: postgres.NewServiceModelStore(db),
+		fallbackChainStore: postgres.NewFallbackChainStore(db),
+		upstreamStore:   upstreamStore,
+		walletStore:     postgres.NewWalletStore(db),
+		ledgerStore:     postgres.NewLedgerStore(db),
+		commissionStore: postgres.NewCommissionStore(db),
+		payoutStore:     postgres.NewPayoutStore(db),
+		invoiceStore:    postgres.NewInvoiceStore(db),
+		slaStore:        postgres.NewSLAStore(db),
+		balancer:        proxy.NewBalancer(),
+		healthChecker:   healthChecker,
+		transportPool:   transportPool,
+		concurrencyQueue: proxy.NewFairQueue(proxy.FairQueueConfig{
+			MaxDepth:       cfg.CONCURRENCY_QUEUE_MAX_DEPTH,
+			MaxTenantDepth: cfg.CONCURRENCY_QUEUE_MAX_DEPTH_PER_BUYER,
+			Timeout:        time.Duration(cfg.CONCURRENCY_QUEUE_TIMEOUT_SECONDS) * time.Second,
+		}),
 		// Redis 服务（可能为 nil）
 		redisClient:     redisClient,
 		sessionService:  sessionService,
 		cacheService:    cacheService,
 		rateLimiter:     rateLimiter,
+		circuitBreaker:  circuitBreaker,
+		concurrencyLimiter: concurrencyLimiter,
+	}
+}
+
+// StartBackgroundWorkers 启动后台任务（历史平台密钥回填、上游健康检查、Redis 限流恢复探测、过期钱包冻结释放、账本对账、卖家结算单和买家发票生成、SLA 评估），ctx 取消时退出
+// 应在服务启动时调用一次
+func (h *BaseHandler) StartBackgroundWorkers(ctx context.Context) {
+	go h.backfillLegacyPlatformKeys()
+	go h.healthChecker.Run(ctx)
+	go h.rateLimiter.Run(ctx)
+	go h.releaseExpiredWalletHolds(ctx)
+	go h.reconcileLedgerPeriodically(ctx)
+	go h.generatePayoutStatementsPeriodically(ctx)
+	go h.generateInvoicesPeriodically(ctx)
+	go h.evaluateSLAPeriodically(ctx)
+}
+
+// backfillLegacyPlatformKeys 将迁移前加密保存的平台密钥转换为前缀加哈希，回填前这些密钥无法通过认证
+func (h *BaseHandler) backfillLegacyPlatformKeys() {
+	migrated, failed, err := h.platformKeyStore.BackfillLegacyPlatformAPIKeys(func(encrypted string) (string, error) {
+		return utils.DecryptAPIKey(encrypted, h.cfg.ENCRYPTION_KEY)
+	})
+	if err != nil {
+		fmt.Printf("Failed to backfill legacy platform API keys: %v\n", err)
+	}
+	if migrated > 0 || failed > 0 {
+		fmt.Printf("Backfilled %d legacy platform API keys, %d could not be decrypted\n", migrated, failed)
 	}
 }
 
 // SetupRoutes 设置所有 API 路由
 // 这是一个临时的路由设置函数，后续会根据 handler 拆分进行更细致的路由分组
 func (h *BaseHandler) SetupRoutes(router *gin.Engine) {
+	// 只采信可信反向代理传来的 X-Forwarded-For，否则客户端可以伪造来源IP绕过密钥的来源IP范围和IP限流
+	if err := router.SetTrustedProxies(trustedProxies(h.cfg.TRUSTED_PROXIES)); err != nil {
+		fmt.Printf("Invalid TRUSTED_PROXIES, trusting no proxies: %v\n", err)
+		router.SetTrustedProxies(nil)
+	}
+
 	// 健康检查端点
 	router.GET("/health", h.HealthCheck)
 
@@ -110,9 +209,29 @@ func (h *BaseHandler) SetupRoutes(router *gin.Engine) {
 			sellerRoutes.GET("/services", h.ListSellerAPIs)        // GET /api/v1/seller/services
 			sellerRoutes.PUT("/services/:service_id", h.UpdateAPIService)    // PUT /api/v1/seller/services/{service_id}
 			sellerRoutes.PUT("/services/:service_id/pricing", h.UpdateAPIPricing) // PUT /api/v1/seller/services/{service_id}/pricing
+			sellerRoutes.PUT("/services/:service_id/billing-policy", h.UpdateBillingPolicy) // PUT /api/v1/seller/services/{service_id}/billing-policy
+			sellerRoutes.PUT("/services/:service_id/rate-limits", h.UpdateServiceRateLimits) // PUT /api/v1/seller/services/{service_id}/rate-limits
 			sellerRoutes.DELETE("/services/:service_id", h.DeleteAPIService) // DELETE /api/v1/seller/services/{service_id}
+
+			// 服务模型目录管理路由（模型ID可能包含斜杠，使用通配参数）
+			sellerRoutes.GET("/services/:service_id/models", h.ListServiceModels)              // GET /api/v1/seller/services/{service_id}/models
+			sellerRoutes.POST("/services/:service_id/models", h.CreateServiceModel)            // POST /api/v1/seller/services/{service_id}/models
+			sellerRoutes.PUT("/services/:service_id/models/*model_id", h.UpdateServiceModel)    // PUT /api/v1/seller/services/{service_id}/models/{model_id}
+			sellerRoutes.DELETE("/services/:service_id/models/*model_id", h.DeleteServiceModel) // DELETE /api/v1/seller/services/{service_id}/models/{model_id}
+
+			// 服务上游池管理路由
+			sellerRoutes.GET("/services/:service_id/upstreams", h.ListServiceUpstreams)                  // GET /api/v1/seller/services/{service_id}/upstreams
+			sellerRoutes.POST("/services/:service_id/upstreams", h.CreateServiceUpstream)                // POST /api/v1/seller/services/{service_id}/upstreams
+			sellerRoutes.PUT("/services/:service_id/upstreams/:upstream_id", h.UpdateServiceUpstream)    // PUT /api/v1/seller/services/{service_id}/upstreams/{upstream_id}
+			sellerRoutes.DELETE("/services/:service_id/upstreams/:upstream_id", h.DeleteServiceUpstream) // DELETE /api/v1/seller/services/{service_id}/upstreams/{upstream_id}
 			sellerRoutes.GET("/usage", h.GetSellerUsage)           // GET /api/v1/seller/usage
 			sellerRoutes.GET("/usage/timeseries", h.GetSellerUsageTimeSeries) // GET /api/v1/seller/usage/timeseries
+
+			// 结算单
+			sellerRoutes.GET("/payout-statements", h.ListSellerPayoutStatements)                          // GET /api/v1/seller/payout-statements
+			sellerRoutes.GET("/payout-statements/:statement_id", h.GetSellerPayoutStatement)              // GET /api/v1/seller/payout-statements/{statement_id}
+			sellerRoutes.GET("/payout-statements/:statement_id/download", h.DownloadSellerPayoutStatement) // GET /api/v1/seller/payout-statements/{statement_id}/download
+			sellerRoutes.GET("/sla-evaluations", h.ListSellerSLAEvaluations)                             // GET /api/v1/seller/sla-evaluations
 			
 			// 卖家专用账户设置
 			sellerRoutes.GET("/account-settings", h.GetSellerAccountSettings)    // GET /api/v1/seller/account-settings
@@ -140,8 +259,26 @@ func (h *BaseHandler) SetupRoutes(router *gin.Engine) {
 			buyerRoutes.POST("/services/:service_id/subscribe", h.SubscribeToAPI)             // POST /api/v1/buyer/services/{service_id}/subscribe
 			buyerRoutes.DELETE("/subscriptions/:service_id", h.UnsubscribeFromAPI)            // DELETE /api/v1/buyer/subscriptions/{service_id}
 			buyerRoutes.GET("/subscriptions", h.GetBuyerSubscriptions)                        // GET /api/v1/buyer/subscriptions
+			buyerRoutes.GET("/subscriptions/:service_id/keys", h.ListSubscriptionKeys)                  // GET /api/v1/buyer/subscriptions/{service_id}/keys
+			buyerRoutes.POST("/subscriptions/:service_id/keys", h.CreateSubscriptionKey)                // POST /api/v1/buyer/subscriptions/{service_id}/keys
+			buyerRoutes.PUT("/subscriptions/:service_id/keys/:key_id/scopes", h.UpdateSubscriptionKeyScopes) // PUT /api/v1/buyer/subscriptions/{service_id}/keys/{key_id}/scopes
+			buyerRoutes.DELETE("/subscriptions/:service_id/keys/:key_id", h.RevokeSubscriptionKey)      // DELETE /api/v1/buyer/subscriptions/{service_id}/keys/{key_id}
+			buyerRoutes.POST("/subscriptions/:service_id/keys/:key_id/rotate", h.RotateSubscriptionKey) // POST /api/v1/buyer/subscriptions/{service_id}/keys/{key_id}/rotate
+			buyerRoutes.GET("/subscriptions/:service_id/token-budget", h.GetSubscriptionTokenBudget)    // GET /api/v1/buyer/subscriptions/{service_id}/token-budget
+			buyerRoutes.PUT("/subscriptions/:service_id/token-budget", h.UpdateSubscriptionTokenBudget) // PUT /api/v1/buyer/subscriptions/{service_id}/token-budget
 			buyerRoutes.GET("/usage", h.GetBuyerUsage)                                     // GET /api/v1/buyer/usage
 			buyerRoutes.GET("/usage/timeseries", h.GetBuyerUsageTimeSeries)               // GET /api/v1/buyer/usage/timeseries
+			buyerRoutes.GET("/wallet", h.GetBuyerWallet)                                   // GET /api/v1/buyer/wallet
+			buyerRoutes.GET("/wallet/topups", h.ListBuyerWalletTopUps)                     // GET /api/v1/buyer/wallet/topups
+			buyerRoutes.GET("/invoices", h.ListBuyerInvoices)                              // GET /api/v1/buyer/invoices
+			buyerRoutes.GET("/invoices/:invoice_id", h.GetBuyerInvoice)                    // GET /api/v1/buyer/invoices/{invoice_id}
+			buyerRoutes.GET("/invoices/:invoice_id/download", h.DownloadBuyerInvoice)      // GET /api/v1/buyer/invoices/{invoice_id}/download
+
+			// 回退链管理路由
+			buyerRoutes.GET("/fallback-chains", h.ListFallbackChains)                  // GET /api/v1/buyer/fallback-chains
+			buyerRoutes.POST("/fallback-chains", h.CreateFallbackChain)                // POST /api/v1/buyer/fallback-chains
+			buyerRoutes.PUT("/fallback-chains/:chain_id", h.UpdateFallbackChain)       // PUT /api/v1/buyer/fallback-chains/{chain_id}
+			buyerRoutes.DELETE("/fallback-chains/:chain_id", h.DeleteFallbackChain)    // DELETE /api/v1/buyer/fallback-chains/{chain_id}
 			
 			// 买家专用账户设置
 			buyerRoutes.GET("/account-settings", h.GetBuyerAccountSettings)    // GET /api/v1/buyer/account-settings
@@ -150,6 +287,48 @@ func (h *BaseHandler) SetupRoutes(router *gin.Engine) {
 			// API文档查看路由
 			buyerRoutes.GET("/services/:service_id/documentation", h.GetAPIDocumentation)     // GET /api/v1/buyer/services/{service_id}/documentation
 		}
+
+		// --- 平台管理路由 (Admin - 需认证) ---
+		adminRoutes := apiV1.Group("/admin")
+		adminRoutes.Use(middleware.AuthMiddleware(h.cfg.JWT_SECRET_KEY))
+		adminRoutes.Use(middleware.RequireRole("admin"))
+		{
+			adminRoutes.GET("/wallets/:user_id", h.AdminGetWallet)            // GET /api/v1/admin/wallets/{user_id}
+			adminRoutes.POST("/wallets/:user_id/topups", h.AdminTopUpWallet) // POST /api/v1/admin/wallets/{user_id}/topups
+
+			// 账本与对账
+			adminRoutes.GET("/ledger/accounts", h.ListLedgerAccounts)                              // GET /api/v1/admin/ledger/accounts
+			adminRoutes.GET("/ledger/accounts/:account_id/entries", h.ListLedgerAccountEntries)    // GET /api/v1/admin/ledger/accounts/{account_id}/entries
+			adminRoutes.GET("/ledger/reconciliation", h.ReconcileLedger)                           // GET /api/v1/admin/ledger/reconciliation
+			adminRoutes.POST("/usage-logs/:log_id/refunds", h.RefundUsage)                         // POST /api/v1/admin/usage-logs/{log_id}/refunds
+
+			// 平台抽成与卖家结算
+			adminRoutes.GET("/commission-rates", h.ListCommissionRates)                                   // GET /api/v1/admin/commission-rates
+			adminRoutes.PUT("/commission-rates", h.UpsertCommissionRate)                                  // PUT /api/v1/admin/commission-rates
+			adminRoutes.DELETE("/commission-rates/:rate_id", h.DeleteCommissionRate)                      // DELETE /api/v1/admin/commission-rates/{rate_id}
+			adminRoutes.GET("/payout-statements", h.AdminListPayoutStatements)                            // GET /api/v1/admin/payout-statements
+			adminRoutes.POST("/payout-statements/generate", h.GeneratePayoutStatements)                   // POST /api/v1/admin/payout-statements/generate
+			adminRoutes.GET("/payout-statements/:statement_id", h.AdminGetPayoutStatement)                // GET /api/v1/admin/payout-statements/{statement_id}
+			adminRoutes.POST("/payout-statements/:statement_id/mark-paid", h.MarkPayoutStatementPaid)     // POST /api/v1/admin/payout-statements/{statement_id}/mark-paid
+
+			// 买家发票与贷项通知单
+			adminRoutes.GET("/invoices", h.AdminListInvoices)                                     // GET /api/v1/admin/invoices
+			adminRoutes.POST("/invoices/generate", h.GenerateInvoices)                            // POST /api/v1/admin/invoices/generate
+			adminRoutes.GET("/invoices/:invoice_id", h.AdminGetInvoice)                           // GET /api/v1/admin/invoices/{invoice_id}
+			adminRoutes.PUT("/invoices/:invoice_id", h.UpdateInvoiceDraft)                        // PUT /api/v1/admin/invoices/{invoice_id}
+			adminRoutes.GET("/invoices/:invoice_id/download", h.AdminDownloadInvoice)             // GET /api/v1/admin/invoices/{invoice_id}/download
+			adminRoutes.POST("/invoices/:invoice_id/issue", h.IssueInvoice)                       // POST /api/v1/admin/invoices/{invoice_id}/issue
+			adminRoutes.POST("/invoices/:invoice_id/mark-paid", h.MarkInvoicePaid)                // POST /api/v1/admin/invoices/{invoice_id}/mark-paid
+			adminRoutes.POST("/invoices/:invoice_id/void", h.VoidInvoice)                         // POST /api/v1/admin/invoices/{invoice_id}/void
+			adminRoutes.POST("/invoices/:invoice_id/credit-notes", h.CreateCreditNote)            // POST /api/v1/admin/invoices/{invoice_id}/credit-notes
+
+			// SLA 评估与补偿
+			adminRoutes.GET("/sla-evaluations", h.AdminListSLAEvaluations)    // GET /api/v1/admin/sla-evaluations
+			adminRoutes.POST("/sla-evaluations/evaluate", h.EvaluateSLA)      // POST /api/v1/admin/sla-evaluations/evaluate
+
+			// 运行指标（expvar），包括各服务并发等待队列的深度和等待时间
+			adminRoutes.GET("/metrics", gin.WrapH(expvar.Handler())) // GET /api/v1/admin/metrics
+		}
 	}
 
 	// --- 平台 API 代理核心路由 (Platform API Proxy Core) ---
@@ -160,6 +339,17 @@ func (h *BaseHandler) SetupRoutes(router *gin.Engine) {
 		proxyRoutes.Any("/:service_id/*seller_path", h.ProxyToSellerService) // e.g., /proxy/v1/{service_id}/{seller_path...}
 	}
 
+	// --- OpenAI 兼容统一路由 (Unified OpenAI-compatible Router) ---
+	// 根据请求体中的 model 字段选择买家已订阅的服务
+	openAIRoutes := router.Group("/v1")
+	openAIRoutes.Use(middleware.BuyerAPIKeyAuthMiddleware(h.platformKeyStore))
+	{
+		openAIRoutes.GET("/models", h.ListModels)              // GET /v1/models
+		openAIRoutes.POST("/chat/completions", h.RouteByModel) // POST /v1/chat/completions
+		openAIRoutes.POST("/completions", h.RouteByModel)      // POST /v1/completions
+		openAIRoutes.POST("/embeddings", h.RouteByModel)       // POST /v1/embeddings
+	}
+
 	// Swaggo API 文档路由
 	// 访问 /swagger/index.html 查看 API 文档
 	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -170,6 +360,17 @@ func (h *BaseHandler) SetupRoutes(router *gin.Engine) {
 	})
 }
 
+// trustedProxies 解析逗号分隔的可信代理列表，为空时返回 nil（不信任任何代理）
+func trustedProxies(value string) []string {
+	var proxies []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			proxies = append(proxies, entry)
+		}
+	}
+	return proxies
+}
+
 // HealthCheck godoc
 // @Summary 服务健康检查
 // @Description 检查 API 平台服务是否正常运行
@@ -379,6 +580,16 @@ func (h *BaseHandler) RegisterAPIService(c *gin.Context) {
 		return
 	}
 
+	// 校验上游认证方式，未提供时按协议格式和密钥格式自动检测
+	var authScheme model.UpstreamAuthScheme
+	if req.AuthScheme != nil {
+		if err := proxy.NormalizeAuthScheme(req.AuthScheme); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auth_scheme: " + err.Error()})
+			return
+		}
+		authScheme = *req.AuthScheme
+	}
+
 	// 生成服务代理前缀
 	proxyPrefix := utils.GenerateServiceProxyPrefix(req.Name)
 
@@ -398,6 +609,12 @@ func (h *BaseHandler) RegisterAPIService(c *gin.Context) {
 		EncryptedOriginalAPIKey:  encryptedAPIKey,
 		PlatformProxyPrefix:      proxyPrefix,
 		IsActive:                 true,
+		APIFormat:                proxy.NormalizeFormat(req.APIFormat),
+		LBStrategy:               proxy.NormalizeLBStrategy(req.LBStrategy),
+		ConnectTimeoutMs:         req.ConnectTimeoutMs,
+		ResponseHeaderTimeoutMs:  req.ResponseHeaderTimeoutMs,
+		IdleConnTimeoutMs:        req.IdleConnTimeoutMs,
+		AuthScheme:               authScheme,
 	}
 
 	if err = h.apiServiceStore.CreateAPIService(apiService); err != nil {
@@ -405,6 +622,14 @@ func (h *BaseHandler) RegisterAPIService(c *gin.Context) {
 		return
 	}
 
+	// 保存服务提供的模型列表，供 /v1 统一路由使用
+	if len(req.Models) > 0 {
+		if err = h.serviceModelStore.ReplaceServiceModels(apiService.ServiceID, req.Models); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save service models"})
+			return
+		}
+	}
+
 	// 返回创建的API服务信息
 	response := model.APIServiceResponse{
 		ServiceID:           apiService.ServiceID,
@@ -412,6 +637,15 @@ func (h *BaseHandler) RegisterAPIService(c *gin.Context) {
 		Description:         apiService.Description,
 		PlatformProxyPrefix: apiService.PlatformProxyPrefix,
 		IsActive:            apiService.IsActive,
+		APIFormat:           apiService.APIFormat,
+		LBStrategy:          apiService.LBStrategy,
+		ConnectTimeoutMs:        apiService.ConnectTimeoutMs,
+		ResponseHeaderTimeoutMs: apiService.ResponseHeaderTimeoutMs,
+		IdleConnTimeoutMs:       apiService.IdleConnTimeoutMs,
+		AuthScheme:              &apiService.AuthScheme,
+		BillingPolicy:           &apiService.BillingPolicy,
+		RateLimits:              &apiService.RateLimits,
+		Models:              req.Models,
 	}
 
 	c.JSON(http.StatusCreated, response)
@@ -464,6 +698,18 @@ func (h *BaseHandler) ListSellerAPIs(c *gin.Context) {
 			PricingModel:        service.PricingModel,
 			PricePerCall:        service.PricePerCall,
 			PricePerToken:       service.PricePerToken,
+			PricingPlan:         service.PricingPlan,
+			APIFormat:           service.APIFormat,
+			LBStrategy:          service.LBStrategy,
+			ConnectTimeoutMs:        service.ConnectTimeoutMs,
+			ResponseHeaderTimeoutMs: service.ResponseHeaderTimeoutMs,
+			IdleConnTimeoutMs:       service.IdleConnTimeoutMs,
+			AuthScheme:              &service.AuthScheme,
+			BillingPolicy:           &service.BillingPolicy,
+			RateLimits:              &service.RateLimits,
+		}
+		if models, err := h.serviceModelStore.GetModelsByServiceID(service.ServiceID); err == nil {
+			response.Models = models
 		}
 		responses = append(responses, response)
 	}
@@ -521,6 +767,7 @@ func (h *BaseHandler) ListAvailableAPIs(c *gin.Context) {
 			PricePerCall:        service.PricePerCall,
 			PricingModel:        service.PricingModel,
 			PricePerToken:       service.PricePerToken,
+			PricingPlan:         service.PricingPlan,
 			TotalCalls:          service.TotalCalls,
 			SubscriberCount:     service.SubscriberCount,
 			Features:            features,
@@ -606,6 +853,7 @@ func (h *BaseHandler) GetAPIDetail(c *gin.Context) {
 			PricePerCall:        apiService.PricePerCall,
 			PricingModel:        apiService.PricingModel,
 			PricePerToken:       apiService.PricePerToken,
+			PricingPlan:         apiService.PricingPlan,
 			TotalCalls:          apiService.TotalCalls,
 			SubscriberCount:     apiService.SubscriberCount,
 			Features:            features,
@@ -619,7 +867,7 @@ func (h *BaseHandler) GetAPIDetail(c *gin.Context) {
 	if isSubscribed {
 		// TODO: 如果需要显示订阅详情，需要额外查询
 		// response.SubscriptionDate = &subscriptionDetails.CreatedAt
-		// response.PlatformAPIKey = subscriptionDetails.PlatformAPIKey
+		// response.PlatformAPIKey = utils.MaskPlatformAPIKey(subscriptionDetails.KeyPrefix)
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -627,7 +875,7 @@ func (h *BaseHandler) GetAPIDetail(c *gin.Context) {
 
 // SubscribeToAPI godoc
 // @Summary 订阅 API 服务
-// @Description 买家选择一个 API 服务进行订阅，平台将为此生成一个唯一的 API 密钥和代理 URL。
+// @Description 买家选择一个 API 服务进行订阅，平台将为此生成一个唯一的 API 密钥和代理 URL。完整密钥只在此响应中返回一次，平台仅保存其哈希。
 // @Tags Buyer
 // @Accept json
 // @Produce json
@@ -680,22 +928,17 @@ func (h *BaseHandler) SubscribeToAPI(c *gin.Context) {
 		return
 	}
 
-	// 生成平台API密钥
+	// 生成平台API密钥，只保存公开前缀和哈希，完整密钥仅在此次响应中返回
 	platformAPIKey := utils.GeneratePlatformAPIKey()
 
-	// 加密API密钥
-	encryptedKey, err := utils.EncryptAPIKey(platformAPIKey, h.cfg.ENCRYPTION_KEY)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt API key"})
-		return
-	}
-
 	// 创建平台API密钥记录
 	platformKey := &model.PlatformAPIKey{
-		BuyerUserID:    userID,
-		ServiceID:      serviceID,
-		PlatformAPIKey: encryptedKey,
-		IsActive:       true,
+		BuyerUserID: userID,
+		ServiceID:   serviceID,
+		Name:        "default",
+		KeyPrefix:   utils.PlatformAPIKeyPrefix(platformAPIKey),
+		KeyHash:     utils.HashPlatformAPIKey(platformAPIKey),
+		IsActive:    true,
 	}
 
 	err = h.platformKeyStore.CreatePlatformAPIKey(platformKey)
@@ -706,7 +949,7 @@ func (h *BaseHandler) SubscribeToAPI(c *gin.Context) {
 
 	// 返回订阅信息
 	response := model.SubscribeToAPIResponse{
-		PlatformAPIKey:   platformAPIKey, // 返回未加密的密钥给用户
+		PlatformAPIKey:   platformAPIKey, // 完整密钥只返回这一次
 		PlatformProxyURL: apiService.PlatformProxyPrefix,
 	}
 
@@ -715,7 +958,7 @@ func (h *BaseHandler) SubscribeToAPI(c *gin.Context) {
 
 // GetBuyerSubscriptions godoc
 // @Summary 获取买家订阅列表
-// @Description 获取买家已订阅的API服务列表，包括订阅详情和API密钥信息。
+// @Description 获取买家已订阅的API服务列表，包括订阅详情和订阅下各个命名密钥的脱敏前缀。
 // @Tags Buyer
 // @Accept json
 // @Produce json
@@ -738,9 +981,29 @@ func (h *BaseHandler) GetBuyerSubscriptions(c *gin.Context) {
 		return
 	}
 
+	// 一个订阅可以有多个命名密钥，按服务分组；密钥按创建时间倒序返回，
+	// 订阅的展示密钥取最新的有效密钥，订阅日期取最早的密钥
+	var serviceOrder []int64
+	keysByService := make(map[int64][]*model.PlatformAPIKey)
+	for _, key := range subscriptions {
+		if _, ok := keysByService[key.ServiceID]; !ok {
+			serviceOrder = append(serviceOrder, key.ServiceID)
+		}
+		keysByService[key.ServiceID] = append(keysByService[key.ServiceID], key)
+	}
+
 	// 构建响应数据
 	var apis []gin.H
-	for _, subscription := range subscriptions {
+	for _, serviceID := range serviceOrder {
+		keys := keysByService[serviceID]
+		subscription := keys[0]
+		for _, key := range keys {
+			if key.IsActive {
+				subscription = key
+				break
+			}
+		}
+
 		// 获取对应的API服务信息
 		apiService, err := h.apiServiceStore.GetAPIServiceByID(subscription.ServiceID)
 		if err != nil {
@@ -754,6 +1017,19 @@ func (h *BaseHandler) GetBuyerSubscriptions(c *gin.Context) {
 			sellerUsername = seller.Username
 		}
 
+		keyInfos := make([]gin.H, 0, len(keys))
+		for _, key := range keys {
+			keyInfos = append(keyInfos, gin.H{
+				"key_id":           key.KeyID,
+				"name":             key.Name,
+				"platform_api_key": utils.MaskPlatformAPIKey(key.KeyPrefix),
+				"is_active":        key.IsActive,
+				"expires_at":       key.ExpiresAt,
+				"revoked_at":       key.RevokedAt,
+				"created_at":       key.CreatedAt,
+			})
+		}
+
 		api := gin.H{
 			"service_id":                subscription.ServiceID,
 			"name":                      apiService.Name,
@@ -761,8 +1037,10 @@ func (h *BaseHandler) GetBuyerSubscriptions(c *gin.Context) {
 			"platform_proxy_prefix":     apiService.PlatformProxyPrefix,
 			"is_active":                 apiService.IsActive && subscription.IsActive,
 			"seller_username":           sellerUsername,
-			"platform_api_key":          subscription.PlatformAPIKey,
-			"subscription_date":         subscription.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
+			"platform_api_key":          utils.MaskPlatformAPIKey(subscription.KeyPrefix), // 只展示脱敏后的前缀
+			"key_prefix":                subscription.KeyPrefix,
+			"keys":                      keyInfos,
+			"subscription_date":         keys[len(keys)-1].CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 			"expires_at":                nil,
 			"price_per_call":            apiService.PricePerCall,
 			"pricing_model":             apiService.PricingModel,
@@ -956,190 +1234,6 @@ func (h *BaseHandler) UnsubscribeFromAPI(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Successfully unsubscribed from API"})
 }
 
-// @Summary Proxy API request to seller's service
-// @Description Proxies an incoming API request to the registered seller's API service, handling authentication and usage tracking.
-// @Tags Platform API Proxy
-// @Accept json
-// @Produce json
-// @Param platform_api_key header string true "Platform API Key issued to the buyer"
-// @Param proxiedPath path string true "Path to be proxied to the seller's service"
-// @Router /proxy/{proxiedPath} [POST] // Note: Handles ANY HTTP method via Gin's Any() method
-// @Success 200 {object} map[string]interface{} "Proxied response from seller's service"
-// @Failure 400 {object} model.ErrorResponse "Bad Request (e.g., missing or invalid platform API key)"
-// @Failure 401 {object} model.ErrorResponse "Unauthorized (e.g., invalid or revoked platform API key)"
-// @Failure 403 {object} model.ErrorResponse "Forbidden (e.g., API key not authorized for this service or quota exceeded)"
-// @Failure 404 {object} model.ErrorResponse "Not Found (e.g., seller service endpoint not found)"
-// @Failure 500 {object} model.ErrorResponse "Internal Server Error (e.g., platform error or error from seller's service)"
-// @Failure 502 {object} model.ErrorResponse "Bad Gateway (e.g., error connecting to seller's service)"
-// @Security ApiKeyAuth
-func (h *BaseHandler) ProxyToSellerService(c *gin.Context) {
-	// 从平台认证中间件获取信息
-	platformKey, _ := middleware.GetPlatformKeyFromContext(c)
-	apiService, _ := middleware.GetAPIServiceFromContext(c)
-	buyerUserID, _ := middleware.GetBuyerUserIDFromContext(c)
-	serviceID, _ := middleware.GetServiceIDFromContext(c)
-
-	if platformKey == nil || apiService == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid platform API key"})
-		return
-	}
-
-	// 获取卖家路径
-	sellerPath := c.Param("seller_path")
-	if sellerPath == "" {
-		sellerPath = "/"
-	}
-
-	// 构建目标URL - 智能路径合并避免重复路径段
-	baseURL, err := url.Parse(apiService.OriginalEndpointURL)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid original endpoint URL"})
-		return
-	}
-	
-	// 智能合并路径，避免重复路径段
-	mergedPath := smartPathJoin(baseURL.Path, sellerPath)
-	baseURL.Path = mergedPath
-	
-	// 添加查询参数
-	if c.Request.URL.RawQuery != "" {
-		baseURL.RawQuery = c.Request.URL.RawQuery
-	}
-	
-	targetURL := baseURL.String()
-
-	// 创建HTTP客户端
-	client := &http.Client{
-		Timeout: 10 * time.Minute,
-	}
-
-	// 读取请求体用于token分析
-	var requestBody []byte
-	if c.Request.Body != nil {
-		requestBody, _ = io.ReadAll(c.Request.Body)
-		// 重新设置请求体供后续使用
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
-	}
-
-	// 创建请求
-	req, err := http.NewRequest(c.Request.Method, targetURL, bytes.NewBuffer(requestBody))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
-		return
-	}
-
-	// 解密卖家的原始API密钥
-	originalAPIKey, err := utils.DecryptAPIKey(apiService.EncryptedOriginalAPIKey, h.cfg.ENCRYPTION_KEY)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt seller API key"})
-		return
-	}
-
-	// 复制请求头（排除一些不需要的头）
-	for key, values := range c.Request.Header {
-		if key != "Authorization" && key != "Host" && key != "X-API-Key" {
-			for _, value := range values {
-				req.Header.Add(key, value)
-			}
-		}
-	}
-
-	// 添加卖家的原始API密钥到请求头
-	// 这里假设卖家API使用Authorization Bearer token或X-API-Key头
-	// 根据实际情况可能需要调整
-	if originalAPIKey != "" {
-		// 尝试检测API密钥的格式
-		if len(originalAPIKey) > 7 && originalAPIKey[:7] == "Bearer " {
-			// 如果已经包含Bearer前缀
-			req.Header.Set("Authorization", originalAPIKey)
-		} else if len(originalAPIKey) > 4 && (originalAPIKey[:4] == "sk-" || originalAPIKey[:4] == "pk-") {
-			// 看起来像API密钥格式，使用Bearer
-			req.Header.Set("Authorization", "Bearer "+originalAPIKey)
-		} else {
-			// 默认使用X-API-Key头
-			req.Header.Set("X-API-Key", originalAPIKey)
-		}
-	}
-
-	// 记录请求开始时间
-	startTime := time.Now()
-
-	// 发送请求
-	resp, err := client.Do(req)
-	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to proxy request"})
-		return
-	}
-	defer resp.Body.Close()
-
-	// 计算响应时间
-	responseTime := time.Since(startTime)
-
-	// 读取响应体
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read response"})
-		return
-	}
-
-	// 解析token使用情况
-	tokenParser := utils.NewTokenParser()
-	modelName := tokenParser.ExtractModelName(requestBody, body)
-	tokenUsage, tokenErr := tokenParser.ParseTokenUsage(body, modelName)
-
-	// 记录使用日志
-	usageLog := &model.UsageLog{
-		BuyerUserID:        buyerUserID,
-		APIServiceID:       serviceID,
-		PlatformAPIKeyID:   platformKey.KeyID,
-		SellerUserID:       apiService.SellerUserID,
-		RequestPath:        sellerPath,
-		RequestMethod:      c.Request.Method,
-		ResponseStatusCode: resp.StatusCode,
-		ProcessingTimeMs:   int(responseTime.Milliseconds()),
-		IsSuccess:          resp.StatusCode >= 200 && resp.StatusCode < 300,
-		RequestTimestamp:   startTime,
-		RequestSizeBytes:   len(requestBody),
-		ResponseSizeBytes:  len(body),
-	}
-
-	// 如果成功解析token使用情况，添加到日志中
-	if tokenErr == nil && tokenUsage != nil {
-		usageLog.InputTokens = tokenUsage.InputTokens
-		usageLog.OutputTokens = tokenUsage.OutputTokens
-		usageLog.TotalTokens = tokenUsage.TotalTokens
-		usageLog.ModelName = tokenUsage.ModelName
-		
-		// 根据API服务的定价模式计算费用
-		if apiService.PricingModel == "per_token" {
-			// 按token计费：使用API服务设定的每token价格
-			usageLog.Cost = float64(tokenUsage.TotalTokens) * apiService.PricePerToken
-		} else {
-			// 按次计费：使用API服务设定的每次调用价格
-			usageLog.Cost = apiService.PricePerCall
-		}
-	}
-
-	// 异步记录使用日志（不阻塞响应）
-	go func() {
-		if err := h.usageLogStore.CreateUsageLog(usageLog); err != nil {
-			// 记录日志错误，但不影响主流程
-			fmt.Printf("Failed to log usage: %v\n", err)
-		}
-	}()
-
-	// 复制响应头
-	for key, values := range resp.Header {
-		for _, value := range values {
-			c.Header(key, value)
-		}
-	}
-
-	// 设置状态码并返回响应体
-	c.Status(resp.StatusCode)
-	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
-}
-
 // GetSellerUsage godoc
 // @Summary Get seller usage statistics
 // @Description Get usage statistics for seller's API services
@@ -1235,6 +1329,44 @@ func (h *BaseHandler) UpdateAPIService(c *gin.Context) {
 		PlatformProxyPrefix:      existingService.PlatformProxyPrefix,      // 保持不变
 		IsActive:                 existingService.IsActive,                 // 默认保持原有状态
 		Documentation:            req.Documentation,                        // 更新文档内容
+		APIFormat:                existingService.APIFormat,                // 默认保持原有协议格式
+		LBStrategy:               existingService.LBStrategy,               // 默认保持原有负载均衡策略
+		ConnectTimeoutMs:         existingService.ConnectTimeoutMs,         // 默认保持原有传输超时
+		ResponseHeaderTimeoutMs:  existingService.ResponseHeaderTimeoutMs,
+		IdleConnTimeoutMs:        existingService.IdleConnTimeoutMs,
+		AuthScheme:               existingService.AuthScheme,               // 默认保持原有认证方式
+		BillingPolicy:            existingService.BillingPolicy,            // 计费策略通过 billing-policy 接口单独修改
+		RateLimits:               existingService.RateLimits,               // 限流通过 rate-limits 接口单独修改
+	}
+
+	// 如果提供了api_format，则更新上游协议格式
+	if req.APIFormat != "" {
+		updatedService.APIFormat = proxy.NormalizeFormat(req.APIFormat)
+	}
+
+	// 如果提供了lb_strategy，则更新上游池负载均衡策略
+	if req.LBStrategy != "" {
+		updatedService.LBStrategy = proxy.NormalizeLBStrategy(req.LBStrategy)
+	}
+
+	// 如果提供了传输超时，则更新（0 表示恢复平台默认值）
+	if req.ConnectTimeoutMs != nil {
+		updatedService.ConnectTimeoutMs = *req.ConnectTimeoutMs
+	}
+	if req.ResponseHeaderTimeoutMs != nil {
+		updatedService.ResponseHeaderTimeoutMs = *req.ResponseHeaderTimeoutMs
+	}
+	if req.IdleConnTimeoutMs != nil {
+		updatedService.IdleConnTimeoutMs = *req.IdleConnTimeoutMs
+	}
+
+	// 如果提供了auth_scheme，则整体替换上游认证方式（type 为空表示恢复自动检测）
+	if req.AuthScheme != nil {
+		if err := proxy.NormalizeAuthScheme(req.AuthScheme); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid auth_scheme: " + err.Error()})
+			return
+		}
+		updatedService.AuthScheme = *req.AuthScheme
 	}
 
 	// 如果提供了新的API密钥，则加密并更新
@@ -1258,6 +1390,14 @@ func (h *BaseHandler) UpdateAPIService(c *gin.Context) {
 		return
 	}
 
+	// 如果提供了模型列表，则整体替换
+	if req.Models != nil {
+		if err = h.serviceModelStore.ReplaceServiceModels(serviceID, req.Models); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service models"})
+			return
+		}
+	}
+
 	// 返回更新后的API服务信息
 	response := model.APIServiceResponse{
 		ServiceID:           serviceID,
@@ -1265,6 +1405,17 @@ func (h *BaseHandler) UpdateAPIService(c *gin.Context) {
 		Description:         updatedService.Description,
 		PlatformProxyPrefix: updatedService.PlatformProxyPrefix,
 		IsActive:            updatedService.IsActive,
+		APIFormat:           updatedService.APIFormat,
+		LBStrategy:          updatedService.LBStrategy,
+		ConnectTimeoutMs:        updatedService.ConnectTimeoutMs,
+		ResponseHeaderTimeoutMs: updatedService.ResponseHeaderTimeoutMs,
+		IdleConnTimeoutMs:       updatedService.IdleConnTimeoutMs,
+		AuthScheme:              &updatedService.AuthScheme,
+		BillingPolicy:           &updatedService.BillingPolicy,
+		RateLimits:              &updatedService.RateLimits,
+	}
+	if models, err := h.serviceModelStore.GetModelsByServiceID(serviceID); err == nil {
+		response.Models = models
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -1272,7 +1423,8 @@ func (h *BaseHandler) UpdateAPIService(c *gin.Context) {
 
 // UpdateAPIPricing godoc
 // @Summary 卖家更新 API 定价设置 (Seller updates API pricing settings)
-// @Description 卖家更新自己注册的 API 服务的定价模式和价格
+// @Description 卖家更新自己注册的 API 服务的定价模式和价格。可以提供阶梯定价计划（累进或总量分档、输入/输出 token 分别定价、每月免费额度），
+// @Description 计划按买家当月(UTC)累计用量计费；不提供计划时清除已有计划，按固定价格计费
 // @Tags Seller
 // @Accept json
 // @Produce json
@@ -1309,6 +1461,18 @@ func (h *BaseHandler) UpdateAPIPricing(c *gin.Context) {
 		return
 	}
 
+	// 提供阶梯定价计划时，定价模式随计划的计量单位设置
+	if req.PricingPlan != nil {
+		if err := utils.NormalizePricingPlan(req.PricingPlan); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pricing_plan: " + err.Error()})
+			return
+		}
+		req.PricingModel = "per_call"
+		if req.PricingPlan.Unit == model.PricingUnitToken {
+			req.PricingModel = "per_token"
+		}
+	}
+
 	// 验证定价模式
 	if req.PricingModel != "per_call" && req.PricingModel != "per_token" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pricing model. Must be 'per_call' or 'per_token'"})
@@ -1334,7 +1498,7 @@ func (h *BaseHandler) UpdateAPIPricing(c *gin.Context) {
 	}
 
 	// 更新API定价
-	err = h.apiServiceStore.UpdateAPIPricing(serviceID, userID, req.PricingModel, req.PricePerCall, req.PricePerToken)
+	err = h.apiServiceStore.UpdateAPIPricing(serviceID, userID, req.PricingModel, req.PricePerCall, req.PricePerToken, req.PricingPlan)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update API pricing"})
 		return
@@ -2323,56 +2487,6 @@ func (h *BaseHandler) UpdateBuyerAccountSettings(c *gin.Context) {
 	c.JSON(http.StatusOK, account)
 }
 
-// smartPathJoin 智能合并两个路径，避免重复路径段
-// 例如: smartPathJoin("/v1", "v1/chat/completions") -> "/v1/chat/completions"
-//      smartPathJoin("/", "v1/chat/completions") -> "/v1/chat/completions"
-//      smartPathJoin("/api", "v1/chat/completions") -> "/api/v1/chat/completions"
-func smartPathJoin(basePath, sellerPath string) string {
-	// 清理路径
-	basePath = path.Clean(basePath)
-	sellerPath = strings.TrimPrefix(sellerPath, "/")
-	
-	// 如果基础路径是根路径，直接返回卖家路径
-	if basePath == "/" || basePath == "." {
-		return "/" + sellerPath
-	}
-	
-	// 分割路径段
-	baseSegments := strings.Split(strings.Trim(basePath, "/"), "/")
-	sellerSegments := strings.Split(sellerPath, "/")
-	
-	// 如果卖家路径为空，返回基础路径
-	if len(sellerSegments) == 1 && sellerSegments[0] == "" {
-		return basePath
-	}
-	
-	// 检查是否有重复的路径段
-	if len(baseSegments) > 0 && len(sellerSegments) > 0 {
-		// 如果基础路径的最后一段与卖家路径的第一段相同，跳过重复
-		if baseSegments[len(baseSegments)-1] == sellerSegments[0] {
-			sellerSegments = sellerSegments[1:]
-		}
-	}
-	
-	// 合并路径段
-	allSegments := append(baseSegments, sellerSegments...)
-	
-	// 过滤空段
-	var filteredSegments []string
-	for _, segment := range allSegments {
-		if segment != "" {
-			filteredSegments = append(filteredSegments, segment)
-		}
-	}
-	
-	// 构建最终路径
-	if len(filteredSegments) == 0 {
-		return "/"
-	}
-	
-	return "/" + strings.Join(filteredSegments, "/")
-}
-
 // TODO: 将上述占位符 handler 替换为具体的实现，并可能拆分到不同的文件中，
 // 例如 auth_handler.go, seller_handler.go, buyer_handler.go, proxy_handler.go, usage_handler.go。
 // 每个具体的 handler 将接收其所需的依赖 (如特定的 store 实例, JWT 密钥, 加密密钥等)。
\ No newline at end of file
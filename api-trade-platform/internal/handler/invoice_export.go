@@ -0,0 +1,295 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+)
+
+// UBL 2.1 文档命名空间
+const (
+	ublInvoiceNamespace    = "urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"
+	ublCreditNoteNamespace = "urn:oasis:names:specification:ubl:schema:xsd:CreditNote-2"
+	ublCACNamespace        = "urn:oasis:names:specification:ubl:schema:xsd:CommonAggregateComponents-2"
+	ublCBCNamespace        = "urn:oasis:names:specification:ubl:schema:xsd:CommonBasicComponents-2"
+)
+
+// ublDateLayout UBL 日期格式
+const ublDateLayout = "2006-01-02"
+
+// invoiceCSV 将发票按明细导出为 CSV，之后依次为小计、折扣、税额和合计行
+func invoiceCSV(invoice *model.Invoice) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	records := [][]string{{"line_no", "service_id", "service_name", "model_name", "description", "call_count",
+		"total_tokens", "charged_micros", "refunded_micros", "amount_micros"}}
+	for _, item := range invoice.Items {
+		records = append(records, []string{
+			strconv.Itoa(item.LineNo),
+			strconv.FormatInt(item.ServiceID, 10),
+			item.ServiceName,
+			item.ModelName,
+			item.Description,
+			strconv.FormatInt(item.CallCount, 10),
+			strconv.FormatInt(item.TotalTokens, 10),
+			strconv.FormatInt(item.ChargedMicros, 10),
+			strconv.FormatInt(item.RefundedMicros, 10),
+			strconv.FormatInt(item.AmountMicros, 10),
+		})
+	}
+	summary := []struct {
+		label  string
+		micros int64
+	}{
+		{"SUBTOTAL", invoice.SubtotalMicros},
+		{"DISCOUNT", -invoice.DiscountMicros},
+		{fmt.Sprintf("TAX %s%%", bpsPercent(invoice.TaxRateBps)), invoice.TaxMicros},
+		{"TOTAL", invoice.TotalMicros},
+	}
+	for _, row := range summary {
+		records = append(records, []string{"", "", "", "", row.label, "", "", "", "", strconv.FormatInt(row.micros, 10)})
+	}
+
+	if err := writer.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// bpsPercent 将基点格式化为百分比数值，例如 1950 为 "19.50"
+func bpsPercent(bps int) string {
+	return fmt.Sprintf("%d.%02d", bps/100, bps%100)
+}
+
+// ublAmount 带币种的金额
+type ublAmount struct {
+	CurrencyID string `xml:"currencyID,attr"`
+	Value      string `xml:",chardata"`
+}
+
+// ublQuantity 带计量单位的数量
+type ublQuantity struct {
+	UnitCode string `xml:"unitCode,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type ublPeriod struct {
+	StartDate string `xml:"cbc:StartDate"`
+	EndDate   string `xml:"cbc:EndDate"`
+}
+
+type ublBillingReference struct {
+	InvoiceDocumentReference struct {
+		ID string `xml:"cbc:ID"`
+	} `xml:"cac:InvoiceDocumentReference"`
+}
+
+type ublParty struct {
+	Party struct {
+		PartyName struct {
+			Name string `xml:"cbc:Name"`
+		} `xml:"cac:PartyName"`
+		PartyTaxScheme *ublPartyTaxScheme `xml:"cac:PartyTaxScheme,omitempty"`
+		Contact        *ublContact        `xml:"cac:Contact,omitempty"`
+	} `xml:"cac:Party"`
+}
+
+type ublPartyTaxScheme struct {
+	CompanyID string       `xml:"cbc:CompanyID"`
+	TaxScheme ublTaxScheme `xml:"cac:TaxScheme"`
+}
+
+type ublContact struct {
+	ElectronicMail string `xml:"cbc:ElectronicMail"`
+}
+
+type ublTaxScheme struct {
+	ID string `xml:"cbc:ID"`
+}
+
+type ublAllowanceCharge struct {
+	ChargeIndicator       bool      `xml:"cbc:ChargeIndicator"`
+	AllowanceChargeReason string    `xml:"cbc:AllowanceChargeReason"`
+	Amount                ublAmount `xml:"cbc:Amount"`
+}
+
+type ublTaxTotal struct {
+	TaxAmount   ublAmount `xml:"cbc:TaxAmount"`
+	TaxSubtotal struct {
+		TaxableAmount ublAmount `xml:"cbc:TaxableAmount"`
+		TaxAmount     ublAmount `xml:"cbc:TaxAmount"`
+		TaxCategory   struct {
+			ID        string       `xml:"cbc:ID"`
+			Percent   string       `xml:"cbc:Percent"`
+			TaxScheme ublTaxScheme `xml:"cac:TaxScheme"`
+		} `xml:"cac:TaxCategory"`
+	} `xml:"cac:TaxSubtotal"`
+}
+
+type ublMonetaryTotal struct {
+	LineExtensionAmount  ublAmount  `xml:"cbc:LineExtensionAmount"`
+	TaxExclusiveAmount   ublAmount  `xml:"cbc:TaxExclusiveAmount"`
+	TaxInclusiveAmount   ublAmount  `xml:"cbc:TaxInclusiveAmount"`
+	AllowanceTotalAmount *ublAmount `xml:"cbc:AllowanceTotalAmount,omitempty"`
+	PayableAmount        ublAmount  `xml:"cbc:PayableAmount"`
+}
+
+// ublLine 发票行或贷项通知单行，两者只有数量元素的名称不同
+type ublLine struct {
+	ID                  string       `xml:"cbc:ID"`
+	InvoicedQuantity    *ublQuantity `xml:"cbc:InvoicedQuantity,omitempty"`
+	CreditedQuantity    *ublQuantity `xml:"cbc:CreditedQuantity,omitempty"`
+	LineExtensionAmount ublAmount    `xml:"cbc:LineExtensionAmount"`
+	Item                struct {
+		Description string `xml:"cbc:Description,omitempty"`
+		Name        string `xml:"cbc:Name"`
+	} `xml:"cac:Item"`
+}
+
+// ublDocument UBL 2.1 的 Invoice 或 CreditNote 文档，元素顺序与 UBL 模式一致
+type ublDocument struct {
+	XMLName                 xml.Name
+	Xmlns                   string               `xml:"xmlns,attr"`
+	XmlnsCAC                string               `xml:"xmlns:cac,attr"`
+	XmlnsCBC                string               `xml:"xmlns:cbc,attr"`
+	UBLVersionID            string               `xml:"cbc:UBLVersionID"`
+	ID                      string               `xml:"cbc:ID"`
+	IssueDate               string               `xml:"cbc:IssueDate"`
+	InvoiceTypeCode         string               `xml:"cbc:InvoiceTypeCode,omitempty"`
+	CreditNoteTypeCode      string               `xml:"cbc:CreditNoteTypeCode,omitempty"`
+	Note                    string               `xml:"cbc:Note,omitempty"`
+	DocumentCurrencyCode    string               `xml:"cbc:DocumentCurrencyCode"`
+	InvoicePeriod           ublPeriod            `xml:"cac:InvoicePeriod"`
+	BillingReference        *ublBillingReference `xml:"cac:BillingReference,omitempty"`
+	AccountingSupplierParty ublParty             `xml:"cac:AccountingSupplierParty"`
+	AccountingCustomerParty ublParty             `xml:"cac:AccountingCustomerParty"`
+	AllowanceCharge         *ublAllowanceCharge  `xml:"cac:AllowanceCharge,omitempty"`
+	TaxTotal                ublTaxTotal          `xml:"cac:TaxTotal"`
+	LegalMonetaryTotal      ublMonetaryTotal     `xml:"cac:LegalMonetaryTotal"`
+	InvoiceLines            []ublLine            `xml:"cac:InvoiceLine,omitempty"`
+	CreditNoteLines         []ublLine            `xml:"cac:CreditNoteLine,omitempty"`
+}
+
+// invoiceUBL 将发票导出为 UBL 2.1 风格的 XML（发票为 Invoice，贷项通知单为 CreditNote），金额保留 6 位小数
+// originalNumber 为贷项通知单更正的发票编号，发票传空字符串
+func invoiceUBL(invoice *model.Invoice, originalNumber string) ([]byte, error) {
+	amount := func(micros int64) ublAmount {
+		return ublAmount{CurrencyID: invoice.Currency, Value: utils.FormatMicros(micros)}
+	}
+
+	doc := ublDocument{
+		XmlnsCAC:             ublCACNamespace,
+		XmlnsCBC:             ublCBCNamespace,
+		UBLVersionID:         "2.1",
+		ID:                   invoice.InvoiceNumber,
+		Note:                 invoice.Notes,
+		DocumentCurrencyCode: invoice.Currency,
+		InvoicePeriod: ublPeriod{
+			StartDate: invoice.PeriodStart.Format(ublDateLayout),
+			EndDate:   invoice.PeriodEnd.AddDate(0, 0, -1).Format(ublDateLayout),
+		},
+	}
+	if doc.ID == "" {
+		doc.ID = fmt.Sprintf("DRAFT-%d", invoice.InvoiceID)
+	}
+	issueDate := invoice.CreatedAt
+	if invoice.IssuedAt != nil {
+		issueDate = *invoice.IssuedAt
+	}
+	doc.IssueDate = issueDate.UTC().Format(ublDateLayout)
+
+	isCreditNote := invoice.InvoiceType == model.InvoiceTypeCreditNote
+	if isCreditNote {
+		doc.XMLName = xml.Name{Local: "CreditNote"}
+		doc.Xmlns = ublCreditNoteNamespace
+		doc.CreditNoteTypeCode = "381"
+		if originalNumber != "" {
+			doc.BillingReference = &ublBillingReference{}
+			doc.BillingReference.InvoiceDocumentReference.ID = originalNumber
+		}
+	} else {
+		doc.XMLName = xml.Name{Local: "Invoice"}
+		doc.Xmlns = ublInvoiceNamespace
+		doc.InvoiceTypeCode = "380"
+	}
+
+	doc.AccountingSupplierParty.Party.PartyName.Name = invoice.IssuerName
+	if invoice.IssuerTaxID != "" {
+		doc.AccountingSupplierParty.Party.PartyTaxScheme = &ublPartyTaxScheme{
+			CompanyID: invoice.IssuerTaxID,
+			TaxScheme: ublTaxScheme{ID: "VAT"},
+		}
+	}
+	doc.AccountingCustomerParty.Party.PartyName.Name = invoice.BuyerName
+	if invoice.BuyerEmail != "" {
+		doc.AccountingCustomerParty.Party.Contact = &ublContact{ElectronicMail: invoice.BuyerEmail}
+	}
+
+	if invoice.DiscountMicros > 0 {
+		doc.AllowanceCharge = &ublAllowanceCharge{
+			ChargeIndicator:       false,
+			AllowanceChargeReason: "Discount",
+			Amount:                amount(invoice.DiscountMicros),
+		}
+		discount := amount(invoice.DiscountMicros)
+		doc.LegalMonetaryTotal.AllowanceTotalAmount = &discount
+	}
+
+	doc.TaxTotal.TaxAmount = amount(invoice.TaxMicros)
+	doc.TaxTotal.TaxSubtotal.TaxableAmount = amount(invoice.NetMicros())
+	doc.TaxTotal.TaxSubtotal.TaxAmount = amount(invoice.TaxMicros)
+	doc.TaxTotal.TaxSubtotal.TaxCategory.ID = "S"
+	if invoice.TaxRateBps == 0 {
+		doc.TaxTotal.TaxSubtotal.TaxCategory.ID = "Z"
+	}
+	doc.TaxTotal.TaxSubtotal.TaxCategory.Percent = bpsPercent(invoice.TaxRateBps)
+	doc.TaxTotal.TaxSubtotal.TaxCategory.TaxScheme.ID = "VAT"
+
+	doc.LegalMonetaryTotal.LineExtensionAmount = amount(invoice.SubtotalMicros)
+	doc.LegalMonetaryTotal.TaxExclusiveAmount = amount(invoice.NetMicros())
+	doc.LegalMonetaryTotal.TaxInclusiveAmount = amount(invoice.TotalMicros)
+	doc.LegalMonetaryTotal.PayableAmount = amount(invoice.TotalMicros)
+
+	for _, item := range invoice.Items {
+		line := ublLine{
+			ID:                  strconv.Itoa(item.LineNo),
+			LineExtensionAmount: amount(item.AmountMicros),
+		}
+		// 数量为调用次数，单位 C62（个）；贷项通知单的更正行数量为 1
+		quantity := &ublQuantity{UnitCode: "C62", Value: strconv.FormatInt(item.CallCount, 10)}
+		if isCreditNote {
+			quantity.Value = "1"
+			line.CreditedQuantity = quantity
+		} else {
+			line.InvoicedQuantity = quantity
+		}
+		line.Item.Name = item.Description
+		if item.TotalTokens > 0 {
+			line.Item.Description = fmt.Sprintf("%d tokens", item.TotalTokens)
+		}
+		if isCreditNote {
+			doc.CreditNoteLines = append(doc.CreditNoteLines, line)
+		} else {
+			doc.InvoiceLines = append(doc.InvoiceLines, line)
+		}
+	}
+
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), body...), nil
+}
+
+// invoiceFilename 下载文件名，例如 INV-2026-000001.xml，草稿为 invoice-draft-12.csv
+func invoiceFilename(invoice *model.Invoice, format string) string {
+	if invoice.InvoiceNumber != "" {
+		return fmt.Sprintf("%s.%s", invoice.InvoiceNumber, format)
+	}
+	return fmt.Sprintf("%s-draft-%d.%s", invoice.InvoiceType, invoice.InvoiceID, format)
+}
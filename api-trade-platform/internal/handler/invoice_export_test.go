@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"encoding/csv"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testInvoice() *model.Invoice {
+	issuedAt := time.Date(2026, 10, 2, 9, 0, 0, 0, time.UTC)
+	invoice := &model.Invoice{
+		InvoiceID:     7,
+		InvoiceType:   model.InvoiceTypeInvoice,
+		InvoiceNumber: "INV-2026-000007",
+		PeriodStart:   time.Date(2026, 9, 1, 0, 0, 0, 0, time.UTC),
+		PeriodEnd:     time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC),
+		Currency:      "USD",
+		IssuerName:    "API Trade Platform",
+		BuyerName:     "acme",
+		BuyerEmail:    "billing@acme.example",
+		TaxRateBps:    1900,
+		Status:        model.InvoiceStatusIssued,
+		IssuedAt:      &issuedAt,
+		Items: []model.InvoiceItem{
+			{LineNo: 1, ServiceID: 1, ServiceName: "OpenAI Proxy", ModelName: "gpt-4o-mini", Description: "OpenAI Proxy - gpt-4o-mini",
+				CallCount: 10, TotalTokens: 5000, ChargedMicros: 1200000, RefundedMicros: 200000, AmountMicros: 1000000},
+			{LineNo: 2, ServiceID: 2, ServiceName: "Weather API", Description: "Weather API", CallCount: 3, AmountMicros: 333333},
+		},
+		DiscountMicros: 33333,
+	}
+	invoice.ApplyTotals()
+	return invoice
+}
+
+func TestInvoiceTotals(t *testing.T) {
+	invoice := testInvoice()
+	if invoice.SubtotalMicros != 1333333 || invoice.TaxMicros != 247000 || invoice.TotalMicros != 1547000 {
+		t.Errorf("totals = %d/%d/%d, want 1333333/247000/1547000", invoice.SubtotalMicros, invoice.TaxMicros, invoice.TotalMicros)
+	}
+}
+
+func TestInvoiceCSV(t *testing.T) {
+	body, err := invoiceCSV(testInvoice())
+	if err != nil {
+		t.Fatalf("invoiceCSV returned error: %v", err)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(body))).ReadAll()
+	if err != nil {
+		t.Fatalf("invalid CSV: %v", err)
+	}
+	if len(records) != 7 {
+		t.Fatalf("got %d rows, want header + 2 items + 4 summary rows", len(records))
+	}
+	if last := records[len(records)-1]; last[4] != "TOTAL" || last[9] != "1547000" {
+		t.Errorf("total row = %v", last)
+	}
+}
+
+func TestInvoiceUBL(t *testing.T) {
+	body, err := invoiceUBL(testInvoice(), "")
+	if err != nil {
+		t.Fatalf("invoiceUBL returned error: %v", err)
+	}
+	xmlText := string(body)
+	for _, want := range []string{
+		`<Invoice xmlns="urn:oasis:names:specification:ubl:schema:xsd:Invoice-2"`,
+		`<cbc:ID>INV-2026-000007</cbc:ID>`,
+		`<cbc:IssueDate>2026-10-02</cbc:IssueDate>`,
+		`<cbc:EndDate>2026-09-30</cbc:EndDate>`,
+		`<cbc:InvoicedQuantity unitCode="C62">10</cbc:InvoicedQuantity>`,
+		`<cbc:Percent>19.00</cbc:Percent>`,
+		`<cbc:AllowanceTotalAmount currencyID="USD">0.033333</cbc:AllowanceTotalAmount>`,
+		`<cbc:PayableAmount currencyID="USD">1.547000</cbc:PayableAmount>`,
+	} {
+		if !strings.Contains(xmlText, want) {
+			t.Errorf("UBL output missing %s", want)
+		}
+	}
+	if err := xml.Unmarshal(body, new(struct{})); err != nil {
+		t.Errorf("UBL output is not well-formed: %v", err)
+	}
+
+	creditNote := testInvoice()
+	creditNote.InvoiceType = model.InvoiceTypeCreditNote
+	creditNote.InvoiceNumber = "CN-2026-000001"
+	body, err = invoiceUBL(creditNote, "INV-2026-000007")
+	if err != nil {
+		t.Fatalf("invoiceUBL returned error: %v", err)
+	}
+	xmlText = string(body)
+	for _, want := range []string{
+		`<CreditNote xmlns="urn:oasis:names:specification:ubl:schema:xsd:CreditNote-2"`,
+		`<cbc:CreditNoteTypeCode>381</cbc:CreditNoteTypeCode>`,
+		`<cac:InvoiceDocumentReference>`,
+		`<cac:CreditNoteLine>`,
+	} {
+		if !strings.Contains(xmlText, want) {
+			t.Errorf("credit note output missing %s", want)
+		}
+	}
+}
@@ -0,0 +1,603 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/store/postgres"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invoiceGenerateInterval 自动生成发票的检查间隔，与结算单一样在月份结束 payoutStatementGenerateDelay 后生成
+const invoiceGenerateInterval = time.Hour
+
+// invoiceDefaults 生成发票草稿时使用的开票方信息和默认税率
+func (h *BaseHandler) invoiceDefaults() postgres.InvoiceDefaults {
+	taxRateBps := h.cfg.INVOICE_TAX_RATE_BPS
+	if taxRateBps < 0 {
+		taxRateBps = 0
+	}
+	if taxRateBps > 10000 {
+		taxRateBps = 10000
+	}
+	return postgres.InvoiceDefaults{
+		IssuerName:  h.cfg.INVOICE_ISSUER_NAME,
+		IssuerTaxID: h.cfg.INVOICE_ISSUER_TAX_ID,
+		TaxRateBps:  taxRateBps,
+	}
+}
+
+// generateInvoices 为账单月份生成发票草稿，配置了自动开具时直接开具新生成的草稿，返回生成数量
+func (h *BaseHandler) generateInvoices(periodStart, periodEnd time.Time) (int, error) {
+	invoiceIDs, err := h.invoiceStore.GenerateInvoices(periodStart, periodEnd, h.invoiceDefaults())
+	if h.cfg.INVOICE_AUTO_ISSUE {
+		for _, invoiceID := range invoiceIDs {
+			if _, err := h.invoiceStore.IssueInvoice(invoiceID); err != nil {
+				fmt.Printf("Failed to issue invoice %d: %v\n", invoiceID, err)
+			}
+		}
+	}
+	return len(invoiceIDs), err
+}
+
+// generateInvoicesPeriodically 定期为上个月生成买家发票（已生成的不会重复生成），ctx 取消时退出
+func (h *BaseHandler) generateInvoicesPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(invoiceGenerateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UTC()
+		periodEnd := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if now.Before(periodEnd.Add(payoutStatementGenerateDelay)) {
+			continue
+		}
+		periodStart := periodEnd.AddDate(0, -1, 0)
+
+		generated, err := h.generateInvoices(periodStart, periodEnd)
+		if err != nil {
+			fmt.Printf("Failed to generate invoices for %s: %v\n", periodStart.Format(payoutStatementMonthLayout), err)
+			continue
+		}
+		if generated > 0 {
+			fmt.Printf("Generated %d invoices for %s\n", generated, periodStart.Format(payoutStatementMonthLayout))
+		}
+	}
+}
+
+// getInvoice 解析路径中的发票ID并获取发票，buyerUserID 不为 0 时只允许访问该买家已开具的发票，失败时写入错误响应
+func (h *BaseHandler) getInvoice(c *gin.Context, buyerUserID int64) (*model.Invoice, bool) {
+	invoiceID, err := strconv.ParseInt(c.Param("invoice_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return nil, false
+	}
+
+	invoice, err := h.invoiceStore.GetInvoice(invoiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get invoice"})
+		return nil, false
+	}
+	if invoice == nil || (buyerUserID != 0 && (invoice.BuyerUserID != buyerUserID || invoice.IssuedAt == nil)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return nil, false
+	}
+	return invoice, true
+}
+
+// writeInvoiceStatusConflict 发票状态不允许当前操作时返回 409
+func writeInvoiceStatusConflict(c *gin.Context, invoice *model.Invoice, action string) {
+	c.JSON(http.StatusConflict, gin.H{
+		"error":  fmt.Sprintf("Invoice is %s and cannot be %s", invoice.Status, action),
+		"code":   "invalid_invoice_status",
+		"status": invoice.Status,
+	})
+}
+
+// downloadInvoice 以 JSON、CSV 或 UBL XML 文件返回发票
+func (h *BaseHandler) downloadInvoice(c *gin.Context, invoice *model.Invoice) {
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+	switch format {
+	case "json":
+		body, err = json.MarshalIndent(invoice, "", "  ")
+		contentType = "application/json; charset=utf-8"
+	case "csv":
+		body, err = invoiceCSV(invoice)
+		contentType = "text/csv; charset=utf-8"
+	case "xml":
+		var originalNumber string
+		if invoice.OriginalInvoiceID != nil {
+			original, getErr := h.invoiceStore.GetInvoice(*invoice.OriginalInvoiceID)
+			if getErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get original invoice"})
+				return
+			}
+			if original != nil {
+				originalNumber = original.InvoiceNumber
+			}
+		}
+		body, err = invoiceUBL(invoice, originalNumber)
+		contentType = "application/xml; charset=utf-8"
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected json, csv or xml"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export invoice"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, invoiceFilename(invoice, format)))
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// ListBuyerInvoices godoc
+// @Summary 获取我的发票 (List my invoices)
+// @Description 买家查看已开具的月度发票和贷项通知单（不含明细），按月份倒序。草稿在开具前不可见
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Param type query string false "文档类型 (Document type)" Enums(invoice, credit_note)
+// @Success 200 {object} object{invoices=[]model.Invoice} "发票列表 (Invoices)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/invoices [get]
+func (h *BaseHandler) ListBuyerInvoices(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	invoiceType := c.Query("type")
+	if invoiceType != "" && invoiceType != model.InvoiceTypeInvoice && invoiceType != model.InvoiceTypeCreditNote {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type, expected invoice or credit_note"})
+		return
+	}
+
+	invoices, err := h.invoiceStore.ListInvoices(userID, "", invoiceType, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invoices"})
+		return
+	}
+	if invoices == nil {
+		invoices = []*model.Invoice{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// GetBuyerInvoice godoc
+// @Summary 获取发票详情 (Get invoice)
+// @Description 买家查看自己的一份已开具发票或贷项通知单及明细
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Success 200 {object} model.Invoice "发票 (Invoice)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/invoices/{invoice_id} [get]
+func (h *BaseHandler) GetBuyerInvoice(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	invoice, ok := h.getInvoice(c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// DownloadBuyerInvoice godoc
+// @Summary 下载发票 (Download invoice)
+// @Description 买家以 JSON、CSV 或 UBL 2.1 风格的 XML 文件下载已开具的发票或贷项通知单，金额为微美元（XML 中为 6 位小数的美元金额）
+// @Tags Buyer
+// @Produce json,text/csv,application/xml
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Param format query string false "文件格式，默认 json (File format)" Enums(json, csv, xml)
+// @Success 200 {file} file "发票文件 (Invoice file)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/invoices/{invoice_id}/download [get]
+func (h *BaseHandler) DownloadBuyerInvoice(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	invoice, ok := h.getInvoice(c, userID)
+	if !ok {
+		return
+	}
+
+	h.downloadInvoice(c, invoice)
+}
+
+// AdminListInvoices godoc
+// @Summary 获取买家发票 (List invoices)
+// @Description 管理员查看所有发票和贷项通知单（含草稿，不含明细），可按状态、类型和买家过滤
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param status query string false "发票状态 (Invoice status)" Enums(draft, issued, paid, void)
+// @Param type query string false "文档类型 (Document type)" Enums(invoice, credit_note)
+// @Param buyer_user_id query int false "买家用户 ID (Buyer user ID)"
+// @Success 200 {object} object{invoices=[]model.Invoice} "发票列表 (Invoices)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices [get]
+func (h *BaseHandler) AdminListInvoices(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", model.InvoiceStatusDraft, model.InvoiceStatusIssued, model.InvoiceStatusPaid, model.InvoiceStatusVoid:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, expected draft, issued, paid or void"})
+		return
+	}
+
+	invoiceType := c.Query("type")
+	if invoiceType != "" && invoiceType != model.InvoiceTypeInvoice && invoiceType != model.InvoiceTypeCreditNote {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid type, expected invoice or credit_note"})
+		return
+	}
+
+	var buyerUserID int64
+	if buyerStr := c.Query("buyer_user_id"); buyerStr != "" {
+		parsed, err := strconv.ParseInt(buyerStr, 10, 64)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid buyer user ID"})
+			return
+		}
+		buyerUserID = parsed
+	}
+
+	invoices, err := h.invoiceStore.ListInvoices(buyerUserID, status, invoiceType, true)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list invoices"})
+		return
+	}
+	if invoices == nil {
+		invoices = []*model.Invoice{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invoices": invoices})
+}
+
+// AdminGetInvoice godoc
+// @Summary 获取发票详情 (Get invoice)
+// @Description 管理员查看任意发票或贷项通知单及明细，包括草稿
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Success 200 {object} model.Invoice "发票 (Invoice)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/{invoice_id} [get]
+func (h *BaseHandler) AdminGetInvoice(c *gin.Context) {
+	invoice, ok := h.getInvoice(c, 0)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, invoice)
+}
+
+// AdminDownloadInvoice godoc
+// @Summary 下载发票 (Download invoice)
+// @Description 管理员以 JSON、CSV 或 UBL 2.1 风格的 XML 文件下载任意发票，草稿使用 DRAFT 编号
+// @Tags Admin
+// @Produce json,text/csv,application/xml
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Param format query string false "文件格式，默认 json (File format)" Enums(json, csv, xml)
+// @Success 200 {file} file "发票文件 (Invoice file)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/{invoice_id}/download [get]
+func (h *BaseHandler) AdminDownloadInvoice(c *gin.Context) {
+	invoice, ok := h.getInvoice(c, 0)
+	if !ok {
+		return
+	}
+
+	h.downloadInvoice(c, invoice)
+}
+
+// GenerateInvoices godoc
+// @Summary 生成月度发票 (Generate invoices)
+// @Description 管理员为已结束的月份生成买家发票草稿，已有未作废发票的买家保持不变。后台任务也会在每月结束后自动生成上个月的发票
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body model.GenerateInvoicesRequest true "账单月份 (Billing month)"
+// @Success 200 {object} object{month=string,generated=int} "生成结果 (Generation result)"
+// @Failure 400 {object} object{error=string} "请求参数错误或月份未结束 (Invalid input or month not finished)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/generate [post]
+func (h *BaseHandler) GenerateInvoices(c *gin.Context) {
+	var req model.GenerateInvoicesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	periodStart, err := time.Parse(payoutStatementMonthLayout, req.Month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month, expected YYYY-MM"})
+		return
+	}
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	if time.Now().Before(periodEnd) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invoices can only be generated for finished months"})
+		return
+	}
+
+	generated, err := h.generateInvoices(periodStart, periodEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"month": req.Month, "generated": generated})
+}
+
+// UpdateInvoiceDraft godoc
+// @Summary 修改发票草稿 (Update invoice draft)
+// @Description 管理员修改草稿的折扣、税率和备注并重新计算金额。发票开具后不可修改，只能通过贷项通知单更正
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Param request body model.UpdateInvoiceDraftRequest true "草稿修改 (Draft changes)"
+// @Success 200 {object} model.Invoice "修改后的草稿 (Updated draft)"
+// @Failure 400 {object} object{error=string} "请求参数错误或折扣超过小计 (Invalid input or discount exceeds subtotal)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 409 {object} object{error=string,code=string,status=string} "发票不是草稿 (Invoice is not a draft)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/{invoice_id} [put]
+func (h *BaseHandler) UpdateInvoiceDraft(c *gin.Context) {
+	var req model.UpdateInvoiceDraftRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	invoice, ok := h.getInvoice(c, 0)
+	if !ok {
+		return
+	}
+	if invoice.Status != model.InvoiceStatusDraft {
+		writeInvoiceStatusConflict(c, invoice, "modified")
+		return
+	}
+
+	if req.DiscountMicros != nil {
+		invoice.DiscountMicros = *req.DiscountMicros
+	}
+	if req.TaxRateBps != nil {
+		invoice.TaxRateBps = *req.TaxRateBps
+	}
+	if req.Notes != nil {
+		invoice.Notes = strings.TrimSpace(*req.Notes)
+	}
+	if invoice.DiscountMicros > 0 && invoice.DiscountMicros > invoice.SubtotalMicros {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Discount exceeds the invoice subtotal", "subtotal_micros": invoice.SubtotalMicros})
+		return
+	}
+	invoice.ApplyTotals()
+
+	updated, err := h.invoiceStore.UpdateDraft(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice"})
+		return
+	}
+	if !updated {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice is no longer a draft", "code": "invalid_invoice_status"})
+		return
+	}
+
+	invoice, ok = h.getInvoice(c, 0)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, invoice)
+}
+
+// IssueInvoice godoc
+// @Summary 开具发票 (Issue invoice)
+// @Description 管理员开具发票或贷项通知单草稿并分配连续编号，开具后买家可见且不可再修改
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Success 200 {object} model.Invoice "已开具的发票 (Issued invoice)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 409 {object} object{error=string,code=string,status=string} "发票不是草稿 (Invoice is not a draft)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/{invoice_id}/issue [post]
+func (h *BaseHandler) IssueInvoice(c *gin.Context) {
+	h.transitionInvoice(c, "issued", func(invoice *model.Invoice) (bool, error) {
+		return h.invoiceStore.IssueInvoice(invoice.InvoiceID)
+	})
+}
+
+// MarkInvoicePaid godoc
+// @Summary 标记发票已支付 (Mark invoice paid)
+// @Description 管理员在收到买家付款后标记已开具的发票已支付
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Param request body model.MarkInvoicePaidRequest true "付款信息 (Payment details)"
+// @Success 200 {object} model.Invoice "已支付的发票 (Paid invoice)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 409 {object} object{error=string,code=string,status=string} "发票不是已开具状态 (Invoice is not issued)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/{invoice_id}/mark-paid [post]
+func (h *BaseHandler) MarkInvoicePaid(c *gin.Context) {
+	var req model.MarkInvoicePaidRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	h.transitionInvoice(c, "marked paid", func(invoice *model.Invoice) (bool, error) {
+		return h.invoiceStore.MarkInvoicePaid(invoice.InvoiceID, req.PaymentReference)
+	})
+}
+
+// VoidInvoice godoc
+// @Summary 作废发票草稿 (Void invoice draft)
+// @Description 管理员作废草稿，之后可以为同一月份重新生成发票。已开具的发票不能作废，需通过贷项通知单冲减
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param invoice_id path int true "发票 ID (Invoice ID)"
+// @Success 200 {object} model.Invoice "已作废的草稿 (Voided draft)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 409 {object} object{error=string,code=string,status=string} "发票不是草稿 (Invoice is not a draft)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/{invoice_id}/void [post]
+func (h *BaseHandler) VoidInvoice(c *gin.Context) {
+	h.transitionInvoice(c, "voided", func(invoice *model.Invoice) (bool, error) {
+		return h.invoiceStore.VoidInvoice(invoice.InvoiceID)
+	})
+}
+
+// transitionInvoice 获取路径中的发票并执行状态变更，变更成功时返回最新的发票，状态不允许时返回 409
+func (h *BaseHandler) transitionInvoice(c *gin.Context, action string, transition func(*model.Invoice) (bool, error)) {
+	invoice, ok := h.getInvoice(c, 0)
+	if !ok {
+		return
+	}
+
+	changed, err := transition(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice status"})
+		return
+	}
+	if !changed {
+		writeInvoiceStatusConflict(c, invoice, action)
+		return
+	}
+
+	invoice, ok = h.getInvoice(c, 0)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, invoice)
+}
+
+// CreateCreditNote godoc
+// @Summary 创建贷项通知单 (Create credit note)
+// @Description 管理员为已开具或已支付的发票创建贷项通知单草稿，用于更正不可修改的发票。贷项通知单按原发票税率计税，开具方式与发票相同
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param invoice_id path int true "原发票 ID (Original invoice ID)"
+// @Param request body model.CreateCreditNoteRequest true "冲减信息 (Credit details)"
+// @Success 201 {object} model.Invoice "贷项通知单草稿 (Credit note draft)"
+// @Failure 400 {object} object{error=string} "请求参数错误或超过可冲减金额 (Invalid input or credit exceeds the creditable amount)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "发票不存在 (Invoice not found)"
+// @Failure 409 {object} object{error=string,code=string,status=string} "发票未开具 (Invoice is not issued)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/invoices/{invoice_id}/credit-notes [post]
+func (h *BaseHandler) CreateCreditNote(c *gin.Context) {
+	var req model.CreateCreditNoteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	invoice, ok := h.getInvoice(c, 0)
+	if !ok {
+		return
+	}
+	if invoice.InvoiceType != model.InvoiceTypeInvoice {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Credit notes can only be created for invoices"})
+		return
+	}
+	if invoice.Status != model.InvoiceStatusIssued && invoice.Status != model.InvoiceStatusPaid {
+		writeInvoiceStatusConflict(c, invoice, "credited")
+		return
+	}
+
+	creditable, err := h.invoiceStore.GetCreditableMicros(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get creditable amount"})
+		return
+	}
+	if req.AmountMicros > creditable {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "Credit exceeds the creditable amount",
+			"creditable_micros": creditable,
+		})
+		return
+	}
+
+	creditNote, err := h.invoiceStore.CreateCreditNote(invoice.InvoiceID, req.AmountMicros, req.Reason)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create credit note"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, creditNote)
+}
@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ledgerReconcileSettleDelay 对账窗口的结束时间比当前时间提前的量，为异步写入的使用日志和分录留出时间
+const ledgerReconcileSettleDelay = 5 * time.Minute
+
+// ledgerEntryListLimit 账户分录列表默认和最多返回的条数
+const ledgerEntryListLimit = 100
+
+// reconcileLedgerPeriodically 定期核对最近一段时间的账本与使用日志，发现差异时输出报告，ctx 取消时退出
+func (h *BaseHandler) reconcileLedgerPeriodically(ctx context.Context) {
+	interval := time.Duration(h.cfg.LEDGER_RECONCILE_INTERVAL_SECONDS) * time.Second
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		until := time.Now().Add(-ledgerReconcileSettleDelay)
+		since := until.Add(-time.Duration(h.cfg.LEDGER_RECONCILE_WINDOW_HOURS) * time.Hour)
+		report, err := h.ledgerStore.Reconcile(since, until)
+		if err != nil {
+			fmt.Printf("Ledger reconciliation failed: %v\n", err)
+			continue
+		}
+		if !report.InSync {
+			fmt.Printf("Ledger drift detected between %s and %s: drift=%d micros, missing=%d (%d micros), mismatched=%d, unexpected=%d, unbalanced=%d, wallet drifts=%d\n",
+				since.Format(time.RFC3339), until.Format(time.RFC3339), report.DriftMicros, report.MissingEntries,
+				report.MissingMicros, report.AmountMismatches, report.UnexpectedEntries, report.UnbalancedEntries,
+				len(report.WalletDrifts))
+		}
+	}
+}
+
+// ListLedgerAccounts godoc
+// @Summary 获取账本账户 (List ledger accounts)
+// @Description 管理员查看账本中的买家钱包、卖家收入、平台收入和平台资金账户及其余额。余额为正表示平台欠账户所有者的金额
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param account_type query string false "账户类型 (Account type)" Enums(buyer_wallet, seller_earnings, platform_revenue, platform_cash)
+// @Success 200 {object} object{accounts=[]model.LedgerAccount} "账户列表 (Accounts)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/ledger/accounts [get]
+func (h *BaseHandler) ListLedgerAccounts(c *gin.Context) {
+	accounts, err := h.ledgerStore.ListAccounts(c.Query("account_type"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list ledger accounts"})
+		return
+	}
+	if accounts == nil {
+		accounts = []*model.LedgerAccount{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// ListLedgerAccountEntries godoc
+// @Summary 获取账户分录 (List ledger account entries)
+// @Description 管理员查看记入某个账户的最近分录，每条分录包含其全部记账
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param account_id path int true "账户 ID (Account ID)"
+// @Param limit query int false "返回条数，最多100 (Max entries, up to 100)"
+// @Success 200 {object} object{entries=[]model.LedgerEntry} "分录列表 (Entries)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/ledger/accounts/{account_id}/entries [get]
+func (h *BaseHandler) ListLedgerAccountEntries(c *gin.Context) {
+	accountID, err := strconv.ParseInt(c.Param("account_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID"})
+		return
+	}
+
+	limit := ledgerEntryListLimit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if parsed, err := strconv.Atoi(limitStr); err == nil && parsed > 0 && parsed < limit {
+			limit = parsed
+		}
+	}
+
+	entries, err := h.ledgerStore.ListAccountEntries(accountID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list ledger entries"})
+		return
+	}
+	if entries == nil {
+		entries = []*model.LedgerEntry{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+// ReconcileLedger godoc
+// @Summary 账本对账 (Reconcile ledger)
+// @Description 管理员核对时间窗口内的计费调用与账本计费分录，并检查分录平衡与钱包余额。默认窗口为配置的对账小时数，截止到5分钟前
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param since query string false "开始时间 RFC3339 (Window start)"
+// @Param until query string false "结束时间 RFC3339 (Window end)"
+// @Success 200 {object} model.LedgerReconciliationReport "对账报告 (Reconciliation report)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/ledger/reconciliation [get]
+func (h *BaseHandler) ReconcileLedger(c *gin.Context) {
+	until := time.Now().Add(-ledgerReconcileSettleDelay)
+	if untilStr := c.Query("until"); untilStr != "" {
+		parsed, err := time.Parse(time.RFC3339, untilStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until, expected RFC3339"})
+			return
+		}
+		until = parsed
+	}
+
+	since := until.Add(-time.Duration(h.cfg.LEDGER_RECONCILE_WINDOW_HOURS) * time.Hour)
+	if sinceStr := c.Query("since"); sinceStr != "" {
+		parsed, err := time.Parse(time.RFC3339, sinceStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected RFC3339"})
+			return
+		}
+		since = parsed
+	}
+	if !since.Before(until) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "since must be before until"})
+		return
+	}
+
+	report, err := h.ledgerStore.Reconcile(since, until)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reconcile ledger"})
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// RefundUsage godoc
+// @Summary 退还调用费用 (Refund a billed call)
+// @Description 管理员退还一次计费调用的部分或全部费用：买家钱包增加退款金额，卖家和平台收入按原计费比例冲减。累计退款不能超过原费用
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param log_id path int true "使用日志 ID (Usage log ID)"
+// @Param request body model.UsageRefundRequest true "退款信息 (Refund details)"
+// @Success 201 {object} object{entry=model.LedgerEntry} "退款分录 (Refund entry)"
+// @Failure 400 {object} object{error=string,refundable_micros=int} "请求参数错误或超过可退金额 (Invalid input or amount exceeds refundable)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "调用没有计费记录 (Call was not billed)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/usage-logs/{log_id}/refunds [post]
+func (h *BaseHandler) RefundUsage(c *gin.Context) {
+	adminUserID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || adminUserID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	logID, err := strconv.ParseInt(c.Param("log_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid usage log ID"})
+		return
+	}
+
+	var req model.UsageRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	refundable, charged, err := h.ledgerStore.GetRefundableMicros(logID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get usage charge"})
+		return
+	}
+	if !charged {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Usage log has no ledger charge"})
+		return
+	}
+	if refundable <= 0 || req.AmountMicros > refundable {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "Refund exceeds the refundable amount",
+			"refundable_micros": refundable,
+		})
+		return
+	}
+
+	entry, err := h.ledgerStore.RefundUsage(logID, req.AmountMicros, req.Reason, adminUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refund usage"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"entry": entry})
+}
@@ -0,0 +1,380 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// payoutStatementGenerateDelay 月份结束后等待多久再自动生成结算单，为异步写入的计费分录留出时间
+const payoutStatementGenerateDelay = time.Hour
+
+// payoutStatementGenerateInterval 自动生成结算单的检查间隔
+const payoutStatementGenerateInterval = time.Hour
+
+// payoutStatementMonthLayout 结算月份的格式
+const payoutStatementMonthLayout = "2006-01"
+
+// generatePayoutStatementsPeriodically 定期为上个月生成卖家结算单（已生成的不会重复生成），ctx 取消时退出
+func (h *BaseHandler) generatePayoutStatementsPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(payoutStatementGenerateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UTC()
+		periodEnd := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if now.Before(periodEnd.Add(payoutStatementGenerateDelay)) {
+			continue
+		}
+		periodStart := periodEnd.AddDate(0, -1, 0)
+
+		generated, err := h.payoutStore.GenerateStatements(periodStart, periodEnd)
+		if err != nil {
+			fmt.Printf("Failed to generate payout statements for %s: %v\n", periodStart.Format(payoutStatementMonthLayout), err)
+			continue
+		}
+		if generated > 0 {
+			fmt.Printf("Generated %d payout statements for %s\n", generated, periodStart.Format(payoutStatementMonthLayout))
+		}
+	}
+}
+
+// getPayoutStatement 解析路径中的结算单ID并获取结算单，sellerUserID 不为 0 时只允许访问该卖家的结算单，失败时写入错误响应
+func (h *BaseHandler) getPayoutStatement(c *gin.Context, sellerUserID int64) (*model.PayoutStatement, bool) {
+	statementID, err := strconv.ParseInt(c.Param("statement_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement ID"})
+		return nil, false
+	}
+
+	statement, err := h.payoutStore.GetStatement(statementID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get payout statement"})
+		return nil, false
+	}
+	if statement == nil || (sellerUserID != 0 && statement.SellerUserID != sellerUserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payout statement not found"})
+		return nil, false
+	}
+	return statement, true
+}
+
+// payoutStatementCSV 将结算单按服务明细导出为 CSV，最后一行为合计
+func payoutStatementCSV(statement *model.PayoutStatement) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+
+	records := [][]string{{"service_id", "service_name", "call_count", "gross_micros", "commission_micros", "refunds_micros", "net_payable_micros"}}
+	for _, item := range statement.Items {
+		records = append(records, []string{
+			strconv.FormatInt(item.ServiceID, 10),
+			item.ServiceName,
+			strconv.FormatInt(item.CallCount, 10),
+			strconv.FormatInt(item.GrossMicros, 10),
+			strconv.FormatInt(item.CommissionMicros, 10),
+			strconv.FormatInt(item.RefundsMicros, 10),
+			strconv.FormatInt(item.NetPayableMicros, 10),
+		})
+	}
+	records = append(records, []string{
+		"",
+		"TOTAL",
+		strconv.FormatInt(statement.CallCount, 10),
+		strconv.FormatInt(statement.GrossMicros, 10),
+		strconv.FormatInt(statement.CommissionMicros, 10),
+		strconv.FormatInt(statement.RefundsMicros, 10),
+		strconv.FormatInt(statement.NetPayableMicros, 10),
+	})
+
+	if err := writer.WriteAll(records); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ListSellerPayoutStatements godoc
+// @Summary 获取我的结算单 (List my payout statements)
+// @Description 卖家查看自己的月度结算单（不含服务明细），按月份倒序
+// @Tags Seller
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{statements=[]model.PayoutStatement} "结算单列表 (Payout statements)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/payout-statements [get]
+func (h *BaseHandler) ListSellerPayoutStatements(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	statements, err := h.payoutStore.ListStatements(userID, "")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list payout statements"})
+		return
+	}
+	if statements == nil {
+		statements = []*model.PayoutStatement{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"statements": statements})
+}
+
+// GetSellerPayoutStatement godoc
+// @Summary 获取结算单详情 (Get payout statement)
+// @Description 卖家查看自己的一份结算单及按服务的明细
+// @Tags Seller
+// @Produce json
+// @Security BearerAuth
+// @Param statement_id path int true "结算单 ID (Statement ID)"
+// @Success 200 {object} model.PayoutStatement "结算单 (Payout statement)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "结算单不存在 (Statement not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/payout-statements/{statement_id} [get]
+func (h *BaseHandler) GetSellerPayoutStatement(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	statement, ok := h.getPayoutStatement(c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, statement)
+}
+
+// DownloadSellerPayoutStatement godoc
+// @Summary 下载结算单 (Download payout statement)
+// @Description 卖家以 CSV 或 JSON 文件下载自己的结算单，CSV 每行一个服务，最后一行为合计
+// @Tags Seller
+// @Produce text/csv,json
+// @Security BearerAuth
+// @Param statement_id path int true "结算单 ID (Statement ID)"
+// @Param format query string false "文件格式，默认 csv (File format)" Enums(csv, json)
+// @Success 200 {file} file "结算单文件 (Statement file)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "结算单不存在 (Statement not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/payout-statements/{statement_id}/download [get]
+func (h *BaseHandler) DownloadSellerPayoutStatement(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	format := strings.ToLower(c.DefaultQuery("format", "csv"))
+	if format != "csv" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid format, expected csv or json"})
+		return
+	}
+
+	statement, ok := h.getPayoutStatement(c, userID)
+	if !ok {
+		return
+	}
+
+	var (
+		body        []byte
+		contentType string
+		err         error
+	)
+	if format == "csv" {
+		body, err = payoutStatementCSV(statement)
+		contentType = "text/csv; charset=utf-8"
+	} else {
+		body, err = json.MarshalIndent(statement, "", "  ")
+		contentType = "application/json; charset=utf-8"
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export payout statement"})
+		return
+	}
+
+	filename := fmt.Sprintf("payout-statement-%s-%d.%s",
+		statement.PeriodStart.Format(payoutStatementMonthLayout), statement.StatementID, format)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// AdminListPayoutStatements godoc
+// @Summary 获取卖家结算单 (List payout statements)
+// @Description 管理员查看所有卖家的结算单（不含服务明细），可按状态和卖家过滤
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param status query string false "结算单状态 (Statement status)" Enums(pending, paid)
+// @Param seller_user_id query int false "卖家用户 ID (Seller user ID)"
+// @Success 200 {object} object{statements=[]model.PayoutStatement} "结算单列表 (Payout statements)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/payout-statements [get]
+func (h *BaseHandler) AdminListPayoutStatements(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != "pending" && status != "paid" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status, expected pending or paid"})
+		return
+	}
+
+	var sellerUserID int64
+	if sellerStr := c.Query("seller_user_id"); sellerStr != "" {
+		parsed, err := strconv.ParseInt(sellerStr, 10, 64)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid seller user ID"})
+			return
+		}
+		sellerUserID = parsed
+	}
+
+	statements, err := h.payoutStore.ListStatements(sellerUserID, status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list payout statements"})
+		return
+	}
+	if statements == nil {
+		statements = []*model.PayoutStatement{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"statements": statements})
+}
+
+// AdminGetPayoutStatement godoc
+// @Summary 获取结算单详情 (Get payout statement)
+// @Description 管理员查看任意卖家的结算单及按服务的明细
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param statement_id path int true "结算单 ID (Statement ID)"
+// @Success 200 {object} model.PayoutStatement "结算单 (Payout statement)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "结算单不存在 (Statement not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/payout-statements/{statement_id} [get]
+func (h *BaseHandler) AdminGetPayoutStatement(c *gin.Context) {
+	statement, ok := h.getPayoutStatement(c, 0)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, statement)
+}
+
+// GeneratePayoutStatements godoc
+// @Summary 生成月度结算单 (Generate payout statements)
+// @Description 管理员为已结束的月份生成卖家结算单，已生成的结算单保持不变。后台任务也会在每月结束后自动生成上个月的结算单
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body model.GeneratePayoutStatementsRequest true "结算月份 (Statement month)"
+// @Success 200 {object} object{month=string,generated=int} "生成结果 (Generation result)"
+// @Failure 400 {object} object{error=string} "请求参数错误或月份未结束 (Invalid input or month not finished)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/payout-statements/generate [post]
+func (h *BaseHandler) GeneratePayoutStatements(c *gin.Context) {
+	var req model.GeneratePayoutStatementsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	periodStart, err := time.Parse(payoutStatementMonthLayout, req.Month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month, expected YYYY-MM"})
+		return
+	}
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	if time.Now().Before(periodEnd) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Statements can only be generated for finished months"})
+		return
+	}
+
+	generated, err := h.payoutStore.GenerateStatements(periodStart, periodEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate payout statements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"month": req.Month, "generated": generated})
+}
+
+// MarkPayoutStatementPaid godoc
+// @Summary 标记结算单已支付 (Mark payout statement paid)
+// @Description 管理员在向卖家付款后标记结算单已支付，应付净额从卖家收入账户转出到平台资金账户
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param statement_id path int true "结算单 ID (Statement ID)"
+// @Param request body model.MarkStatementPaidRequest true "付款信息 (Payment details)"
+// @Success 200 {object} model.PayoutStatement "已支付的结算单 (Paid statement)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "结算单不存在 (Statement not found)"
+// @Failure 409 {object} object{error=string,code=string} "结算单已支付 (Statement already paid)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/payout-statements/{statement_id}/mark-paid [post]
+func (h *BaseHandler) MarkPayoutStatementPaid(c *gin.Context) {
+	adminUserID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || adminUserID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	var req model.MarkStatementPaidRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	statement, ok := h.getPayoutStatement(c, 0)
+	if !ok {
+		return
+	}
+
+	marked, err := h.payoutStore.MarkStatementPaid(statement.StatementID, req.PaymentReference, adminUserID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to mark payout statement paid"})
+		return
+	}
+	if !marked {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payout statement is already paid", "code": "already_paid"})
+		return
+	}
+
+	statement, ok = h.getPayoutStatement(c, 0)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, statement)
+}
@@ -0,0 +1,318 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultKeyRotationGracePeriod 轮换密钥时旧密钥默认继续可用的时间
+const defaultKeyRotationGracePeriod = 24 * time.Hour
+
+// getBuyerSubscriptionKeys 解析路径中的服务ID并获取买家在该服务订阅下的所有密钥
+// 买家未订阅该服务时写入 404 并返回 ok=false
+func (h *BaseHandler) getBuyerSubscriptionKeys(c *gin.Context) (int64, int64, []*model.PlatformAPIKey, bool) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return 0, 0, nil, false
+	}
+
+	serviceID, err := strconv.ParseInt(c.Param("service_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+		return 0, 0, nil, false
+	}
+
+	keys, err := h.platformKeyStore.GetSubscriptionKeys(userID, serviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get subscription keys"})
+		return 0, 0, nil, false
+	}
+	if len(keys) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return 0, 0, nil, false
+	}
+	return userID, serviceID, keys, true
+}
+
+// findSubscriptionKey 在订阅的密钥中查找路径参数 key_id 指定的密钥，找不到时写入错误响应
+func findSubscriptionKey(c *gin.Context, keys []*model.PlatformAPIKey) (*model.PlatformAPIKey, bool) {
+	keyID, err := strconv.ParseInt(c.Param("key_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key ID"})
+		return nil, false
+	}
+	for _, key := range keys {
+		if key.KeyID == keyID {
+			return key, true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": "Platform API key not found"})
+	return nil, false
+}
+
+// ListSubscriptionKeys godoc
+// @Summary 获取订阅的密钥列表 (List subscription keys)
+// @Description 买家查看某个订阅下的所有命名密钥（包括已吊销和轮换宽限期中的密钥），只返回密钥前缀
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Success 200 {object} object{keys=[]model.PlatformAPIKey} "密钥列表 (Key list)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "订阅未找到 (Subscription not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/subscriptions/{service_id}/keys [get]
+func (h *BaseHandler) ListSubscriptionKeys(c *gin.Context) {
+	_, _, keys, ok := h.getBuyerSubscriptionKeys(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"keys": keys})
+}
+
+// CreateSubscriptionKey godoc
+// @Summary 创建订阅密钥 (Create a subscription key)
+// @Description 买家为已订阅的服务创建新的命名密钥（例如 ci、staging、prod），完整密钥只在此响应中返回一次
+// @Tags Buyer
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param request body model.CreatePlatformKeyRequest true "密钥信息 (Key details)"
+// @Success 201 {object} model.PlatformKeySecretResponse "创建成功 (Created)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "订阅未找到 (Subscription not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/subscriptions/{service_id}/keys [post]
+func (h *BaseHandler) CreateSubscriptionKey(c *gin.Context) {
+	userID, serviceID, _, ok := h.getBuyerSubscriptionKeys(c)
+	if !ok {
+		return
+	}
+
+	var req model.CreatePlatformKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return
+	}
+	var scopes model.PlatformKeyScopes
+	if req.Scopes != nil {
+		if err := middleware.NormalizeKeyScopes(req.Scopes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scopes: " + err.Error()})
+			return
+		}
+		scopes = *req.Scopes
+	}
+
+	platformAPIKey := utils.GeneratePlatformAPIKey()
+	key := &model.PlatformAPIKey{
+		BuyerUserID: userID,
+		ServiceID:   serviceID,
+		Name:        req.Name,
+		KeyPrefix:   utils.PlatformAPIKeyPrefix(platformAPIKey),
+		KeyHash:     utils.HashPlatformAPIKey(platformAPIKey),
+		IsActive:    true,
+		ExpiresAt:   req.ExpiresAt,
+		Scopes:      scopes,
+	}
+
+	if err := h.platformKeyStore.CreatePlatformAPIKey(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create platform API key"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, model.PlatformKeySecretResponse{Key: key, PlatformAPIKey: platformAPIKey})
+}
+
+// UpdateSubscriptionKeyScopes godoc
+// @Summary 更新密钥访问范围 (Update key scopes)
+// @Description 买家限制某个密钥可以使用的 HTTP 方法、路径、模型、max_tokens 上限和来源 IP 段，适合交给前端或外包人员使用。
+// @Description 请求体整体替换原有范围，空对象表示取消所有限制；违反范围的请求会收到 403 (code=key_scope_violation)。
+// @Tags Buyer
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param key_id path int true "密钥 ID (Key ID)"
+// @Param request body model.PlatformKeyScopes true "访问范围 (Key scopes)"
+// @Success 200 {object} model.PlatformAPIKey "更新成功 (Updated)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "密钥未找到 (Key not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/subscriptions/{service_id}/keys/{key_id}/scopes [put]
+func (h *BaseHandler) UpdateSubscriptionKeyScopes(c *gin.Context) {
+	_, _, keys, ok := h.getBuyerSubscriptionKeys(c)
+	if !ok {
+		return
+	}
+	key, ok := findSubscriptionKey(c, keys)
+	if !ok {
+		return
+	}
+
+	var scopes model.PlatformKeyScopes
+	if err := c.ShouldBindJSON(&scopes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if err := middleware.NormalizeKeyScopes(&scopes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid scopes: " + err.Error()})
+		return
+	}
+
+	key.Scopes = scopes
+	if err := h.platformKeyStore.UpdatePlatformKeyScopes(key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update platform API key scopes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, key)
+}
+
+// RevokeSubscriptionKey godoc
+// @Summary 吊销订阅密钥 (Revoke a subscription key)
+// @Description 买家吊销订阅下的某个密钥，吊销立即生效；密钥记录保留，历史用量仍归属于该密钥
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param key_id path int true "密钥 ID (Key ID)"
+// @Success 200 {object} object{message=string} "吊销成功 (Revoked)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "密钥未找到 (Key not found)"
+// @Failure 409 {object} object{error=string} "密钥已吊销 (Key already revoked)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/subscriptions/{service_id}/keys/{key_id} [delete]
+func (h *BaseHandler) RevokeSubscriptionKey(c *gin.Context) {
+	userID, serviceID, keys, ok := h.getBuyerSubscriptionKeys(c)
+	if !ok {
+		return
+	}
+	key, ok := findSubscriptionKey(c, keys)
+	if !ok {
+		return
+	}
+	if !key.IsActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Platform API key is already revoked"})
+		return
+	}
+
+	if err := h.platformKeyStore.RevokePlatformAPIKey(key.KeyID, userID, serviceID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke platform API key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Platform API key revoked successfully"})
+}
+
+// RotateSubscriptionKey godoc
+// @Summary 轮换订阅密钥 (Rotate a subscription key)
+// @Description 买家为某个密钥生成同名的替换密钥，完整的新密钥只在此响应中返回一次。
+// @Description 旧密钥在宽限期（默认 24 小时）内仍然可用，便于逐步切换；grace_period_seconds 为 0 时立即吊销旧密钥。
+// @Tags Buyer
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param key_id path int true "密钥 ID (Key ID)"
+// @Param request body model.RotatePlatformKeyRequest false "轮换参数 (Rotation options)"
+// @Success 201 {object} model.PlatformKeySecretResponse "轮换成功，previous_key 为宽限期中的旧密钥 (Rotated, previous_key is the old key in its grace period)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "密钥未找到 (Key not found)"
+// @Failure 409 {object} object{error=string} "密钥已吊销、已过期或已轮换 (Key already revoked, expired or rotated)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/subscriptions/{service_id}/keys/{key_id}/rotate [post]
+func (h *BaseHandler) RotateSubscriptionKey(c *gin.Context) {
+	userID, serviceID, keys, ok := h.getBuyerSubscriptionKeys(c)
+	if !ok {
+		return
+	}
+	oldKey, ok := findSubscriptionKey(c, keys)
+	if !ok {
+		return
+	}
+	if !oldKey.IsActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Platform API key is already revoked"})
+		return
+	}
+	now := time.Now()
+	if oldKey.ExpiresAt != nil && !oldKey.ExpiresAt.After(now) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Platform API key has expired"})
+		return
+	}
+	// 宽限期中的旧密钥已有替换密钥，再次轮换会产生多个替换密钥
+	for _, key := range keys {
+		if key.RotatedFromKeyID != nil && *key.RotatedFromKeyID == oldKey.KeyID &&
+			key.IsActive && (key.ExpiresAt == nil || key.ExpiresAt.After(now)) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Platform API key already has an active replacement"})
+			return
+		}
+	}
+
+	var req model.RotatePlatformKeyRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+			return
+		}
+	}
+	gracePeriod := defaultKeyRotationGracePeriod
+	if req.GracePeriodSeconds != nil {
+		gracePeriod = time.Duration(*req.GracePeriodSeconds) * time.Second
+	}
+
+	// 替换密钥沿用旧密钥的名称、访问范围和原有的过期时间
+	var expiresAt *time.Time
+	if oldKey.ExpiresAt != nil {
+		originalExpiry := *oldKey.ExpiresAt
+		expiresAt = &originalExpiry
+	}
+
+	platformAPIKey := utils.GeneratePlatformAPIKey()
+	newKey := &model.PlatformAPIKey{
+		BuyerUserID: userID,
+		ServiceID:   serviceID,
+		Name:        oldKey.Name,
+		KeyPrefix:   utils.PlatformAPIKeyPrefix(platformAPIKey),
+		KeyHash:     utils.HashPlatformAPIKey(platformAPIKey),
+		IsActive:    true,
+		ExpiresAt:   expiresAt,
+		Scopes:      oldKey.Scopes,
+	}
+
+	rotated, err := h.platformKeyStore.RotatePlatformAPIKey(oldKey, newKey, gracePeriod)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate platform API key"})
+		return
+	}
+	if !rotated {
+		// 并发的吊销或轮换先完成
+		c.JSON(http.StatusConflict, gin.H{"error": "Platform API key was revoked, expired or rotated concurrently"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, model.PlatformKeySecretResponse{Key: newKey, PlatformAPIKey: platformAPIKey, PreviousKey: oldKey})
+}
@@ -0,0 +1,838 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/proxy"
+	"api-trade-platform/internal/redis"
+	"api-trade-platform/internal/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// streamChunkSize SSE 转发时每次从上游读取的缓冲区大小
+const streamChunkSize = 32 * 1024
+
+// statusClientClosedRequest 买家在上游响应前断开连接时记录的状态码（沿用 nginx 的 499）
+const statusClientClosedRequest = 499
+
+// @Summary Proxy API request to seller's service
+// @Description Proxies an incoming API request to the registered seller's API service, handling authentication and usage tracking.
+// @Description Responses with Content-Type text/event-stream are flushed to the buyer chunk by chunk as they arrive.
+// @Tags Platform API Proxy
+// @Accept json
+// @Produce json
+// @Param platform_api_key header string true "Platform API Key issued to the buyer"
+// @Param proxiedPath path string true "Path to be proxied to the seller's service"
+// @Router /proxy/{proxiedPath} [POST] // Note: Handles ANY HTTP method via Gin's Any() method
+// @Success 200 {object} map[string]interface{} "Proxied response from seller's service"
+// @Failure 400 {object} model.ErrorResponse "Bad Request (e.g., missing or invalid platform API key)"
+// @Failure 401 {object} model.ErrorResponse "Unauthorized (e.g., invalid or revoked platform API key)"
+// @Failure 403 {object} object{error=string,code=string,scope=string} "Forbidden (e.g., request outside the key's scopes, code=key_scope_violation)"
+// @Failure 402 {object} object{error=string,code=string,required_micros=int,available_micros=int} "Payment Required (wallet balance cannot cover the estimated cost, code=insufficient_funds)"
+// @Failure 404 {object} model.ErrorResponse "Not Found (e.g., seller service endpoint not found)"
+// @Failure 429 {object} object{error=string,code=string,retry_after=int} "Too Many Requests (rate, token or concurrency limit exceeded, code=token_rate_limited, concurrency_queue_full or concurrency_queue_timeout; see Retry-After header)"
+// @Failure 500 {object} model.ErrorResponse "Internal Server Error (e.g., platform error or error from seller's service)"
+// @Failure 502 {object} model.ErrorResponse "Bad Gateway (e.g., error connecting to seller's service)"
+// @Failure 503 {object} object{error=string,code=string,service_id=int,retry_after=int} "Service Unavailable (circuit breaker open for the seller upstream, see Retry-After header)"
+// @Security ApiKeyAuth
+func (h *BaseHandler) ProxyToSellerService(c *gin.Context) {
+	// 从平台认证中间件获取信息
+	platformKey, _ := middleware.GetPlatformKeyFromContext(c)
+	apiService, _ := middleware.GetAPIServiceFromContext(c)
+
+	if platformKey == nil || apiService == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid platform API key"})
+		return
+	}
+
+	// 获取卖家路径
+	sellerPath := c.Param("seller_path")
+	if sellerPath == "" {
+		sellerPath = "/"
+	}
+
+	routes := []*model.ServiceRoute{{PlatformKey: platformKey, APIService: apiService}}
+
+	// 买家为该服务配置了回退链时，主服务失败后按顺序尝试链中的其他服务
+	chain, err := h.fallbackChainStore.FindChainForService(platformKey.BuyerUserID, apiService.ServiceID)
+	if err != nil {
+		fmt.Printf("Failed to find fallback chain for buyer %d, service %d: %v\n", platformKey.BuyerUserID, apiService.ServiceID, err)
+	} else if chain != nil {
+		routes = h.appendChainRoutes(routes, platformKey.BuyerUserID, chain)
+		usePresentedKey(routes, platformKey)
+	}
+
+	h.forwardWithFallback(c, routes, sellerPath, readRequestBody(c))
+}
+
+// readRequestBody 读取请求体用于token分析，并重新设置请求体供后续使用
+func readRequestBody(c *gin.Context) []byte {
+	var requestBody []byte
+	if c.Request.Body != nil {
+		requestBody, _ = io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	}
+	return requestBody
+}
+
+// appendChainRoutes 将回退链中买家仍有有效订阅的服务按顺序追加到路由列表，跳过重复的服务
+func (h *BaseHandler) appendChainRoutes(routes []*model.ServiceRoute, buyerUserID int64, chain *model.FallbackChain) []*model.ServiceRoute {
+	return resolveChainRoutes(routes, chain, func(serviceID int64) (*model.ServiceRoute, error) {
+		return h.platformKeyStore.GetSubscriptionRoute(buyerUserID, serviceID)
+	})
+}
+
+// resolveChainRoutes 按回退链顺序用 lookup 查找买家在各服务上的订阅路由并追加到 routes
+// 已在 routes 中或在链中重复出现的服务只保留第一次；没有有效订阅或查询失败的服务被跳过，查询失败时记录日志
+func resolveChainRoutes(routes []*model.ServiceRoute, chain *model.FallbackChain, lookup func(serviceID int64) (*model.ServiceRoute, error)) []*model.ServiceRoute {
+	seen := make(map[int64]bool)
+	for _, route := range routes {
+		seen[route.APIService.ServiceID] = true
+	}
+
+	for _, serviceID := range chain.ServiceIDs {
+		if seen[serviceID] {
+			continue
+		}
+		seen[serviceID] = true
+
+		route, err := lookup(serviceID)
+		if err != nil {
+			fmt.Printf("Failed to resolve fallback chain %d service %d: %v\n", chain.ChainID, serviceID, err)
+			continue
+		}
+		if route == nil {
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// usePresentedKey 让路由到买家其他订阅的服务时仍使用买家出示的密钥，而不是该订阅下最早创建的密钥
+// 使用日志按该密钥记录，按密钥的 token 预算和并发限制也作用于该密钥；买家在各服务上的 token 预算随订阅路由查出，保持不变
+func usePresentedKey(routes []*model.ServiceRoute, presentedKey *model.PlatformAPIKey) {
+	for _, route := range routes {
+		key := *presentedKey
+		key.TokenBudget = route.PlatformKey.TokenBudget
+		route.PlatformKey = &key
+	}
+}
+
+// proxyAttemptError 单次转发尝试在拿到上游响应之前失败的原因
+type proxyAttemptError struct {
+	status     int
+	body       gin.H
+	retryAfter time.Duration // 大于0时随错误返回 Retry-After 头
+}
+
+// write 将错误写给买家
+func (e *proxyAttemptError) write(c *gin.Context) {
+	if e.retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(e.retryAfter.Seconds()))))
+	}
+	c.JSON(e.status, e.body)
+}
+
+// upstreamAttempt 一次已拿到上游响应的转发尝试
+type upstreamAttempt struct {
+	route        *model.ServiceRoute
+	upstream     *model.ServiceUpstream // 服务上游池中被选中的上游，上游池为空时为 nil
+	serviceModel *model.ServiceModel
+	translate    bool
+	resp         *http.Response
+	usageLog     *model.UsageLog
+	reserved     attemptReservations // 转发前占用的并发名额、token 预算和钱包冻结，记录使用日志时结算
+	startTime    time.Time
+}
+
+// attemptReservations 一次转发尝试在转发前占用的资源，尝试结束记录使用日志时统一释放或按实际用量结算
+type attemptReservations struct {
+	lease  *redis.ConcurrencyLease // 服务、订阅者和密钥的并发名额
+	tokens *redis.TokenReservation // token 预算中预留的预估用量
+	hold   *model.WalletHold       // 买家钱包中冻结的预估费用
+}
+
+// isRetryableStatus 判断上游状态码是否值得在回退链的下一个服务上重试
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusRequestTimeout ||
+		statusCode == http.StatusTooManyRequests ||
+		statusCode >= http.StatusInternalServerError
+}
+
+// forwardWithFallback 按顺序将请求转发到 routes 中的服务，是 /proxy/v1 与 /v1 统一路由共用的代理流程
+// 上游连接失败或返回可重试状态码时尝试下一个服务，最后一个服务的结果原样返回给买家。
+// 每次尝试都会记录一条使用日志，并通过同一个 request_id 关联，只有成功的尝试产生费用。
+func (h *BaseHandler) forwardWithFallback(c *gin.Context, routes []*model.ServiceRoute, sellerPath string, requestBody []byte) {
+	if len(routes) == 0 {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "No available service to proxy request"})
+		return
+	}
+
+	requestID := uuid.New().String()
+	c.Header("X-Platform-Request-ID", requestID)
+
+	tokenParser := utils.NewTokenParser()
+	modelName := tokenParser.ExtractModelName(requestBody, nil)
+
+	for i, route := range routes {
+		isLast := i == len(routes)-1
+
+		attempt, attemptErr := h.sendToUpstream(c, route, sellerPath, requestBody, modelName, requestID)
+		if attemptErr != nil {
+			// 买家已断开连接时不再尝试回退链中的其他服务
+			if isLast || c.Request.Context().Err() != nil {
+				attemptErr.write(c)
+				return
+			}
+			continue
+		}
+
+		if !isLast && isRetryableStatus(attempt.resp.StatusCode) {
+			h.discardAttempt(attempt)
+			continue
+		}
+
+		h.writeUpstreamResponse(c, attempt, requestBody, tokenParser)
+		return
+	}
+}
+
+// sendToUpstream 向单个卖家服务发送请求
+// 返回错误时没有任何内容写给买家，调用方可以继续尝试下一个服务
+func (h *BaseHandler) sendToUpstream(c *gin.Context, route *model.ServiceRoute, sellerPath string, requestBody []byte, modelName, requestID string) (*upstreamAttempt, *proxyAttemptError) {
+	apiService := route.APIService
+
+	// 服务声明了模型目录时，只允许调用目录中列出的模型
+	serviceModel, attemptErr := h.resolveServiceModel(apiService, modelName)
+	if attemptErr != nil {
+		return nil, attemptErr
+	}
+
+	// 上游为 Anthropic 格式时，将买家的 OpenAI Chat Completions 请求转换为 Messages 请求
+	upstreamPath, upstreamBody := sellerPath, requestBody
+	translate := proxy.NeedsTranslation(apiService.APIFormat, sellerPath)
+	if translate {
+		translatedBody, err := proxy.OpenAIToAnthropicRequest(requestBody)
+		if err != nil {
+			return nil, &proxyAttemptError{status: http.StatusBadRequest, body: gin.H{"error": "Failed to translate request: " + err.Error()}}
+		}
+		upstreamPath, upstreamBody = proxy.AnthropicMessagesPath, translatedBody
+	}
+
+	// 服务配置了上游池时按负载均衡策略选择未熔断的上游，否则使用服务的原始端点和密钥；
+	// 所有上游都熔断中时快速失败，不再等待连接或超时
+	upstream, attemptErr := h.selectUpstream(apiService)
+	if attemptErr != nil {
+		h.logUpstreamUnavailable(c, route, sellerPath, requestBody, requestID, attemptErr.status)
+		return nil, attemptErr
+	}
+	endpointURL, encryptedAPIKey := apiService.OriginalEndpointURL, apiService.EncryptedOriginalAPIKey
+	if upstream != nil {
+		endpointURL, encryptedAPIKey = upstream.EndpointURL, upstream.EncryptedAPIKey
+	}
+
+	// 熔断器放行后请求没有发往上游（例如被限流、余额不足或买家断开）时归还半开状态的探测名额
+	circuitPending := true
+	defer func() {
+		if circuitPending {
+			h.releaseCircuit(apiService, upstream)
+		}
+	}()
+
+	// 构建目标URL - 智能路径合并避免重复路径段
+	baseURL, err := url.Parse(endpointURL)
+	if err != nil {
+		return nil, &proxyAttemptError{status: http.StatusInternalServerError, body: gin.H{"error": "Invalid original endpoint URL"}}
+	}
+
+	// 智能合并路径，避免重复路径段
+	mergedPath := smartPathJoin(baseURL.Path, upstreamPath)
+	baseURL.Path = mergedPath
+
+	// 添加查询参数
+	if c.Request.URL.RawQuery != "" {
+		baseURL.RawQuery = c.Request.URL.RawQuery
+	}
+
+	targetURL := baseURL.String()
+
+	// 使用按服务超时配置共享的HTTP客户端
+	client := h.transportPool.Client(upstreamTransportConfig(apiService))
+
+	// 创建请求，买家断开连接时随请求上下文一起取消上游调用
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, targetURL, bytes.NewBuffer(upstreamBody))
+	if err != nil {
+		return nil, &proxyAttemptError{status: http.StatusInternalServerError, body: gin.H{"error": "Failed to create request"}}
+	}
+
+	// 解密卖家的原始API密钥
+	originalAPIKey, err := utils.DecryptAPIKey(encryptedAPIKey, h.cfg.ENCRYPTION_KEY)
+	if err != nil {
+		return nil, &proxyAttemptError{status: http.StatusInternalServerError, body: gin.H{"error": "Failed to decrypt seller API key"}}
+	}
+
+	// 复制请求头（排除一些不需要的头）
+	// 买家的平台密钥不转发给上游；请求头的键已规范化，X-API-Key 在 Header 中为 X-Api-Key
+	for key, values := range c.Request.Header {
+		canonicalKey := http.CanonicalHeaderKey(key)
+		if canonicalKey != "Authorization" && canonicalKey != "Host" && canonicalKey != "X-Api-Key" && !proxy.IsAuthHeader(apiService.AuthScheme, key) {
+			// 需要转换响应时由 Transport 自动处理压缩，避免拿到无法解析的压缩响应体
+			if translate && key == "Accept-Encoding" {
+				continue
+			}
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+
+	// 按服务配置的认证方式附加卖家的原始API密钥，未配置时按协议格式和密钥格式自动检测
+	if err := proxy.ApplyAuth(req, apiService.AuthScheme, apiService.APIFormat, originalAPIKey, upstreamBody); err != nil {
+		return nil, &proxyAttemptError{status: http.StatusInternalServerError, body: gin.H{"error": "Failed to apply upstream authentication"}}
+	}
+	// Anthropic Messages 接口还需要 anthropic-version 头
+	if proxy.NormalizeFormat(apiService.APIFormat) == proxy.FormatAnthropic && req.Header.Get("anthropic-version") == "" {
+		req.Header.Set("anthropic-version", proxy.AnthropicVersion)
+	}
+
+	// 占用并发名额，名额不足时排队等待
+	var reserved attemptReservations
+	reserved.lease, attemptErr = h.acquireConcurrencyLease(c, route)
+	if attemptErr != nil {
+		return nil, attemptErr
+	}
+
+	// 在各级 token 预算中预留预估用量，预算不足时不转发
+	reserved.tokens, attemptErr = h.reserveTokens(c, route, modelName, requestBody)
+	if attemptErr != nil {
+		h.releaseConcurrencyLease(reserved.lease, apiService.ServiceID)
+		return nil, attemptErr
+	}
+
+	// 在买家钱包中冻结预估费用，可用余额不足时不转发
+	reserved.hold, attemptErr = h.reserveWalletHold(route, serviceModel, requestBody, requestID)
+	if attemptErr != nil {
+		h.releaseConcurrencyLease(reserved.lease, apiService.ServiceID)
+		h.reconcileTokens(reserved.tokens, 0)
+		return nil, attemptErr
+	}
+
+	// 卖家设置的服务和订阅者请求数限流，每个实际尝试的服务分别检查；
+	// 放在其他可能拒绝请求的检查之后，只有确定转发的请求才消耗配额，被限流时归还已占用的名额、预算和冻结
+	if attemptErr := h.checkRequestRateLimit(c, route); attemptErr != nil {
+		h.releaseConcurrencyLease(reserved.lease, apiService.ServiceID)
+		h.reconcileTokens(reserved.tokens, 0)
+		h.releaseWalletHold(reserved.hold, &model.UsageLog{
+			BuyerUserID:  route.PlatformKey.BuyerUserID,
+			SellerUserID: apiService.SellerUserID,
+			APIServiceID: apiService.ServiceID,
+			RequestID:    requestID,
+		})
+		return nil, attemptErr
+	}
+
+	// 记录请求开始时间
+	startTime := time.Now()
+
+	// 记录使用日志的公共字段，状态码、token 与费用在拿到响应后补充
+	usageLog := &model.UsageLog{
+		BuyerUserID:      route.PlatformKey.BuyerUserID,
+		APIServiceID:     apiService.ServiceID,
+		PlatformAPIKeyID: route.PlatformKey.KeyID,
+		SellerUserID:     apiService.SellerUserID,
+		RequestPath:      sellerPath,
+		RequestMethod:    c.Request.Method,
+		RequestTimestamp: startTime,
+		RequestSizeBytes: len(requestBody),
+		RequestID:        requestID,
+	}
+
+	// 发送请求
+	resp, err := client.Do(req)
+	if err != nil && c.Request.Context().Err() != nil {
+		// 买家已断开连接，上游调用被取消，不计入上游的失败
+		usageLog.ResponseStatusCode = statusClientClosedRequest
+		usageLog.ProcessingTimeMs = int(time.Since(startTime).Milliseconds())
+		h.logUsageAsync(usageLog, reserved)
+		return nil, &proxyAttemptError{status: statusClientClosedRequest, body: gin.H{"error": "Client closed request"}}
+	}
+	h.observeUpstream(apiService, upstream, startTime, resp, err)
+	circuitPending = false
+	if err != nil {
+		// 连接失败或超时同样记录为一次失败的尝试
+		usageLog.ResponseStatusCode = http.StatusBadGateway
+		usageLog.ProcessingTimeMs = int(time.Since(startTime).Milliseconds())
+		h.logUsageAsync(usageLog, reserved)
+		return nil, &proxyAttemptError{status: http.StatusBadGateway, body: gin.H{"error": "Failed to proxy request"}}
+	}
+
+	usageLog.ResponseStatusCode = resp.StatusCode
+	usageLog.IsSuccess = resp.StatusCode >= 200 && resp.StatusCode < 300
+
+	return &upstreamAttempt{
+		route:        route,
+		upstream:     upstream,
+		serviceModel: serviceModel,
+		translate:    translate,
+		resp:         resp,
+		usageLog:     usageLog,
+		reserved:     reserved,
+		startTime:    startTime,
+	}, nil
+}
+
+// upstreamTransportConfig 获取服务的上游传输配置，未设置的超时使用平台默认值
+func upstreamTransportConfig(apiService *model.APIService) proxy.TransportConfig {
+	return proxy.TransportConfig{
+		ConnectTimeout:        time.Duration(apiService.ConnectTimeoutMs) * time.Millisecond,
+		ResponseHeaderTimeout: time.Duration(apiService.ResponseHeaderTimeoutMs) * time.Millisecond,
+		IdleConnTimeout:       time.Duration(apiService.IdleConnTimeoutMs) * time.Millisecond,
+	}
+}
+
+// selectUpstream 从服务的上游池中选择本次请求使用的上游，跳过熔断中的上游
+// 上游池为空或查询失败时返回 nil，使用服务的原始端点；选中的上游（或原始端点）已通过熔断器检查。
+// 上游池由健康检查器缓存，不在每次请求时查询数据库。
+func (h *BaseHandler) selectUpstream(apiService *model.APIService) (*model.ServiceUpstream, *proxyAttemptError) {
+	upstreams, err := h.healthChecker.ActiveUpstreams(apiService.ServiceID)
+	if err != nil || len(upstreams) == 0 {
+		if allowed, retryAfter := h.allowCircuit(apiService, nil); !allowed {
+			return nil, circuitOpenError(apiService, retryAfter)
+		}
+		return nil, nil
+	}
+
+	var shortestWait time.Duration
+	candidates := upstreams
+	for len(candidates) > 0 {
+		upstream := h.balancer.Pick(apiService.LBStrategy, candidates)
+		allowed, retryAfter := h.allowCircuit(apiService, upstream)
+		if allowed {
+			return upstream, nil
+		}
+		if shortestWait == 0 || retryAfter < shortestWait {
+			shortestWait = retryAfter
+		}
+
+		remaining := make([]*model.ServiceUpstream, 0, len(candidates)-1)
+		for _, candidate := range candidates {
+			if candidate.UpstreamID != upstream.UpstreamID {
+				remaining = append(remaining, candidate)
+			}
+		}
+		candidates = remaining
+	}
+	return nil, circuitOpenError(apiService, shortestWait)
+}
+
+// upstreamCircuitKey 获取本次请求所用上游的熔断器键，使用服务原始端点时上游ID为0
+func upstreamCircuitKey(apiService *model.APIService, upstream *model.ServiceUpstream) string {
+	var upstreamID int64
+	if upstream != nil {
+		upstreamID = upstream.UpstreamID
+	}
+	return redis.UpstreamCircuitKey(apiService.ServiceID, upstreamID)
+}
+
+// allowCircuit 检查上游熔断器，返回是否放行以及熔断中时建议的重试等待时间
+// 未启用熔断或 Redis 出错时放行请求
+func (h *BaseHandler) allowCircuit(apiService *model.APIService, upstream *model.ServiceUpstream) (bool, time.Duration) {
+	if h.circuitBreaker == nil {
+		return true, 0
+	}
+
+	allowed, retryAfter, err := h.circuitBreaker.Allow(upstreamCircuitKey(apiService, upstream))
+	if err != nil {
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+// releaseCircuit 归还熔断器放行但没有发往上游的请求占用的探测名额
+func (h *BaseHandler) releaseCircuit(apiService *model.APIService, upstream *model.ServiceUpstream) {
+	if h.circuitBreaker == nil {
+		return
+	}
+	if err := h.circuitBreaker.Release(upstreamCircuitKey(apiService, upstream)); err != nil {
+		fmt.Printf("Failed to release circuit breaker probe for service %d: %v\n", apiService.ServiceID, err)
+	}
+}
+
+// circuitOpenError 上游熔断中时返回的带 Retry-After 的 503 错误
+func circuitOpenError(apiService *model.APIService, retryAfter time.Duration) *proxyAttemptError {
+	retryAfterSeconds := int(math.Ceil(retryAfter.Seconds()))
+	if retryAfterSeconds < 1 {
+		retryAfterSeconds = 1
+	}
+	return &proxyAttemptError{
+		status: http.StatusServiceUnavailable,
+		body: gin.H{
+			"error":       "Upstream service is temporarily unavailable",
+			"code":        "circuit_open",
+			"service_id":  apiService.ServiceID,
+			"retry_after": retryAfterSeconds,
+		},
+		retryAfter: time.Duration(retryAfterSeconds) * time.Second,
+	}
+}
+
+// logUpstreamUnavailable 记录一次因上游熔断中而没有转发的尝试：不计费，但作为 5xx 计入服务的 SLA 可用率
+func (h *BaseHandler) logUpstreamUnavailable(c *gin.Context, route *model.ServiceRoute, sellerPath string, requestBody []byte, requestID string, statusCode int) {
+	usageLog := &model.UsageLog{
+		BuyerUserID:        route.PlatformKey.BuyerUserID,
+		APIServiceID:       route.APIService.ServiceID,
+		PlatformAPIKeyID:   route.PlatformKey.KeyID,
+		SellerUserID:       route.APIService.SellerUserID,
+		RequestPath:        sellerPath,
+		RequestMethod:      c.Request.Method,
+		RequestTimestamp:   time.Now(),
+		RequestSizeBytes:   len(requestBody),
+		RequestID:          requestID,
+		ResponseStatusCode: statusCode,
+		ZeroRatedReason:    model.ZeroRatedCircuitOpen,
+	}
+	h.logUsageAsync(usageLog, attemptReservations{})
+}
+
+// observeUpstream 记录一次上游调用的结果：连接失败和 5xx 响应计为失败
+// 结果用于熔断器计数，使用上游池时还会记录响应延迟并报告给健康检查（被动检查）
+func (h *BaseHandler) observeUpstream(apiService *model.APIService, upstream *model.ServiceUpstream, startTime time.Time, resp *http.Response, err error) {
+	if err == nil && resp.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	}
+
+	if h.circuitBreaker != nil {
+		circuitKey := upstreamCircuitKey(apiService, upstream)
+		if err != nil {
+			h.circuitBreaker.RecordFailure(circuitKey)
+		} else {
+			h.circuitBreaker.RecordSuccess(circuitKey)
+		}
+	}
+
+	if upstream == nil {
+		return
+	}
+	if err == nil {
+		h.balancer.ObserveLatency(upstream.UpstreamID, time.Since(startTime))
+	}
+	h.healthChecker.ReportResult(upstream, err)
+}
+
+// maxDiscardBytes 丢弃失败尝试的响应体时最多读取的字节数，便于复用连接
+const maxDiscardBytes = 64 * 1024
+
+// discardAttempt 丢弃一次将被重试的尝试的响应，并记录为不计费的失败尝试
+func (h *BaseHandler) discardAttempt(attempt *upstreamAttempt) {
+	io.Copy(io.Discard, io.LimitReader(attempt.resp.Body, maxDiscardBytes))
+	attempt.resp.Body.Close()
+
+	attempt.usageLog.ProcessingTimeMs = int(time.Since(attempt.startTime).Milliseconds())
+	h.logUsageAsync(attempt.usageLog, attempt.reserved)
+}
+
+// writeUpstreamResponse 将上游响应写给买家，统计 token 并记录使用日志
+func (h *BaseHandler) writeUpstreamResponse(c *gin.Context, attempt *upstreamAttempt, requestBody []byte, tokenParser *utils.TokenParser) {
+	resp := attempt.resp
+	defer resp.Body.Close()
+
+	usageLog := attempt.usageLog
+	apiService := attempt.route.APIService
+
+	// SSE 流式响应：边读边转发给买家，同时交给增量解析器统计 token
+	if isEventStream(resp) {
+		// Anthropic 事件流实时转换为 OpenAI chunk 流，token 统计也基于转换后的流
+		if attempt.translate {
+			resp.Body = proxy.NewAnthropicStreamReader(resp.Body)
+		}
+
+		modelName := tokenParser.ExtractModelName(requestBody, nil)
+		streamParser := tokenParser.NewStreamUsageParser(modelName)
+		written, streamErr := streamResponse(c, resp, streamParser)
+		if streamErr != nil {
+			fmt.Printf("Stream proxy interrupted: %v\n", streamErr)
+			if errors.Is(streamErr, errUpstreamStreamRead) {
+				usageLog.ZeroRatedReason = model.ZeroRatedUpstreamInterrupt
+			}
+		}
+
+		usageLog.ProcessingTimeMs = int(time.Since(attempt.startTime).Milliseconds())
+		usageLog.ResponseSizeBytes = written
+
+		tokenUsage, tokenErr := streamParser.Result()
+		if tokenErr == nil {
+			h.applyTokenUsage(usageLog, tokenUsage, apiService, attempt.serviceModel)
+		} else if errors.Is(tokenErr, utils.ErrStreamEventTooLarge) {
+			fmt.Printf("Stream usage not parsed for request %s: %v\n", usageLog.RequestID, tokenErr)
+		}
+
+		h.logUsageAsync(usageLog, attempt.reserved)
+		return
+	}
+
+	// 计算响应时间
+	responseTime := time.Since(attempt.startTime)
+
+	// 读取响应体
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		// 买家没有拿到响应，记录为不计费的失败调用并释放冻结
+		usageLog.ResponseStatusCode = http.StatusBadGateway
+		usageLog.IsSuccess = false
+		usageLog.ProcessingTimeMs = int(responseTime.Milliseconds())
+		h.logUsageAsync(usageLog, attempt.reserved)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to read response"})
+		return
+	}
+
+	// 将 Anthropic 响应（包括错误响应）转换为 OpenAI 格式，usage 一并规范化
+	if attempt.translate {
+		if translated, err := proxy.AnthropicToOpenAIResponse(body); err == nil {
+			body = translated
+			resp.Header.Del("Content-Length")
+			resp.Header.Set("Content-Type", "application/json")
+		}
+	}
+
+	usageLog.ProcessingTimeMs = int(responseTime.Milliseconds())
+	usageLog.ResponseSizeBytes = len(body)
+
+	// 解析token使用情况
+	modelName := tokenParser.ExtractModelName(requestBody, body)
+	tokenUsage, tokenErr := tokenParser.ParseTokenUsage(body, modelName)
+
+	// 如果成功解析token使用情况，添加到日志中
+	if tokenErr == nil {
+		h.applyTokenUsage(usageLog, tokenUsage, apiService, attempt.serviceModel)
+	}
+
+	// 异步记录使用日志（不阻塞响应）
+	h.logUsageAsync(usageLog, attempt.reserved)
+
+	// 复制响应头
+	for key, values := range resp.Header {
+		for _, value := range values {
+			c.Header(key, value)
+		}
+	}
+
+	// 设置状态码并返回响应体
+	c.Status(resp.StatusCode)
+	c.Data(resp.StatusCode, resp.Header.Get("Content-Type"), body)
+}
+
+// resolveServiceModel 在服务的模型目录中查找请求的模型
+// 目录为空或请求中没有模型字段时不做限制；模型不在目录中时返回 400 错误
+func (h *BaseHandler) resolveServiceModel(apiService *model.APIService, modelName string) (*model.ServiceModel, *proxyAttemptError) {
+	if modelName == "" || modelName == "unknown" {
+		return nil, nil
+	}
+
+	catalog, err := h.serviceModelStore.ListServiceModels(apiService.ServiceID)
+	if err != nil {
+		return nil, &proxyAttemptError{status: http.StatusInternalServerError, body: gin.H{"error": "Failed to load service model catalog"}}
+	}
+	if len(catalog) == 0 {
+		return nil, nil
+	}
+
+	available := make([]string, 0, len(catalog))
+	for _, m := range catalog {
+		if m.ModelID == modelName {
+			return m, nil
+		}
+		available = append(available, m.ModelID)
+	}
+
+	return nil, &proxyAttemptError{status: http.StatusBadRequest, body: gin.H{
+		"error":            fmt.Sprintf("Model '%s' is not offered by service '%s'", modelName, apiService.Name),
+		"code":             "model_not_found",
+		"available_models": available,
+	}}
+}
+
+// applyTokenUsage 将解析得到的 token 使用情况写入日志，并按服务定价计算费用
+func (h *BaseHandler) applyTokenUsage(usageLog *model.UsageLog, tokenUsage *utils.TokenUsage, apiService *model.APIService, serviceModel *model.ServiceModel) {
+	if tokenUsage == nil {
+		return
+	}
+
+	usageLog.InputTokens = tokenUsage.InputTokens
+	usageLog.OutputTokens = tokenUsage.OutputTokens
+	usageLog.TotalTokens = tokenUsage.TotalTokens
+	usageLog.CachedInputTokens = tokenUsage.CachedInputTokens
+	usageLog.CacheWriteTokens = tokenUsage.CacheWriteTokens
+	usageLog.ReasoningTokens = tokenUsage.ReasoningTokens
+	usageLog.AudioInputTokens = tokenUsage.AudioInputTokens
+	usageLog.AudioOutputTokens = tokenUsage.AudioOutputTokens
+	usageLog.ImageInputTokens = tokenUsage.ImageInputTokens
+	usageLog.ImageOutputTokens = tokenUsage.ImageOutputTokens
+	usageLog.ModelName = tokenUsage.ModelName
+
+	// 按服务的计费策略判断是否计费：失败（包括回退链中被重试的尝试）、状态码不可计费、中途中断和超时的调用不产生费用
+	if usageLog.ZeroRatedReason == "" {
+		usageLog.ZeroRatedReason = utils.ZeroRatedReason(apiService.BillingPolicy, usageLog.ResponseStatusCode, usageLog.ProcessingTimeMs)
+	}
+	if usageLog.ZeroRatedReason != "" {
+		usageLog.Cost = 0
+		usageLog.CostMicros = 0
+		return
+	}
+
+	// 按服务的定价计划计费，未配置阶梯定价的服务按固定价格计费；卖家为模型设定的价格替换计划中的输入/输出价格
+	usageLog.CostMicros = h.planCostMicros(usageLog, tokenUsage, apiService, serviceModel)
+	usageLog.Cost = utils.MicrosToUSD(usageLog.CostMicros)
+}
+
+// planCostMicros 按服务（及模型）的定价计划计算本次调用的费用，需要分档时查询买家本月之前的累计用量
+func (h *BaseHandler) planCostMicros(usageLog *model.UsageLog, tokenUsage *utils.TokenUsage, apiService *model.APIService, serviceModel *model.ServiceModel) int64 {
+	plan := utils.ModelPricingPlan(apiService, serviceModel)
+
+	usage := *tokenUsage
+	if usage.InputTokens+usage.OutputTokens == 0 {
+		// 只返回总 token 数的响应按输入 token 计费
+		usage.InputTokens = usage.TotalTokens
+	}
+
+	var prior model.PeriodUsage
+	if utils.PricingPlanNeedsPeriodUsage(plan) {
+		periodUsage, err := h.usageLogStore.GetPeriodUsage(usageLog.BuyerUserID, apiService.ServiceID, utils.BillingPeriodStart(time.Now()))
+		if err != nil {
+			// 查询失败时按本月首次调用计费
+			fmt.Printf("Failed to get period usage for buyer %d service %d: %v\n", usageLog.BuyerUserID, apiService.ServiceID, err)
+		} else {
+			prior = periodUsage
+		}
+	}
+	return utils.PricingPlanCostMicros(plan, prior, &usage)
+}
+
+// logUsageAsync 在尝试结束时释放并发名额，再异步记录使用日志（不阻塞响应），
+// 按日志中的实际费用结算钱包冻结和记账（日志写入失败时只释放冻结），并按实际 token 用量修正预留的 token 预算（用量未知的成功调用保留预估用量）
+func (h *BaseHandler) logUsageAsync(usageLog *model.UsageLog, reserved attemptReservations) {
+	h.releaseConcurrencyLease(reserved.lease, usageLog.APIServiceID)
+	go func() {
+		if err := h.usageLogStore.CreateUsageLog(usageLog); err != nil {
+			// 记录日志错误，但不影响主流程；没有使用日志就无法记账，只释放冻结，不扣费
+			fmt.Printf("Failed to log usage: %v\n", err)
+			h.releaseWalletHold(reserved.hold, usageLog)
+		} else {
+			h.settleUsage(reserved.hold, usageLog)
+		}
+		h.reconcileAttemptTokens(reserved.tokens, usageLog)
+	}()
+}
+
+// isEventStream 判断上游响应是否为 SSE (text/event-stream) 流
+func isEventStream(resp *http.Response) bool {
+	contentType := strings.ToLower(resp.Header.Get("Content-Type"))
+	return strings.HasPrefix(contentType, "text/event-stream")
+}
+
+// errUpstreamStreamRead 上游在流式响应中途断开或读取超时，与买家断开连接区分开，用于判断是否计费
+var errUpstreamStreamRead = errors.New("failed to read upstream stream")
+
+// streamResponse 将上游的 SSE 响应逐块转发给买家并立即 flush，
+// 同时把读到的每一块写入 tee，供流结束后统计 token 使用情况。
+// 返回已写给买家的字节数；买家断开连接时返回错误并停止读取上游。
+func streamResponse(c *gin.Context, resp *http.Response, tee io.Writer) (int, error) {
+	// 复制响应头，流式响应的长度未知，去掉 Content-Length
+	for key, values := range resp.Header {
+		if key == "Content-Length" {
+			continue
+		}
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+	// 避免反向代理 (如 nginx) 缓冲 SSE
+	c.Writer.Header().Set("X-Accel-Buffering", "no")
+
+	c.Status(resp.StatusCode)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	written := 0
+	buf := make([]byte, streamChunkSize)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			tee.Write(buf[:n])
+			if _, err := c.Writer.Write(buf[:n]); err != nil {
+				return written, fmt.Errorf("failed to write stream to client: %w", err)
+			}
+			c.Writer.Flush()
+			written += n
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, fmt.Errorf("%w: %v", errUpstreamStreamRead, readErr)
+		}
+	}
+}
+
+// smartPathJoin 智能合并两个路径，避免重复路径段
+// 例如: smartPathJoin("/v1", "v1/chat/completions") -> "/v1/chat/completions"
+//      smartPathJoin("/", "v1/chat/completions") -> "/v1/chat/completions"
+//      smartPathJoin("/api", "v1/chat/completions") -> "/api/v1/chat/completions"
+func smartPathJoin(basePath, sellerPath string) string {
+	// 清理路径
+	basePath = path.Clean(basePath)
+	sellerPath = strings.TrimPrefix(sellerPath, "/")
+
+	// 如果基础路径是根路径，直接返回卖家路径
+	if basePath == "/" || basePath == "." {
+		return "/" + sellerPath
+	}
+
+	// 分割路径段
+	baseSegments := strings.Split(strings.Trim(basePath, "/"), "/")
+	sellerSegments := strings.Split(sellerPath, "/")
+
+	// 如果卖家路径为空，返回基础路径
+	if len(sellerSegments) == 1 && sellerSegments[0] == "" {
+		return basePath
+	}
+
+	// 检查是否有重复的路径段
+	if len(baseSegments) > 0 && len(sellerSegments) > 0 {
+		// 如果基础路径的最后一段与卖家路径的第一段相同，跳过重复
+		if baseSegments[len(baseSegments)-1] == sellerSegments[0] {
+			sellerSegments = sellerSegments[1:]
+		}
+	}
+
+	// 合并路径段
+	allSegments := append(baseSegments, sellerSegments...)
+
+	// 过滤空段
+	var filteredSegments []string
+	for _, segment := range allSegments {
+		if segment != "" {
+			filteredSegments = append(filteredSegments, segment)
+		}
+	}
+
+	// 构建最终路径
+	if len(filteredSegments) == 0 {
+		return "/"
+	}
+
+	return "/" + strings.Join(filteredSegments, "/")
+}
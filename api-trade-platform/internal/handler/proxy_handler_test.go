@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"api-trade-platform/internal/config"
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/proxy"
+	"api-trade-platform/internal/redis"
+	"api-trade-platform/internal/store/postgres"
+	"api-trade-platform/internal/utils"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// testEncryptionKey 测试用的 32 字节 AES-256 密钥
+const testEncryptionKey = "0123456789abcdef0123456789abcdef"
+
+// unavailableDriver 所有连接都失败的数据库驱动，测试中的数据库调用返回错误而不是访问真实数据库
+type unavailableDriver struct{}
+
+func (unavailableDriver) Open(string) (driver.Conn, error) {
+	return nil, errors.New("database unavailable in tests")
+}
+
+// usageLogDriver 把写入 usage_logs 的响应状态码发送到 statuses 后返回错误的数据库驱动，用于检查代理记录的使用日志
+type usageLogDriver struct {
+	statuses chan int
+}
+
+func (d usageLogDriver) Open(string) (driver.Conn, error) {
+	return usageLogConn(d), nil
+}
+
+type usageLogConn usageLogDriver
+
+func (c usageLogConn) Prepare(query string) (driver.Stmt, error) {
+	return usageLogStmt{conn: c, query: query}, nil
+}
+
+func (usageLogConn) Close() error { return nil }
+
+func (usageLogConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("database unavailable in tests")
+}
+
+type usageLogStmt struct {
+	conn  usageLogConn
+	query string
+}
+
+func (usageLogStmt) Close() error  { return nil }
+func (usageLogStmt) NumInput() int { return -1 }
+
+func (usageLogStmt) Exec([]driver.Value) (driver.Result, error) {
+	return nil, errors.New("database unavailable in tests")
+}
+
+func (s usageLogStmt) Query(args []driver.Value) (driver.Rows, error) {
+	if strings.Contains(s.query, "INSERT INTO usage_logs") {
+		s.conn.statuses <- int(args[5].(int64))
+	}
+	return nil, errors.New("database unavailable in tests")
+}
+
+// testUsageLogs 记录测试中写入 usage_logs 的响应状态码
+var testUsageLogs = usageLogDriver{statuses: make(chan int, 16)}
+
+func init() {
+	sql.Register("handler-test-unavailable", unavailableDriver{})
+	sql.Register("handler-test-usage-logs", testUsageLogs)
+	gin.SetMode(gin.TestMode)
+}
+
+// fakeUpstreamStore 测试用的上游池
+type fakeUpstreamStore struct {
+	upstreams []*model.ServiceUpstream
+}
+
+func (s *fakeUpstreamStore) ListActiveUpstreams() ([]*model.ServiceUpstream, error) {
+	return s.upstreams, nil
+}
+
+func (s *fakeUpstreamStore) ListActiveUpstreamsByServiceID(serviceID int64) ([]*model.ServiceUpstream, error) {
+	var upstreams []*model.ServiceUpstream
+	for _, upstream := range s.upstreams {
+		if upstream.ServiceID == serviceID {
+			upstreams = append(upstreams, upstream)
+		}
+	}
+	return upstreams, nil
+}
+
+func (s *fakeUpstreamStore) SetUpstreamHealth(upstreamID int64, healthy bool, lastError string) error {
+	return nil
+}
+
+// newTestHandler 创建连接到进程内 miniredis、数据库不可用的 handler，熔断阈值为 1 次失败
+func newTestHandler(t *testing.T, upstreams ...*model.ServiceUpstream) *BaseHandler {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	host, port, err := net.SplitHostPort(mr.Addr())
+	if err != nil {
+		t.Fatalf("invalid miniredis address: %v", err)
+	}
+	redisClient := redis.NewLazyRedisClient(host, port, "", 0, 4)
+	t.Cleanup(func() { redisClient.Close() })
+
+	db, err := sql.Open("handler-test-unavailable", "")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	cfg := &config.Config{
+		ENCRYPTION_KEY:                    testEncryptionKey,
+		CIRCUIT_BREAKER_FAILURE_THRESHOLD: 1,
+		CIRCUIT_BREAKER_COOLDOWN_SECONDS:  60,
+		RATE_LIMIT_FALLBACK_PERCENT:       100,
+	}
+	h := NewBaseHandler(&postgres.Store{DB: db}, cfg, redisClient)
+	h.healthChecker = proxy.NewHealthChecker(&fakeUpstreamStore{upstreams: upstreams}, proxy.HealthCheckConfig{})
+	return h
+}
+
+// testRoute 创建指向 endpointURL 的服务路由
+func testRoute(t *testing.T, serviceID int64, endpointURL string) *model.ServiceRoute {
+	t.Helper()
+	encryptedKey, err := utils.EncryptAPIKey("sk-seller-"+endpointURL, testEncryptionKey)
+	if err != nil {
+		t.Fatalf("EncryptAPIKey() error = %v", err)
+	}
+	return &model.ServiceRoute{
+		PlatformKey: &model.PlatformAPIKey{KeyID: 10, BuyerUserID: 20},
+		APIService: &model.APIService{
+			ServiceID:               serviceID,
+			SellerUserID:            30,
+			Name:                    "service",
+			OriginalEndpointURL:     endpointURL,
+			EncryptedOriginalAPIKey: encryptedKey,
+			PricingModel:            "per_call",
+		},
+	}
+}
+
+// testContext 创建买家请求的 gin 上下文
+func testContext(method, target, body string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	return c, w
+}
+
+func poolUpstream(id int64, endpointURL string) *model.ServiceUpstream {
+	return &model.ServiceUpstream{UpstreamID: id, ServiceID: 1, EndpointURL: endpointURL, Weight: 1, IsActive: true, IsHealthy: true}
+}
+
+func TestSelectUpstreamSkipsOpenCircuits(t *testing.T) {
+	h := newTestHandler(t, poolUpstream(1, "https://a.example.com"), poolUpstream(2, "https://b.example.com"))
+	apiService := &model.APIService{ServiceID: 1}
+
+	// 上游 1 熔断后只选择上游 2，而不是在轮到上游 1 时返回 503
+	h.circuitBreaker.RecordFailure(redis.UpstreamCircuitKey(1, 1))
+	for i := 0; i < 4; i++ {
+		upstream, attemptErr := h.selectUpstream(apiService)
+		if attemptErr != nil {
+			t.Fatalf("selectUpstream() error = %+v while upstream 2 is healthy", attemptErr.body)
+		}
+		if upstream.UpstreamID != 2 {
+			t.Fatalf("selectUpstream() picked upstream %d, want 2", upstream.UpstreamID)
+		}
+	}
+
+	// 所有上游都熔断时返回 503
+	h.circuitBreaker.RecordFailure(redis.UpstreamCircuitKey(1, 2))
+	upstream, attemptErr := h.selectUpstream(apiService)
+	if upstream != nil || attemptErr == nil || attemptErr.status != http.StatusServiceUnavailable || attemptErr.body["code"] != "circuit_open" {
+		t.Fatalf("selectUpstream() = %v, %+v, want a circuit_open error", upstream, attemptErr)
+	}
+	if attemptErr.retryAfter <= 0 {
+		t.Errorf("circuit_open error has no Retry-After")
+	}
+}
+
+func TestSendToUpstreamReleasesProbeOnEarlyExit(t *testing.T) {
+	h := newTestHandler(t)
+	route := testRoute(t, 1, "https://seller.example.com")
+	// 无法解密的密钥使请求在熔断器放行后、发往上游前失败
+	route.APIService.EncryptedOriginalAPIKey = "invalid"
+	circuitKey := redis.UpstreamCircuitKey(1, 0)
+
+	// 刚进入半开状态、尚未放行探测请求的熔断器，只允许一个探测请求
+	err := h.redisClient.GetClient().HSet(h.redisClient.GetContext(), circuitKey,
+		"state", "half_open", "opened_at", time.Now().UnixMilli(), "inflight", 0, "successes", 0).Err()
+	if err != nil {
+		t.Fatalf("failed to prepare half-open circuit: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, _ := testContext(http.MethodPost, "/proxy/v1/1/chat", `{}`)
+		_, attemptErr := h.sendToUpstream(c, route, "/chat", []byte(`{}`), "unknown", "req")
+		if attemptErr == nil || attemptErr.status != http.StatusInternalServerError {
+			t.Fatalf("attempt %d: sendToUpstream() error = %+v, want the decryption failure", i+1, attemptErr)
+		}
+	}
+	if state, _ := h.circuitBreaker.GetState(circuitKey); state != redis.CircuitHalfOpen {
+		t.Errorf("circuit state = %s, want half_open", state)
+	}
+}
+
+func TestSendToUpstreamNeverForwardsBuyerKey(t *testing.T) {
+	var received http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	schemes := []model.UpstreamAuthScheme{
+		{},
+		{Type: proxy.AuthTypeHeader, HeaderName: "api-key", Template: "{key}"},
+		{Type: proxy.AuthTypeQuery, QueryParam: "key"},
+		{Type: proxy.AuthTypeBasic, Username: "account-1"},
+		{Type: proxy.AuthTypeHMAC, SignatureHeader: "X-Signature", TimestampHeader: "X-Timestamp", SignatureEncoding: "hex"},
+		{Type: proxy.AuthTypeNone},
+	}
+	for _, scheme := range schemes {
+		h := newTestHandler(t)
+		route := testRoute(t, 1, server.URL)
+		route.APIService.AuthScheme = scheme
+
+		c, _ := testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+		c.Request.Header.Set("Authorization", "Bearer pk_buyer")
+		c.Request.Header.Set("X-API-Key", "pk_buyer")
+		c.Request.Header.Set("X-Request-Id", "req-1")
+		attempt, attemptErr := h.sendToUpstream(c, route, "/chat/completions", []byte(`{}`), "unknown", "req")
+		if attemptErr != nil {
+			t.Fatalf("auth type %q: sendToUpstream() error = %+v", scheme.Type, attemptErr.body)
+		}
+		h.discardAttempt(attempt)
+
+		for name, values := range received {
+			for _, value := range values {
+				if strings.Contains(value, "pk_buyer") {
+					t.Errorf("auth type %q: buyer key forwarded in %s: %s", scheme.Type, name, value)
+				}
+			}
+		}
+		if received.Get("X-Request-Id") != "req-1" {
+			t.Errorf("auth type %q: other buyer headers not forwarded: %v", scheme.Type, received)
+		}
+	}
+}
+
+func TestOpenCircuitLowersMeasuredUptime(t *testing.T) {
+	h := newTestHandler(t)
+	db, err := sql.Open("handler-test-usage-logs", "")
+	if err != nil {
+		t.Fatalf("sql.Open() error = %v", err)
+	}
+	h.usageLogStore = postgres.NewUsageLogStore(&postgres.Store{DB: db})
+	server, _ := newTestUpstream(t, http.StatusOK)
+	route := testRoute(t, 1, server.URL)
+
+	c, _ := testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	attempt, attemptErr := h.sendToUpstream(c, route, "/chat/completions", []byte(`{}`), "unknown", "req-1")
+	if attemptErr != nil {
+		t.Fatalf("sendToUpstream() error = %+v", attemptErr.body)
+	}
+	h.writeUpstreamResponse(c, attempt, []byte(`{}`), utils.NewTokenParser())
+
+	// 熔断中的请求没有发往上游，但同样是卖家侧的不可用
+	h.circuitBreaker.RecordFailure(redis.UpstreamCircuitKey(1, 0))
+	c, _ = testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	if _, attemptErr := h.sendToUpstream(c, route, "/chat/completions", []byte(`{}`), "unknown", "req-2"); attemptErr == nil || attemptErr.status != http.StatusServiceUnavailable {
+		t.Fatalf("sendToUpstream() error = %+v, want a circuit_open error", attemptErr)
+	}
+
+	// 与 SLA 评估相同的统计口径：5xx 计为失败，499 不计入
+	var totalCalls, failedCalls int64
+	for i := 0; i < 2; i++ {
+		select {
+		case status := <-testUsageLogs.statuses:
+			if status == statusClientClosedRequest {
+				continue
+			}
+			totalCalls++
+			if status >= http.StatusInternalServerError {
+				failedCalls++
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("only %d of 2 attempts wrote a usage log", i)
+		}
+	}
+	if uptime := utils.SLAUptimeBps(totalCalls, failedCalls); uptime != 5000 {
+		t.Errorf("uptime = %d bps over %d calls, want 5000", uptime, totalCalls)
+	}
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxRequestsPerMinute 卖家可设置的每分钟请求数上限
+const maxRequestsPerMinute = 1000000
+
+// maxConcurrentRequests 卖家可设置的同时进行中请求数上限
+const maxConcurrentRequests = 100000
+
+// validateServiceRateLimits 校验并规范化卖家设置的限流，每个订阅者的配额不能超过服务整体配额
+func validateServiceRateLimits(limits *model.ServiceRateLimits) error {
+	if limits.RequestsPerMinute < 0 || limits.RequestsPerMinute > maxRequestsPerMinute {
+		return fmt.Errorf("requests_per_minute must be between 0 and %d", maxRequestsPerMinute)
+	}
+	if limits.SubscriberRequestsPerMinute < 0 || limits.SubscriberRequestsPerMinute > maxRequestsPerMinute {
+		return fmt.Errorf("subscriber_requests_per_minute must be between 0 and %d", maxRequestsPerMinute)
+	}
+	if limits.RequestsPerMinute > 0 && limits.SubscriberRequestsPerMinute > limits.RequestsPerMinute {
+		return fmt.Errorf("subscriber_requests_per_minute cannot exceed requests_per_minute")
+	}
+	if limits.RequestBurst < 0 || limits.RequestBurst > limits.RequestsPerMinute {
+		return fmt.Errorf("request_burst must be between 0 and requests_per_minute")
+	}
+	if limits.SubscriberRequestBurst < 0 || limits.SubscriberRequestBurst > limits.SubscriberRequestsPerMinute {
+		return fmt.Errorf("subscriber_request_burst must be between 0 and subscriber_requests_per_minute")
+	}
+
+	modelBudgets, err := utils.NormalizeTokenBudget(limits.TokensPerMinute, limits.ModelTokensPerMinute)
+	if err != nil {
+		return err
+	}
+	limits.ModelTokensPerMinute = modelBudgets
+	if limits.SubscriberTokensPerMinute < 0 || limits.SubscriberTokensPerMinute > utils.MaxTokensPerMinute {
+		return fmt.Errorf("subscriber_tokens_per_minute must be between 0 and %d", utils.MaxTokensPerMinute)
+	}
+	if limits.KeyTokensPerMinute < 0 || limits.KeyTokensPerMinute > utils.MaxTokensPerMinute {
+		return fmt.Errorf("key_tokens_per_minute must be between 0 and %d", utils.MaxTokensPerMinute)
+	}
+	if limits.TokensPerMinute > 0 && limits.SubscriberTokensPerMinute > limits.TokensPerMinute {
+		return fmt.Errorf("subscriber_tokens_per_minute cannot exceed tokens_per_minute")
+	}
+
+	if limits.MaxConcurrentRequests < 0 || limits.MaxConcurrentRequests > maxConcurrentRequests {
+		return fmt.Errorf("max_concurrent_requests must be between 0 and %d", maxConcurrentRequests)
+	}
+	if limits.SubscriberMaxConcurrentRequests < 0 || limits.SubscriberMaxConcurrentRequests > maxConcurrentRequests {
+		return fmt.Errorf("subscriber_max_concurrent_requests must be between 0 and %d", maxConcurrentRequests)
+	}
+	if limits.KeyMaxConcurrentRequests < 0 || limits.KeyMaxConcurrentRequests > maxConcurrentRequests {
+		return fmt.Errorf("key_max_concurrent_requests must be between 0 and %d", maxConcurrentRequests)
+	}
+	if limits.MaxConcurrentRequests > 0 && limits.SubscriberMaxConcurrentRequests > limits.MaxConcurrentRequests {
+		return fmt.Errorf("subscriber_max_concurrent_requests cannot exceed max_concurrent_requests")
+	}
+	return nil
+}
+
+// UpdateServiceRateLimits godoc
+// @Summary 卖家设置服务限流 (Seller sets service rate limits)
+// @Description 卖家设置通过 /proxy/v1 调用服务时服务整体和每个订阅者的每分钟请求数上限，以及服务整体、每个订阅者、每个平台密钥和每个模型的每分钟 token 上限，0 表示不限制。
+// @Description 超过请求数限制的请求返回 429 和 Retry-After，所有受限请求都带有 RateLimit-Limit、RateLimit-Remaining、RateLimit-Reset 响应头。
+// @Description token 限流在转发前按预估输入 token 加 max_tokens 预留额度，响应后按实际用量修正，预留不下时返回 429，单个请求超过上限时返回 400。
+// @Description 并发限制统计服务整体、每个订阅者和每个平台密钥同时进行中的请求，超过时请求按买家轮转排队，排队超时或队列已满时返回 429。请求体整体替换原设置
+// @Tags Seller
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param request body model.ServiceRateLimits true "限流设置 (Rate limits)"
+// @Success 200 {object} object{message=string,rate_limits=model.ServiceRateLimits} "更新成功 (Update successful)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "API 服务未找到 (API service not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/rate-limits [put]
+func (h *BaseHandler) UpdateServiceRateLimits(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	serviceID, err := strconv.ParseInt(c.Param("service_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+		return
+	}
+
+	var limits model.ServiceRateLimits
+	if err := c.ShouldBindJSON(&limits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if err := validateServiceRateLimits(&limits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rate limits: " + err.Error()})
+		return
+	}
+
+	existingService, err := h.apiServiceStore.GetAPIServiceByID(serviceID)
+	if err != nil || existingService == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API service not found"})
+		return
+	}
+	if existingService.SellerUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: service not owned by user"})
+		return
+	}
+
+	if err := h.apiServiceStore.UpdateRateLimits(serviceID, userID, limits); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update rate limits"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rate limits updated successfully", "rate_limits": limits})
+}
@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/redis"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestRateLimitWindow 卖家设置的请求数限流的窗口
+const requestRateLimitWindow = time.Minute
+
+// requestRateLimitChecks 汇总一次调用需要检查的请求数限流：卖家为每个订阅者和服务整体设置的每分钟请求数及突发请求数
+func requestRateLimitChecks(route *model.ServiceRoute) []redis.RateLimitCheck {
+	service, limits := route.APIService, route.APIService.RateLimits
+
+	var checks []redis.RateLimitCheck
+	if limits.SubscriberRequestsPerMinute > 0 {
+		checks = append(checks, redis.RateLimitCheck{
+			Key:    redis.SubscriberRateLimitKey(route.PlatformKey.BuyerUserID, service.ServiceID),
+			Config: redis.RateLimitConfig{Limit: int64(limits.SubscriberRequestsPerMinute), Window: requestRateLimitWindow, Burst: int64(limits.SubscriberRequestBurst)},
+		})
+	}
+	if limits.RequestsPerMinute > 0 {
+		checks = append(checks, redis.RateLimitCheck{
+			Key:    redis.ServiceRateLimitKey(service.ServiceID),
+			Config: redis.RateLimitConfig{Limit: int64(limits.RequestsPerMinute), Window: requestRateLimitWindow, Burst: int64(limits.RequestBurst)},
+		})
+	}
+	return checks
+}
+
+// checkRequestRateLimit 转发到服务前按卖家设置的每分钟请求数限流，/proxy/v1、/v1 统一路由和回退链中实际尝试的每个服务分别检查
+// 订阅者和服务整体的配额都足够时才同时扣减，被拒绝的请求不占用任何配额；响应头按 IETF RateLimit 草案返回剩余配额最少的一项。
+// Redis 不可用时由进程内限流按较保守的限制检查。
+func (h *BaseHandler) checkRequestRateLimit(c *gin.Context, route *model.ServiceRoute) *proxyAttemptError {
+	if h.rateLimiter == nil {
+		return nil
+	}
+	checks := requestRateLimitChecks(route)
+	if len(checks) == 0 {
+		return nil
+	}
+
+	result, err := h.rateLimiter.AllowAll(checks)
+	if err != nil {
+		// 限流检查失败，记录错误但允许请求通过（降级处理）
+		fmt.Printf("Failed to check request rate limit for service %d: %v\n", route.APIService.ServiceID, err)
+		c.Header("X-RateLimit-Error", "Rate limit check failed")
+		return nil
+	}
+
+	c.Header("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	c.Header("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	c.Header("RateLimit-Reset", strconv.FormatInt(ceilSeconds(result.ResetAfter), 10))
+	c.Header("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit, int64(requestRateLimitWindow.Seconds())))
+	if result.Allowed {
+		return nil
+	}
+
+	retryAfter := ceilSeconds(result.ResetAfter)
+	return &proxyAttemptError{
+		status: http.StatusTooManyRequests,
+		body: gin.H{
+			"error":       "Rate limit exceeded",
+			"message":     "Too many requests to this service, retry after the indicated number of seconds",
+			"code":        "rate_limited",
+			"service_id":  route.APIService.ServiceID,
+			"retry_after": retryAfter,
+		},
+		retryAfter: time.Duration(retryAfter) * time.Second,
+	}
+}
+
+// ceilSeconds 将时长向上取整为秒，至少为 1 秒
+func ceilSeconds(d time.Duration) int64 {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
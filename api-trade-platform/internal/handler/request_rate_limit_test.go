@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/redis"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestUpstream 创建返回固定 JSON 响应的上游，返回上游和收到的请求数
+func newTestUpstream(t *testing.T, status int) (*httptest.Server, *int64) {
+	t.Helper()
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`))
+	}))
+	t.Cleanup(server.Close)
+	return server, &calls
+}
+
+func TestRequestRateLimitAppliesToEveryAttemptedService(t *testing.T) {
+	h := newTestHandler(t)
+	limitedServer, limitedCalls := newTestUpstream(t, http.StatusOK)
+	fallbackServer, fallbackCalls := newTestUpstream(t, http.StatusOK)
+
+	limited := testRoute(t, 1, limitedServer.URL)
+	limited.APIService.RateLimits = model.ServiceRateLimits{RequestsPerMinute: 1}
+	fallback := testRoute(t, 2, fallbackServer.URL)
+
+	// 与 /v1 统一路由和回退链一样直接调用 forwardWithFallback，不经过 /proxy/v1 的中间件
+	c, w := testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	h.forwardWithFallback(c, []*model.ServiceRoute{limited, fallback}, "/chat/completions", []byte(`{}`))
+	if w.Code != http.StatusOK || atomic.LoadInt64(limitedCalls) != 1 || atomic.LoadInt64(fallbackCalls) != 0 {
+		t.Fatalf("first request: status %d, calls %d/%d, want 200 from the limited service", w.Code, atomic.LoadInt64(limitedCalls), atomic.LoadInt64(fallbackCalls))
+	}
+	if w.Header().Get("RateLimit-Limit") != "1" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("RateLimit headers = %v", w.Header())
+	}
+
+	// 超过限流的服务被跳过，回退链中的下一个服务继续处理
+	c, w = testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	h.forwardWithFallback(c, []*model.ServiceRoute{limited, fallback}, "/chat/completions", []byte(`{}`))
+	if w.Code != http.StatusOK || atomic.LoadInt64(limitedCalls) != 1 || atomic.LoadInt64(fallbackCalls) != 1 {
+		t.Fatalf("second request: status %d, calls %d/%d, want 200 from the fallback service", w.Code, atomic.LoadInt64(limitedCalls), atomic.LoadInt64(fallbackCalls))
+	}
+
+	// 只有一个服务时返回 429
+	c, w = testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+	h.forwardWithFallback(c, []*model.ServiceRoute{limited}, "/chat/completions", []byte(`{}`))
+	if w.Code != http.StatusTooManyRequests || atomic.LoadInt64(limitedCalls) != 1 {
+		t.Fatalf("third request: status %d, calls %d, want 429 without reaching the upstream", w.Code, atomic.LoadInt64(limitedCalls))
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Errorf("429 response has no Retry-After header")
+	}
+}
+
+func TestRequestRateLimitRejectionKeepsSubscriberQuota(t *testing.T) {
+	h := newTestHandler(t)
+	server, calls := newTestUpstream(t, http.StatusOK)
+
+	route := testRoute(t, 1, server.URL)
+	route.APIService.RateLimits = model.ServiceRateLimits{RequestsPerMinute: 1, SubscriberRequestsPerMinute: 3}
+
+	for i, wantStatus := range []int{http.StatusOK, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+		c, w := testContext(http.MethodPost, "/proxy/v1/1/chat", `{}`)
+		h.forwardWithFallback(c, []*model.ServiceRoute{route}, "/chat", []byte(`{}`))
+		if w.Code != wantStatus {
+			t.Fatalf("request %d: status %d, want %d", i+1, w.Code, wantStatus)
+		}
+	}
+	if atomic.LoadInt64(calls) != 1 {
+		t.Errorf("upstream called %d times, want 1", atomic.LoadInt64(calls))
+	}
+
+	// 被服务整体限流拒绝的请求没有扣减买家自己的配额
+	_, remaining, err := redis.NewRateLimiter(h.redisClient).GetRateLimitInfo(
+		redis.SubscriberRateLimitKey(route.PlatformKey.BuyerUserID, 1), redis.RateLimitConfig{Limit: 3, Window: time.Minute})
+	if err != nil {
+		t.Fatalf("GetRateLimitInfo() error = %v", err)
+	}
+	if remaining != 2 {
+		t.Errorf("subscriber remaining = %d, want 2", remaining)
+	}
+}
+
+func TestRequestRateLimitSkipsRequestsRefusedByConcurrencyLimit(t *testing.T) {
+	h := newTestHandler(t)
+	server, calls := newTestUpstream(t, http.StatusOK)
+
+	route := testRoute(t, 1, server.URL)
+	route.APIService.RateLimits = model.ServiceRateLimits{RequestsPerMinute: 1, MaxConcurrentRequests: 1}
+
+	// 占满服务的并发名额，没有等待队列时请求直接被拒绝
+	lease, _, err := h.concurrencyLimiter.Acquire(concurrencyLimits(route))
+	if err != nil || lease == nil {
+		t.Fatalf("Acquire() = %v, %v", lease, err)
+	}
+	c, w := testContext(http.MethodPost, "/proxy/v1/1/chat", `{}`)
+	h.forwardWithFallback(c, []*model.ServiceRoute{route}, "/chat", []byte(`{}`))
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "concurrency_queue_full") {
+		t.Fatalf("request while at capacity: status %d, body %s, want concurrency_queue_full", w.Code, w.Body.String())
+	}
+
+	// 被并发限制拒绝的请求没有消耗每分钟请求数配额
+	h.releaseConcurrencyLease(lease, 1)
+	c, w = testContext(http.MethodPost, "/proxy/v1/1/chat", `{}`)
+	h.forwardWithFallback(c, []*model.ServiceRoute{route}, "/chat", []byte(`{}`))
+	if w.Code != http.StatusOK || atomic.LoadInt64(calls) != 1 {
+		t.Fatalf("request after release: status %d, body %s, calls %d, want 200", w.Code, w.Body.String(), atomic.LoadInt64(calls))
+	}
+}
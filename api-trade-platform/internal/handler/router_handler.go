@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/utils"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openAIError 返回 OpenAI 兼容格式的错误响应，便于 OpenAI SDK 正确解析
+func openAIError(c *gin.Context, status int, errType, code, message string) {
+	c.AbortWithStatusJSON(status, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    errType,
+			"code":    code,
+		},
+	})
+}
+
+// RouteByModel godoc
+// @Summary OpenAI 兼容统一路由 (OpenAI-compatible unified router)
+// @Description 根据请求体中的 model 字段，将请求转发到买家已订阅且提供该模型的卖家服务。
+// @Description 买家可以使用任意一个有效的平台密钥访问其所有订阅，用量记在所使用的密钥上并受该密钥的访问范围限制，使用日志与计费沿用代理流程。
+// @Tags Platform API Proxy
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} map[string]interface{} "卖家服务的响应 (Proxied response from seller's service)"
+// @Failure 400 {object} object{error=object{message=string,type=string,code=string}} "缺少 model 字段 (Missing model field)"
+// @Failure 401 {object} object{error=string} "平台密钥无效 (Invalid platform API key)"
+// @Failure 402 {object} object{error=string,code=string,required_micros=int,available_micros=int} "钱包余额不足以支付预估费用 (Insufficient wallet balance, code=insufficient_funds)"
+// @Failure 403 {object} object{error=string,code=string,scope=string} "请求超出密钥的访问范围 (Request outside the key's scopes)"
+// @Failure 404 {object} object{error=object{message=string,type=string,code=string}} "没有提供该模型的订阅 (No subscribed service serves the model)"
+// @Failure 429 {object} object{error=string,code=string,retry_after=int} "超过 token 预算或并发限制 (Token budget or concurrency limit exceeded, see Retry-After header)"
+// @Failure 502 {object} object{error=string} "转发失败 (Failed to proxy request)"
+// @Router /v1/chat/completions [post]
+// @Router /v1/completions [post]
+// @Router /v1/embeddings [post]
+func (h *BaseHandler) RouteByModel(c *gin.Context) {
+	buyerUserID, exists := middleware.GetBuyerUserIDFromContext(c)
+	if !exists || buyerUserID == 0 {
+		openAIError(c, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Invalid platform API key")
+		return
+	}
+
+	requestBody := readRequestBody(c)
+
+	// 与 TokenParser 统计使用量时使用同一套规则提取模型名
+	modelName := utils.NewTokenParser().ExtractModelName(requestBody, nil)
+	if modelName == "" || modelName == "unknown" {
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", "model_required", "The 'model' field is required")
+		return
+	}
+
+	routes, err := h.serviceModelStore.FindSubscribedServicesByModel(buyerUserID, modelName)
+	if err != nil {
+		openAIError(c, http.StatusInternalServerError, "api_error", "internal_error", "Failed to resolve model route")
+		return
+	}
+	if len(routes) == 0 {
+		openAIError(c, http.StatusNotFound, "invalid_request_error", "model_not_found",
+			"The model '"+modelName+"' does not exist or you do not have an active subscription that serves it")
+		return
+	}
+
+	// 多个订阅提供同一模型时使用最早的订阅，保证路由结果稳定；
+	// 买家为该模型配置了回退链时按链中的顺序依次尝试
+	routes = routes[:1]
+	chain, err := h.fallbackChainStore.FindChainForModel(buyerUserID, modelName)
+	if err != nil {
+		// 回退链查询失败时仍转发到最早的订阅，只是不再回退
+		fmt.Printf("Failed to find fallback chain for buyer %d, model %s: %v\n", buyerUserID, modelName, err)
+	} else if chain != nil {
+		if chainRoutes := h.appendChainRoutes(nil, buyerUserID, chain); len(chainRoutes) > 0 {
+			routes = chainRoutes
+		}
+	}
+
+	// 用量、访问范围和按密钥的限额都归属买家实际出示的密钥，该密钥的访问范围已由认证中间件检查
+	if presentedKey, ok := middleware.GetPlatformKeyFromContext(c); ok {
+		usePresentedKey(routes, presentedKey)
+	}
+
+	route := routes[0]
+	c.Set("platform_key", route.PlatformKey)
+	c.Set("api_service", route.APIService)
+	c.Set("service_id", route.APIService.ServiceID)
+
+	h.forwardWithFallback(c, routes, c.Request.URL.Path, requestBody)
+}
+
+// ListModels godoc
+// @Summary OpenAI 兼容模型列表 (OpenAI-compatible model list)
+// @Description 汇总当前平台密钥所属买家全部有效订阅中卖家声明的模型目录
+// @Tags Platform API Proxy
+// @Produce json
+// @Security ApiKeyAuth
+// @Success 200 {object} object{object=string,data=[]object} "模型列表 (Model list)"
+// @Failure 401 {object} object{error=string} "平台密钥无效 (Invalid platform API key)"
+// @Router /v1/models [get]
+func (h *BaseHandler) ListModels(c *gin.Context) {
+	buyerUserID, exists := middleware.GetBuyerUserIDFromContext(c)
+	if !exists || buyerUserID == 0 {
+		openAIError(c, http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Invalid platform API key")
+		return
+	}
+
+	models, err := h.serviceModelStore.ListSubscribedModels(buyerUserID)
+	if err != nil {
+		openAIError(c, http.StatusInternalServerError, "api_error", "internal_error", "Failed to list models")
+		return
+	}
+
+	// 同一模型由多个订阅提供时，与路由规则一致只展示最早订阅的服务
+	data := []gin.H{}
+	seen := make(map[string]bool)
+	for _, m := range models {
+		if seen[m.ModelID] {
+			continue
+		}
+		seen[m.ModelID] = true
+
+		entry := gin.H{
+			"id":       m.ModelID,
+			"object":   "model",
+			"created":  m.CreatedAt.Unix(),
+			"owned_by": m.ServiceName,
+		}
+		if m.ContextWindow > 0 {
+			entry["context_window"] = m.ContextWindow
+		}
+		if len(m.Modalities) > 0 {
+			entry["modalities"] = m.Modalities
+		}
+		if m.HasPricing() {
+			entry["pricing"] = gin.H{
+				"input_price_per_1k":  m.InputPricePer1K,
+				"output_price_per_1k": m.OutputPricePer1K,
+			}
+		}
+		data = append(data, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": data})
+}
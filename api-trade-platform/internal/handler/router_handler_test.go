@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"testing"
+)
+
+func TestUsePresentedKeyAcrossServices(t *testing.T) {
+	presented := &model.PlatformAPIKey{
+		KeyID:       7,
+		BuyerUserID: 20,
+		ServiceID:   1,
+		Scopes:      model.PlatformKeyScopes{TokensPerMinute: 1000},
+	}
+	own := testRoute(t, 1, "https://a.example.com")
+	own.PlatformKey.TokenBudget = model.TokenBudget{TokensPerMinute: 500}
+	other := testRoute(t, 2, "https://b.example.com")
+	other.PlatformKey = &model.PlatformAPIKey{KeyID: 99, BuyerUserID: 20, ServiceID: 2, TokenBudget: model.TokenBudget{TokensPerMinute: 800}}
+
+	usePresentedKey([]*model.ServiceRoute{own, other}, presented)
+
+	// 路由到其他订阅的服务时不使用该订阅下的其他密钥，用量和按密钥的限额都归属出示的密钥
+	for _, route := range []*model.ServiceRoute{own, other} {
+		if route.PlatformKey.KeyID != 7 || route.PlatformKey.Scopes.TokensPerMinute != 1000 {
+			t.Errorf("service %d uses key %+v, want the presented key 7", route.APIService.ServiceID, route.PlatformKey)
+		}
+	}
+	// 买家在各服务上的 token 预算保持不变，出示的密钥本身不被修改
+	if own.PlatformKey.TokenBudget.TokensPerMinute != 500 || other.PlatformKey.TokenBudget.TokensPerMinute != 800 {
+		t.Errorf("token budgets = %d, %d, want 500, 800", own.PlatformKey.TokenBudget.TokensPerMinute, other.PlatformKey.TokenBudget.TokensPerMinute)
+	}
+	if !presented.TokenBudget.IsEmpty() {
+		t.Errorf("presented key was modified: %+v", presented.TokenBudget)
+	}
+}
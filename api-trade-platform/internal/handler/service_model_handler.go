@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getSellerOwnedService 解析路径中的 service_id 并校验服务属于当前卖家
+// 校验失败时已写入错误响应，调用方直接返回即可
+func (h *BaseHandler) getSellerOwnedService(c *gin.Context) (*model.APIService, bool) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return nil, false
+	}
+
+	serviceID, err := strconv.ParseInt(c.Param("service_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+		return nil, false
+	}
+
+	apiService, err := h.apiServiceStore.GetAPIServiceByID(serviceID)
+	if err != nil || apiService == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API service not found"})
+		return nil, false
+	}
+
+	if apiService.SellerUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: service not owned by user"})
+		return nil, false
+	}
+
+	return apiService, true
+}
+
+// modelIDParam 获取路径中的模型ID，模型ID可能包含斜杠（如 meta-llama/Llama-3-8b）
+func modelIDParam(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("model_id"), "/")
+}
+
+// ListServiceModels godoc
+// @Summary 获取服务模型目录 (List service model catalog)
+// @Description 卖家查看自己服务声明的模型目录，包括上下文窗口、模态和按模型定价
+// @Tags Seller
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Success 200 {object} object{models=[]model.ServiceModel} "模型目录 (Model catalog)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "API 服务未找到 (API service not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/models [get]
+func (h *BaseHandler) ListServiceModels(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	models, err := h.serviceModelStore.ListServiceModels(apiService.ServiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service models"})
+		return
+	}
+	if models == nil {
+		models = []*model.ServiceModel{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+// CreateServiceModel godoc
+// @Summary 新增服务模型 (Add a model to the service catalog)
+// @Description 卖家在服务模型目录中声明一个模型，买家只能通过平台调用目录中列出的模型
+// @Tags Seller
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param request body model.ServiceModelRequest true "模型信息 (Model details)"
+// @Success 201 {object} model.ServiceModel "创建成功 (Created)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "API 服务未找到 (API service not found)"
+// @Failure 409 {object} object{error=string} "模型已存在 (Model already exists)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/models [post]
+func (h *BaseHandler) CreateServiceModel(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	var req model.ServiceModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	req.ModelID = strings.TrimSpace(req.ModelID)
+	if req.ModelID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model_id is required"})
+		return
+	}
+
+	existing, err := h.serviceModelStore.GetServiceModel(apiService.ServiceID, req.ModelID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check service model"})
+		return
+	}
+	if existing != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Model already exists in service catalog"})
+		return
+	}
+
+	serviceModel := &model.ServiceModel{
+		ServiceID:        apiService.ServiceID,
+		ModelID:          req.ModelID,
+		ContextWindow:    req.ContextWindow,
+		Modalities:       req.Modalities,
+		InputPricePer1K:  req.InputPricePer1K,
+		OutputPricePer1K: req.OutputPricePer1K,
+	}
+	if err := h.serviceModelStore.CreateServiceModel(serviceModel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service model"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, serviceModel)
+}
+
+// UpdateServiceModel godoc
+// @Summary 更新服务模型 (Update a model in the service catalog)
+// @Description 卖家更新目录中模型的上下文窗口、模态和定价
+// @Tags Seller
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param model_id path string true "模型 ID (Model ID)"
+// @Param request body model.ServiceModelRequest true "模型信息 (Model details)"
+// @Success 200 {object} model.ServiceModel "更新成功 (Updated)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "模型未找到 (Model not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/models/{model_id} [put]
+func (h *BaseHandler) UpdateServiceModel(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	var req model.ServiceModelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	existing, err := h.serviceModelStore.GetServiceModel(apiService.ServiceID, modelIDParam(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service model"})
+		return
+	}
+	if existing == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service model not found"})
+		return
+	}
+
+	existing.ContextWindow = req.ContextWindow
+	existing.Modalities = req.Modalities
+	existing.InputPricePer1K = req.InputPricePer1K
+	existing.OutputPricePer1K = req.OutputPricePer1K
+	if err := h.serviceModelStore.UpdateServiceModel(existing); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service model"})
+		return
+	}
+
+	c.JSON(http.StatusOK, existing)
+}
+
+// DeleteServiceModel godoc
+// @Summary 删除服务模型 (Remove a model from the service catalog)
+// @Description 卖家从服务模型目录中移除一个模型，之后平台将拒绝对该模型的调用
+// @Tags Seller
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param model_id path string true "模型 ID (Model ID)"
+// @Success 200 {object} object{message=string} "删除成功 (Deleted)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "模型未找到 (Model not found)"
+// @Router /api/v1/seller/services/{service_id}/models/{model_id} [delete]
+func (h *BaseHandler) DeleteServiceModel(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	if err := h.serviceModelStore.DeleteServiceModel(apiService.ServiceID, modelIDParam(c)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service model not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Service model deleted successfully"})
+}
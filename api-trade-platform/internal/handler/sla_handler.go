@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// evaluateSLAPeriodically 定期评估上个月各服务的 SLA 并发放补偿（已评估的服务不会重复评估），ctx 取消时退出
+func (h *BaseHandler) evaluateSLAPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(payoutStatementGenerateInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now().UTC()
+		periodEnd := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		if now.Before(periodEnd.Add(payoutStatementGenerateDelay)) {
+			continue
+		}
+		periodStart := periodEnd.AddDate(0, -1, 0)
+
+		evaluations, err := h.slaStore.EvaluateSLA(periodStart, periodEnd)
+		if err != nil {
+			fmt.Printf("Failed to evaluate SLA for %s: %v\n", periodStart.Format(payoutStatementMonthLayout), err)
+			continue
+		}
+		for _, evaluation := range evaluations {
+			if evaluation.Breached {
+				fmt.Printf("Service %d missed its SLA for %s, credited %d buyers %s USD\n", evaluation.ServiceID,
+					periodStart.Format(payoutStatementMonthLayout), evaluation.CreditedBuyers, utils.FormatMicros(evaluation.CreditedMicros))
+			}
+		}
+	}
+}
+
+// UpdateBillingPolicy godoc
+// @Summary 卖家更新计费策略 (Seller updates billing policy)
+// @Description 卖家声明哪些响应状态码计费、计费时限以及月度 SLA 目标。5xx、上游中途中断和超过计费时限的调用一律不计费；
+// @Description 配置 SLA 后平台每月评估可用率和 P95 延迟，未达标时按 credit_bps 自动补偿买家当月在该服务的消费。请求体整体替换原策略
+// @Tags Seller
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param request body model.BillingPolicy true "计费策略 (Billing policy)"
+// @Success 200 {object} object{message=string,billing_policy=model.BillingPolicy} "更新成功 (Update successful)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "API 服务未找到 (API service not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/billing-policy [put]
+func (h *BaseHandler) UpdateBillingPolicy(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	serviceID, err := strconv.ParseInt(c.Param("service_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid service ID"})
+		return
+	}
+
+	var policy model.BillingPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if err := utils.NormalizeBillingPolicy(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid billing policy: " + err.Error()})
+		return
+	}
+
+	existingService, err := h.apiServiceStore.GetAPIServiceByID(serviceID)
+	if err != nil || existingService == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "API service not found"})
+		return
+	}
+	if existingService.SellerUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: service not owned by user"})
+		return
+	}
+
+	if err := h.apiServiceStore.UpdateBillingPolicy(serviceID, userID, policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update billing policy"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Billing policy updated successfully", "billing_policy": policy})
+}
+
+// ListSellerSLAEvaluations godoc
+// @Summary 获取 SLA 评估列表 (List SLA evaluations)
+// @Description 卖家查看自己服务的月度 SLA 评估结果和已发放的补偿
+// @Tags Seller
+// @Produce json
+// @Security BearerAuth
+// @Param service_id query int false "API 服务 ID (API Service ID)"
+// @Success 200 {object} object{evaluations=[]model.SLAEvaluation} "SLA 评估列表 (SLA evaluations)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/sla-evaluations [get]
+func (h *BaseHandler) ListSellerSLAEvaluations(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	serviceID, ok := parseOptionalIDQuery(c, "service_id", "Invalid service ID")
+	if !ok {
+		return
+	}
+
+	evaluations, err := h.slaStore.ListEvaluations(userID, serviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list SLA evaluations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"evaluations": evaluations})
+}
+
+// AdminListSLAEvaluations godoc
+// @Summary 获取 SLA 评估列表 (List SLA evaluations)
+// @Description 管理员查看所有服务的月度 SLA 评估结果，可按卖家和服务过滤
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param seller_user_id query int false "卖家用户 ID (Seller user ID)"
+// @Param service_id query int false "API 服务 ID (API Service ID)"
+// @Success 200 {object} object{evaluations=[]model.SLAEvaluation} "SLA 评估列表 (SLA evaluations)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/sla-evaluations [get]
+func (h *BaseHandler) AdminListSLAEvaluations(c *gin.Context) {
+	sellerUserID, ok := parseOptionalIDQuery(c, "seller_user_id", "Invalid seller user ID")
+	if !ok {
+		return
+	}
+	serviceID, ok := parseOptionalIDQuery(c, "service_id", "Invalid service ID")
+	if !ok {
+		return
+	}
+
+	evaluations, err := h.slaStore.ListEvaluations(sellerUserID, serviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list SLA evaluations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"evaluations": evaluations})
+}
+
+// parseOptionalIDQuery 解析可选的正整数 ID 查询参数，未提供时返回 0，格式错误时写入错误响应
+func parseOptionalIDQuery(c *gin.Context, name, message string) (int64, bool) {
+	value := c.Query(name)
+	if value == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || id <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return 0, false
+	}
+	return id, true
+}
+
+// EvaluateSLA godoc
+// @Summary 评估月度 SLA (Evaluate monthly SLA)
+// @Description 管理员为已结束的月份评估声明了 SLA 的服务，未达标时自动向买家发放补偿，已评估的服务保持不变。后台任务也会在每月结束后自动评估上个月
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body model.EvaluateSLARequest true "评估月份 (Evaluation month)"
+// @Success 200 {object} object{month=string,evaluations=[]model.SLAEvaluation} "新生成的评估 (New evaluations)"
+// @Failure 400 {object} object{error=string} "请求参数错误或月份未结束 (Invalid input or month not finished)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/sla-evaluations/evaluate [post]
+func (h *BaseHandler) EvaluateSLA(c *gin.Context) {
+	var req model.EvaluateSLARequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	periodStart, err := time.Parse(payoutStatementMonthLayout, req.Month)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid month, expected YYYY-MM"})
+		return
+	}
+	periodEnd := periodStart.AddDate(0, 1, 0)
+	if time.Now().Before(periodEnd) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "SLA can only be evaluated for finished months"})
+		return
+	}
+
+	evaluations, err := h.slaStore.EvaluateSLA(periodStart, periodEnd)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to evaluate SLA"})
+		return
+	}
+	if evaluations == nil {
+		evaluations = []*model.SLAEvaluation{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"month": req.Month, "evaluations": evaluations})
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/proxy"
+	"api-trade-platform/internal/utils"
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	testStreamChunk = `data: {"choices":[{"delta":{"content":"Hi"}}]}` + "\n\n"
+	testStreamUsage = `data: {"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":5,"total_tokens":8}}` + "\n\n" + "data: [DONE]\n\n"
+)
+
+// startStreamProxy 启动经过 forwardWithFallback 转发到 upstreamURL 的代理服务
+func startStreamProxy(t *testing.T, h *BaseHandler, upstreamURL string) *httptest.Server {
+	t.Helper()
+	router := gin.New()
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		h.forwardWithFallback(c, []*model.ServiceRoute{testRoute(t, 1, upstreamURL)}, "/chat/completions", readRequestBody(c))
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// readEvent 读取一个以空行结束的 SSE 事件
+func readEvent(t *testing.T, reader *bufio.Reader) string {
+	t.Helper()
+	var event strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		event.WriteString(line)
+		if err != nil {
+			t.Fatalf("failed to read SSE event: %v (read %q)", err, event.String())
+		}
+		if line == "\n" {
+			return event.String()
+		}
+	}
+}
+
+func TestStreamResponseFlushesEachEvent(t *testing.T) {
+	proceed := make(chan struct{})
+	var stalled int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, testStreamChunk)
+		w.(http.Flusher).Flush()
+		// 买家收到第一个事件之前不发送后续事件，代理缓冲事件时买家会一直等待
+		select {
+		case <-proceed:
+		case <-time.After(5 * time.Second):
+			atomic.StoreInt32(&stalled, 1)
+		}
+		io.WriteString(w, testStreamUsage)
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t)
+	proxyServer := startStreamProxy(t, h, upstream.URL)
+
+	resp, err := http.Post(proxyServer.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("X-Accel-Buffering") != "no" || resp.ContentLength != -1 {
+		t.Errorf("stream response headers = %v, content length %d", resp.Header, resp.ContentLength)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	if event := readEvent(t, reader); event != testStreamChunk {
+		t.Fatalf("first event = %q, want %q", event, testStreamChunk)
+	}
+	close(proceed)
+	rest, err := io.ReadAll(reader)
+	if err != nil || string(rest) != testStreamUsage {
+		t.Fatalf("rest of stream = %q, %v, want %q", rest, err, testStreamUsage)
+	}
+	if atomic.LoadInt32(&stalled) != 0 {
+		t.Errorf("first event reached the buyer only after the upstream gave up waiting")
+	}
+}
+
+func TestStreamResponseCancelsUpstreamOnClientDisconnect(t *testing.T) {
+	upstreamCancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, testStreamChunk)
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			close(upstreamCancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer upstream.Close()
+
+	h := newTestHandler(t)
+	proxyServer := startStreamProxy(t, h, upstream.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, proxyServer.URL+"/v1/chat/completions", strings.NewReader(`{}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	readEvent(t, bufio.NewReader(resp.Body))
+
+	// 买家断开后代理取消上游请求，不再继续读取上游
+	cancel()
+	select {
+	case <-upstreamCancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("upstream request was not cancelled after the buyer disconnected")
+	}
+}
+
+func TestWriteUpstreamResponseStreamUsage(t *testing.T) {
+	anthropicEvents := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude","usage":{"input_tokens":3,"output_tokens":1}}}` + "\n\n" +
+		"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}` + "\n\n" +
+		"event: message_delta\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}` + "\n\n"
+
+	tests := []struct {
+		name          string
+		apiFormat     string
+		body          string
+		cutConnection bool // 写完 body 后直接断开连接，模拟上游中途中断
+		wantZeroRated string
+		wantTokens    int
+	}{
+		{name: "complete", body: testStreamChunk + testStreamUsage, wantTokens: 8},
+		{name: "upstream cut off", body: testStreamChunk, cutConnection: true, wantZeroRated: model.ZeroRatedUpstreamInterrupt},
+		{name: "anthropic complete", apiFormat: proxy.FormatAnthropic, body: anthropicEvents + "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n", wantTokens: 8},
+		{name: "anthropic without message_stop", apiFormat: proxy.FormatAnthropic, body: anthropicEvents, wantZeroRated: model.ZeroRatedUpstreamInterrupt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				io.WriteString(w, tt.body)
+				w.(http.Flusher).Flush()
+				if tt.cutConnection {
+					// 不发送分块编码的结束块就关闭连接
+					conn, _, err := w.(http.Hijacker).Hijack()
+					if err == nil {
+						conn.Close()
+					}
+				}
+			}))
+			defer upstream.Close()
+
+			h := newTestHandler(t)
+			route := testRoute(t, 1, upstream.URL)
+			route.APIService.APIFormat = tt.apiFormat
+			c, w := testContext(http.MethodPost, "/v1/chat/completions", `{}`)
+			attempt, attemptErr := h.sendToUpstream(c, route, "/chat/completions", []byte(`{}`), "unknown", "req")
+			if attemptErr != nil {
+				t.Fatalf("sendToUpstream() error = %+v", attemptErr.body)
+			}
+			h.writeUpstreamResponse(c, attempt, []byte(`{}`), utils.NewTokenParser())
+
+			usageLog := attempt.usageLog
+			if !strings.Contains(w.Body.String(), `"content":"Hi"`) || usageLog.ResponseSizeBytes != w.Body.Len() {
+				t.Errorf("buyer received %q, logged %d bytes", w.Body.String(), usageLog.ResponseSizeBytes)
+			}
+			if usageLog.ZeroRatedReason != tt.wantZeroRated || usageLog.TotalTokens != tt.wantTokens {
+				t.Errorf("usage log zero_rated_reason = %q, total_tokens = %d, want %q, %d",
+					usageLog.ZeroRatedReason, usageLog.TotalTokens, tt.wantZeroRated, tt.wantTokens)
+			}
+			if tt.wantZeroRated != "" && usageLog.CostMicros != 0 {
+				t.Errorf("interrupted stream charged %d micros", usageLog.CostMicros)
+			}
+		})
+	}
+}
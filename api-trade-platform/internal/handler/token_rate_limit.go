@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/redis"
+	"api-trade-platform/internal/utils"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// tokenRateLimitWindow token 预算的滑动窗口长度
+const tokenRateLimitWindow = time.Minute
+
+// minTokenBudget 取两个预算中更严格的一个，0 表示不限
+func minTokenBudget(a, b int) int {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// tokenBudgetLimits 汇总一次调用需要检查的所有 token 预算：
+// 卖家为服务、每个订阅者、每个密钥和每个模型设置的预算，买家为订阅的服务、模型以及密钥设置的预算。
+// 卖家与买家在同一维度上都设置时取更严格的一个，请求中没有模型（modelName 为空或 unknown）时不检查按模型的预算。
+func tokenBudgetLimits(route *model.ServiceRoute, modelName string) []redis.TokenLimit {
+	key, service := route.PlatformKey, route.APIService
+	sellerLimits, buyerBudget := service.RateLimits, key.TokenBudget
+
+	var limits []redis.TokenLimit
+	add := func(limit int, format string, args ...interface{}) {
+		if limit > 0 {
+			limits = append(limits, redis.TokenLimit{Key: fmt.Sprintf(format, args...), Limit: int64(limit)})
+		}
+	}
+
+	add(sellerLimits.TokensPerMinute, redis.TokenRateLimitKeyService, service.ServiceID)
+	add(minTokenBudget(sellerLimits.SubscriberTokensPerMinute, buyerBudget.TokensPerMinute),
+		redis.TokenRateLimitKeySubscriber, key.BuyerUserID, service.ServiceID)
+	add(minTokenBudget(sellerLimits.KeyTokensPerMinute, key.Scopes.TokensPerMinute), redis.TokenRateLimitKeyAPIKey, key.KeyID)
+	if modelName != "" && modelName != "unknown" {
+		add(sellerLimits.ModelTokensPerMinute[modelName], redis.TokenRateLimitKeyServiceModel, service.ServiceID, modelName)
+		add(buyerBudget.ModelTokensPerMinute[modelName], redis.TokenRateLimitKeyBuyerModel, key.BuyerUserID, service.ServiceID, modelName)
+		add(key.Scopes.ModelTokensPerMinute[modelName], redis.TokenRateLimitKeyAPIKeyModel, key.KeyID, modelName)
+	}
+	return limits
+}
+
+// estimateRequestTokens 预估一次调用最多消耗的 token：输入按请求体每 4 字节 1 个 token 估算，
+// 输出取请求声明的上限，未声明时使用配置的默认值
+func (h *BaseHandler) estimateRequestTokens(requestBody []byte) int64 {
+	outputTokens := requestedOutputTokens(requestBody)
+	if outputTokens == 0 {
+		outputTokens = int64(h.cfg.TPM_DEFAULT_OUTPUT_TOKENS)
+	}
+	return int64(len(requestBody)/4+1) + outputTokens
+}
+
+// reserveTokens 转发前在所有适用的 token 预算中预留本次调用的预估 token，记录使用日志时按实际用量修正
+// 未设置预算时不预留；Redis 不可用时由进程内限流按较保守的预算预留。
+func (h *BaseHandler) reserveTokens(c *gin.Context, route *model.ServiceRoute, modelName string, requestBody []byte) (*redis.TokenReservation, *proxyAttemptError) {
+	if h.rateLimiter == nil {
+		return nil, nil
+	}
+	limits := tokenBudgetLimits(route, modelName)
+	if len(limits) == 0 {
+		return nil, nil
+	}
+
+	tokens := h.estimateRequestTokens(requestBody)
+	reservation, result, err := h.rateLimiter.ReserveTokens(limits, tokens, tokenRateLimitWindow)
+	if err != nil {
+		fmt.Printf("Failed to reserve tokens for service %d: %v\n", route.APIService.ServiceID, err)
+		return nil, nil
+	}
+
+	c.Header("X-Platform-RateLimit-Limit-Tokens", strconv.FormatInt(result.Limit, 10))
+	c.Header("X-Platform-RateLimit-Remaining-Tokens", strconv.FormatInt(result.Remaining, 10))
+	if result.Allowed {
+		return reservation, nil
+	}
+
+	// 单个请求的预估用量已经超过预算本身，等待也无法通过
+	if result.ResetAfter < 0 {
+		return nil, &proxyAttemptError{status: http.StatusBadRequest, body: gin.H{
+			"error":            "Request exceeds the tokens-per-minute budget, lower max_tokens or the prompt size",
+			"code":             "tokens_exceed_budget",
+			"requested_tokens": tokens,
+			"limit_tokens":     result.Limit,
+		}}
+	}
+
+	retryAfterSeconds := int(math.Ceil(result.ResetAfter.Seconds()))
+	if retryAfterSeconds < 1 {
+		retryAfterSeconds = 1
+	}
+	return nil, &proxyAttemptError{
+		status: http.StatusTooManyRequests,
+		body: gin.H{
+			"error":            "Tokens-per-minute budget exceeded",
+			"code":             "token_rate_limited",
+			"requested_tokens": tokens,
+			"limit_tokens":     result.Limit,
+			"remaining_tokens": result.Remaining,
+			"retry_after":      retryAfterSeconds,
+		},
+		retryAfter: result.ResetAfter,
+	}
+}
+
+// reconcileAttemptTokens 尝试结束时按使用日志修正预留的 token
+// 解析到用量时按实际用量修正；成功且已有输出但用量未知（例如流式响应未带 usage、买家中途断开、响应体不是 JSON）时保留预估用量，
+// 避免借助不带 usage 的请求绕过预算；没有拿到上游输出的失败尝试全部释放。
+func (h *BaseHandler) reconcileAttemptTokens(reservation *redis.TokenReservation, usageLog *model.UsageLog) {
+	actualTokens := usageLog.TotalTokens
+	if actualTokens == 0 {
+		actualTokens = usageLog.InputTokens + usageLog.OutputTokens
+	}
+	if actualTokens == 0 && usageLog.IsSuccess && usageLog.ResponseSizeBytes > 0 {
+		return
+	}
+	h.reconcileTokens(reservation, actualTokens)
+}
+
+// reconcileTokens 按实际用量修正转发前预留的 token，actualTokens 为 0 时释放全部预留
+func (h *BaseHandler) reconcileTokens(reservation *redis.TokenReservation, actualTokens int) {
+	if h.rateLimiter == nil || reservation == nil {
+		return
+	}
+	if err := h.rateLimiter.ReconcileTokens(reservation, int64(actualTokens)); err != nil {
+		fmt.Printf("Failed to reconcile token reservation: %v\n", err)
+	}
+}
+
+// GetSubscriptionTokenBudget godoc
+// @Summary 获取订阅的 token 预算 (Get subscription token budget)
+// @Description 买家查看自己为订阅服务设置的每分钟 token 预算，该服务下的所有密钥共享这一预算
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Success 200 {object} object{service_id=int,token_budget=model.TokenBudget} "token 预算 (Token budget)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "订阅未找到 (Subscription not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/subscriptions/{service_id}/token-budget [get]
+func (h *BaseHandler) GetSubscriptionTokenBudget(c *gin.Context) {
+	userID, serviceID, _, ok := h.getBuyerSubscriptionKeys(c)
+	if !ok {
+		return
+	}
+
+	budget, err := h.platformKeyStore.GetTokenBudget(userID, serviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get token budget"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"service_id": serviceID, "token_budget": budget})
+}
+
+// UpdateSubscriptionTokenBudget godoc
+// @Summary 设置订阅的 token 预算 (Set subscription token budget)
+// @Description 买家为订阅的服务设置每分钟 token 上限和按模型的上限，防止失控的客户端耗尽余额，0 或空对象表示不限制。
+// @Description 卖家也设置了订阅者上限时取更严格的一个；单个密钥的预算通过密钥访问范围的 tokens_per_minute 设置。请求体整体替换原预算
+// @Tags Buyer
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param request body model.TokenBudget true "token 预算 (Token budget)"
+// @Success 200 {object} object{message=string,token_budget=model.TokenBudget} "更新成功 (Update successful)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 404 {object} object{error=string} "订阅未找到 (Subscription not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/subscriptions/{service_id}/token-budget [put]
+func (h *BaseHandler) UpdateSubscriptionTokenBudget(c *gin.Context) {
+	userID, serviceID, _, ok := h.getBuyerSubscriptionKeys(c)
+	if !ok {
+		return
+	}
+
+	var budget model.TokenBudget
+	if err := c.ShouldBindJSON(&budget); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	modelBudgets, err := utils.NormalizeTokenBudget(budget.TokensPerMinute, budget.ModelTokensPerMinute)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid token budget: " + err.Error()})
+		return
+	}
+	budget.ModelTokensPerMinute = modelBudgets
+
+	if err := h.platformKeyStore.SetTokenBudget(userID, serviceID, budget); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update token budget"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Token budget updated successfully", "token_budget": budget})
+}
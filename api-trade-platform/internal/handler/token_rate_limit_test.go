@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/redis"
+	"testing"
+	"time"
+)
+
+func TestTokenBudgetLimitsSkipsUnknownModel(t *testing.T) {
+	route := testRoute(t, 1, "https://seller.example.com")
+	route.APIService.RateLimits = model.ServiceRateLimits{ModelTokensPerMinute: map[string]int{"unknown": 100, "gpt-4o": 100}}
+
+	for _, modelName := range []string{"", "unknown"} {
+		if limits := tokenBudgetLimits(route, modelName); len(limits) != 0 {
+			t.Errorf("tokenBudgetLimits(%q) = %v, want no per-model budget", modelName, limits)
+		}
+	}
+	if limits := tokenBudgetLimits(route, "gpt-4o"); len(limits) != 1 {
+		t.Errorf("tokenBudgetLimits(gpt-4o) = %v, want the model budget", limits)
+	}
+}
+
+func TestReconcileAttemptTokens(t *testing.T) {
+	tests := []struct {
+		name          string
+		usageLog      model.UsageLog
+		wantRemaining int64
+	}{
+		// 流式响应没有 usage 或买家中途断开时保留预估的 60 个 token
+		{name: "success with unknown usage", usageLog: model.UsageLog{IsSuccess: true, ResponseSizeBytes: 512}, wantRemaining: 40},
+		{name: "success with usage", usageLog: model.UsageLog{IsSuccess: true, ResponseSizeBytes: 512, TotalTokens: 25}, wantRemaining: 75},
+		{name: "success with input and output only", usageLog: model.UsageLog{IsSuccess: true, ResponseSizeBytes: 512, InputTokens: 5, OutputTokens: 5}, wantRemaining: 90},
+		{name: "upstream error", usageLog: model.UsageLog{ResponseStatusCode: 503, ResponseSizeBytes: 64}, wantRemaining: 100},
+		{name: "no output", usageLog: model.UsageLog{IsSuccess: true}, wantRemaining: 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t)
+			limits := []redis.TokenLimit{{Key: "tpm:test", Limit: 100}}
+			reservation, _, err := h.rateLimiter.ReserveTokens(limits, 60, time.Minute)
+			if err != nil || reservation == nil {
+				t.Fatalf("ReserveTokens() = %v, %v", reservation, err)
+			}
+
+			h.reconcileAttemptTokens(reservation, &tt.usageLog)
+
+			_, result, err := h.rateLimiter.ReserveTokens(limits, 0, time.Minute)
+			if err != nil {
+				t.Fatalf("ReserveTokens() error = %v", err)
+			}
+			if result.Remaining != tt.wantRemaining {
+				t.Errorf("remaining tokens = %d, want %d", result.Remaining, tt.wantRemaining)
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package handler
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListServiceUpstreams godoc
+// @Summary 获取服务上游池 (List service upstreams)
+// @Description 卖家查看服务上游池中的所有上游及其健康状态。上游池为空时代理使用服务的原始端点。
+// @Tags Seller
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Success 200 {object} object{lb_strategy=string,upstreams=[]model.ServiceUpstream} "上游池 (Upstream pool)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "API 服务未找到 (API service not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/upstreams [get]
+func (h *BaseHandler) ListServiceUpstreams(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	upstreams, err := h.upstreamStore.ListUpstreamsByServiceID(apiService.ServiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service upstreams"})
+		return
+	}
+	if upstreams == nil {
+		upstreams = []*model.ServiceUpstream{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"lb_strategy": apiService.LBStrategy, "upstreams": upstreams})
+}
+
+// CreateServiceUpstream godoc
+// @Summary 添加服务上游 (Add an upstream to the service pool)
+// @Description 卖家为服务添加一个上游（例如另一个地域的部署或另一个密钥），代理按服务的 lb_strategy 在上游池中负载均衡
+// @Tags Seller
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param request body model.ServiceUpstreamRequest true "上游信息 (Upstream details)"
+// @Success 201 {object} model.ServiceUpstream "创建成功 (Created)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "API 服务未找到 (API service not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/upstreams [post]
+func (h *BaseHandler) CreateServiceUpstream(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	var req model.ServiceUpstreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+	if req.APIKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "api_key is required"})
+		return
+	}
+
+	encryptedAPIKey, err := utils.EncryptAPIKey(req.APIKey, h.cfg.ENCRYPTION_KEY)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt API key"})
+		return
+	}
+
+	upstream := &model.ServiceUpstream{
+		ServiceID:       apiService.ServiceID,
+		Name:            strings.TrimSpace(req.Name),
+		EndpointURL:     req.EndpointURL,
+		EncryptedAPIKey: encryptedAPIKey,
+		Weight:          req.Weight,
+		HealthCheckPath: strings.TrimSpace(req.HealthCheckPath),
+		IsActive:        true,
+	}
+	if upstream.Weight == 0 {
+		upstream.Weight = 1
+	}
+	if req.IsActive != nil {
+		upstream.IsActive = *req.IsActive
+	}
+
+	if err := h.upstreamStore.CreateUpstream(upstream); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service upstream"})
+		return
+	}
+	h.healthChecker.InvalidateService(apiService.ServiceID)
+
+	c.JSON(http.StatusCreated, upstream)
+}
+
+// UpdateServiceUpstream godoc
+// @Summary 更新服务上游 (Update a service upstream)
+// @Description 卖家更新上游的端点、密钥、权重或启用状态，api_key 为空时保持原有密钥
+// @Tags Seller
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param upstream_id path int true "上游 ID (Upstream ID)"
+// @Param request body model.ServiceUpstreamRequest true "上游信息 (Upstream details)"
+// @Success 200 {object} model.ServiceUpstream "更新成功 (Updated)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "上游未找到 (Upstream not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/seller/services/{service_id}/upstreams/{upstream_id} [put]
+func (h *BaseHandler) UpdateServiceUpstream(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	upstreamID, err := strconv.ParseInt(c.Param("upstream_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upstream ID"})
+		return
+	}
+
+	var req model.ServiceUpstreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	upstream, err := h.upstreamStore.GetUpstreamByID(upstreamID, apiService.ServiceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get service upstream"})
+		return
+	}
+	if upstream == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service upstream not found"})
+		return
+	}
+
+	upstream.Name = strings.TrimSpace(req.Name)
+	upstream.EndpointURL = req.EndpointURL
+	upstream.HealthCheckPath = strings.TrimSpace(req.HealthCheckPath)
+	if req.Weight > 0 {
+		upstream.Weight = req.Weight
+	}
+	if req.IsActive != nil {
+		upstream.IsActive = *req.IsActive
+	}
+
+	// 如果提供了新的API密钥，则加密并更新
+	if req.APIKey != "" {
+		encryptedAPIKey, err := utils.EncryptAPIKey(req.APIKey, h.cfg.ENCRYPTION_KEY)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt API key"})
+			return
+		}
+		upstream.EncryptedAPIKey = encryptedAPIKey
+	}
+
+	if err := h.upstreamStore.UpdateUpstream(upstream); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update service upstream"})
+		return
+	}
+	h.healthChecker.InvalidateService(apiService.ServiceID)
+
+	c.JSON(http.StatusOK, upstream)
+}
+
+// DeleteServiceUpstream godoc
+// @Summary 删除服务上游 (Remove an upstream from the service pool)
+// @Description 卖家从服务上游池中删除上游，上游池删空后代理回到服务的原始端点
+// @Tags Seller
+// @Produce json
+// @Security BearerAuth
+// @Param service_id path int true "API 服务 ID (API Service ID)"
+// @Param upstream_id path int true "上游 ID (Upstream ID)"
+// @Success 200 {object} object{message=string} "删除成功 (Deleted)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "禁止操作 (Forbidden)"
+// @Failure 404 {object} object{error=string} "上游未找到 (Upstream not found)"
+// @Router /api/v1/seller/services/{service_id}/upstreams/{upstream_id} [delete]
+func (h *BaseHandler) DeleteServiceUpstream(c *gin.Context) {
+	apiService, ok := h.getSellerOwnedService(c)
+	if !ok {
+		return
+	}
+
+	upstreamID, err := strconv.ParseInt(c.Param("upstream_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upstream ID"})
+		return
+	}
+
+	if err := h.upstreamStore.DeleteUpstream(upstreamID, apiService.ServiceID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Service upstream not found"})
+		return
+	}
+	h.healthChecker.InvalidateService(apiService.ServiceID)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Service upstream deleted successfully"})
+}
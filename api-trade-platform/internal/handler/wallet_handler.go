@@ -0,0 +1,347 @@
+package handler
+
+import (
+	"api-trade-platform/internal/middleware"
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// walletTopUpListLimit 充值记录列表最多返回的条数
+const walletTopUpListLimit = 100
+
+// holdOutputTokenFields 请求体中声明输出上限的字段，用于预估冻结金额
+var holdOutputTokenFields = []string{"max_tokens", "max_completion_tokens", "max_output_tokens"}
+
+// newWalletResponse 构造钱包响应，买家从未充值时返回零余额
+func newWalletResponse(userID int64, wallet *model.BuyerWallet) model.WalletResponse {
+	if wallet == nil {
+		wallet = &model.BuyerWallet{UserID: userID, Currency: "USD"}
+	}
+	return model.WalletResponse{
+		BuyerWallet:     *wallet,
+		AvailableMicros: wallet.AvailableMicros(),
+		Balance:         utils.MicrosToUSD(wallet.BalanceMicros),
+		Held:            utils.MicrosToUSD(wallet.HeldMicros),
+		Available:       utils.MicrosToUSD(wallet.AvailableMicros()),
+	}
+}
+
+// estimateHoldMicros 按服务定价预估一次调用的最高费用，作为转发前冻结的金额
+// 输入 token 按请求体每 4 字节 1 个 token 估算，输出 token 取请求声明的上限，未声明时使用配置的默认值。
+func (h *BaseHandler) estimateHoldMicros(apiService *model.APIService, serviceModel *model.ServiceModel, requestBody []byte) int64 {
+	inputTokens := int64(len(requestBody)/4 + 1)
+	outputTokens := requestedOutputTokens(requestBody)
+	if outputTokens == 0 {
+		outputTokens = int64(h.cfg.WALLET_DEFAULT_HOLD_OUTPUT_TOKENS)
+	}
+
+	// 与结算使用同一定价计划，阶梯定价按最高一档价格预估，不查询累计用量
+	return utils.PricingPlanMaxCostMicros(utils.ModelPricingPlan(apiService, serviceModel), inputTokens, outputTokens)
+}
+
+// requestedOutputTokens 读取 JSON 请求体中声明的输出 token 上限，未声明时返回 0
+func requestedOutputTokens(requestBody []byte) int64 {
+	var payload map[string]interface{}
+	if err := json.Unmarshal(requestBody, &payload); err != nil {
+		return 0
+	}
+	for _, field := range holdOutputTokenFields {
+		if value, ok := payload[field].(float64); ok && value > 0 {
+			return int64(value)
+		}
+	}
+	return 0
+}
+
+// reserveWalletHold 转发前在买家钱包中冻结本次调用的预估费用
+// 未启用钱包或预估费用为 0 时不冻结；可用余额不足时返回 402 错误。
+func (h *BaseHandler) reserveWalletHold(route *model.ServiceRoute, serviceModel *model.ServiceModel, requestBody []byte, requestID string) (*model.WalletHold, *proxyAttemptError) {
+	if !h.cfg.WALLET_ENABLED {
+		return nil, nil
+	}
+
+	amount := h.estimateHoldMicros(route.APIService, serviceModel, requestBody)
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	hold := &model.WalletHold{
+		UserID:       route.PlatformKey.BuyerUserID,
+		ServiceID:    route.APIService.ServiceID,
+		RequestID:    requestID,
+		AmountMicros: amount,
+		ExpiresAt:    time.Now().Add(time.Duration(h.cfg.WALLET_HOLD_TTL_SECONDS) * time.Second),
+	}
+	reserved, err := h.walletStore.ReserveHold(hold)
+	if err != nil {
+		fmt.Printf("Failed to reserve wallet hold: %v\n", err)
+		return nil, &proxyAttemptError{status: http.StatusInternalServerError, body: gin.H{"error": "Failed to reserve wallet balance"}}
+	}
+	if reserved {
+		return hold, nil
+	}
+
+	var available int64
+	if wallet, err := h.walletStore.GetWallet(hold.UserID); err == nil && wallet != nil {
+		available = wallet.AvailableMicros()
+	}
+	return nil, &proxyAttemptError{status: http.StatusPaymentRequired, body: gin.H{
+		"error":            "Insufficient wallet balance",
+		"code":             "insufficient_funds",
+		"required_micros":  amount,
+		"available_micros": available,
+	}}
+}
+
+// settleUsage 按使用日志中的实际费用结算一次尝试：释放或结算钱包冻结，扣减买家余额并写入账本
+// 未启用钱包时不结算；失败或不计费的尝试只释放冻结金额。
+func (h *BaseHandler) settleUsage(hold *model.WalletHold, usageLog *model.UsageLog) {
+	if !h.cfg.WALLET_ENABLED || (hold == nil && usageLog.CostMicros <= 0) {
+		return
+	}
+
+	charge := &model.UsageCharge{
+		UsageLogID:   usageLog.LogID,
+		BuyerUserID:  usageLog.BuyerUserID,
+		SellerUserID: usageLog.SellerUserID,
+		ServiceID:    usageLog.APIServiceID,
+		AmountMicros: usageLog.CostMicros,
+	}
+	if hold != nil {
+		charge.HoldID = hold.HoldID
+	}
+	if charge.AmountMicros > 0 {
+		charge.CommissionBps = h.commissionRateBps(usageLog.APIServiceID)
+	}
+	if err := h.walletStore.SettleUsage(charge); err != nil {
+		fmt.Printf("Failed to settle usage for request %s: %v\n", usageLog.RequestID, err)
+		return
+	}
+	if charge.ShortfallMicros > 0 {
+		fmt.Printf("Wallet of user %d could not cover request %s: charged %d micros, shortfall %d micros\n",
+			charge.BuyerUserID, usageLog.RequestID, charge.AmountMicros, charge.ShortfallMicros)
+	}
+}
+
+// releaseWalletHold 不扣费地释放一次尝试的钱包冻结，用于使用日志写入失败、无法记账的尝试
+func (h *BaseHandler) releaseWalletHold(hold *model.WalletHold, usageLog *model.UsageLog) {
+	if hold == nil {
+		return
+	}
+	charge := &model.UsageCharge{
+		HoldID:       hold.HoldID,
+		BuyerUserID:  usageLog.BuyerUserID,
+		SellerUserID: usageLog.SellerUserID,
+		ServiceID:    usageLog.APIServiceID,
+	}
+	if err := h.walletStore.SettleUsage(charge); err != nil {
+		fmt.Printf("Failed to release wallet hold for request %s: %v\n", usageLog.RequestID, err)
+	}
+}
+
+// releaseExpiredWalletHolds 定期释放超过有效期仍未结算的冻结，ctx 取消时退出
+func (h *BaseHandler) releaseExpiredWalletHolds(ctx context.Context) {
+	interval := time.Duration(h.cfg.WALLET_HOLD_SWEEP_INTERVAL_SECONDS) * time.Second
+	if !h.cfg.WALLET_ENABLED || interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		released, err := h.walletStore.ReleaseExpiredHolds()
+		if err != nil {
+			fmt.Printf("Failed to release expired wallet holds: %v\n", err)
+			continue
+		}
+		if released > 0 {
+			fmt.Printf("Released %d expired wallet holds\n", released)
+		}
+	}
+}
+
+// GetBuyerWallet godoc
+// @Summary 获取钱包余额 (Get wallet balance)
+// @Description 买家查看预付费钱包的余额、冻结金额和可用余额。代理转发前会冻结预估费用，调用结束后按实际费用结算
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} model.WalletResponse "钱包余额 (Wallet balance)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/wallet [get]
+func (h *BaseHandler) GetBuyerWallet(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	wallet, err := h.walletStore.GetWallet(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newWalletResponse(userID, wallet))
+}
+
+// ListBuyerWalletTopUps godoc
+// @Summary 获取充值记录 (List wallet top-ups)
+// @Description 买家查看钱包最近的充值记录，按时间倒序
+// @Tags Buyer
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} object{topups=[]model.WalletTopUp} "充值记录 (Top-ups)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/buyer/wallet/topups [get]
+func (h *BaseHandler) ListBuyerWalletTopUps(c *gin.Context) {
+	userID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || userID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	topUps, err := h.walletStore.ListTopUps(userID, walletTopUpListLimit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list wallet top-ups"})
+		return
+	}
+	if topUps == nil {
+		topUps = []*model.WalletTopUp{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"topups": topUps})
+}
+
+// getBuyerForWallet 解析路径中的用户ID并确认该用户是买家，失败时写入错误响应
+func (h *BaseHandler) getBuyerForWallet(c *gin.Context) (int64, bool) {
+	userID, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return 0, false
+	}
+
+	user, err := h.userStore.GetUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return 0, false
+	}
+	if user.Role != "buyer" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Wallets are only available for buyers"})
+		return 0, false
+	}
+	return userID, true
+}
+
+// AdminGetWallet godoc
+// @Summary 查看买家钱包 (Get buyer wallet)
+// @Description 管理员查看指定买家的钱包余额
+// @Tags Admin
+// @Produce json
+// @Security BearerAuth
+// @Param user_id path int true "买家用户 ID (Buyer user ID)"
+// @Success 200 {object} model.WalletResponse "钱包余额 (Wallet balance)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "用户未找到 (User not found)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/wallets/{user_id} [get]
+func (h *BaseHandler) AdminGetWallet(c *gin.Context) {
+	userID, ok := h.getBuyerForWallet(c)
+	if !ok {
+		return
+	}
+
+	wallet, err := h.walletStore.GetWallet(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newWalletResponse(userID, wallet))
+}
+
+// AdminTopUpWallet godoc
+// @Summary 为买家钱包充值 (Top up buyer wallet)
+// @Description 管理员在确认收款后为买家钱包入账。携带外部流水号 reference 时重复提交不会重复入账
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user_id path int true "买家用户 ID (Buyer user ID)"
+// @Param request body model.WalletTopUpRequest true "充值信息 (Top-up details)"
+// @Success 201 {object} object{topup=model.WalletTopUp,wallet=model.WalletResponse} "充值成功 (Top-up credited)"
+// @Failure 400 {object} object{error=string} "请求参数错误 (Invalid input)"
+// @Failure 401 {object} object{error=string} "未授权 (Unauthorized)"
+// @Failure 403 {object} object{error=string} "权限不足 (Forbidden)"
+// @Failure 404 {object} object{error=string} "用户未找到 (User not found)"
+// @Failure 409 {object} object{error=string,code=string} "流水号已入账 (Reference already credited)"
+// @Failure 500 {object} object{error=string} "服务器内部错误 (Internal server error)"
+// @Router /api/v1/admin/wallets/{user_id}/topups [post]
+func (h *BaseHandler) AdminTopUpWallet(c *gin.Context) {
+	adminUserID, exists := middleware.GetUserIDFromContext(c)
+	if !exists || adminUserID == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	userID, ok := h.getBuyerForWallet(c)
+	if !ok {
+		return
+	}
+
+	var req model.WalletTopUpRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data: " + err.Error()})
+		return
+	}
+
+	topUp := &model.WalletTopUp{
+		UserID:          userID,
+		AmountMicros:    req.AmountMicros,
+		Reference:       req.Reference,
+		Note:            req.Note,
+		CreatedByUserID: &adminUserID,
+	}
+	credited, err := h.walletStore.TopUp(topUp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to top up wallet"})
+		return
+	}
+	if !credited {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Top-up reference has already been credited",
+			"code":  "duplicate_reference",
+		})
+		return
+	}
+
+	wallet, err := h.walletStore.GetWallet(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get wallet"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"topup":  topUp,
+		"wallet": newWalletResponse(userID, wallet),
+	})
+}
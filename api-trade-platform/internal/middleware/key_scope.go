@@ -0,0 +1,320 @@
+package middleware
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxTokensFields 各家接口中限制输出长度的字段，嵌套字段用 . 分隔
+var maxTokensFields = []string{"max_tokens", "max_completion_tokens", "max_output_tokens", "generationConfig.maxOutputTokens"}
+
+// pathModelSegments 路径中紧跟模型名的段，例如 Gemini 的 /v1beta/models/{model}:generateContent 和 Azure OpenAI 的 /openai/deployments/{deployment}/...
+var pathModelSegments = []string{"models", "deployments"}
+
+// ScopeViolation 请求违反平台密钥访问范围的原因
+type ScopeViolation struct {
+	Scope   string // 违反的范围字段，例如 allowed_methods
+	Message string
+}
+
+// NormalizeKeyScopes 校验并规范化买家配置的密钥访问范围
+func NormalizeKeyScopes(scopes *model.PlatformKeyScopes) error {
+	for i, method := range scopes.AllowedMethods {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method == "" {
+			return fmt.Errorf("allowed_methods contains an empty method")
+		}
+		scopes.AllowedMethods[i] = method
+	}
+
+	for i, glob := range scopes.PathGlobs {
+		glob = strings.TrimSpace(glob)
+		if !strings.HasPrefix(glob, "/") {
+			return fmt.Errorf("path glob %q must start with /", glob)
+		}
+		if _, err := path.Match(strings.TrimSuffix(glob, "/**"), "/"); err != nil {
+			return fmt.Errorf("invalid path glob %q", glob)
+		}
+		scopes.PathGlobs[i] = glob
+	}
+
+	for i, modelID := range scopes.AllowedModels {
+		modelID = strings.TrimSpace(modelID)
+		if modelID == "" {
+			return fmt.Errorf("allowed_models contains an empty model")
+		}
+		scopes.AllowedModels[i] = modelID
+	}
+
+	if scopes.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative")
+	}
+
+	modelBudgets, err := utils.NormalizeTokenBudget(scopes.TokensPerMinute, scopes.ModelTokensPerMinute)
+	if err != nil {
+		return err
+	}
+	scopes.ModelTokensPerMinute = modelBudgets
+
+	for i, cidr := range scopes.SourceCIDRs {
+		cidr = strings.TrimSpace(cidr)
+		// 允许直接填写单个 IP
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return fmt.Errorf("invalid source CIDR %q", cidr)
+			}
+			if ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("invalid source CIDR %q", cidr)
+		}
+		scopes.SourceCIDRs[i] = cidr
+	}
+	return nil
+}
+
+// CheckKeyScopes 检查请求是否在密钥的访问范围内，违反时返回原因
+// requestPath 为范围中路径规则匹配的路径，body 只在限制了模型或 max_tokens 时才会被解析。
+// 限制了模型或 max_tokens 时，带请求体的请求必须是 JSON 对象，并且要声明模型和输出上限，否则视为违反范围。
+func CheckKeyScopes(scopes model.PlatformKeyScopes, method, requestPath, clientIP string, body []byte) *ScopeViolation {
+	if len(scopes.SourceCIDRs) > 0 && !ipInCIDRs(clientIP, scopes.SourceCIDRs) {
+		return &ScopeViolation{
+			Scope:   "source_cidrs",
+			Message: fmt.Sprintf("source IP %s is not allowed for this API key", clientIP),
+		}
+	}
+
+	if len(scopes.AllowedMethods) > 0 && !containsString(scopes.AllowedMethods, strings.ToUpper(method)) {
+		return &ScopeViolation{
+			Scope:   "allowed_methods",
+			Message: fmt.Sprintf("method %s is not allowed for this API key (allowed: %s)", method, strings.Join(scopes.AllowedMethods, ", ")),
+		}
+	}
+
+	// 按规范化后的路径匹配，避免用 .. 绕过路径规则
+	cleanPath := path.Clean("/" + requestPath)
+	if len(scopes.PathGlobs) > 0 && !matchAnyPathGlob(scopes.PathGlobs, cleanPath) {
+		return &ScopeViolation{
+			Scope:   "path_globs",
+			Message: fmt.Sprintf("path %s is not allowed for this API key (allowed: %s)", requestPath, strings.Join(scopes.PathGlobs, ", ")),
+		}
+	}
+
+	if len(scopes.AllowedModels) == 0 && scopes.MaxTokens == 0 {
+		return nil
+	}
+
+	// 模型也可能写在路径中
+	pathModel := modelInPath(cleanPath)
+	if pathModel != "" && len(scopes.AllowedModels) > 0 && !pathModelAllowed(scopes.AllowedModels, pathModel) {
+		return modelViolation(scopes, pathModel)
+	}
+
+	// 没有请求体的 GET 等请求不会调用模型，只受路径中模型的限制
+	if len(bytes.TrimSpace(body)) == 0 && !methodHasBody(method) {
+		return nil
+	}
+
+	scope := "max_tokens"
+	if len(scopes.AllowedModels) > 0 {
+		scope = "allowed_models"
+	}
+	var payload map[string]interface{}
+	if err := json.Unmarshal(body, &payload); err != nil || payload == nil {
+		return &ScopeViolation{
+			Scope:   scope,
+			Message: "request body must be a JSON object because this API key restricts models or max_tokens",
+		}
+	}
+
+	if len(scopes.AllowedModels) > 0 {
+		rawModel, present := payload["model"]
+		modelID, isString := rawModel.(string)
+		switch {
+		case present && !isString:
+			return &ScopeViolation{Scope: "allowed_models", Message: "model must be a string"}
+		case !present && pathModel == "":
+			return &ScopeViolation{
+				Scope:   "allowed_models",
+				Message: fmt.Sprintf("request must specify a model for this API key (allowed: %s)", strings.Join(scopes.AllowedModels, ", ")),
+			}
+		case present && !containsString(scopes.AllowedModels, modelID):
+			return modelViolation(scopes, modelID)
+		}
+	}
+
+	if scopes.MaxTokens > 0 {
+		declared := false
+		for _, field := range maxTokensFields {
+			raw, ok := lookupField(payload, field)
+			if !ok {
+				continue
+			}
+			value, isNumber := raw.(float64)
+			if !isNumber {
+				return &ScopeViolation{Scope: "max_tokens", Message: fmt.Sprintf("%s must be a number", field)}
+			}
+			if value > float64(scopes.MaxTokens) {
+				return &ScopeViolation{
+					Scope:   "max_tokens",
+					Message: fmt.Sprintf("%s %d exceeds the limit of %d for this API key", field, int64(value), scopes.MaxTokens),
+				}
+			}
+			declared = true
+		}
+		// 不声明输出上限的请求由上游按默认值（可能远超限制）生成，同样拒绝
+		if !declared {
+			return &ScopeViolation{
+				Scope:   "max_tokens",
+				Message: fmt.Sprintf("request must set max_tokens (at most %d) for this API key", scopes.MaxTokens),
+			}
+		}
+	}
+	return nil
+}
+
+// modelViolation 模型不在密钥允许范围内的违反原因
+func modelViolation(scopes model.PlatformKeyScopes, modelID string) *ScopeViolation {
+	return &ScopeViolation{
+		Scope:   "allowed_models",
+		Message: fmt.Sprintf("model %s is not allowed for this API key (allowed: %s)", modelID, strings.Join(scopes.AllowedModels, ", ")),
+	}
+}
+
+// modelInPath 提取路径中 models/ 或 deployments/ 之后的模型名，没有时返回空字符串
+func modelInPath(requestPath string) string {
+	segments := strings.Split(strings.Trim(requestPath, "/"), "/")
+	for i := 0; i+1 < len(segments); i++ {
+		if containsString(pathModelSegments, segments[i]) && segments[i+1] != "" {
+			return segments[i+1]
+		}
+	}
+	return ""
+}
+
+// pathModelAllowed 判断路径中的模型是否在允许范围内，允许带有 Gemini 风格的 :action 后缀
+// 模型名本身可能包含 :（例如微调模型），因此先按完整的段匹配
+func pathModelAllowed(allowed []string, segment string) bool {
+	if containsString(allowed, segment) {
+		return true
+	}
+	if i := strings.LastIndex(segment, ":"); i > 0 {
+		return containsString(allowed, segment[:i])
+	}
+	return false
+}
+
+// methodHasBody 判断请求方法是否通常携带请求体
+func methodHasBody(method string) bool {
+	switch strings.ToUpper(method) {
+	case http.MethodGet, http.MethodHead, http.MethodDelete, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// lookupField 按 . 分隔的字段路径查找 JSON 对象中的值
+func lookupField(payload map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = payload
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[name]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// enforceKeyScopes 检查请求是否在平台密钥的访问范围内，违反时返回 403 并中止请求
+func enforceKeyScopes(c *gin.Context, platformKey *model.PlatformAPIKey, requestPath string) bool {
+	scopes := platformKey.Scopes
+	if scopes.IsEmpty() {
+		return true
+	}
+
+	// 读取请求体用于检查模型和 max_tokens，并重新设置请求体供后续使用
+	var body []byte
+	if (len(scopes.AllowedModels) > 0 || scopes.MaxTokens > 0) && c.Request.Body != nil {
+		body, _ = io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	}
+
+	// ClientIP 只采信可信代理（SetupRoutes 中按 TRUSTED_PROXIES 配置）传来的 X-Forwarded-For，客户端无法伪造来源IP
+	violation := CheckKeyScopes(scopes, c.Request.Method, requestPath, c.ClientIP(), body)
+	if violation == nil {
+		return true
+	}
+
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": "API key scope violation: " + violation.Message,
+		"code":  "key_scope_violation",
+		"scope": violation.Scope,
+	})
+	c.Abort()
+	return false
+}
+
+// matchAnyPathGlob 判断路径是否匹配任一路径规则
+// * 不跨越 /；以 /** 结尾的规则匹配该前缀本身及其下的所有路径
+func matchAnyPathGlob(globs []string, requestPath string) bool {
+	for _, glob := range globs {
+		if prefix, ok := strings.CutSuffix(glob, "/**"); ok {
+			// 取请求路径中与前缀段数相同的开头部分进行匹配
+			segments := strings.Split(requestPath, "/")
+			depth := strings.Count(prefix, "/")
+			if len(segments) > depth {
+				if matched, _ := path.Match(prefix, strings.Join(segments[:depth+1], "/")); matched {
+					return true
+				}
+			}
+			continue
+		}
+		if matched, _ := path.Match(glob, requestPath); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ipInCIDRs 判断 IP 是否属于任一网段
+func ipInCIDRs(clientIP string, cidrs []string) bool {
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false
+	}
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString 判断切片中是否包含指定字符串
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"api-trade-platform/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMatchAnyPathGlob(t *testing.T) {
+	tests := []struct {
+		globs []string
+		path  string
+		want  bool
+	}{
+		{[]string{"/v1/chat/*"}, "/v1/chat/completions", true},
+		{[]string{"/v1/chat/*"}, "/v1/chat/completions/extra", false},
+		{[]string{"/v1/chat/*"}, "/v1/embeddings", false},
+		{[]string{"/v1/**"}, "/v1", true},
+		{[]string{"/v1/**"}, "/v1/chat/completions", true},
+		{[]string{"/v1/**"}, "/v10/chat", false},
+		{[]string{"/v1/**"}, "/v2/chat", false},
+		{[]string{"/v1/*/completions/**"}, "/v1/chat/completions/stream/x", true},
+		{[]string{"/v1/*/completions/**"}, "/v1/chat", false},
+		{[]string{"/v1/embeddings", "/v1/chat/**"}, "/v1/embeddings", true},
+		{[]string{"/v1/embeddings", "/v1/chat/**"}, "/v1/models", false},
+	}
+	for _, tt := range tests {
+		if got := matchAnyPathGlob(tt.globs, tt.path); got != tt.want {
+			t.Errorf("matchAnyPathGlob(%v, %q) = %v, want %v", tt.globs, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCheckKeyScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		scopes model.PlatformKeyScopes
+		method string
+		path   string
+		ip     string
+		body   string
+		want   string // 违反的范围，空字符串表示放行
+	}{
+		{"no scopes", model.PlatformKeyScopes{}, "POST", "/anything", "203.0.113.9", "not json", ""},
+		{"ip allowed", model.PlatformKeyScopes{SourceCIDRs: []string{"10.0.0.0/8"}}, "GET", "/", "10.1.2.3", "", ""},
+		{"ip denied", model.PlatformKeyScopes{SourceCIDRs: []string{"10.0.0.0/8"}}, "GET", "/", "203.0.113.9", "", "source_cidrs"},
+		{"method denied", model.PlatformKeyScopes{AllowedMethods: []string{"POST"}}, "DELETE", "/", "", "", "allowed_methods"},
+		{"path allowed", model.PlatformKeyScopes{PathGlobs: []string{"/v1/**"}}, "GET", "/v1/models", "", "", ""},
+		{"dot dot escapes prefix", model.PlatformKeyScopes{PathGlobs: []string{"/v1/**"}}, "GET", "/v1/../admin/users", "", "", "path_globs"},
+		{"dot dot inside prefix", model.PlatformKeyScopes{PathGlobs: []string{"/v1/**"}}, "GET", "/v1/chat/../models", "", "", ""},
+
+		{"model allowed", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "POST", "/v1/chat/completions", "", `{"model":"gpt-4o-mini"}`, ""},
+		{"model denied", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "POST", "/v1/chat/completions", "", `{"model":"gpt-4o"}`, "allowed_models"},
+		{"model missing", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "POST", "/v1/chat/completions", "", `{"messages":[]}`, "allowed_models"},
+		{"model not a string", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "POST", "/v1/chat/completions", "", `{"model":["gpt-4o-mini"]}`, "allowed_models"},
+		{"body not json", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "POST", "/v1/chat/completions", "", `model=gpt-4o`, "allowed_models"},
+		{"empty post body", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "POST", "/v1/chat/completions", "", ``, "allowed_models"},
+		{"get without body", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "GET", "/v1/models", "", ``, ""},
+		{"path model allowed", model.PlatformKeyScopes{AllowedModels: []string{"gemini-1.5-pro"}}, "POST", "/v1beta/models/gemini-1.5-pro:generateContent", "", `{"contents":[]}`, ""},
+		{"path model denied", model.PlatformKeyScopes{AllowedModels: []string{"gemini-1.5-pro"}}, "POST", "/v1beta/models/gemini-ultra:generateContent", "", `{"contents":[]}`, "allowed_models"},
+		{"path model denied without body", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "GET", "/v1/models/gpt-4o", "", ``, "allowed_models"},
+		{"deployment denied", model.PlatformKeyScopes{AllowedModels: []string{"gpt-4o-mini"}}, "POST", "/openai/deployments/gpt-4o/chat/completions", "", `{"messages":[]}`, "allowed_models"},
+		{"fine-tuned path model", model.PlatformKeyScopes{AllowedModels: []string{"ft:gpt-4o-mini:acme::abc"}}, "GET", "/v1/models/ft:gpt-4o-mini:acme::abc", "", ``, ""},
+
+		{"max tokens within limit", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1/chat/completions", "", `{"max_tokens":1000}`, ""},
+		{"max tokens over limit", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1/chat/completions", "", `{"max_tokens":1001}`, "max_tokens"},
+		{"max completion tokens over limit", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1/chat/completions", "", `{"max_tokens":10,"max_completion_tokens":5000}`, "max_tokens"},
+		{"max tokens missing", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1/chat/completions", "", `{"model":"gpt-4o"}`, "max_tokens"},
+		{"max tokens not a number", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1/chat/completions", "", `{"max_tokens":"10"}`, "max_tokens"},
+		{"max tokens body not json", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1/chat/completions", "", `[1,2]`, "max_tokens"},
+		{"gemini max output tokens", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1beta/models/gemini-1.5-pro:generateContent", "", `{"generationConfig":{"maxOutputTokens":800}}`, ""},
+		{"gemini max output tokens over limit", model.PlatformKeyScopes{MaxTokens: 1000}, "POST", "/v1beta/models/gemini-1.5-pro:generateContent", "", `{"generationConfig":{"maxOutputTokens":8000}}`, "max_tokens"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violation := CheckKeyScopes(tt.scopes, tt.method, tt.path, tt.ip, []byte(tt.body))
+			got := ""
+			if violation != nil {
+				got = violation.Scope
+			}
+			if got != tt.want {
+				t.Errorf("CheckKeyScopes() violated scope = %q (%v), want %q", got, violation, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnforceKeyScopesIgnoresSpoofedForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	// 与 SetupRoutes 在未配置 TRUSTED_PROXIES 时一致
+	if err := router.SetTrustedProxies(nil); err != nil {
+		t.Fatalf("SetTrustedProxies() error = %v", err)
+	}
+	key := &model.PlatformAPIKey{Scopes: model.PlatformKeyScopes{SourceCIDRs: []string{"10.0.0.0/8"}}}
+	router.GET("/", func(c *gin.Context) {
+		if enforceKeyScopes(c, key, "/") {
+			c.Status(http.StatusOK)
+		}
+	})
+
+	tests := []struct {
+		remoteAddr   string
+		forwardedFor string
+		wantStatus   int
+	}{
+		{"10.1.2.3:5000", "", http.StatusOK},
+		{"203.0.113.9:5000", "10.1.2.3", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.wantStatus {
+			t.Errorf("request from %s (X-Forwarded-For %q) status = %d, want %d", tt.remoteAddr, tt.forwardedFor, w.Code, tt.wantStatus)
+		}
+	}
+}
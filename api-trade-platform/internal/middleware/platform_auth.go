@@ -4,6 +4,7 @@ import (
 	"api-trade-platform/internal/model"
 	"api-trade-platform/internal/store/postgres"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -41,6 +42,15 @@ func PlatformAPIKeyAuthMiddleware(platformKeyStore *postgres.PlatformKeyStore) g
 			return
 		}
 
+		// 检查密钥的访问范围（方法、卖家路径、模型、max_tokens、来源IP）
+		sellerPath := c.Param("seller_path")
+		if sellerPath == "" {
+			sellerPath = "/"
+		}
+		if !enforceKeyScopes(c, platformKey, sellerPath) {
+			return
+		}
+
 		// 将平台密钥和API服务信息存储到上下文中
 		c.Set("platform_key", platformKey)
 		c.Set("api_service", apiService)
@@ -51,6 +61,57 @@ func PlatformAPIKeyAuthMiddleware(platformKeyStore *postgres.PlatformKeyStore) g
 	}
 }
 
+// BuyerAPIKeyAuthMiddleware 统一路由 (/v1) 使用的平台API密钥认证中间件
+// 买家可以使用任意一个有效的平台密钥，具体转发到哪个服务由请求中的模型决定。
+// 兼容 OpenAI SDK，同时支持 Authorization: Bearer 和 X-API-Key 两种方式传递密钥。
+func BuyerAPIKeyAuthMiddleware(platformKeyStore *postgres.PlatformKeyStore) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader("X-API-Key")
+		if apiKey == "" {
+			authHeader := c.GetHeader("Authorization")
+			if strings.HasPrefix(authHeader, "Bearer ") {
+				apiKey = strings.TrimSpace(strings.TrimPrefix(authHeader, "Bearer "))
+			}
+		}
+		if apiKey == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Authorization: Bearer or X-API-Key header is required",
+			})
+			c.Abort()
+			return
+		}
+
+		platformKey, err := platformKeyStore.GetPlatformAPIKeyByKey(apiKey)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid or inactive API key",
+			})
+			c.Abort()
+			return
+		}
+
+		if platformKey.ExpiresAt != nil && platformKey.ExpiresAt.Before(time.Now()) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "API key has expired",
+			})
+			c.Abort()
+			return
+		}
+
+		// 统一路由下路径规则匹配 /v1 请求路径
+		if !enforceKeyScopes(c, platformKey, c.Request.URL.Path) {
+			return
+		}
+
+		// 只确定买家身份，服务和实际使用的订阅密钥由路由处理函数决定；
+		// 买家所持的密钥也放入上下文，路由到该密钥所属服务时用量记在这个密钥上
+		c.Set("buyer_user_id", platformKey.BuyerUserID)
+		c.Set("platform_key", platformKey)
+
+		c.Next()
+	}
+}
+
 // GetPlatformKeyFromContext 从上下文获取平台API密钥
 func GetPlatformKeyFromContext(c *gin.Context) (*model.PlatformAPIKey, bool) {
 	key, exists := c.Get("platform_key")
@@ -1,6 +1,9 @@
 package model
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 )
 
@@ -35,6 +38,22 @@ type APIService struct {
 	// 新增定价字段
 	PricingModel             string    `json:"pricing_model,omitempty" example:"per_call" enums:"per_call,per_token" description:"定价模式"`
 	PricePerToken            float64   `json:"price_per_token,omitempty" example:"0.001" description:"每token价格(USD)"`
+	// 阶梯定价计划，为空时按 PricingModel 的固定价格计费
+	PricingPlan              *PricingPlan `json:"pricing_plan,omitempty" description:"阶梯定价计划"`
+	// 上游协议格式
+	APIFormat                string    `json:"api_format" example:"openai" enums:"openai,anthropic" description:"上游API协议格式，anthropic 格式的服务会自动转换 OpenAI Chat Completions 请求"`
+	// 上游池负载均衡策略
+	LBStrategy               string    `json:"lb_strategy" example:"weighted_round_robin" enums:"weighted_round_robin,least_latency" description:"配置了多个上游时的负载均衡策略"`
+	// 上游传输超时（毫秒），为0时使用平台默认值
+	ConnectTimeoutMs         int       `json:"connect_timeout_ms,omitempty" example:"5000" description:"连接上游的超时时间(毫秒)"`
+	ResponseHeaderTimeoutMs  int       `json:"response_header_timeout_ms,omitempty" example:"120000" description:"等待上游响应头的超时时间(毫秒)"`
+	IdleConnTimeoutMs        int       `json:"idle_conn_timeout_ms,omitempty" example:"90000" description:"空闲keep-alive连接保留时间(毫秒)"`
+	// 上游认证方式
+	AuthScheme               UpstreamAuthScheme `json:"auth_scheme" description:"调用上游时附加卖家密钥的方式"`
+	// 计费策略：可计费状态码与 SLA 承诺
+	BillingPolicy            BillingPolicy `json:"billing_policy" description:"哪些调用计费以及服务的 SLA 承诺"`
+	// 卖家设置的代理限流
+	RateLimits               ServiceRateLimits `json:"rate_limits" description:"服务整体和每个订阅者的每分钟请求数上限"`
 	TotalCalls               int64     `json:"total_calls,omitempty" example:"10000" description:"总调用次数"`
 	SubscriberCount          int64     `json:"subscriber_count,omitempty" example:"50" description:"订阅者数量"`
 	Features                 string    `json:"features,omitempty" example:"[\"real-time\",\"global\"]" description:"特性标签JSON数组字符串"`
@@ -43,16 +62,350 @@ type APIService struct {
 	UpdatedAt                time.Time `json:"updated_at" example:"2024-01-01T00:00:00Z" description:"服务最后更新时间"`
 }
 
+// UpstreamAuthScheme 代表代理调用卖家上游时附加卖家密钥的方式，以 JSON 存储在 api_services.auth_scheme
+// @Description 上游认证方式。type 为空时沿用自动检测（兼容旧服务），其余类型严格按配置执行。
+// @Description 模板中的 {key} 会被替换为解密后的卖家密钥。
+type UpstreamAuthScheme struct {
+	Type              string            `json:"type,omitempty" example:"header" enums:"header,query,basic,hmac,none" description:"认证类型，为空表示自动检测"`
+	HeaderName        string            `json:"header_name,omitempty" example:"api-key" description:"header 类型：放置密钥的请求头"`
+	Template          string            `json:"template,omitempty" example:"Bearer {key}" description:"header 类型：请求头的值模板，默认 {key}"`
+	QueryParam        string            `json:"query_param,omitempty" example:"key" description:"query 类型：放置密钥的查询参数"`
+	Username          string            `json:"username,omitempty" example:"account-1" description:"basic 类型：用户名，密钥作为密码；为空时密钥需为 user:password 形式"`
+	SignatureHeader   string            `json:"signature_header,omitempty" example:"X-Signature" description:"hmac 类型：签名请求头，默认 X-Signature"`
+	TimestampHeader   string            `json:"timestamp_header,omitempty" example:"X-Timestamp" description:"hmac 类型：时间戳请求头，默认 X-Timestamp"`
+	SignatureEncoding string            `json:"signature_encoding,omitempty" example:"hex" enums:"hex,base64" description:"hmac 类型：签名编码，默认 hex"`
+	ExtraHeaders      map[string]string `json:"extra_headers,omitempty" example:"anthropic-version:2023-06-01" description:"额外附加的静态请求头，值同样支持 {key} 模板"`
+}
+
+// Value 实现 driver.Valuer，未配置认证方式时存储为 NULL
+func (s UpstreamAuthScheme) Value() (driver.Value, error) {
+	if s.Type == "" && len(s.ExtraHeaders) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner，NULL 视为未配置
+func (s *UpstreamAuthScheme) Scan(src interface{}) error {
+	*s = UpstreamAuthScheme{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("unsupported auth scheme type %T", src)
+	}
+}
+
+// 零费率原因：调用被记录但不向买家收费
+const (
+	ZeroRatedStatusNotBillable = "status_not_billable" // 响应状态码不在卖家声明的可计费状态码中
+	ZeroRatedUpstreamInterrupt = "upstream_interrupted" // 上游在流式响应中途中断或超时
+	ZeroRatedLatencyExceeded   = "latency_exceeded"     // 响应时间超过卖家声明的计费时限
+	ZeroRatedCircuitOpen       = "circuit_open"         // 上游熔断中，请求没有转发，计入服务 SLA 的失败调用
+)
+
+// BillingPolicy 代表服务的计费策略，以 JSON 存储在 api_services.billing_policy
+// @Description 计费策略。未声明可计费状态码时只有 2xx 响应计费；失败(5xx)、中途中断和超时的调用一律不计费。
+// @Description 配置 SLA 后平台每月按服务的可用率和 P95 延迟评估，未达标时按买家当月在该服务的消费自动发放补偿。
+type BillingPolicy struct {
+	BillableStatusCodes  []string   `json:"billable_status_codes,omitempty" example:"2xx,404" description:"可计费的状态码，支持具体状态码或 2xx/3xx/4xx 形式，不允许 5xx；为空时为 2xx"`
+	MaxBillableLatencyMs int        `json:"max_billable_latency_ms,omitempty" example:"60000" description:"响应时间超过该值(毫秒)的调用视为超时不计费，0 表示不限"`
+	SLA                  *ServiceSLA `json:"sla,omitempty" description:"服务等级承诺，为空表示不提供 SLA 补偿"`
+}
+
+// ServiceSLA 代表服务声明的月度 SLA 目标与未达标时的补偿比例
+type ServiceSLA struct {
+	UptimeTargetBps    int `json:"uptime_target_bps" example:"9990" description:"月度可用率目标(基点)，9990 即 99.90%；5xx 和连接失败计为不可用"`
+	LatencyP95TargetMs int `json:"latency_p95_target_ms,omitempty" example:"3000" description:"月度成功调用 P95 延迟目标(毫秒)，0 表示不考核延迟"`
+	CreditBps          int `json:"credit_bps" example:"1000" description:"未达标时补偿买家当月在该服务消费的比例(基点)"`
+}
+
+// Value 实现 driver.Valuer，未配置计费策略时存储为 NULL
+func (p BillingPolicy) Value() (driver.Value, error) {
+	if len(p.BillableStatusCodes) == 0 && p.MaxBillableLatencyMs == 0 && p.SLA == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner，NULL 视为默认策略
+func (p *BillingPolicy) Scan(src interface{}) error {
+	*p = BillingPolicy{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	default:
+		return fmt.Errorf("unsupported billing policy type %T", src)
+	}
+}
+
+// ServiceRateLimits 代表卖家为服务设置的代理限流，以 JSON 存储在 api_services.rate_limits，0 表示不限制
+// @Description 调用服务时的限流，超过时返回 429 和 Retry-After，同时作用于 /proxy/v1、/v1 和回退链中实际尝试的服务。
+// @Description 请求数限流中订阅者和服务整体的配额都足够时才扣减；
+// @Description token 限流在转发前按预估输入 token 加 max_tokens 预留，拿到实际用量后修正
+// @Description 并发限制统计同时进行中的请求，名额不足时请求按买家轮转排队等待，排队超时或队列已满时返回 429
+type ServiceRateLimits struct {
+	RequestsPerMinute               int            `json:"requests_per_minute,omitempty" example:"6000" description:"服务所有买家合计每分钟最多请求数，0 表示不限"`
+	SubscriberRequestsPerMinute     int            `json:"subscriber_requests_per_minute,omitempty" example:"600" description:"每个订阅买家每分钟最多请求数，0 表示不限"`
+	RequestBurst                    int            `json:"request_burst,omitempty" example:"500" description:"服务所有买家合计空闲后最多可连续发出的请求数，0 表示等于每分钟请求数"`
+	SubscriberRequestBurst          int            `json:"subscriber_request_burst,omitempty" example:"50" description:"每个订阅买家空闲后最多可连续发出的请求数，0 表示等于每分钟请求数"`
+	TokensPerMinute                 int            `json:"tokens_per_minute,omitempty" example:"2000000" description:"服务所有买家合计每分钟最多 token 数，0 表示不限"`
+	SubscriberTokensPerMinute       int            `json:"subscriber_tokens_per_minute,omitempty" example:"200000" description:"每个订阅买家每分钟最多 token 数，0 表示不限"`
+	KeyTokensPerMinute              int            `json:"key_tokens_per_minute,omitempty" example:"100000" description:"每个平台密钥每分钟最多 token 数，0 表示不限"`
+	ModelTokensPerMinute            map[string]int `json:"model_tokens_per_minute,omitempty" description:"按模型设置的服务每分钟最多 token 数"`
+	MaxConcurrentRequests           int            `json:"max_concurrent_requests,omitempty" example:"200" description:"服务所有买家合计同时进行中的最多请求数，0 表示不限"`
+	SubscriberMaxConcurrentRequests int            `json:"subscriber_max_concurrent_requests,omitempty" example:"20" description:"每个订阅买家同时进行中的最多请求数，0 表示不限"`
+	KeyMaxConcurrentRequests        int            `json:"key_max_concurrent_requests,omitempty" example:"10" description:"每个平台密钥同时进行中的最多请求数，0 表示不限"`
+}
+
+// IsEmpty 判断是否未设置任何限流
+func (l ServiceRateLimits) IsEmpty() bool {
+	return l.RequestsPerMinute == 0 && l.SubscriberRequestsPerMinute == 0 && l.RequestBurst == 0 && l.SubscriberRequestBurst == 0 &&
+		l.TokensPerMinute == 0 &&
+		l.SubscriberTokensPerMinute == 0 && l.KeyTokensPerMinute == 0 && len(l.ModelTokensPerMinute) == 0 &&
+		l.MaxConcurrentRequests == 0 && l.SubscriberMaxConcurrentRequests == 0 && l.KeyMaxConcurrentRequests == 0
+}
+
+// Value 实现 driver.Valuer，未设置限流时存储为 NULL
+func (l ServiceRateLimits) Value() (driver.Value, error) {
+	if l.IsEmpty() {
+		return nil, nil
+	}
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner，NULL 视为不限制
+func (l *ServiceRateLimits) Scan(src interface{}) error {
+	*l = ServiceRateLimits{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, l)
+	case string:
+		return json.Unmarshal([]byte(v), l)
+	default:
+		return fmt.Errorf("unsupported rate limits type %T", src)
+	}
+}
+
+// TokenBudget 代表买家设置的每分钟 token 预算，0 表示不限
+type TokenBudget struct {
+	TokensPerMinute      int            `json:"tokens_per_minute,omitempty" example:"100000" description:"每分钟最多 token 数，0 表示不限"`
+	ModelTokensPerMinute map[string]int `json:"model_tokens_per_minute,omitempty" description:"按模型设置的每分钟最多 token 数"`
+}
+
+// IsEmpty 判断是否未设置任何预算
+func (b TokenBudget) IsEmpty() bool {
+	return b.TokensPerMinute == 0 && len(b.ModelTokensPerMinute) == 0
+}
+
+// Value 实现 driver.Valuer
+func (b TokenBudget) Value() (driver.Value, error) {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner，NULL 视为不限制
+func (b *TokenBudget) Scan(src interface{}) error {
+	*b = TokenBudget{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, b)
+	case string:
+		return json.Unmarshal([]byte(v), b)
+	default:
+		return fmt.Errorf("unsupported token budget type %T", src)
+	}
+}
+
+// 定价计划的计量单位
+const (
+	PricingUnitCall  = "call"
+	PricingUnitToken = "token"
+)
+
+// 定价计划的分档方式
+const (
+	PricingModeGraduated = "graduated" // 累进：每档内的用量按该档价格计费
+	PricingModeVolume    = "volume"    // 总量：本次调用全部按累计用量所在档的价格计费
+)
+
+// PricingPlan 代表服务的阶梯定价计划，以 JSON 存储在 api_services.pricing_plan
+// @Description 阶梯定价计划。用量按买家在当前自然月(UTC)内对该服务的累计成功调用次数或 token 数分档，
+// @Description 每月前 included_units 个单位免费，超出部分按分档价格计费。未配置计划的服务按 pricing_model 的固定价格计费。
+type PricingPlan struct {
+	Unit          string        `json:"unit" example:"token" enums:"call,token" description:"计量单位：call(调用次数) 或 token(输入+输出 token 数)"`
+	Mode          string        `json:"mode,omitempty" example:"graduated" enums:"graduated,volume" description:"分档方式，默认 graduated"`
+	IncludedUnits int64         `json:"included_units,omitempty" example:"100000" description:"每月包含的免费用量"`
+	Tiers         []PricingTier `json:"tiers" description:"按 up_to 递增排列的价格档，最后一档 up_to 为 0 表示无上限"`
+}
+
+// PricingTier 代表定价计划中的一档价格，价格均为微美元
+type PricingTier struct {
+	UpTo                   int64 `json:"up_to,omitempty" example:"1000000" description:"本档覆盖到的累计用量（不含），0 表示无上限"`
+	PricePerCallMicros     int64 `json:"price_per_call_micros,omitempty" example:"10000" description:"unit=call：每次调用价格(微美元)"`
+	InputPricePer1MMicros  int64 `json:"input_price_per_1m_micros,omitempty" example:"150000" description:"unit=token：每百万输入 token 价格(微美元)"`
+	OutputPricePer1MMicros int64 `json:"output_price_per_1m_micros,omitempty" example:"600000" description:"unit=token：每百万输出 token 价格(微美元)"`
+	// 按 token 类别单独定价（unit=token），未设置时缓存、音频和图像输入按输入价格，推理、音频和图像输出按输出价格
+	CachedInputPricePer1MMicros *int64 `json:"cached_input_price_per_1m_micros,omitempty" example:"75000" description:"每百万缓存命中输入 token 价格(微美元)"`
+	CacheWritePricePer1MMicros  *int64 `json:"cache_write_price_per_1m_micros,omitempty" example:"187500" description:"每百万缓存写入 token 价格(微美元)"`
+	ReasoningPricePer1MMicros   *int64 `json:"reasoning_price_per_1m_micros,omitempty" example:"600000" description:"每百万推理 token 价格(微美元)"`
+	AudioInputPricePer1MMicros  *int64 `json:"audio_input_price_per_1m_micros,omitempty" example:"10000000" description:"每百万音频输入 token 价格(微美元)"`
+	AudioOutputPricePer1MMicros *int64 `json:"audio_output_price_per_1m_micros,omitempty" example:"20000000" description:"每百万音频输出 token 价格(微美元)"`
+	ImageInputPricePer1MMicros  *int64 `json:"image_input_price_per_1m_micros,omitempty" example:"150000" description:"每百万图像输入 token 价格(微美元)"`
+	ImageOutputPricePer1MMicros *int64 `json:"image_output_price_per_1m_micros,omitempty" example:"40000000" description:"每百万图像输出 token 价格(微美元)"`
+}
+
+// Value 实现 driver.Valuer，nil 计划存储为 NULL
+func (p *PricingPlan) Value() (driver.Value, error) {
+	if p == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner
+func (p *PricingPlan) Scan(src interface{}) error {
+	*p = PricingPlan{}
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	default:
+		return fmt.Errorf("unsupported pricing plan type %T", src)
+	}
+}
+
+// PeriodUsage 代表买家在当前计费周期内对某个服务的累计用量
+type PeriodUsage struct {
+	Calls  int64
+	Tokens int64
+}
+
 // PlatformAPIKey 代表由平台为买家生成的 API 密钥，用于访问特定的 API 服务
 type PlatformAPIKey struct {
-	KeyID          int64      `json:"key_id"`
-	BuyerUserID    int64      `json:"buyer_user_id"`
-	ServiceID      int64      `json:"service_id"`
-	PlatformAPIKey string     `json:"platform_api_key"` // 平台生成的 API 密钥
-	IsActive       bool       `json:"is_active"`
-	ExpiresAt      *time.Time `json:"expires_at,omitempty"` // 密钥过期时间 (可选)
-	CreatedAt      time.Time  `json:"created_at"`
-	UpdatedAt      time.Time  `json:"updated_at"`
+	KeyID            int64             `json:"key_id"`
+	BuyerUserID      int64             `json:"buyer_user_id"`
+	ServiceID        int64             `json:"service_id"`
+	Name             string            `json:"name"`                          // 密钥名称，例如 ci、staging、prod
+	KeyPrefix        string            `json:"key_prefix"`                    // 密钥的公开前缀，用于查找和脱敏展示
+	KeyHash          string            `json:"-"`                             // 完整密钥的 SHA-256 哈希，平台不保存密钥明文
+	IsActive         bool              `json:"is_active"`
+	ExpiresAt        *time.Time        `json:"expires_at,omitempty"`          // 密钥过期时间 (可选)，轮换后旧密钥在宽限期结束时过期
+	RevokedAt        *time.Time        `json:"revoked_at,omitempty"`          // 密钥吊销时间
+	RotatedFromKeyID *int64            `json:"rotated_from_key_id,omitempty"` // 轮换时被本密钥替换的旧密钥
+	Scopes           PlatformKeyScopes `json:"scopes"`                        // 密钥的访问范围限制，为空表示不限制
+	TokenBudget      TokenBudget       `json:"-"`                             // 买家为密钥所属服务设置的 token 预算（同一服务的所有密钥共享），只在查询订阅路由时读取
+	CreatedAt        time.Time         `json:"created_at"`
+	UpdatedAt        time.Time         `json:"updated_at"`
+}
+
+// PlatformKeyScopes 代表平台密钥的访问范围限制，以 JSON 存储在 platform_api_keys.scopes
+// @Description 平台密钥的访问范围。每一项为空表示该维度不限制，代理对违反范围的请求返回 403。
+type PlatformKeyScopes struct {
+	AllowedMethods []string `json:"allowed_methods,omitempty" example:"GET,POST" description:"允许的 HTTP 方法"`
+	PathGlobs      []string `json:"path_globs,omitempty" example:"/chat/completions,/models/**" description:"允许的卖家路径（/proxy/v1 下为 seller_path，/v1 下为请求路径），* 不跨越 /，以 /** 结尾匹配该前缀下的所有路径"`
+	AllowedModels  []string `json:"allowed_models,omitempty" example:"gpt-4o-mini" description:"允许请求的模型，检查请求体的 model 字段和路径中 models/、deployments/ 后的模型名；带请求体的请求必须声明模型"`
+	MaxTokens      int      `json:"max_tokens,omitempty" example:"1024" description:"请求中 max_tokens（或 max_completion_tokens、max_output_tokens、generationConfig.maxOutputTokens）的上限，设置后请求必须声明其中之一，0 表示不限制"`
+	SourceCIDRs    []string `json:"source_cidrs,omitempty" example:"203.0.113.0/24" description:"允许的调用方 IP 段，只采信 TRUSTED_PROXIES 中代理传来的 X-Forwarded-For"`
+	// 密钥的每分钟 token 预算，转发前按预估用量预留
+	TokensPerMinute      int            `json:"tokens_per_minute,omitempty" example:"100000" description:"该密钥每分钟最多 token 数，0 表示不限制"`
+	ModelTokensPerMinute map[string]int `json:"model_tokens_per_minute,omitempty" description:"该密钥按模型设置的每分钟最多 token 数"`
+}
+
+// IsEmpty 判断是否未设置任何范围限制
+func (s PlatformKeyScopes) IsEmpty() bool {
+	return len(s.AllowedMethods) == 0 && len(s.PathGlobs) == 0 && len(s.AllowedModels) == 0 &&
+		s.MaxTokens == 0 && len(s.SourceCIDRs) == 0 && s.TokensPerMinute == 0 && len(s.ModelTokensPerMinute) == 0
+}
+
+// Value 实现 driver.Valuer，未设置范围限制时存储为 NULL
+func (s PlatformKeyScopes) Value() (driver.Value, error) {
+	if s.IsEmpty() {
+		return nil, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 实现 sql.Scanner，NULL 视为不限制
+func (s *PlatformKeyScopes) Scan(src interface{}) error {
+	*s = PlatformKeyScopes{}
+	switch v := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("unsupported key scopes type %T", src)
+	}
+}
+
+// ServiceRoute 代表统一路由命中的一条订阅：买家在该服务上的平台密钥及服务信息
+type ServiceRoute struct {
+	PlatformKey *PlatformAPIKey
+	APIService  *APIService
+}
+
+// ServiceModel 代表卖家服务目录中声明的一个模型
+// @Description 服务模型目录条目，包含上下文窗口、模态和按模型定价
+type ServiceModel struct {
+	ID               int64     `json:"id" example:"1" description:"目录条目ID"`
+	ServiceID        int64     `json:"service_id" example:"1" description:"所属API服务ID"`
+	ModelID          string    `json:"model_id" example:"gpt-4o-mini" description:"模型ID，与请求体中的 model 字段一致"`
+	ContextWindow    int       `json:"context_window,omitempty" example:"128000" description:"上下文窗口大小(token)"`
+	Modalities       []string  `json:"modalities,omitempty" example:"text,image" description:"支持的模态"`
+	InputPricePer1K  float64   `json:"input_price_per_1k,omitempty" example:"0.00015" description:"每1K输入token价格(USD)，为0时使用服务定价"`
+	OutputPricePer1K float64   `json:"output_price_per_1k,omitempty" example:"0.0006" description:"每1K输出token价格(USD)，为0时使用服务定价"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// HasPricing 判断模型是否设置了独立的按 token 定价
+func (m *ServiceModel) HasPricing() bool {
+	return m.InputPricePer1K > 0 || m.OutputPricePer1K > 0
+}
+
+// SubscribedModel 买家通过订阅可以访问的模型，附带提供该模型的服务名称
+type SubscribedModel struct {
+	ServiceModel
+	ServiceName string `json:"service_name"`
 }
 
 // UsageLog 代表一次 API 调用的使用日志
@@ -72,10 +425,339 @@ type UsageLog struct {
 	InputTokens        int       `json:"input_tokens"`
 	OutputTokens       int       `json:"output_tokens"`
 	TotalTokens        int       `json:"total_tokens"`
+	// 输入/输出 token 中按类别单独计价的部分
+	CachedInputTokens  int       `json:"cached_input_tokens"`
+	CacheWriteTokens   int       `json:"cache_write_tokens"`
+	ReasoningTokens    int       `json:"reasoning_tokens"`
+	AudioInputTokens   int       `json:"audio_input_tokens"`
+	AudioOutputTokens  int       `json:"audio_output_tokens"`
+	ImageInputTokens   int       `json:"image_input_tokens"`
+	ImageOutputTokens  int       `json:"image_output_tokens"`
 	Cost               float64   `json:"cost"`                // Cost in USD for this API call
+	CostMicros         int64     `json:"cost_micros"`         // 精确费用(微美元)，钱包结算和账本以此为准
 	ModelName          string    `json:"model_name,omitempty"`
 	RequestSizeBytes   int       `json:"request_size_bytes"`
 	ResponseSizeBytes  int       `json:"response_size_bytes"`
+	RequestID          string    `json:"request_id,omitempty"` // 同一买家请求的多次回退尝试共享该ID
+	ZeroRatedReason    string    `json:"zero_rated_reason,omitempty"` // 调用不计费的原因，计费或没有 token 用量时为空；熔断中未转发的调用为 circuit_open
+}
+
+// FallbackChain 买家定义的回退链：匹配某个模型或服务的请求失败时按顺序尝试其他已订阅服务
+type FallbackChain struct {
+	ChainID     int64     `json:"chain_id" example:"1"`
+	BuyerUserID int64     `json:"buyer_user_id" example:"2"`
+	Name        string    `json:"name" example:"gpt-4o backup"`
+	ModelID     string    `json:"model_id,omitempty" example:"gpt-4o" description:"按模型匹配，与 service_id 二选一"`
+	ServiceID   *int64    `json:"service_id,omitempty" example:"3" description:"按服务匹配，与 model_id 二选一"`
+	ServiceIDs  []int64   `json:"service_ids" example:"3,5,8" description:"按顺序尝试的服务ID列表"`
+	IsActive    bool      `json:"is_active" example:"true"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// ServiceUpstream 代表 API 服务上游池中的一个上游，每个上游有独立的端点、密钥和权重
+// @Description 服务上游，配置后代理在上游池中负载均衡，上游池为空时使用服务的原始端点
+type ServiceUpstream struct {
+	UpstreamID      int64      `json:"upstream_id" example:"1" description:"上游唯一标识符"`
+	ServiceID       int64      `json:"service_id" example:"1" description:"所属API服务ID"`
+	Name            string     `json:"name,omitempty" example:"us-east" description:"上游名称，便于区分地域或密钥"`
+	EndpointURL     string     `json:"endpoint_url" example:"https://us-east.api.example.com/v1" description:"上游的基础URL"`
+	EncryptedAPIKey string     `json:"-"` // 加密存储的上游 API 密钥，不通过 API 返回
+	Weight          int        `json:"weight" example:"1" description:"加权轮询的权重"`
+	HealthCheckPath string     `json:"health_check_path,omitempty" example:"/models" description:"健康检查路径，为空时探测基础URL"`
+	IsActive        bool       `json:"is_active" example:"true" description:"卖家是否启用该上游"`
+	IsHealthy       bool       `json:"is_healthy" example:"true" description:"健康检查状态，不健康的上游会被暂时剔除"`
+	LastCheckedAt   *time.Time `json:"last_checked_at,omitempty" description:"最近一次健康状态变化的时间"`
+	LastError       string     `json:"last_error,omitempty" description:"最近一次导致剔除的错误"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// BuyerWallet 代表买家的预付费钱包，金额以微美元（1 USD = 1,000,000）为单位
+// @Description 买家钱包，可用余额 = 余额 - 冻结金额
+type BuyerWallet struct {
+	UserID        int64     `json:"user_id" example:"2" description:"买家用户ID"`
+	BalanceMicros int64     `json:"balance_micros" example:"25000000" description:"已结算余额(微美元)，实际费用超过冻结金额时可能略低于0"`
+	HeldMicros    int64     `json:"held_micros" example:"120000" description:"进行中请求冻结的金额(微美元)"`
+	Currency      string    `json:"currency" example:"USD" description:"币种"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// AvailableMicros 获取可用于新请求的余额
+func (w *BuyerWallet) AvailableMicros() int64 {
+	return w.BalanceMicros - w.HeldMicros
+}
+
+// WalletTopUp 代表一次钱包充值记录
+type WalletTopUp struct {
+	TopUpID         int64     `json:"topup_id" example:"1"`
+	UserID          int64     `json:"user_id" example:"2"`
+	AmountMicros    int64     `json:"amount_micros" example:"10000000" description:"充值金额(微美元)"`
+	Reference       string    `json:"reference,omitempty" example:"stripe_pi_3Nx..." description:"外部支付流水号，同一买家不可重复"`
+	Note            string    `json:"note,omitempty" example:"Invoice 2026-10"`
+	CreatedByUserID *int64    `json:"created_by_user_id,omitempty" example:"1" description:"执行充值的管理员"`
+	CreatedAt       time.Time `json:"created_at"`
+}
+
+// WalletHold 代表代理转发前为一次调用冻结的预估费用
+type WalletHold struct {
+	HoldID          int64      `json:"hold_id"`
+	UserID          int64      `json:"user_id"`
+	ServiceID       int64      `json:"service_id"`
+	RequestID       string     `json:"request_id,omitempty"`
+	AmountMicros    int64      `json:"amount_micros"`
+	Status          string     `json:"status"` // held, settled, released, expired
+	SettledMicros   int64      `json:"settled_micros"`
+	ShortfallMicros int64      `json:"shortfall_micros"` // 实际费用中冻结金额与可用余额都不足以支付、未扣除的部分
+	ExpiresAt       time.Time  `json:"expires_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	SettledAt       *time.Time `json:"settled_at,omitempty"`
+}
+
+// 账本账户类型：余额为正表示平台欠账户所有者的金额
+const (
+	LedgerAccountBuyerWallet     = "buyer_wallet"     // 买家预付费余额
+	LedgerAccountSellerEarnings  = "seller_earnings"  // 卖家待结算收入
+	LedgerAccountPlatformRevenue = "platform_revenue" // 平台收入
+	LedgerAccountPlatformCash    = "platform_cash"    // 平台实际收付的资金（充值流入、结算流出）
+)
+
+// 账本分录类型
+const (
+	LedgerEntryUsageCharge    = "usage_charge"    // 计费调用：买家支付，卖家与平台收入
+	LedgerEntryTopUp          = "topup"           // 买家充值
+	LedgerEntryRefund         = "refund"          // 退还计费调用的费用
+	LedgerEntryPayout         = "payout"          // 向卖家结算收入
+	LedgerEntryOpeningBalance = "opening_balance" // 启用账本前已有的钱包余额
+	LedgerEntrySLACredit      = "sla_credit"      // 服务未达到 SLA 时补偿买家
+)
+
+// LedgerAccount 代表复式记账中的一个账户
+type LedgerAccount struct {
+	AccountID     int64     `json:"account_id" example:"1"`
+	AccountType   string    `json:"account_type" example:"buyer_wallet" enums:"buyer_wallet,seller_earnings,platform_revenue,platform_cash"`
+	OwnerUserID   *int64    `json:"owner_user_id,omitempty" example:"2" description:"账户所属的买家或卖家，平台账户为空"`
+	Currency      string    `json:"currency" example:"USD"`
+	BalanceMicros int64     `json:"balance_micros" example:"25000000" description:"账户余额(微美元)，所有分录金额之和"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// LedgerEntry 代表一条不可修改的记账分录，各账户金额之和为 0
+type LedgerEntry struct {
+	EntryID         int64           `json:"entry_id"`
+	EntryType       string          `json:"entry_type" enums:"usage_charge,topup,refund,payout,opening_balance,sla_credit"`
+	ReferenceID     *int64          `json:"reference_id,omitempty" description:"关联的使用日志ID、充值记录ID或 SLA 评估ID"`
+	ServiceID       *int64          `json:"service_id,omitempty" description:"计费、退款和 SLA 补偿分录对应的服务"`
+	Description     string          `json:"description,omitempty"`
+	CreatedByUserID *int64          `json:"created_by_user_id,omitempty"`
+	CreatedAt       time.Time       `json:"created_at"`
+	Postings        []LedgerPosting `json:"postings"`
+}
+
+// LedgerPosting 代表分录中记入某个账户的金额，正数为贷记（增加平台对所有者的欠款），负数为借记
+type LedgerPosting struct {
+	PostingID    int64 `json:"posting_id"`
+	AccountID    int64 `json:"account_id"`
+	AmountMicros int64 `json:"amount_micros"`
+}
+
+// UsageCharge 一次调用结束后需要结算的费用：钱包冻结的结算与账本计费分录在同一事务中完成
+type UsageCharge struct {
+	UsageLogID    int64 // 使用日志写入失败时为 0，分录不关联日志
+	BuyerUserID   int64
+	SellerUserID  int64
+	ServiceID     int64
+	HoldID        int64 // 转发前的钱包冻结，没有冻结时为 0
+	AmountMicros  int64 // 实际费用，0 表示不计费；结算后为实际从买家余额扣除的金额
+	CommissionBps int   // 平台抽成比例（基点），其余计入卖家收入
+
+	ShortfallMicros int64 // 结算时冻结金额与可用余额都不足以支付、未扣除的费用
+}
+
+// CommissionMicros 按抽成比例计算平台收入，四舍五入到微美元
+func (c *UsageCharge) CommissionMicros() int64 {
+	return (c.AmountMicros*int64(c.CommissionBps) + 5000) / 10000
+}
+
+// 平台抽成比例的作用范围，匹配时卖家优先于分类，分类优先于全局
+const (
+	CommissionScopeGlobal   = "global"
+	CommissionScopeCategory = "category"
+	CommissionScopeSeller   = "seller"
+)
+
+// CommissionRate 代表平台抽成比例配置
+// @Description 平台从每次计费调用中抽取的比例，1 bps = 0.01%
+type CommissionRate struct {
+	RateID       int64     `json:"rate_id" example:"1"`
+	Scope        string    `json:"scope" example:"category" enums:"global,category,seller"`
+	SellerUserID *int64    `json:"seller_user_id,omitempty" example:"3" description:"scope=seller 时的卖家"`
+	Category     string    `json:"category,omitempty" example:"AI" description:"scope=category 时的服务分类"`
+	RateBps      int       `json:"rate_bps" example:"1500" description:"抽成比例(基点)，1500 即 15%"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// PayoutStatement 代表卖家的月度结算单，金额均为微美元
+// @Description 卖家月度结算单：应付净额 = 总收入 - 平台抽成 - 退款
+type PayoutStatement struct {
+	StatementID      int64                 `json:"statement_id" example:"1"`
+	SellerUserID     int64                 `json:"seller_user_id" example:"3"`
+	PeriodStart      time.Time             `json:"period_start" example:"2026-09-01T00:00:00Z"`
+	PeriodEnd        time.Time             `json:"period_end" example:"2026-10-01T00:00:00Z" description:"不包含"`
+	Currency         string                `json:"currency" example:"USD"`
+	CallCount        int64                 `json:"call_count" example:"1200"`
+	GrossMicros      int64                 `json:"gross_micros" example:"12000000" description:"买家支付的费用合计"`
+	CommissionMicros int64                 `json:"commission_micros" example:"1800000" description:"平台抽成，已扣除退款冲回的部分"`
+	RefundsMicros    int64                 `json:"refunds_micros" example:"100000" description:"退还给买家的费用"`
+	NetPayableMicros int64                 `json:"net_payable_micros" example:"10100000" description:"应付卖家的净额"`
+	Status           string                `json:"status" example:"pending" enums:"pending,paid"`
+	PaymentReference string                `json:"payment_reference,omitempty" example:"wire-2026-10-05-0042"`
+	PaidAt           *time.Time            `json:"paid_at,omitempty"`
+	CreatedAt        time.Time             `json:"created_at"`
+	Items            []PayoutStatementItem `json:"items,omitempty"`
+}
+
+// PayoutStatementItem 代表结算单中单个服务的明细
+type PayoutStatementItem struct {
+	ServiceID        int64  `json:"service_id" example:"1"`
+	ServiceName      string `json:"service_name" example:"Weather API"`
+	CallCount        int64  `json:"call_count" example:"1200"`
+	GrossMicros      int64  `json:"gross_micros" example:"12000000"`
+	CommissionMicros int64  `json:"commission_micros" example:"1800000"`
+	RefundsMicros    int64  `json:"refunds_micros" example:"100000"`
+	NetPayableMicros int64  `json:"net_payable_micros" example:"10100000"`
+}
+
+// SLAEvaluation 代表服务某个月的 SLA 评估结果，未达标时已按买家消费发放补偿
+type SLAEvaluation struct {
+	EvaluationID       int64     `json:"evaluation_id" example:"1"`
+	ServiceID          int64     `json:"service_id" example:"1"`
+	ServiceName        string    `json:"service_name,omitempty" example:"Weather API"`
+	SellerUserID       int64     `json:"seller_user_id" example:"3"`
+	PeriodStart        time.Time `json:"period_start" example:"2026-09-01T00:00:00Z"`
+	PeriodEnd          time.Time `json:"period_end" example:"2026-10-01T00:00:00Z" description:"不包含"`
+	TotalCalls         int64     `json:"total_calls" example:"120000"`
+	FailedCalls        int64     `json:"failed_calls" example:"240" description:"5xx、超时和上游不可达的调用数"`
+	UptimeBps          int       `json:"uptime_bps" example:"9980" description:"实际可用率(基点)"`
+	LatencyP95Ms       int       `json:"latency_p95_ms" example:"2100" description:"成功调用的 P95 处理时间(毫秒)"`
+	UptimeTargetBps    int       `json:"uptime_target_bps" example:"9990"`
+	LatencyP95TargetMs int       `json:"latency_p95_target_ms" example:"3000"`
+	CreditBps          int       `json:"credit_bps" example:"1000"`
+	Breached           bool      `json:"breached" example:"true"`
+	CreditedBuyers     int       `json:"credited_buyers" example:"12"`
+	CreditedMicros     int64     `json:"credited_micros" example:"1500000" description:"补偿给买家的金额合计"`
+	CreatedAt          time.Time `json:"created_at"`
+}
+
+// 发票类型
+const (
+	InvoiceTypeInvoice    = "invoice"     // 月度发票
+	InvoiceTypeCreditNote = "credit_note" // 冲减已开具发票的贷项通知单
+)
+
+// 发票状态：草稿可以修改，开具后不可修改，只能标记已支付或用贷项通知单更正
+const (
+	InvoiceStatusDraft  = "draft"
+	InvoiceStatusIssued = "issued"
+	InvoiceStatusPaid   = "paid"
+	InvoiceStatusVoid   = "void" // 作废的草稿
+)
+
+// Invoice 代表买家的月度发票或贷项通知单，金额均为微美元
+// @Description 买家发票：合计 = 小计 - 折扣 + 税额。贷项通知单的金额为冲减原发票的正数金额
+type Invoice struct {
+	InvoiceID         int64         `json:"invoice_id" example:"1"`
+	InvoiceType       string        `json:"invoice_type" example:"invoice" enums:"invoice,credit_note"`
+	InvoiceNumber     string        `json:"invoice_number,omitempty" example:"INV-2026-000001" description:"开具时分配的编号，草稿为空"`
+	BuyerUserID       int64         `json:"buyer_user_id" example:"2"`
+	OriginalInvoiceID *int64        `json:"original_invoice_id,omitempty" example:"1" description:"贷项通知单更正的发票"`
+	PeriodStart       time.Time     `json:"period_start" example:"2026-09-01T00:00:00Z"`
+	PeriodEnd         time.Time     `json:"period_end" example:"2026-10-01T00:00:00Z" description:"不包含"`
+	Currency          string        `json:"currency" example:"USD"`
+	IssuerName        string        `json:"issuer_name" example:"API Trade Platform"`
+	IssuerTaxID       string        `json:"issuer_tax_id,omitempty" example:"DE123456789"`
+	BuyerName         string        `json:"buyer_name" example:"acme_corp"`
+	BuyerEmail        string        `json:"buyer_email" example:"billing@acme.example"`
+	SubtotalMicros    int64         `json:"subtotal_micros" example:"12000000" description:"明细金额合计"`
+	DiscountMicros    int64         `json:"discount_micros" example:"1000000"`
+	TaxRateBps        int           `json:"tax_rate_bps" example:"1900" description:"税率(基点)，1900 即 19%"`
+	TaxMicros         int64         `json:"tax_micros" example:"2090000"`
+	TotalMicros       int64         `json:"total_micros" example:"13090000"`
+	Status            string        `json:"status" example:"issued" enums:"draft,issued,paid,void"`
+	Notes             string        `json:"notes,omitempty"`
+	PaymentReference  string        `json:"payment_reference,omitempty" example:"wire-2026-10-05-0042"`
+	IssuedAt          *time.Time    `json:"issued_at,omitempty"`
+	PaidAt            *time.Time    `json:"paid_at,omitempty"`
+	VoidedAt          *time.Time    `json:"voided_at,omitempty"`
+	CreatedAt         time.Time     `json:"created_at"`
+	UpdatedAt         time.Time     `json:"updated_at"`
+	Items             []InvoiceItem `json:"items,omitempty"`
+}
+
+// InvoiceItem 代表发票中一个服务和模型的明细，贷项通知单只有一行更正说明
+type InvoiceItem struct {
+	LineNo         int    `json:"line_no" example:"1"`
+	ServiceID      int64  `json:"service_id" example:"1"`
+	ServiceName    string `json:"service_name" example:"OpenAI Proxy"`
+	ModelName      string `json:"model_name,omitempty" example:"gpt-4o-mini"`
+	Description    string `json:"description" example:"OpenAI Proxy - gpt-4o-mini"`
+	CallCount      int64  `json:"call_count" example:"1200"`
+	TotalTokens    int64  `json:"total_tokens" example:"350000"`
+	ChargedMicros  int64  `json:"charged_micros" example:"12100000"`
+	RefundedMicros int64  `json:"refunded_micros" example:"100000"`
+	AmountMicros   int64  `json:"amount_micros" example:"12000000"`
+}
+
+// ApplyTotals 根据明细、折扣和税率重新计算小计、税额和合计，税额四舍五入到微美元
+func (inv *Invoice) ApplyTotals() {
+	var subtotal int64
+	for _, item := range inv.Items {
+		subtotal += item.AmountMicros
+	}
+	inv.SubtotalMicros = subtotal
+	taxable := subtotal - inv.DiscountMicros
+	inv.TaxMicros = roundBps(taxable, inv.TaxRateBps)
+	inv.TotalMicros = taxable + inv.TaxMicros
+}
+
+// NetMicros 折扣后的不含税金额，贷项通知单累计冲减的金额不能超过原发票的这一金额
+func (inv *Invoice) NetMicros() int64 {
+	return inv.SubtotalMicros - inv.DiscountMicros
+}
+
+// roundBps 计算金额乘以基点比例，正负金额都四舍五入（远离零）
+func roundBps(amountMicros int64, bps int) int64 {
+	product := amountMicros * int64(bps)
+	if product < 0 {
+		return -((-product + 5000) / 10000)
+	}
+	return (product + 5000) / 10000
+}
+
+// LedgerReconciliationReport 账本与使用日志的对账结果
+type LedgerReconciliationReport struct {
+	Since               time.Time           `json:"since"`
+	Until               time.Time           `json:"until"`
+	BilledCalls         int64               `json:"billed_calls" description:"时间窗口内产生费用的调用数"`
+	BilledMicros        int64               `json:"billed_micros" description:"使用日志中的费用合计(微美元)"`
+	LedgerChargedMicros int64               `json:"ledger_charged_micros" description:"账本中对应计费分录向买家收取的合计(微美元)"`
+	DriftMicros         int64               `json:"drift_micros" description:"billed_micros - ledger_charged_micros"`
+	MissingEntries      int64               `json:"missing_entries" description:"没有计费分录的计费调用数"`
+	MissingMicros       int64               `json:"missing_micros" description:"没有计费分录的费用合计(微美元)"`
+	AmountMismatches    int64               `json:"amount_mismatches" description:"分录金额与日志费用不一致的调用数"`
+	UnexpectedEntries   int64               `json:"unexpected_entries" description:"关联的日志不存在或不计费的计费分录数"`
+	UnbalancedEntries   int64               `json:"unbalanced_entries" description:"各账户金额之和不为 0 的分录数"`
+	WalletDrifts        []WalletLedgerDrift `json:"wallet_drifts" description:"钱包余额与账本余额不一致的买家"`
+	InSync              bool                `json:"in_sync"`
+}
+
+// WalletLedgerDrift 钱包余额与账本中买家钱包账户余额的差异
+type WalletLedgerDrift struct {
+	UserID       int64 `json:"user_id"`
+	WalletMicros int64 `json:"wallet_micros"`
+	LedgerMicros int64 `json:"ledger_micros"`
 }
 
 // --- 请求和响应结构体 (用于 API handlers) ---
@@ -118,6 +800,13 @@ type RegisterAPIServiceRequest struct {
 	Description         string `json:"description" example:"提供全球天气数据查询服务" description:"API服务描述"`
 	OriginalEndpointURL string `json:"original_endpoint_url" binding:"required,url" example:"https://api.weather.com/v1" description:"卖家原始API的基础URL，必须是有效的URL格式"`
 	OriginalAPIKey      string `json:"original_api_key" binding:"required" example:"sk-1234567890abcdef" description:"卖家原始API密钥，将被加密存储"`
+	APIFormat           string `json:"api_format,omitempty" binding:"omitempty,oneof=openai anthropic" example:"openai" enums:"openai,anthropic" description:"上游API协议格式，默认 openai"`
+	LBStrategy          string `json:"lb_strategy,omitempty" binding:"omitempty,oneof=weighted_round_robin least_latency" example:"weighted_round_robin" enums:"weighted_round_robin,least_latency" description:"上游池负载均衡策略，默认 weighted_round_robin"`
+	ConnectTimeoutMs        int `json:"connect_timeout_ms,omitempty" binding:"gte=0" example:"5000" description:"连接上游的超时时间(毫秒)，默认使用平台配置"`
+	ResponseHeaderTimeoutMs int `json:"response_header_timeout_ms,omitempty" binding:"gte=0" example:"120000" description:"等待上游响应头的超时时间(毫秒)，默认使用平台配置"`
+	IdleConnTimeoutMs       int `json:"idle_conn_timeout_ms,omitempty" binding:"gte=0" example:"90000" description:"空闲keep-alive连接保留时间(毫秒)，默认使用平台配置"`
+	AuthScheme          *UpstreamAuthScheme `json:"auth_scheme,omitempty" description:"上游认证方式，默认根据密钥格式和协议格式自动检测"`
+	Models              []string `json:"models,omitempty" example:"gpt-4o,gpt-4o-mini" description:"该服务提供的模型ID列表，用于 /v1 统一路由"`
 }
 
 // UpdateAPIServiceRequest 更新 API 服务请求体
@@ -128,15 +817,147 @@ type UpdateAPIServiceRequest struct {
 	OriginalEndpointURL string `json:"original_endpoint_url" binding:"required,url" example:"https://api.weather.com/v2" description:"卖家原始API的基础URL"`
 	OriginalAPIKey      string `json:"original_api_key,omitempty" example:"sk-new1234567890abcdef" description:"卖家原始API密钥，可选，如果提供则更新"`
 	IsActive            *bool  `json:"is_active,omitempty" example:"true" description:"服务是否激活，可选"`
+	APIFormat           string `json:"api_format,omitempty" binding:"omitempty,oneof=openai anthropic" example:"anthropic" enums:"openai,anthropic" description:"上游API协议格式，可选"`
+	LBStrategy          string `json:"lb_strategy,omitempty" binding:"omitempty,oneof=weighted_round_robin least_latency" example:"least_latency" enums:"weighted_round_robin,least_latency" description:"上游池负载均衡策略，可选"`
+	ConnectTimeoutMs        *int `json:"connect_timeout_ms,omitempty" binding:"omitempty,gte=0" example:"5000" description:"连接上游的超时时间(毫秒)，可选，0 表示使用平台配置"`
+	ResponseHeaderTimeoutMs *int `json:"response_header_timeout_ms,omitempty" binding:"omitempty,gte=0" example:"120000" description:"等待上游响应头的超时时间(毫秒)，可选，0 表示使用平台配置"`
+	IdleConnTimeoutMs       *int `json:"idle_conn_timeout_ms,omitempty" binding:"omitempty,gte=0" example:"90000" description:"空闲keep-alive连接保留时间(毫秒)，可选，0 表示使用平台配置"`
+	AuthScheme          *UpstreamAuthScheme `json:"auth_scheme,omitempty" description:"上游认证方式，可选，如果提供则整体替换"`
 	Documentation       string `json:"documentation,omitempty" description:"API文档内容，Markdown格式，可选"`
+	Models              []string `json:"models,omitempty" description:"该服务提供的模型ID列表，可选，如果提供则整体替换"`
 }
 
 // UpdateAPIPricingRequest 更新 API 定价请求体
 // @Description 卖家更新API服务定价策略的请求参数
 type UpdateAPIPricingRequest struct {
-	PricingModel  string  `json:"pricing_model" binding:"required,oneof=per_call per_token" example:"per_call" enums:"per_call,per_token" description:"定价模式：per_call(按调用次数) 或 per_token(按token数量)"`
+	PricingModel  string  `json:"pricing_model" binding:"omitempty,oneof=per_call per_token" example:"per_call" enums:"per_call,per_token" description:"定价模式：per_call(按调用次数) 或 per_token(按token数量)，未提供 pricing_plan 时必填"`
 	PricePerCall  float64 `json:"price_per_call,omitempty" example:"0.01" description:"每次调用价格(USD)，当pricing_model为per_call时必填"`
 	PricePerToken float64 `json:"price_per_token,omitempty" example:"0.001" description:"每token价格(USD)，当pricing_model为per_token时必填"`
+	PricingPlan   *PricingPlan `json:"pricing_plan,omitempty" description:"阶梯定价计划，可选；提供时 pricing_model 随计划的计量单位设置，不提供时清除已有计划"`
+}
+
+// ServiceModelRequest 创建或更新服务模型目录条目请求体
+// @Description 卖家声明服务所提供模型的请求参数
+type ServiceModelRequest struct {
+	ModelID          string   `json:"model_id" example:"gpt-4o-mini" description:"模型ID，创建时必填，更新时以路径中的模型ID为准"`
+	ContextWindow    int      `json:"context_window,omitempty" binding:"gte=0" example:"128000" description:"上下文窗口大小(token)"`
+	Modalities       []string `json:"modalities,omitempty" example:"text,image" description:"支持的模态，例如 text、image、audio"`
+	InputPricePer1K  float64  `json:"input_price_per_1k,omitempty" binding:"gte=0" example:"0.00015" description:"每1K输入token价格(USD)"`
+	OutputPricePer1K float64  `json:"output_price_per_1k,omitempty" binding:"gte=0" example:"0.0006" description:"每1K输出token价格(USD)"`
+}
+
+// ServiceUpstreamRequest 创建或更新服务上游请求体
+// @Description 卖家向服务上游池添加或更新上游的请求参数
+type ServiceUpstreamRequest struct {
+	Name            string `json:"name,omitempty" binding:"max=100" example:"us-east" description:"上游名称"`
+	EndpointURL     string `json:"endpoint_url" binding:"required,url" example:"https://us-east.api.example.com/v1" description:"上游的基础URL"`
+	APIKey          string `json:"api_key,omitempty" example:"sk-1234567890abcdef" description:"上游API密钥，将被加密存储；创建时必填，更新时为空则保持不变"`
+	Weight          int    `json:"weight,omitempty" binding:"gte=0" example:"2" description:"加权轮询的权重，默认1"`
+	HealthCheckPath string `json:"health_check_path,omitempty" binding:"max=255" example:"/models" description:"健康检查路径，可选"`
+	IsActive        *bool  `json:"is_active,omitempty" example:"true" description:"是否启用，默认启用"`
+}
+
+// FallbackChainRequest 创建或更新回退链请求体
+// @Description 买家定义回退链的请求参数，model_id 与 service_id 必须且只能提供一个
+type FallbackChainRequest struct {
+	Name       string  `json:"name" binding:"required,max=100" example:"gpt-4o backup" description:"回退链名称"`
+	ModelID    string  `json:"model_id,omitempty" example:"gpt-4o" description:"匹配的模型ID（用于 /v1 统一路由）"`
+	ServiceID  *int64  `json:"service_id,omitempty" example:"3" description:"匹配的服务ID（用于 /proxy/v1/{service_id}）"`
+	ServiceIDs []int64 `json:"service_ids" binding:"required,min=1" example:"3,5,8" description:"按顺序尝试的已订阅服务ID列表"`
+	IsActive   *bool   `json:"is_active,omitempty" example:"true" description:"是否启用，默认启用"`
+}
+
+// CreatePlatformKeyRequest 为订阅创建平台密钥请求体
+// @Description 买家为已订阅的服务创建一个新的命名密钥
+type CreatePlatformKeyRequest struct {
+	Name      string             `json:"name" binding:"required,max=100" example:"ci" description:"密钥名称"`
+	ExpiresAt *time.Time         `json:"expires_at,omitempty" example:"2027-01-01T00:00:00Z" description:"过期时间，可选"`
+	Scopes    *PlatformKeyScopes `json:"scopes,omitempty" description:"访问范围限制，可选，默认不限制"`
+}
+
+// RotatePlatformKeyRequest 轮换平台密钥请求体
+// @Description 生成替换密钥，旧密钥在宽限期内仍然可用
+type RotatePlatformKeyRequest struct {
+	GracePeriodSeconds *int `json:"grace_period_seconds,omitempty" binding:"omitempty,gte=0,lte=2592000" example:"86400" description:"旧密钥继续可用的秒数，默认86400，0表示立即吊销"`
+}
+
+// PlatformKeySecretResponse 创建或轮换平台密钥的响应体，完整密钥只在此返回一次
+type PlatformKeySecretResponse struct {
+	Key            *PlatformAPIKey `json:"key"`
+	PlatformAPIKey string          `json:"platform_api_key"`       // 完整的平台密钥，之后只能看到脱敏前缀
+	PreviousKey    *PlatformAPIKey `json:"previous_key,omitempty"` // 轮换时被替换的旧密钥
+}
+
+// WalletTopUpRequest 管理员为买家钱包充值请求体
+// @Description 充值金额以微美元为单位，避免浮点误差
+type WalletTopUpRequest struct {
+	AmountMicros int64  `json:"amount_micros" binding:"required,gt=0" example:"10000000" description:"充值金额(微美元)，10000000 即 10 USD"`
+	Reference    string `json:"reference,omitempty" binding:"max=255" example:"stripe_pi_3Nx..." description:"外部支付流水号，重复提交同一流水号不会重复入账"`
+	Note         string `json:"note,omitempty" example:"Invoice 2026-10" description:"备注"`
+}
+
+// UsageRefundRequest 管理员退还计费调用费用请求体
+// @Description 不指定金额时退还该调用尚未退还的全部费用
+type UsageRefundRequest struct {
+	AmountMicros int64  `json:"amount_micros,omitempty" binding:"gte=0" example:"1500" description:"退还金额(微美元)，默认退还剩余全部费用"`
+	Reason       string `json:"reason" binding:"required,max=500" example:"Upstream returned truncated output"`
+}
+
+// CommissionRateRequest 设置平台抽成比例请求体
+// @Description 同一作用范围和对象重复设置时覆盖原比例
+type CommissionRateRequest struct {
+	Scope        string `json:"scope" binding:"required,oneof=global category seller" example:"category" enums:"global,category,seller"`
+	SellerUserID int64  `json:"seller_user_id,omitempty" example:"3" description:"scope=seller 时必填"`
+	Category     string `json:"category,omitempty" binding:"max=100" example:"AI" description:"scope=category 时必填"`
+	RateBps      *int   `json:"rate_bps" binding:"required,gte=0,lte=10000" example:"1500" description:"抽成比例(基点)，0-10000"`
+}
+
+// GeneratePayoutStatementsRequest 生成月度结算单请求体
+type GeneratePayoutStatementsRequest struct {
+	Month string `json:"month" binding:"required" example:"2026-09" description:"结算月份 YYYY-MM (UTC)，只能是已结束的月份"`
+}
+
+// EvaluateSLARequest 评估月度 SLA 请求体
+type EvaluateSLARequest struct {
+	Month string `json:"month" binding:"required" example:"2026-09" description:"评估月份 YYYY-MM (UTC)，只能是已结束的月份"`
+}
+
+// GenerateInvoicesRequest 生成月度发票请求体
+type GenerateInvoicesRequest struct {
+	Month string `json:"month" binding:"required" example:"2026-09" description:"账单月份 YYYY-MM (UTC)，只能是已结束的月份"`
+}
+
+// UpdateInvoiceDraftRequest 修改发票草稿请求体，未提供的字段保持不变
+type UpdateInvoiceDraftRequest struct {
+	DiscountMicros *int64  `json:"discount_micros,omitempty" binding:"omitempty,gte=0" example:"1000000" description:"折扣(微美元)，不能超过小计"`
+	TaxRateBps     *int    `json:"tax_rate_bps,omitempty" binding:"omitempty,gte=0,lte=10000" example:"1900" description:"税率(基点)"`
+	Notes          *string `json:"notes,omitempty" binding:"omitempty,max=2000" example:"PO-4711"`
+}
+
+// MarkInvoicePaidRequest 标记发票已支付请求体
+type MarkInvoicePaidRequest struct {
+	PaymentReference string `json:"payment_reference" binding:"required,max=255" example:"wire-2026-10-05-0042" description:"付款流水号"`
+}
+
+// CreateCreditNoteRequest 为已开具的发票创建贷项通知单请求体
+// @Description 冲减金额为不含税金额，按原发票税率计算税额；同一发票所有未作废贷项通知单的冲减合计不能超过原发票折扣后的金额
+type CreateCreditNoteRequest struct {
+	AmountMicros int64  `json:"amount_micros" binding:"required,gt=0" example:"500000" description:"冲减的不含税金额(微美元)"`
+	Reason       string `json:"reason" binding:"required,max=500" example:"Upstream outage on 2026-09-14"`
+}
+
+// MarkStatementPaidRequest 标记结算单已支付请求体
+type MarkStatementPaidRequest struct {
+	PaymentReference string `json:"payment_reference" binding:"required,max=255" example:"wire-2026-10-05-0042" description:"付款流水号"`
+}
+
+// WalletResponse 买家钱包响应体，同时返回微美元整数和美元金额
+type WalletResponse struct {
+	BuyerWallet
+	AvailableMicros int64   `json:"available_micros" example:"24880000" description:"可用余额(微美元)"`
+	Balance         float64 `json:"balance" example:"25" description:"已结算余额(USD)"`
+	Held            float64 `json:"held" example:"0.12" description:"冻结金额(USD)"`
+	Available       float64 `json:"available" example:"24.88" description:"可用余额(USD)"`
 }
 
 // APIServiceResponse API 服务信息响应体
@@ -146,6 +967,14 @@ type APIServiceResponse struct {
 	Description         string    `json:"description,omitempty"`
 	PlatformProxyPrefix string    `json:"platform_proxy_prefix"`
 	IsActive            bool      `json:"is_active"`
+	APIFormat           string    `json:"api_format,omitempty"`
+	LBStrategy          string    `json:"lb_strategy,omitempty"`
+	ConnectTimeoutMs        int   `json:"connect_timeout_ms,omitempty"`
+	ResponseHeaderTimeoutMs int   `json:"response_header_timeout_ms,omitempty"`
+	IdleConnTimeoutMs       int   `json:"idle_conn_timeout_ms,omitempty"`
+	AuthScheme          *UpstreamAuthScheme `json:"auth_scheme,omitempty"`
+	BillingPolicy       *BillingPolicy `json:"billing_policy,omitempty"`
+	RateLimits          *ServiceRateLimits `json:"rate_limits,omitempty"`
 	SellerUsername      string    `json:"seller_username,omitempty"` // 用于买家列表展示
 	// API市场扩展字段
 	Category            string    `json:"category,omitempty"`
@@ -154,10 +983,12 @@ type APIServiceResponse struct {
 	PricePerCall        float64   `json:"price_per_call,omitempty"`
 	PricingModel        string    `json:"pricing_model,omitempty"`
 	PricePerToken       float64   `json:"price_per_token,omitempty"`
+	PricingPlan         *PricingPlan `json:"pricing_plan,omitempty"`
 	TotalCalls          int64     `json:"total_calls,omitempty"`
 	SubscriberCount     int64     `json:"subscriber_count,omitempty"`
 	Features            []string  `json:"features,omitempty"`          // 解析后的特性数组
 	Documentation       string    `json:"documentation,omitempty"`
+	Models              []string  `json:"models,omitempty"`            // 服务提供的模型ID列表
 }
 
 // APIServiceDetailResponse API 服务详情响应体（包含完整信息）
@@ -165,12 +996,12 @@ type APIServiceDetailResponse struct {
 	APIServiceResponse
 	IsSubscribed        bool      `json:"is_subscribed"`        // 当前买家是否已订阅
 	SubscriptionDate    *time.Time `json:"subscription_date,omitempty"` // 订阅日期
-	PlatformAPIKey      string    `json:"platform_api_key,omitempty"` // 如果已订阅，返回平台密钥
+	PlatformAPIKey      string    `json:"platform_api_key,omitempty"` // 如果已订阅，返回脱敏后的平台密钥
 }
 
 // SubscribeToAPIResponse 订阅 API 响应体
 type SubscribeToAPIResponse struct {
-	PlatformAPIKey string `json:"platform_api_key"`
+	PlatformAPIKey string `json:"platform_api_key"` // 完整的平台密钥，只在订阅时返回一次，之后只能看到脱敏前缀
 	PlatformProxyURL string `json:"platform_proxy_url"` // 完整的代理 URL 示例
 }
 
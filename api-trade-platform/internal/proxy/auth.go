@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"api-trade-platform/internal/model"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 上游认证类型
+const (
+	AuthTypeAuto   = ""       // 未配置：按协议格式和密钥格式自动检测（兼容旧服务）
+	AuthTypeHeader = "header" // 将密钥按模板写入指定请求头
+	AuthTypeQuery  = "query"  // 将密钥写入指定查询参数
+	AuthTypeBasic  = "basic"  // HTTP Basic 认证
+	AuthTypeHMAC   = "hmac"   // 使用密钥对请求做 HMAC-SHA256 签名
+	AuthTypeNone   = "none"   // 上游不需要认证
+)
+
+const (
+	// authKeyPlaceholder 认证模板中代表卖家密钥的占位符
+	authKeyPlaceholder = "{key}"
+
+	defaultSignatureHeader = "X-Signature"
+	defaultTimestampHeader = "X-Timestamp"
+)
+
+// NormalizeAuthScheme 校验并规范化卖家配置的认证方式，填充默认值
+func NormalizeAuthScheme(scheme *model.UpstreamAuthScheme) error {
+	scheme.Type = strings.ToLower(strings.TrimSpace(scheme.Type))
+
+	switch scheme.Type {
+	case AuthTypeAuto, AuthTypeNone, AuthTypeBasic:
+	case AuthTypeHeader:
+		scheme.HeaderName = strings.TrimSpace(scheme.HeaderName)
+		if scheme.HeaderName == "" {
+			return fmt.Errorf("header_name is required for header auth")
+		}
+		if scheme.Template == "" {
+			scheme.Template = authKeyPlaceholder
+		}
+		if !strings.Contains(scheme.Template, authKeyPlaceholder) {
+			return fmt.Errorf("template must contain %s", authKeyPlaceholder)
+		}
+	case AuthTypeQuery:
+		scheme.QueryParam = strings.TrimSpace(scheme.QueryParam)
+		if scheme.QueryParam == "" {
+			return fmt.Errorf("query_param is required for query auth")
+		}
+	case AuthTypeHMAC:
+		if scheme.SignatureHeader == "" {
+			scheme.SignatureHeader = defaultSignatureHeader
+		}
+		if scheme.TimestampHeader == "" {
+			scheme.TimestampHeader = defaultTimestampHeader
+		}
+		switch scheme.SignatureEncoding {
+		case "":
+			scheme.SignatureEncoding = "hex"
+		case "hex", "base64":
+		default:
+			return fmt.Errorf("signature_encoding must be hex or base64")
+		}
+	default:
+		return fmt.Errorf("unsupported auth type %q", scheme.Type)
+	}
+
+	for name := range scheme.ExtraHeaders {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("extra_headers contains an empty header name")
+		}
+	}
+	return nil
+}
+
+// ApplyAuth 按服务配置的认证方式为上游请求附加卖家密钥
+// 调用前请求的 URL 应已确定；body 为发送给上游的请求体，hmac 认证会对其签名。
+func ApplyAuth(req *http.Request, scheme model.UpstreamAuthScheme, apiFormat, apiKey string, body []byte) error {
+	switch scheme.Type {
+	case AuthTypeAuto:
+		applyAutoAuth(req, apiFormat, apiKey)
+	case AuthTypeNone:
+	case AuthTypeHeader:
+		req.Header.Set(scheme.HeaderName, strings.ReplaceAll(scheme.Template, authKeyPlaceholder, apiKey))
+	case AuthTypeQuery:
+		query := req.URL.Query()
+		query.Set(scheme.QueryParam, apiKey)
+		req.URL.RawQuery = query.Encode()
+	case AuthTypeBasic:
+		username, password := scheme.Username, apiKey
+		if username == "" {
+			var ok bool
+			username, password, ok = strings.Cut(apiKey, ":")
+			if !ok {
+				return fmt.Errorf("basic auth without username requires a user:password key")
+			}
+		}
+		req.SetBasicAuth(username, password)
+	case AuthTypeHMAC:
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(scheme.TimestampHeader, timestamp)
+		req.Header.Set(scheme.SignatureHeader, signRequest(req, scheme.SignatureEncoding, apiKey, timestamp, body))
+	default:
+		return fmt.Errorf("unsupported auth type %q", scheme.Type)
+	}
+
+	for name, value := range scheme.ExtraHeaders {
+		req.Header.Set(name, strings.ReplaceAll(value, authKeyPlaceholder, apiKey))
+	}
+	return nil
+}
+
+// applyAutoAuth 未配置认证方式时的自动检测
+// Anthropic 格式使用 x-api-key；已带 Bearer 前缀或 sk-/pk- 开头的密钥使用 Authorization；其余使用 X-API-Key。
+func applyAutoAuth(req *http.Request, apiFormat, apiKey string) {
+	if NormalizeFormat(apiFormat) == FormatAnthropic {
+		req.Header.Set("x-api-key", apiKey)
+		return
+	}
+	if apiKey == "" {
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(apiKey, "Bearer "):
+		req.Header.Set("Authorization", apiKey)
+	case strings.HasPrefix(apiKey, "sk-") || strings.HasPrefix(apiKey, "pk-"):
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	default:
+		req.Header.Set("X-API-Key", apiKey)
+	}
+}
+
+// signRequest 计算请求的 HMAC-SHA256 签名
+// 签名内容为以换行分隔的：时间戳、HTTP 方法、带查询参数的请求路径、请求体的 SHA256 十六进制摘要。
+func signRequest(req *http.Request, encoding, secret, timestamp string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	payload := strings.Join([]string{
+		timestamp,
+		req.Method,
+		req.URL.RequestURI(),
+		hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload))
+	signature := mac.Sum(nil)
+
+	if encoding == "base64" {
+		return base64.StdEncoding.EncodeToString(signature)
+	}
+	return hex.EncodeToString(signature)
+}
+
+// IsAuthHeader 判断请求头是否由认证方式写入，代理不应把买家请求中的同名头转发给上游
+func IsAuthHeader(scheme model.UpstreamAuthScheme, name string) bool {
+	canonical := http.CanonicalHeaderKey(name)
+	switch scheme.Type {
+	case AuthTypeAuto:
+		return canonical == "X-Api-Key"
+	case AuthTypeHeader:
+		if canonical == http.CanonicalHeaderKey(scheme.HeaderName) {
+			return true
+		}
+	case AuthTypeHMAC:
+		if canonical == http.CanonicalHeaderKey(scheme.SignatureHeader) || canonical == http.CanonicalHeaderKey(scheme.TimestampHeader) {
+			return true
+		}
+	}
+	for header := range scheme.ExtraHeaders {
+		if canonical == http.CanonicalHeaderKey(header) {
+			return true
+		}
+	}
+	return false
+}
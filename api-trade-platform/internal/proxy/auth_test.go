@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"api-trade-platform/internal/model"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestApplyAuth(t *testing.T) {
+	tests := []struct {
+		name        string
+		scheme      model.UpstreamAuthScheme
+		apiFormat   string
+		apiKey      string
+		wantHeaders http.Header
+		wantQuery   string
+		wantErr     bool
+	}{
+		{
+			name:        "auto anthropic",
+			apiFormat:   FormatAnthropic,
+			apiKey:      "sk-ant-1",
+			wantHeaders: http.Header{"X-Api-Key": {"sk-ant-1"}},
+			wantQuery:   "a=1",
+		},
+		{
+			name:        "auto sk- key",
+			apiKey:      "sk-1",
+			wantHeaders: http.Header{"Authorization": {"Bearer sk-1"}},
+			wantQuery:   "a=1",
+		},
+		{
+			name:        "auto bearer key",
+			apiKey:      "Bearer token-1",
+			wantHeaders: http.Header{"Authorization": {"Bearer token-1"}},
+			wantQuery:   "a=1",
+		},
+		{
+			name:        "auto other key",
+			apiKey:      "token-1",
+			wantHeaders: http.Header{"X-Api-Key": {"token-1"}},
+			wantQuery:   "a=1",
+		},
+		{
+			name:        "auto empty key",
+			apiKey:      "",
+			wantHeaders: http.Header{},
+			wantQuery:   "a=1",
+		},
+		{
+			name: "header with template and extra headers",
+			scheme: model.UpstreamAuthScheme{
+				Type:         AuthTypeHeader,
+				HeaderName:   "api-key",
+				Template:     "Token {key}",
+				ExtraHeaders: map[string]string{"X-Account": "acct-{key}", "X-Version": "2"},
+			},
+			apiKey:      "secret",
+			wantHeaders: http.Header{"Api-Key": {"Token secret"}, "X-Account": {"acct-secret"}, "X-Version": {"2"}},
+			wantQuery:   "a=1",
+		},
+		{
+			name:        "query replaces a parameter of the same name",
+			scheme:      model.UpstreamAuthScheme{Type: AuthTypeQuery, QueryParam: "key"},
+			apiKey:      "secret",
+			wantHeaders: http.Header{},
+			wantQuery:   "a=1&key=secret",
+		},
+		{
+			name:        "basic with username",
+			scheme:      model.UpstreamAuthScheme{Type: AuthTypeBasic, Username: "account-1"},
+			apiKey:      "secret",
+			wantHeaders: http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("account-1:secret"))}},
+			wantQuery:   "a=1",
+		},
+		{
+			name:        "basic with user:password key",
+			scheme:      model.UpstreamAuthScheme{Type: AuthTypeBasic},
+			apiKey:      "user:pass:word",
+			wantHeaders: http.Header{"Authorization": {"Basic " + base64.StdEncoding.EncodeToString([]byte("user:pass:word"))}},
+			wantQuery:   "a=1",
+		},
+		{
+			name:    "basic without username or colon",
+			scheme:  model.UpstreamAuthScheme{Type: AuthTypeBasic},
+			apiKey:  "secret",
+			wantErr: true,
+		},
+		{
+			name:        "none",
+			scheme:      model.UpstreamAuthScheme{Type: AuthTypeNone},
+			apiKey:      "secret",
+			wantHeaders: http.Header{},
+			wantQuery:   "a=1",
+		},
+		{
+			name:    "unsupported type",
+			scheme:  model.UpstreamAuthScheme{Type: "oauth"},
+			apiKey:  "secret",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "https://seller.example.com/v1/chat?a=1", nil)
+			req.Header = http.Header{}
+
+			err := ApplyAuth(req, tt.scheme, tt.apiFormat, tt.apiKey, nil)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ApplyAuth() error = nil, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ApplyAuth() error = %v", err)
+			}
+			if !reflect.DeepEqual(req.Header, tt.wantHeaders) {
+				t.Errorf("headers = %v, want %v", req.Header, tt.wantHeaders)
+			}
+			if req.URL.RawQuery != tt.wantQuery {
+				t.Errorf("query = %q, want %q", req.URL.RawQuery, tt.wantQuery)
+			}
+		})
+	}
+}
+
+func TestApplyAuthHMAC(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o"}`)
+	bodyHash := sha256.Sum256(body)
+
+	for _, encoding := range []string{"hex", "base64"} {
+		t.Run(encoding, func(t *testing.T) {
+			scheme := model.UpstreamAuthScheme{Type: AuthTypeHMAC, SignatureEncoding: encoding}
+			if err := NormalizeAuthScheme(&scheme); err != nil {
+				t.Fatalf("NormalizeAuthScheme() error = %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "https://seller.example.com/v1/chat?b=2&a=1", nil)
+			req.Header = http.Header{}
+
+			before := time.Now().Unix()
+			if err := ApplyAuth(req, scheme, "", "secret", body); err != nil {
+				t.Fatalf("ApplyAuth() error = %v", err)
+			}
+			if len(req.Header) != 2 {
+				t.Errorf("headers = %v, want only the timestamp and signature", req.Header)
+			}
+			timestamp := req.Header.Get(defaultTimestampHeader)
+			if unix, err := strconv.ParseInt(timestamp, 10, 64); err != nil || unix < before || unix > time.Now().Unix() {
+				t.Fatalf("timestamp header = %q", timestamp)
+			}
+
+			// 签名内容：时间戳、方法、带查询参数的路径、请求体 SHA256 十六进制摘要，以换行分隔
+			mac := hmac.New(sha256.New, []byte("secret"))
+			mac.Write([]byte(timestamp + "\nPOST\n/v1/chat?b=2&a=1\n" + hex.EncodeToString(bodyHash[:])))
+			want := hex.EncodeToString(mac.Sum(nil))
+			if encoding == "base64" {
+				want = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+			}
+			if got := req.Header.Get(defaultSignatureHeader); got != want {
+				t.Errorf("signature = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestIsAuthHeader(t *testing.T) {
+	tests := []struct {
+		scheme model.UpstreamAuthScheme
+		header string
+		want   bool
+	}{
+		{model.UpstreamAuthScheme{}, "x-api-key", true},
+		{model.UpstreamAuthScheme{}, "X-Request-Id", false},
+		{model.UpstreamAuthScheme{Type: AuthTypeHeader, HeaderName: "api-key"}, "Api-Key", true},
+		{model.UpstreamAuthScheme{Type: AuthTypeHMAC, SignatureHeader: "X-Signature", TimestampHeader: "X-Timestamp"}, "x-timestamp", true},
+		{model.UpstreamAuthScheme{Type: AuthTypeQuery, QueryParam: "key", ExtraHeaders: map[string]string{"X-Account": "a"}}, "X-Account", true},
+		{model.UpstreamAuthScheme{Type: AuthTypeQuery, QueryParam: "key"}, "Key", false},
+	}
+	for _, tt := range tests {
+		if got := IsAuthHeader(tt.scheme, tt.header); got != tt.want {
+			t.Errorf("IsAuthHeader(%q, %q) = %v, want %v", tt.scheme.Type, tt.header, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package proxy
+
+import (
+	"api-trade-platform/internal/model"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 上游池的负载均衡策略
+const (
+	LBWeightedRoundRobin = "weighted_round_robin"
+	LBLeastLatency       = "least_latency"
+)
+
+// latencyEWMAAlpha 上游延迟指数移动平均中新样本的权重
+const latencyEWMAAlpha = 0.3
+
+// NormalizeLBStrategy 规范化服务的负载均衡策略，空值或未知值视为加权轮询
+func NormalizeLBStrategy(strategy string) string {
+	if strings.EqualFold(strings.TrimSpace(strategy), LBLeastLatency) {
+		return LBLeastLatency
+	}
+	return LBWeightedRoundRobin
+}
+
+// Balancer 在服务的上游池中选择上游
+// 轮询进度和延迟统计保存在进程内存中，各平台实例独立维护；健康状态以数据库中的 is_healthy 为准。
+type Balancer struct {
+	mu            sync.Mutex
+	currentWeight map[int64]int     // 平滑加权轮询的当前权重，按 upstream_id 记录
+	latency       map[int64]float64 // 响应延迟的指数移动平均（毫秒），按 upstream_id 记录
+}
+
+// NewBalancer 创建上游负载均衡器
+func NewBalancer() *Balancer {
+	return &Balancer{
+		currentWeight: make(map[int64]int),
+		latency:       make(map[int64]float64),
+	}
+}
+
+// Pick 按策略从上游池中选择一个上游，上游池为空时返回 nil
+// 优先在健康的上游中选择；全部上游都被剔除时仍在所有上游中选择，避免整个服务不可用。
+func (b *Balancer) Pick(strategy string, upstreams []*model.ServiceUpstream) *model.ServiceUpstream {
+	candidates := make([]*model.ServiceUpstream, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if upstream.IsHealthy {
+			candidates = append(candidates, upstream)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = upstreams
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if NormalizeLBStrategy(strategy) == LBLeastLatency {
+		return b.pickLeastLatency(candidates)
+	}
+	return b.pickWeightedRoundRobin(candidates)
+}
+
+// pickWeightedRoundRobin 平滑加权轮询（与 nginx 相同的算法），权重高的上游被更频繁地选中且分布均匀
+func (b *Balancer) pickWeightedRoundRobin(candidates []*model.ServiceUpstream) *model.ServiceUpstream {
+	var best *model.ServiceUpstream
+	total := 0
+	for _, upstream := range candidates {
+		weight := upstreamWeight(upstream)
+		total += weight
+		b.currentWeight[upstream.UpstreamID] += weight
+		if best == nil || b.currentWeight[upstream.UpstreamID] > b.currentWeight[best.UpstreamID] {
+			best = upstream
+		}
+	}
+	b.currentWeight[best.UpstreamID] -= total
+	return best
+}
+
+// pickLeastLatency 选择平均延迟最低的上游，尚无延迟样本的上游优先被选中以获得样本
+// 延迟相同时选择权重更高的上游
+func (b *Balancer) pickLeastLatency(candidates []*model.ServiceUpstream) *model.ServiceUpstream {
+	var best *model.ServiceUpstream
+	bestLatency := 0.0
+	for _, upstream := range candidates {
+		latency, observed := b.latency[upstream.UpstreamID]
+		if !observed {
+			return upstream
+		}
+		if best == nil || latency < bestLatency ||
+			(latency == bestLatency && upstreamWeight(upstream) > upstreamWeight(best)) {
+			best, bestLatency = upstream, latency
+		}
+	}
+	return best
+}
+
+// ObserveLatency 记录一次上游响应延迟（收到响应头的耗时）
+func (b *Balancer) ObserveLatency(upstreamID int64, elapsed time.Duration) {
+	sample := float64(elapsed) / float64(time.Millisecond)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if previous, ok := b.latency[upstreamID]; ok {
+		b.latency[upstreamID] = latencyEWMAAlpha*sample + (1-latencyEWMAAlpha)*previous
+	} else {
+		b.latency[upstreamID] = sample
+	}
+}
+
+// upstreamWeight 返回上游的有效权重，未设置时按 1 计算
+func upstreamWeight(upstream *model.ServiceUpstream) int {
+	if upstream.Weight <= 0 {
+		return 1
+	}
+	return upstream.Weight
+}
@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"api-trade-platform/internal/model"
+	"testing"
+	"time"
+)
+
+func testUpstream(id int64, weight int, healthy bool) *model.ServiceUpstream {
+	return &model.ServiceUpstream{UpstreamID: id, ServiceID: 1, Weight: weight, IsActive: true, IsHealthy: healthy}
+}
+
+// pickSequence 连续选择 n 次，返回选中的上游ID
+func pickSequence(b *Balancer, strategy string, upstreams []*model.ServiceUpstream, n int) []int64 {
+	picked := make([]int64, 0, n)
+	for i := 0; i < n; i++ {
+		upstream := b.Pick(strategy, upstreams)
+		if upstream == nil {
+			picked = append(picked, 0)
+			continue
+		}
+		picked = append(picked, upstream.UpstreamID)
+	}
+	return picked
+}
+
+func equalIDs(got, want []int64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBalancerWeightedRoundRobin(t *testing.T) {
+	upstreams := []*model.ServiceUpstream{testUpstream(1, 5, true), testUpstream(2, 1, true), testUpstream(3, 1, true)}
+
+	// 与 nginx 平滑加权轮询相同的顺序：权重高的上游被分散选中而不是连续选中
+	got := pickSequence(NewBalancer(), LBWeightedRoundRobin, upstreams, 14)
+	want := []int64{1, 1, 2, 1, 3, 1, 1, 1, 1, 2, 1, 3, 1, 1}
+	if !equalIDs(got, want) {
+		t.Errorf("picks = %v, want %v", got, want)
+	}
+}
+
+func TestBalancerWeightDefaultsToOne(t *testing.T) {
+	upstreams := []*model.ServiceUpstream{testUpstream(1, 0, true), testUpstream(2, -3, true)}
+
+	got := pickSequence(NewBalancer(), "", upstreams, 4)
+	want := []int64{1, 2, 1, 2}
+	if !equalIDs(got, want) {
+		t.Errorf("picks = %v, want %v", got, want)
+	}
+}
+
+func TestBalancerSkipsUnhealthyUpstreams(t *testing.T) {
+	upstreams := []*model.ServiceUpstream{testUpstream(1, 10, false), testUpstream(2, 1, true)}
+
+	got := pickSequence(NewBalancer(), LBWeightedRoundRobin, upstreams, 3)
+	want := []int64{2, 2, 2}
+	if !equalIDs(got, want) {
+		t.Errorf("picks = %v, want %v", got, want)
+	}
+
+	// 全部上游都不健康时仍在所有上游中选择
+	upstreams = []*model.ServiceUpstream{testUpstream(1, 1, false), testUpstream(2, 1, false)}
+	got = pickSequence(NewBalancer(), LBWeightedRoundRobin, upstreams, 2)
+	want = []int64{1, 2}
+	if !equalIDs(got, want) {
+		t.Errorf("picks with every upstream unhealthy = %v, want %v", got, want)
+	}
+
+	if upstream := NewBalancer().Pick(LBWeightedRoundRobin, nil); upstream != nil {
+		t.Errorf("Pick() on an empty pool = %v, want nil", upstream)
+	}
+}
+
+func TestBalancerLeastLatency(t *testing.T) {
+	b := NewBalancer()
+	upstreams := []*model.ServiceUpstream{testUpstream(1, 1, true), testUpstream(2, 1, true), testUpstream(3, 2, true)}
+
+	// 尚无延迟样本的上游优先被选中
+	b.ObserveLatency(1, 200*time.Millisecond)
+	if got := b.Pick(LBLeastLatency, upstreams); got.UpstreamID != 2 {
+		t.Fatalf("picked upstream %d, want the unobserved upstream 2", got.UpstreamID)
+	}
+
+	b.ObserveLatency(2, 50*time.Millisecond)
+	b.ObserveLatency(3, 100*time.Millisecond)
+	if got := b.Pick(LBLeastLatency, upstreams); got.UpstreamID != 2 {
+		t.Errorf("picked upstream %d, want the fastest upstream 2", got.UpstreamID)
+	}
+
+	// 延迟按指数移动平均更新：50*0.7 + 300*0.3 = 125 毫秒，慢于上游 3
+	b.ObserveLatency(2, 300*time.Millisecond)
+	if got := b.Pick(LBLeastLatency, upstreams); got.UpstreamID != 3 {
+		t.Errorf("picked upstream %d, want upstream 3 after upstream 2 slowed down", got.UpstreamID)
+	}
+
+	// 延迟相同时选择权重更高的上游
+	tie := NewBalancer()
+	tie.ObserveLatency(1, 100*time.Millisecond)
+	tie.ObserveLatency(3, 100*time.Millisecond)
+	if got := tie.Pick(LBLeastLatency, []*model.ServiceUpstream{upstreams[0], upstreams[2]}); got.UpstreamID != 3 {
+		t.Errorf("picked upstream %d on a latency tie, want the heavier upstream 3", got.UpstreamID)
+	}
+}
+
+func TestNormalizeLBStrategy(t *testing.T) {
+	tests := map[string]string{
+		"":                     LBWeightedRoundRobin,
+		"weighted_round_robin": LBWeightedRoundRobin,
+		" Least_Latency ":      LBLeastLatency,
+		"random":               LBWeightedRoundRobin,
+	}
+	for strategy, want := range tests {
+		if got := NormalizeLBStrategy(strategy); got != want {
+			t.Errorf("NormalizeLBStrategy(%q) = %q, want %q", strategy, got, want)
+		}
+	}
+}
@@ -0,0 +1,317 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"sync"
+	"time"
+)
+
+// 等待队列返回的错误
+var (
+	ErrQueueFull    = errors.New("concurrency queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting in concurrency queue")
+)
+
+// queuePollInterval 有请求排队时定期重新尝试占用名额的间隔，用于发现其他实例释放或过期的名额
+const queuePollInterval = 100 * time.Millisecond
+
+// queueMetrics 排队指标，通过 expvar 以 proxy_concurrency_queue 发布，按队列名分组
+var queueMetrics = expvar.NewMap("proxy_concurrency_queue")
+
+// FairQueueConfig 等待队列配置
+type FairQueueConfig struct {
+	MaxDepth       int           // 每个队列最多排队的请求数，0 表示不排队，名额不足时直接拒绝
+	MaxTenantDepth int           // 每个队列中单个租户最多排队的请求数，0 表示不单独限制
+	Timeout        time.Duration // 请求在队列中最长的等待时间
+}
+
+// AcquireFunc 尝试占用一个名额，返回是否成功；失败且 exhausted 为 true 表示名额对所有租户都已耗尽（例如服务整体并发已满），
+// 本轮调度不再为其他租户尝试
+type AcquireFunc func() (acquired bool, exhausted bool)
+
+// FairQueue 名额不足时请求排队等待的有界队列
+// 每个队列（例如一个服务）内按租户（买家）轮转调度，同一租户的请求先进先出，单个租户的大量请求不会饿死其他租户。
+// 队列保存在进程内存中，名额本身由 AcquireFunc 决定，可以是跨实例共享的分布式计数。
+type FairQueue struct {
+	config FairQueueConfig
+	mu     sync.Mutex
+	lanes  map[string]*queueLane
+}
+
+// queueLane 一个队列的排队状态
+type queueLane struct {
+	mu          sync.Mutex
+	tenants     []string                  // 有请求排队的租户，按轮转顺序排列
+	waiters     map[string][]*queueWaiter // 每个租户按到达顺序排队的请求
+	next        int                       // 下一次调度从 tenants 中的这个位置开始
+	depth       int
+	dispatching bool
+	wake        chan struct{}
+	metrics     *queueLaneMetrics
+}
+
+// queueWaiter 一个排队中的请求
+type queueWaiter struct {
+	acquire  AcquireFunc
+	ready    chan struct{}
+	admitted bool
+	pending  chan struct{} // 调度正在不持锁地为该请求占用名额时非 nil，尝试结束后关闭
+}
+
+// queueLaneMetrics 单个队列的 expvar 指标
+type queueLaneMetrics struct {
+	depth       expvar.Int // 当前排队的请求数
+	enqueued    expvar.Int // 累计进入队列的请求数
+	admitted    expvar.Int // 累计排队后获得名额的请求数
+	rejected    expvar.Int // 累计因队列已满被拒绝的请求数
+	timedOut    expvar.Int // 累计等待超时的请求数
+	canceled    expvar.Int // 累计在等待中断开的请求数
+	waitMsTotal expvar.Int // 获得名额的请求累计等待时间(毫秒)，除以 admitted 得到平均等待时间
+	waitMsMax   expvar.Int // 获得名额的请求中最长的等待时间(毫秒)
+}
+
+// NewFairQueue 创建等待队列
+func NewFairQueue(config FairQueueConfig) *FairQueue {
+	return &FairQueue{
+		config: config,
+		lanes:  make(map[string]*queueLane),
+	}
+}
+
+// lane 获取指定名称的队列，不存在时创建并注册指标
+func (q *FairQueue) lane(name string) *queueLane {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if l, ok := q.lanes[name]; ok {
+		return l
+	}
+	l := &queueLane{
+		waiters: make(map[string][]*queueWaiter),
+		wake:    make(chan struct{}, 1),
+		metrics: &queueLaneMetrics{},
+	}
+	vars := new(expvar.Map).Init()
+	vars.Set("depth", &l.metrics.depth)
+	vars.Set("enqueued", &l.metrics.enqueued)
+	vars.Set("admitted", &l.metrics.admitted)
+	vars.Set("rejected", &l.metrics.rejected)
+	vars.Set("timed_out", &l.metrics.timedOut)
+	vars.Set("canceled", &l.metrics.canceled)
+	vars.Set("wait_ms_total", &l.metrics.waitMsTotal)
+	vars.Set("wait_ms_max", &l.metrics.waitMsMax)
+	queueMetrics.Set(name, vars)
+
+	q.lanes[name] = l
+	return l
+}
+
+// Wait 为 tenant 在队列 lane 中占用一个名额
+// 队列中没有排队的请求时直接尝试，否则排在该租户已有请求之后，由调度按租户轮转为其占用名额。
+// 返回排队等待的时间；队列已满返回 ErrQueueFull，等待超时返回 ErrQueueTimeout，ctx 取消时返回 ctx.Err()。
+func (q *FairQueue) Wait(ctx context.Context, lane, tenant string, acquire AcquireFunc) (time.Duration, error) {
+	l := q.lane(lane)
+
+	l.mu.Lock()
+	idle := l.depth == 0
+	l.mu.Unlock()
+	// 名额充足时不经过队列锁，避免同一服务的请求被串行化
+	if idle {
+		if acquired, _ := acquire(); acquired {
+			return 0, nil
+		}
+	}
+
+	l.mu.Lock()
+	if l.depth >= q.config.MaxDepth || (q.config.MaxTenantDepth > 0 && len(l.waiters[tenant]) >= q.config.MaxTenantDepth) {
+		l.mu.Unlock()
+		l.metrics.rejected.Add(1)
+		return 0, ErrQueueFull
+	}
+	w := &queueWaiter{acquire: acquire, ready: make(chan struct{})}
+	if len(l.waiters[tenant]) == 0 {
+		l.tenants = append(l.tenants, tenant)
+	}
+	l.waiters[tenant] = append(l.waiters[tenant], w)
+	l.depth++
+	l.metrics.depth.Set(int64(l.depth))
+	l.metrics.enqueued.Add(1)
+	if !l.dispatching {
+		l.dispatching = true
+		go l.dispatch()
+	}
+	l.mu.Unlock()
+	l.signal()
+
+	start := time.Now()
+	timer := time.NewTimer(q.config.Timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	waited := time.Since(start)
+
+	if err != nil {
+		l.mu.Lock()
+		// 调度正在为该请求占用名额时等待结果；超时与获得名额同时发生时以获得名额为准，名额已被占用，不能丢弃
+		for w.pending != nil {
+			pending := w.pending
+			l.mu.Unlock()
+			<-pending
+			l.mu.Lock()
+		}
+		if w.admitted {
+			err = nil
+		} else {
+			l.removeWaiter(tenant, w)
+		}
+		l.mu.Unlock()
+	}
+
+	switch {
+	case err == nil:
+		l.metrics.admitted.Add(1)
+		waitedMs := waited.Milliseconds()
+		l.metrics.waitMsTotal.Add(waitedMs)
+		if waitedMs > l.metrics.waitMsMax.Value() {
+			l.metrics.waitMsMax.Set(waitedMs)
+		}
+	case err == ErrQueueTimeout:
+		l.metrics.timedOut.Add(1)
+	default:
+		l.metrics.canceled.Add(1)
+	}
+	return waited, err
+}
+
+// Notify 通知队列有名额被释放，立即为排队的请求重新尝试
+func (q *FairQueue) Notify(lane string) {
+	q.mu.Lock()
+	l := q.lanes[lane]
+	q.mu.Unlock()
+	if l != nil {
+		l.signal()
+	}
+}
+
+// Depth 返回队列中当前排队的请求数
+func (q *FairQueue) Depth(lane string) int {
+	l := q.lane(lane)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.depth
+}
+
+// signal 唤醒调度，已有未处理的唤醒时不重复发送
+func (l *queueLane) signal() {
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch 在有请求排队期间运行，被唤醒或定期为排队的请求占用名额，队列清空后退出
+func (l *queueLane) dispatch() {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.wake:
+		case <-ticker.C:
+		}
+
+		l.admit()
+		l.mu.Lock()
+		if l.depth == 0 {
+			l.dispatching = false
+			l.mu.Unlock()
+			return
+		}
+		l.mu.Unlock()
+	}
+}
+
+// admit 从上次的位置开始按租户轮转，依次为每个租户最早的请求尝试占用名额，
+// 直到完整一轮都没有成功或名额已对所有租户耗尽。
+// 只在选择请求和交付结果时持有 l.mu，占用名额（例如一次 Redis 往返）时不持锁，不阻塞入队和超时退出的请求
+func (l *queueLane) admit() {
+	l.mu.Lock()
+	attempts := len(l.tenants)
+	for attempts > 0 && len(l.tenants) > 0 {
+		if l.next >= len(l.tenants) {
+			l.next = 0
+		}
+		tenant := l.tenants[l.next]
+		w := l.waiters[tenant][0]
+		pending := make(chan struct{})
+		w.pending = pending
+		l.mu.Unlock()
+
+		acquired, exhausted := w.acquire()
+
+		l.mu.Lock()
+		w.pending = nil
+		close(pending)
+		// 不持锁期间其他租户可能入队或退出，按租户重新定位；请求在尝试期间不会被移出队列
+		index := 0
+		for l.tenants[index] != tenant {
+			index++
+		}
+		if !acquired {
+			if exhausted {
+				break
+			}
+			l.next = index + 1
+			attempts--
+			continue
+		}
+
+		w.admitted = true
+		close(w.ready)
+		l.next = index
+		l.removeWaiter(tenant, w)
+		// 租户还有排队的请求时轮到下一个租户；租户已被移出时 next 已经指向下一个租户
+		if index < len(l.tenants) && l.tenants[index] == tenant {
+			l.next = index + 1
+		}
+		attempts = len(l.tenants)
+	}
+	l.mu.Unlock()
+}
+
+// removeWaiter 将请求移出队列，租户没有排队的请求时同时移出轮转；调用方需持有 l.mu
+func (l *queueLane) removeWaiter(tenant string, w *queueWaiter) {
+	waiters := l.waiters[tenant]
+	for i, candidate := range waiters {
+		if candidate == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			l.depth--
+			l.metrics.depth.Set(int64(l.depth))
+			break
+		}
+	}
+	if len(waiters) > 0 {
+		l.waiters[tenant] = waiters
+		return
+	}
+
+	delete(l.waiters, tenant)
+	for i, candidate := range l.tenants {
+		if candidate == tenant {
+			l.tenants = append(l.tenants[:i], l.tenants[i+1:]...)
+			if i < l.next {
+				l.next--
+			}
+			break
+		}
+	}
+}
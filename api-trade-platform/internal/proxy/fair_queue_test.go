@@ -0,0 +1,164 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSlots 测试用的名额计数
+type testSlots struct {
+	mu   sync.Mutex
+	free int
+}
+
+func (s *testSlots) acquire() (bool, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.free == 0 {
+		return false, true
+	}
+	s.free--
+	return true, false
+}
+
+func (s *testSlots) release(n int) {
+	s.mu.Lock()
+	s.free += n
+	s.mu.Unlock()
+}
+
+// waitForDepth 等待队列中排队的请求数达到 depth
+func waitForDepth(t *testing.T, q *FairQueue, lane string, depth int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for q.Depth(lane) != depth {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth = %d, want %d", q.Depth(lane), depth)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFairQueueRoundRobinAcrossTenants(t *testing.T) {
+	q := NewFairQueue(FairQueueConfig{MaxDepth: 10, Timeout: 5 * time.Second})
+	slots := &testSlots{}
+	admitted := make(chan string, 4)
+
+	enqueue := func(tenant, name string, depth int) {
+		go func() {
+			if _, err := q.Wait(context.Background(), "fair", tenant, slots.acquire); err != nil {
+				t.Errorf("Wait(%s) error = %v", name, err)
+			}
+			admitted <- name
+		}()
+		waitForDepth(t, q, "fair", depth)
+	}
+	enqueue("a", "a1", 1)
+	enqueue("a", "a2", 2)
+	enqueue("a", "a3", 3)
+	enqueue("b", "b1", 4)
+
+	var order []string
+	for len(order) < 4 {
+		slots.release(1)
+		q.Notify("fair")
+		select {
+		case name := <-admitted:
+			order = append(order, name)
+		case <-time.After(time.Second):
+			t.Fatalf("no request admitted, order so far %v", order)
+		}
+	}
+
+	want := []string{"a1", "b1", "a2", "a3"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("admission order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestFairQueueLimits(t *testing.T) {
+	q := NewFairQueue(FairQueueConfig{MaxDepth: 2, MaxTenantDepth: 1, Timeout: 50 * time.Millisecond})
+	slots := &testSlots{}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Wait(context.Background(), "limits", "a", slots.acquire)
+		done <- err
+	}()
+	waitForDepth(t, q, "limits", 1)
+
+	if _, err := q.Wait(context.Background(), "limits", "a", slots.acquire); err != ErrQueueFull {
+		t.Errorf("Wait() over tenant depth error = %v, want ErrQueueFull", err)
+	}
+	if err := <-done; err != ErrQueueTimeout {
+		t.Errorf("Wait() without free slots error = %v, want ErrQueueTimeout", err)
+	}
+	if depth := q.Depth("limits"); depth != 0 {
+		t.Errorf("queue depth after timeout = %d, want 0", depth)
+	}
+
+	slots.release(1)
+	if waited, err := q.Wait(context.Background(), "limits", "b", slots.acquire); err != nil || waited != 0 {
+		t.Errorf("Wait() with a free slot = %v, %v, want immediate admission", waited, err)
+	}
+}
+
+func TestFairQueueAcquiresWithoutHoldingLock(t *testing.T) {
+	q := NewFairQueue(FairQueueConfig{MaxDepth: 10, Timeout: 50 * time.Millisecond})
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	var calls int
+	// 第一次（入队前的直接尝试）失败，调度时的尝试阻塞到 unblock 后成功，模拟慢的 Redis 往返
+	slowAcquire := func() (bool, bool) {
+		calls++
+		if calls == 1 {
+			return false, false
+		}
+		close(entered)
+		<-unblock
+		return true, false
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := q.Wait(context.Background(), "slow", "a", slowAcquire)
+		result <- err
+	}()
+	select {
+	case <-entered:
+	case <-time.After(time.Second):
+		t.Fatal("dispatcher never tried to acquire a slot")
+	}
+
+	// 占用名额期间队列仍可查询，其他请求也能入队
+	depth := make(chan int, 1)
+	go func() { depth <- q.Depth("slow") }()
+	select {
+	case d := <-depth:
+		if d != 1 {
+			t.Errorf("Depth() = %d while acquiring, want 1", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Depth() blocked while the dispatcher was acquiring a slot")
+	}
+	if _, err := q.Wait(context.Background(), "slow", "b", func() (bool, bool) { return false, true }); err != ErrQueueTimeout {
+		t.Errorf("Wait(b) error = %v, want ErrQueueTimeout", err)
+	}
+
+	// 等待超时后占用才成功：名额已被占用，请求仍视为获得名额
+	time.Sleep(60 * time.Millisecond)
+	close(unblock)
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("Wait(a) error = %v, want the slot acquired after its timeout", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Wait(a) never returned")
+	}
+	waitForDepth(t, q, "slow", 0)
+}
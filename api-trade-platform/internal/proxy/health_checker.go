@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"api-trade-platform/internal/model"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+)
+
+// 健康检查的默认配置
+const (
+	defaultHealthCheckInterval  = 30 * time.Second
+	defaultHealthCheckTimeout   = 5 * time.Second
+	defaultUnhealthyThreshold   = 3
+	defaultHealthyThreshold     = 2
+	maxHealthCheckResponseBytes = 4 * 1024
+)
+
+// UpstreamHealthStore 健康检查依赖的上游存储
+type UpstreamHealthStore interface {
+	ListActiveUpstreams() ([]*model.ServiceUpstream, error)
+	ListActiveUpstreamsByServiceID(serviceID int64) ([]*model.ServiceUpstream, error)
+	SetUpstreamHealth(upstreamID int64, healthy bool, lastError string) error
+}
+
+// HealthCheckConfig 健康检查配置，零值字段使用默认值
+type HealthCheckConfig struct {
+	Interval           time.Duration // 主动探测的间隔
+	Timeout            time.Duration // 单次探测的超时时间
+	UnhealthyThreshold int           // 连续失败多少次后剔除上游
+	HealthyThreshold   int           // 被剔除的上游连续成功多少次后恢复
+}
+
+// upstreamHealthState 单个上游在本实例中的连续成功/失败计数
+type upstreamHealthState struct {
+	healthy   bool
+	failures  int
+	successes int
+}
+
+// HealthChecker 维护上游池中各上游的健康状态
+// 主动探测与代理转发的结果（被动检查）共用同一组计数：连续失败达到阈值时剔除上游，
+// 连续成功达到阈值时恢复。状态变化写入数据库，所有平台实例据此选择上游。
+// 各服务启用的上游池缓存在内存中供代理选择上游，每轮主动探测时从数据库刷新。
+type HealthChecker struct {
+	store  UpstreamHealthStore
+	cfg    HealthCheckConfig
+	client *http.Client
+
+	mu     sync.Mutex
+	states map[int64]*upstreamHealthState
+	pools  map[int64][]*model.ServiceUpstream // 按 service_id 缓存的启用上游，缓存中的上游不会被原地修改
+}
+
+// NewHealthChecker 创建上游健康检查器
+func NewHealthChecker(store UpstreamHealthStore, cfg HealthCheckConfig) *HealthChecker {
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultHealthCheckInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultHealthCheckTimeout
+	}
+	if cfg.UnhealthyThreshold <= 0 {
+		cfg.UnhealthyThreshold = defaultUnhealthyThreshold
+	}
+	if cfg.HealthyThreshold <= 0 {
+		cfg.HealthyThreshold = defaultHealthyThreshold
+	}
+
+	return &HealthChecker{
+		store:  store,
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		states: make(map[int64]*upstreamHealthState),
+		pools:  make(map[int64][]*model.ServiceUpstream),
+	}
+}
+
+// ActiveUpstreams 返回服务中卖家启用的上游（包括当前不健康的上游），缓存未命中时从数据库加载
+// 返回的上游只能读取，不能修改。
+func (hc *HealthChecker) ActiveUpstreams(serviceID int64) ([]*model.ServiceUpstream, error) {
+	hc.mu.Lock()
+	pool, ok := hc.pools[serviceID]
+	hc.mu.Unlock()
+	if ok {
+		return pool, nil
+	}
+
+	pool, err := hc.store.ListActiveUpstreamsByServiceID(serviceID)
+	if err != nil {
+		return nil, err
+	}
+	hc.mu.Lock()
+	hc.pools[serviceID] = pool
+	hc.mu.Unlock()
+	return pool, nil
+}
+
+// InvalidateService 丢弃服务的上游池缓存，卖家修改上游后调用；其他平台实例在下一轮探测时刷新
+func (hc *HealthChecker) InvalidateService(serviceID int64) {
+	hc.mu.Lock()
+	delete(hc.pools, serviceID)
+	hc.mu.Unlock()
+}
+
+// refreshPools 用数据库中最新的上游列表替换所有服务的上游池缓存
+func (hc *HealthChecker) refreshPools(upstreams []*model.ServiceUpstream) {
+	pools := make(map[int64][]*model.ServiceUpstream)
+	for _, upstream := range upstreams {
+		pools[upstream.ServiceID] = append(pools[upstream.ServiceID], upstream)
+	}
+	hc.mu.Lock()
+	hc.pools = pools
+	hc.mu.Unlock()
+}
+
+// Run 周期性地探测所有启用的上游，直到 ctx 被取消
+func (hc *HealthChecker) Run(ctx context.Context) {
+	ticker := time.NewTicker(hc.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		hc.CheckAll(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll 并发探测一遍所有启用的上游
+func (hc *HealthChecker) CheckAll(ctx context.Context) {
+	upstreams, err := hc.store.ListActiveUpstreams()
+	if err != nil {
+		fmt.Printf("Upstream health check failed: %v\n", err)
+		return
+	}
+	hc.refreshPools(upstreams)
+
+	var wg sync.WaitGroup
+	for _, upstream := range upstreams {
+		wg.Add(1)
+		go func(upstream *model.ServiceUpstream) {
+			defer wg.Done()
+			hc.record(upstream, hc.probe(ctx, upstream))
+		}(upstream)
+	}
+	wg.Wait()
+}
+
+// probe 向上游发送一次不带认证的 GET 请求，能建立连接且状态码小于 500 即视为健康
+// 未认证的请求通常返回 401/404，这同样说明上游在线。
+func (hc *HealthChecker) probe(ctx context.Context, upstream *model.ServiceUpstream) error {
+	target, err := url.Parse(upstream.EndpointURL)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint url: %w", err)
+	}
+	if upstream.HealthCheckPath != "" {
+		target.Path = path.Join("/", target.Path, upstream.HealthCheckPath)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := hc.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxHealthCheckResponseBytes))
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ReportResult 记录一次代理转发的结果（被动检查），err 为 nil 表示上游正常响应
+func (hc *HealthChecker) ReportResult(upstream *model.ServiceUpstream, err error) {
+	hc.record(upstream, err)
+}
+
+// record 更新上游的连续成功/失败计数，健康状态变化时写入数据库
+func (hc *HealthChecker) record(upstream *model.ServiceUpstream, checkErr error) {
+	hc.mu.Lock()
+	state, ok := hc.states[upstream.UpstreamID]
+	if !ok || state.healthy != upstream.IsHealthy {
+		// 以数据库中的状态为准，其他平台实例可能已经剔除或恢复了该上游
+		state = &upstreamHealthState{healthy: upstream.IsHealthy}
+		hc.states[upstream.UpstreamID] = state
+	}
+
+	changed := false
+	if checkErr == nil {
+		state.failures = 0
+		state.successes++
+		if !state.healthy && state.successes >= hc.cfg.HealthyThreshold {
+			state.healthy, changed = true, true
+		}
+	} else {
+		state.successes = 0
+		state.failures++
+		if state.healthy && state.failures >= hc.cfg.UnhealthyThreshold {
+			state.healthy, changed = false, true
+		}
+	}
+	healthy := state.healthy
+	if changed {
+		hc.setCachedHealth(upstream, healthy)
+	}
+	hc.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	lastError := ""
+	if checkErr != nil {
+		lastError = checkErr.Error()
+		fmt.Printf("Upstream %d of service %d ejected: %v\n", upstream.UpstreamID, upstream.ServiceID, checkErr)
+	} else {
+		fmt.Printf("Upstream %d of service %d recovered\n", upstream.UpstreamID, upstream.ServiceID)
+	}
+	if err := hc.store.SetUpstreamHealth(upstream.UpstreamID, healthy, lastError); err != nil {
+		fmt.Printf("Upstream health check failed: %v\n", err)
+	}
+}
+
+// setCachedHealth 更新上游池缓存中上游的健康状态，替换为新的副本而不修改正在被读取的上游，调用方需持有 hc.mu
+func (hc *HealthChecker) setCachedHealth(upstream *model.ServiceUpstream, healthy bool) {
+	pool, ok := hc.pools[upstream.ServiceID]
+	if !ok {
+		return
+	}
+	updated := make([]*model.ServiceUpstream, len(pool))
+	for i, cached := range pool {
+		if cached.UpstreamID == upstream.UpstreamID {
+			replacement := *cached
+			replacement.IsHealthy = healthy
+			cached = &replacement
+		}
+		updated[i] = cached
+	}
+	hc.pools[upstream.ServiceID] = updated
+}
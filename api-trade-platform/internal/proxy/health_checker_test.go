@@ -0,0 +1,175 @@
+package proxy
+
+import (
+	"api-trade-platform/internal/model"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// fakeUpstreamStore 测试用的内存上游存储，记录数据库查询和健康状态写入
+type fakeUpstreamStore struct {
+	mu        sync.Mutex
+	upstreams []*model.ServiceUpstream
+	queries   int
+	updates   map[int64]bool
+}
+
+func (s *fakeUpstreamStore) ListActiveUpstreams() ([]*model.ServiceUpstream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+	return s.copyUpstreams(0), nil
+}
+
+func (s *fakeUpstreamStore) ListActiveUpstreamsByServiceID(serviceID int64) ([]*model.ServiceUpstream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queries++
+	return s.copyUpstreams(serviceID), nil
+}
+
+// copyUpstreams 与数据库查询一样每次返回新的上游，serviceID 为 0 时返回所有服务的上游
+func (s *fakeUpstreamStore) copyUpstreams(serviceID int64) []*model.ServiceUpstream {
+	var upstreams []*model.ServiceUpstream
+	for _, upstream := range s.upstreams {
+		if serviceID == 0 || upstream.ServiceID == serviceID {
+			copied := *upstream
+			upstreams = append(upstreams, &copied)
+		}
+	}
+	return upstreams
+}
+
+func (s *fakeUpstreamStore) SetUpstreamHealth(upstreamID int64, healthy bool, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.updates == nil {
+		s.updates = make(map[int64]bool)
+	}
+	s.updates[upstreamID] = healthy
+	for _, upstream := range s.upstreams {
+		if upstream.UpstreamID == upstreamID {
+			upstream.IsHealthy = healthy
+		}
+	}
+	return nil
+}
+
+func (s *fakeUpstreamStore) queryCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries
+}
+
+func TestHealthCheckerEjectsAndRecovers(t *testing.T) {
+	store := &fakeUpstreamStore{upstreams: []*model.ServiceUpstream{testUpstream(1, 1, true)}}
+	hc := NewHealthChecker(store, HealthCheckConfig{UnhealthyThreshold: 3, HealthyThreshold: 2})
+	upstream, _ := hc.ActiveUpstreams(1)
+
+	failure := errors.New("connection refused")
+	for i := 0; i < 2; i++ {
+		hc.ReportResult(upstream[0], failure)
+	}
+	if len(store.updates) != 0 {
+		t.Fatalf("upstream ejected before reaching the unhealthy threshold")
+	}
+	// 成功的响应清零连续失败次数
+	hc.ReportResult(upstream[0], nil)
+	for i := 0; i < 2; i++ {
+		hc.ReportResult(upstream[0], failure)
+	}
+	if len(store.updates) != 0 {
+		t.Fatalf("failures separated by a success should not eject the upstream")
+	}
+	hc.ReportResult(upstream[0], failure)
+	if healthy, ok := store.updates[1]; !ok || healthy {
+		t.Fatalf("upstream not ejected after 3 consecutive failures, updates = %v", store.updates)
+	}
+
+	// 缓存中的上游池立即反映剔除，原先取到的上游不会被修改
+	pool, _ := hc.ActiveUpstreams(1)
+	if pool[0].IsHealthy {
+		t.Errorf("cached pool still reports the ejected upstream as healthy")
+	}
+	if !upstream[0].IsHealthy {
+		t.Errorf("ReportResult modified an upstream that was already handed out")
+	}
+
+	hc.ReportResult(pool[0], nil)
+	if store.updates[1] {
+		t.Fatalf("upstream recovered after a single success")
+	}
+	hc.ReportResult(pool[0], nil)
+	if !store.updates[1] {
+		t.Errorf("upstream not recovered after 2 consecutive successes")
+	}
+}
+
+func TestHealthCheckerCachesUpstreamPools(t *testing.T) {
+	store := &fakeUpstreamStore{upstreams: []*model.ServiceUpstream{testUpstream(1, 1, true)}}
+	hc := NewHealthChecker(store, HealthCheckConfig{})
+
+	for i := 0; i < 3; i++ {
+		pool, err := hc.ActiveUpstreams(1)
+		if err != nil || len(pool) != 1 {
+			t.Fatalf("ActiveUpstreams() = %v, %v", pool, err)
+		}
+	}
+	if got := store.queryCount(); got != 1 {
+		t.Errorf("store queried %d times, want 1", got)
+	}
+
+	// 没有上游的服务同样被缓存
+	hc.ActiveUpstreams(2)
+	hc.ActiveUpstreams(2)
+	if got := store.queryCount(); got != 2 {
+		t.Errorf("store queried %d times for an empty pool, want 2", got)
+	}
+
+	// 卖家修改上游后重新从数据库加载
+	store.upstreams = append(store.upstreams, &model.ServiceUpstream{UpstreamID: 2, ServiceID: 1, Weight: 1, IsActive: true, IsHealthy: true})
+	hc.InvalidateService(1)
+	if pool, _ := hc.ActiveUpstreams(1); len(pool) != 2 {
+		t.Errorf("pool after invalidation has %d upstreams, want 2", len(pool))
+	}
+}
+
+func TestHealthCheckerCheckAllRefreshesPools(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 未认证的探测返回 401 也说明上游在线
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer healthy.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+
+	store := &fakeUpstreamStore{upstreams: []*model.ServiceUpstream{
+		{UpstreamID: 1, ServiceID: 1, EndpointURL: healthy.URL, IsActive: true, IsHealthy: true},
+		{UpstreamID: 2, ServiceID: 1, EndpointURL: broken.URL, IsActive: true, IsHealthy: true},
+	}}
+	hc := NewHealthChecker(store, HealthCheckConfig{UnhealthyThreshold: 1})
+
+	hc.CheckAll(context.Background())
+	if healthy, ok := store.updates[2]; !ok || healthy {
+		t.Errorf("upstream returning 502 not ejected, updates = %v", store.updates)
+	}
+	if _, ok := store.updates[1]; ok {
+		t.Errorf("healthy upstream changed state, updates = %v", store.updates)
+	}
+
+	// 探测时已从数据库刷新上游池，选择上游不再查询数据库
+	queries := store.queryCount()
+	pool, _ := hc.ActiveUpstreams(1)
+	if store.queryCount() != queries {
+		t.Errorf("ActiveUpstreams() queried the store after CheckAll refreshed the pools")
+	}
+	if len(pool) != 2 || !pool[0].IsHealthy || pool[1].IsHealthy {
+		t.Errorf("refreshed pool = %+v, want upstream 2 ejected", pool)
+	}
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+)
+
+// anthropicStreamEvent Anthropic Messages 流式事件中用到的字段
+type anthropicStreamEvent struct {
+	Type         string             `json:"type"`
+	Index        int                `json:"index"`
+	Message      *anthropicResponse `json:"message,omitempty"`
+	ContentBlock *anthropicBlock    `json:"content_block,omitempty"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *anthropicUsage `json:"usage,omitempty"`
+	Error *anthropicError `json:"error,omitempty"`
+}
+
+// AnthropicStreamReader 将上游 Anthropic Messages SSE 流实时转换为 OpenAI Chat Completions chunk 流
+// 上游以 message_stop 结束时，转换后的流以带 usage 的 chunk 和 "data: [DONE]" 结束，使下游的 token 统计与 OpenAI 流一致；
+// 上游没有 message_stop 就结束时 Read 返回 io.ErrUnexpectedEOF
+type AnthropicStreamReader struct {
+	src io.ReadCloser
+	buf []byte
+	out bytes.Buffer
+	err error
+
+	pending   []byte
+	eventType string
+	dataLines []string
+
+	id        string
+	model     string
+	created   int64
+	usage     anthropicUsage
+	toolIndex map[int]int // Anthropic 内容块索引 -> OpenAI tool_calls 索引
+	finished  bool
+}
+
+// NewAnthropicStreamReader 包装上游响应体，返回 OpenAI 格式的事件流
+func NewAnthropicStreamReader(src io.ReadCloser) *AnthropicStreamReader {
+	return &AnthropicStreamReader{
+		src:       src,
+		buf:       make([]byte, 32*1024),
+		created:   time.Now().Unix(),
+		toolIndex: make(map[int]int),
+	}
+}
+
+// Read 读取转换后的 OpenAI 事件流
+func (r *AnthropicStreamReader) Read(p []byte) (int, error) {
+	for r.out.Len() == 0 && r.err == nil {
+		n, err := r.src.Read(r.buf)
+		if n > 0 {
+			r.feed(r.buf[:n])
+		}
+		if err != nil {
+			r.err = err
+			if err == io.EOF {
+				r.flush()
+				// 上游没有发送 message_stop 就结束时流被截断，不输出 usage 和 [DONE]，
+				// 以非 EOF 错误结束使代理按上游中断处理，不对截断的流计费
+				if !r.finished {
+					r.err = io.ErrUnexpectedEOF
+				}
+			}
+		}
+	}
+	if r.out.Len() > 0 {
+		return r.out.Read(p)
+	}
+	return 0, r.err
+}
+
+// Close 关闭上游响应体
+func (r *AnthropicStreamReader) Close() error {
+	return r.src.Close()
+}
+
+// feed 按行解析上游 SSE 数据
+func (r *AnthropicStreamReader) feed(chunk []byte) {
+	r.pending = append(r.pending, chunk...)
+	for {
+		idx := bytes.IndexByte(r.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(r.pending[:idx]), "\r")
+		r.pending = r.pending[idx+1:]
+		r.processLine(line)
+	}
+	if len(r.pending) == 0 {
+		r.pending = nil
+	}
+}
+
+// flush 处理上游结束时残留的事件
+func (r *AnthropicStreamReader) flush() {
+	if len(r.pending) > 0 {
+		r.processLine(strings.TrimSuffix(string(r.pending), "\r"))
+		r.pending = nil
+	}
+	r.dispatchEvent()
+}
+
+func (r *AnthropicStreamReader) processLine(line string) {
+	switch {
+	case line == "":
+		r.dispatchEvent()
+	case strings.HasPrefix(line, ":"):
+		// SSE 注释 / 心跳
+	case strings.HasPrefix(line, "data:"):
+		r.dataLines = append(r.dataLines, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+	case strings.HasPrefix(line, "event:"):
+		r.eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+	}
+}
+
+// dispatchEvent 将一个完整的 Anthropic 事件转换为零个或多个 OpenAI chunk
+func (r *AnthropicStreamReader) dispatchEvent() {
+	if len(r.dataLines) == 0 {
+		r.eventType = ""
+		return
+	}
+	data := strings.Join(r.dataLines, "\n")
+	r.dataLines = nil
+	r.eventType = ""
+
+	var event anthropicStreamEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return
+	}
+
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			r.id = event.Message.ID
+			r.model = event.Message.Model
+			r.usage = event.Message.Usage
+		}
+		r.writeChunk(map[string]interface{}{"role": "assistant", "content": ""}, nil)
+	case "content_block_start":
+		if event.ContentBlock != nil && event.ContentBlock.Type == "tool_use" {
+			index := len(r.toolIndex)
+			r.toolIndex[event.Index] = index
+			r.writeChunk(map[string]interface{}{
+				"tool_calls": []openAIToolCall{{
+					Index:    &index,
+					ID:       event.ContentBlock.ID,
+					Type:     "function",
+					Function: openAIFunctionCall{Name: event.ContentBlock.Name, Arguments: ""},
+				}},
+			}, nil)
+		}
+	case "content_block_delta":
+		switch event.Delta.Type {
+		case "text_delta":
+			r.writeChunk(map[string]interface{}{"content": event.Delta.Text}, nil)
+		case "input_json_delta":
+			index, ok := r.toolIndex[event.Index]
+			if !ok {
+				return
+			}
+			r.writeChunk(map[string]interface{}{
+				"tool_calls": []openAIToolCall{{
+					Index:    &index,
+					Function: openAIFunctionCall{Arguments: event.Delta.PartialJSON},
+				}},
+			}, nil)
+		}
+	case "message_delta":
+		if event.Usage != nil {
+			r.usage.OutputTokens = event.Usage.OutputTokens
+			if event.Usage.InputTokens > 0 {
+				r.usage.InputTokens = event.Usage.InputTokens
+			}
+			if event.Usage.CacheCreationInputTokens > 0 {
+				r.usage.CacheCreationInputTokens = event.Usage.CacheCreationInputTokens
+			}
+			if event.Usage.CacheReadInputTokens > 0 {
+				r.usage.CacheReadInputTokens = event.Usage.CacheReadInputTokens
+			}
+		}
+		if event.Delta.StopReason != "" {
+			reason := finishReason(event.Delta.StopReason)
+			r.writeChunk(map[string]interface{}{}, &reason)
+		}
+	case "message_stop":
+		r.finish()
+	case "error":
+		if event.Error != nil {
+			r.writeData(map[string]interface{}{
+				"error": map[string]interface{}{
+					"message": event.Error.Message,
+					"type":    event.Error.Type,
+					"code":    event.Error.Type,
+				},
+			})
+		}
+	}
+}
+
+// finish 在收到 message_stop 时输出带 usage 的最终 chunk 和 [DONE]，只执行一次
+func (r *AnthropicStreamReader) finish() {
+	if r.finished {
+		return
+	}
+	r.finished = true
+
+	r.writeData(map[string]interface{}{
+		"id":      r.id,
+		"object":  "chat.completion.chunk",
+		"created": r.created,
+		"model":   r.model,
+		"choices": []interface{}{},
+		"usage":   r.usage.toOpenAI(),
+	})
+	r.out.WriteString("data: [DONE]\n\n")
+}
+
+// writeChunk 输出一个 chat.completion.chunk 事件
+func (r *AnthropicStreamReader) writeChunk(delta map[string]interface{}, finishReason *string) {
+	r.writeData(map[string]interface{}{
+		"id":      r.id,
+		"object":  "chat.completion.chunk",
+		"created": r.created,
+		"model":   r.model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"delta":         delta,
+			"finish_reason": finishReason,
+		}},
+	})
+}
+
+func (r *AnthropicStreamReader) writeData(payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+	r.out.WriteString("data: ")
+	r.out.Write(data)
+	r.out.WriteString("\n\n")
+}
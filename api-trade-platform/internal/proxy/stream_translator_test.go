@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// anthropicStream 一个包含文本和工具调用的完整 Anthropic 事件流
+const anthropicStream = "event: message_start\n" +
+	`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet","usage":{"input_tokens":10,"output_tokens":1,"cache_read_input_tokens":4}}}` + "\n\n" +
+	": ping\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}` + "\r\n\r\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"lo"}}` + "\n\n" +
+	"event: content_block_start\n" +
+	`data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather"}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}` + "\n\n" +
+	"event: content_block_delta\n" +
+	`data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}` + "\n\n" +
+	"event: message_delta\n" +
+	`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":12}}` + "\n\n"
+
+const anthropicStreamStop = "event: message_stop\n" + `data: {"type":"message_stop"}` + "\n\n"
+
+// readOpenAIStream 读取转换后的事件流，返回各事件的 data 和读取结束时的错误
+func readOpenAIStream(t *testing.T, src io.Reader) ([]string, error) {
+	t.Helper()
+	out, err := io.ReadAll(NewAnthropicStreamReader(io.NopCloser(src)))
+	var events []string
+	for _, event := range strings.Split(string(out), "\n\n") {
+		if event == "" {
+			continue
+		}
+		if !strings.HasPrefix(event, "data: ") || strings.Contains(event, "\n") {
+			t.Fatalf("malformed SSE event %q", event)
+		}
+		events = append(events, strings.TrimPrefix(event, "data: "))
+	}
+	return events, err
+}
+
+// chunkDelta 取出 chunk 中的 delta 和 finish_reason
+func chunkDelta(t *testing.T, data string) (map[string]interface{}, interface{}) {
+	t.Helper()
+	var chunk struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Model   string `json:"model"`
+		Choices []struct {
+			Delta        map[string]interface{} `json:"delta"`
+			FinishReason interface{}            `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		t.Fatalf("invalid chunk %s: %v", data, err)
+	}
+	if chunk.ID != "msg_1" || chunk.Object != "chat.completion.chunk" || chunk.Model != "claude-sonnet" || len(chunk.Choices) != 1 {
+		t.Fatalf("unexpected chunk %s", data)
+	}
+	return chunk.Choices[0].Delta, chunk.Choices[0].FinishReason
+}
+
+func TestAnthropicStreamReaderTranslatesDeltas(t *testing.T) {
+	// 逐字节读取，验证事件跨多次读取时的增量解析
+	events, err := readOpenAIStream(t, iotest.OneByteReader(strings.NewReader(anthropicStream+anthropicStreamStop)))
+	if err != nil {
+		t.Fatalf("reading the translated stream failed: %v", err)
+	}
+	if len(events) != 9 {
+		t.Fatalf("got %d events, want 9: %v", len(events), events)
+	}
+
+	wantDeltas := []map[string]interface{}{
+		{"role": "assistant", "content": ""},
+		{"content": "Hel"},
+		{"content": "lo"},
+		{"tool_calls": []interface{}{map[string]interface{}{
+			"index": 0.0, "id": "toolu_1", "type": "function",
+			"function": map[string]interface{}{"name": "get_weather", "arguments": ""},
+		}}},
+		{"tool_calls": []interface{}{map[string]interface{}{"index": 0.0, "function": map[string]interface{}{"arguments": `{"city":`}}}},
+		{"tool_calls": []interface{}{map[string]interface{}{"index": 0.0, "function": map[string]interface{}{"arguments": `"Paris"}`}}}},
+		{},
+	}
+	for i, want := range wantDeltas {
+		delta, finishReason := chunkDelta(t, events[i])
+		if !reflect.DeepEqual(delta, want) {
+			t.Errorf("chunk %d delta = %v, want %v", i, delta, want)
+		}
+		wantFinish := interface{}(nil)
+		if i == len(wantDeltas)-1 {
+			wantFinish = "tool_calls"
+		}
+		if finishReason != wantFinish {
+			t.Errorf("chunk %d finish_reason = %v, want %v", i, finishReason, wantFinish)
+		}
+	}
+
+	// 最后输出带 usage 的 chunk 和 [DONE]，output_tokens 取 message_delta 中的累计值
+	var final struct {
+		Choices []interface{} `json:"choices"`
+		Usage   openAIUsage   `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(events[7]), &final); err != nil {
+		t.Fatalf("invalid usage chunk: %v", err)
+	}
+	wantUsage := openAIUsage{PromptTokens: 14, CompletionTokens: 12, TotalTokens: 26, PromptTokensDetails: &openAIPromptTokensDetails{CachedTokens: 4}}
+	if len(final.Choices) != 0 || !reflect.DeepEqual(final.Usage, wantUsage) {
+		t.Errorf("usage chunk = %s, want usage %+v", events[7], wantUsage)
+	}
+	if events[8] != "[DONE]" {
+		t.Errorf("stream ends with %q, want [DONE]", events[8])
+	}
+}
+
+func TestAnthropicStreamReaderTruncatedStream(t *testing.T) {
+	// 上游没有发送 message_stop 就结束时，已收到的内容照常转发，但不输出 usage 和 [DONE]
+	events, err := readOpenAIStream(t, strings.NewReader(anthropicStream))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("read error = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(events) != 7 {
+		t.Fatalf("got %d events, want the 7 content chunks: %v", len(events), events)
+	}
+	for _, event := range events {
+		if event == "[DONE]" || strings.Contains(event, `"usage"`) {
+			t.Errorf("truncated stream emitted %s", event)
+		}
+	}
+}
+
+func TestAnthropicStreamReaderError(t *testing.T) {
+	stream := "event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","model":"claude-sonnet","usage":{"input_tokens":10,"output_tokens":1}}}` + "\n\n" +
+		"event: error\n" +
+		`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`
+
+	// 上游在流中报错后断开，错误转换为 OpenAI 格式，不计费
+	events, err := readOpenAIStream(t, strings.NewReader(stream))
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("read error = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2: %v", len(events), events)
+	}
+	want := `{"error":{"code":"overloaded_error","message":"Overloaded","type":"overloaded_error"}}`
+	if events[1] != want {
+		t.Errorf("error event = %s, want %s", events[1], want)
+	}
+}
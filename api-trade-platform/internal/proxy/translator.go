@@ -0,0 +1,515 @@
+// Package proxy 包含代理转发过程中与上游协议相关的处理逻辑，
+// 例如在 OpenAI Chat Completions 与 Anthropic Messages 两种协议之间转换请求和响应，
+// 以及在服务的上游池中选择上游并维护上游的健康状态。
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// 上游服务声明的 API 协议格式
+const (
+	FormatOpenAI    = "openai"
+	FormatAnthropic = "anthropic"
+)
+
+const (
+	// AnthropicMessagesPath Anthropic Messages 接口路径
+	AnthropicMessagesPath = "/v1/messages"
+	// AnthropicVersion 调用 Anthropic 接口时默认使用的 anthropic-version 头
+	AnthropicVersion = "2023-06-01"
+	// defaultAnthropicMaxTokens OpenAI 请求未指定 max_tokens 时使用的默认值（Anthropic 要求必填）
+	defaultAnthropicMaxTokens = 4096
+	// maxAnthropicTemperature Anthropic temperature 的上限，OpenAI 的取值范围为 0-2
+	maxAnthropicTemperature = 1.0
+)
+
+// NormalizeFormat 规范化服务的 API 格式，空值视为 OpenAI
+func NormalizeFormat(format string) string {
+	if strings.EqualFold(strings.TrimSpace(format), FormatAnthropic) {
+		return FormatAnthropic
+	}
+	return FormatOpenAI
+}
+
+// NeedsTranslation 判断买家以 OpenAI 格式发起的请求是否需要转换为上游格式
+// 目前只转换 Chat Completions 请求，其他路径原样透传
+func NeedsTranslation(upstreamFormat, requestPath string) bool {
+	return NormalizeFormat(upstreamFormat) == FormatAnthropic &&
+		strings.HasSuffix(strings.TrimRight(requestPath, "/"), "/chat/completions")
+}
+
+// --- OpenAI Chat Completions 结构 ---
+
+type openAIChatRequest struct {
+	Model               string           `json:"model"`
+	Messages            []openAIMessage  `json:"messages"`
+	MaxTokens           *int             `json:"max_tokens,omitempty"`
+	MaxCompletionTokens *int             `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64         `json:"temperature,omitempty"`
+	TopP                *float64         `json:"top_p,omitempty"`
+	Stop                json.RawMessage  `json:"stop,omitempty"`
+	Stream              bool             `json:"stream,omitempty"`
+	Tools               []openAITool     `json:"tools,omitempty"`
+	ToolChoice          json.RawMessage  `json:"tool_choice,omitempty"`
+	Functions           []openAIFunction `json:"functions,omitempty"`
+	FunctionCall        json.RawMessage  `json:"function_call,omitempty"`
+	User                string           `json:"user,omitempty"`
+}
+
+type openAIMessage struct {
+	Role         string              `json:"role"`
+	Content      json.RawMessage     `json:"content,omitempty"`
+	Name         string              `json:"name,omitempty"`
+	ToolCalls    []openAIToolCall    `json:"tool_calls,omitempty"`
+	ToolCallID   string              `json:"tool_call_id,omitempty"`
+	FunctionCall *openAIFunctionCall `json:"function_call,omitempty"`
+}
+
+type openAIContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	ImageURL *struct {
+		URL string `json:"url"`
+	} `json:"image_url,omitempty"`
+}
+
+type openAITool struct {
+	Type     string         `json:"type"`
+	Function openAIFunction `json:"function"`
+}
+
+type openAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type openAIToolCall struct {
+	Index    *int               `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function openAIFunctionCall `json:"function"`
+}
+
+type openAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type openAIUsage struct {
+	PromptTokens        int                        `json:"prompt_tokens"`
+	CompletionTokens    int                        `json:"completion_tokens"`
+	TotalTokens         int                        `json:"total_tokens"`
+	PromptTokensDetails *openAIPromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+	// 非 OpenAI 标准字段：保留 Anthropic 的缓存写入 token 数，缓存写入与普通输入价格不同
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+}
+
+type openAIPromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// --- Anthropic Messages 结构 ---
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	MaxTokens     int                `json:"max_tokens"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice    interface{}        `json:"tool_choice,omitempty"`
+	Metadata      map[string]string  `json:"metadata,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicBlock struct {
+	Type      string           `json:"type"`
+	Text      string           `json:"text,omitempty"`
+	Source    *anthropicSource `json:"source,omitempty"`
+	ID        string           `json:"id,omitempty"`
+	Name      string           `json:"name,omitempty"`
+	Input     json.RawMessage  `json:"input,omitempty"`
+	ToolUseID string           `json:"tool_use_id,omitempty"`
+	Content   string           `json:"content,omitempty"`
+}
+
+type anthropicSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicResponse struct {
+	ID         string           `json:"id"`
+	Type       string           `json:"type"`
+	Model      string           `json:"model"`
+	Content    []anthropicBlock `json:"content"`
+	StopReason string           `json:"stop_reason"`
+	Usage      anthropicUsage   `json:"usage"`
+	Error      *anthropicError  `json:"error,omitempty"`
+}
+
+type anthropicUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// toOpenAI 将 Anthropic usage 转换为 OpenAI usage
+// Anthropic 的 input_tokens 不包含缓存读写的 token，OpenAI 的 prompt_tokens 包含缓存命中的 token。
+func (u anthropicUsage) toOpenAI() openAIUsage {
+	promptTokens := u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+	usage := openAIUsage{
+		PromptTokens:             promptTokens,
+		CompletionTokens:         u.OutputTokens,
+		TotalTokens:              promptTokens + u.OutputTokens,
+		CacheCreationInputTokens: u.CacheCreationInputTokens,
+	}
+	if u.CacheReadInputTokens > 0 {
+		usage.PromptTokensDetails = &openAIPromptTokensDetails{CachedTokens: u.CacheReadInputTokens}
+	}
+	return usage
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// OpenAIToAnthropicRequest 将 OpenAI Chat Completions 请求体转换为 Anthropic Messages 请求体
+// system 消息合并为顶层 system，tool 消息转换为 tool_result，tools/functions 转换为 Anthropic 工具定义
+func OpenAIToAnthropicRequest(body []byte) ([]byte, error) {
+	var req openAIChatRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("invalid chat completions request: %w", err)
+	}
+
+	out := anthropicRequest{
+		Model:       req.Model,
+		MaxTokens:   defaultAnthropicMaxTokens,
+		Temperature: clampTemperature(req.Temperature),
+		TopP:        req.TopP,
+		Stream:      req.Stream,
+	}
+	if req.MaxCompletionTokens != nil {
+		out.MaxTokens = *req.MaxCompletionTokens
+	} else if req.MaxTokens != nil {
+		out.MaxTokens = *req.MaxTokens
+	}
+	// Anthropic 的 metadata 只支持 user_id，对应 OpenAI 的 user 字段
+	if req.User != "" {
+		out.Metadata = map[string]string{"user_id": req.User}
+	}
+
+	stops, err := parseStop(req.Stop)
+	if err != nil {
+		return nil, err
+	}
+	out.StopSequences = stops
+
+	var systemParts []string
+	for _, msg := range req.Messages {
+		switch msg.Role {
+		case "system", "developer":
+			text, _, err := convertContent(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			systemParts = append(systemParts, text)
+		case "user":
+			_, blocks, err := convertContent(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			out.Messages = appendMessage(out.Messages, "user", blocks)
+		case "assistant":
+			_, blocks, err := convertContent(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			for _, call := range msg.ToolCalls {
+				blocks = append(blocks, toolUseBlock(call.ID, call.Function))
+			}
+			if msg.FunctionCall != nil {
+				blocks = append(blocks, toolUseBlock("call_"+msg.FunctionCall.Name, *msg.FunctionCall))
+			}
+			out.Messages = appendMessage(out.Messages, "assistant", blocks)
+		case "tool", "function":
+			text, _, err := convertContent(msg.Content)
+			if err != nil {
+				return nil, err
+			}
+			toolUseID := msg.ToolCallID
+			if toolUseID == "" {
+				toolUseID = "call_" + msg.Name
+			}
+			out.Messages = appendMessage(out.Messages, "user", []anthropicBlock{{
+				Type:      "tool_result",
+				ToolUseID: toolUseID,
+				Content:   text,
+			}})
+		default:
+			return nil, fmt.Errorf("unsupported message role: %s", msg.Role)
+		}
+	}
+	out.System = strings.Join(systemParts, "\n\n")
+
+	for _, tool := range req.Tools {
+		out.Tools = append(out.Tools, convertTool(tool.Function))
+	}
+	for _, fn := range req.Functions {
+		out.Tools = append(out.Tools, convertTool(fn))
+	}
+
+	toolChoice := req.ToolChoice
+	if len(toolChoice) == 0 {
+		toolChoice = req.FunctionCall
+	}
+	choice, disableTools := convertToolChoice(toolChoice)
+	if disableTools {
+		// Anthropic 通过不传 tools 实现 "none"
+		out.Tools = nil
+	} else if len(out.Tools) > 0 {
+		out.ToolChoice = choice
+	}
+
+	return json.Marshal(out)
+}
+
+// clampTemperature 将 OpenAI temperature 限制在 Anthropic 的 0-1 范围内
+// 0-1 之间的值原样保留，超过 1 的值按 1 处理，避免上游以参数超出范围拒绝请求
+func clampTemperature(temperature *float64) *float64 {
+	if temperature == nil {
+		return nil
+	}
+	clamped := math.Min(math.Max(*temperature, 0), maxAnthropicTemperature)
+	return &clamped
+}
+
+// parseStop 解析 OpenAI 的 stop 参数，支持字符串或字符串数组
+func parseStop(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(raw, &multiple); err != nil {
+		return nil, fmt.Errorf("invalid stop parameter: %w", err)
+	}
+	return multiple, nil
+}
+
+// convertContent 将 OpenAI 消息内容（字符串或内容片段数组）转换为纯文本和 Anthropic 内容块
+func convertContent(raw json.RawMessage) (string, []anthropicBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil, nil
+	}
+
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if text == "" {
+			return "", nil, nil
+		}
+		return text, []anthropicBlock{{Type: "text", Text: text}}, nil
+	}
+
+	var parts []openAIContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return "", nil, fmt.Errorf("invalid message content: %w", err)
+	}
+
+	var texts []string
+	var blocks []anthropicBlock
+	for _, part := range parts {
+		switch part.Type {
+		case "text":
+			texts = append(texts, part.Text)
+			blocks = append(blocks, anthropicBlock{Type: "text", Text: part.Text})
+		case "image_url":
+			if part.ImageURL == nil {
+				continue
+			}
+			blocks = append(blocks, anthropicBlock{Type: "image", Source: imageSource(part.ImageURL.URL)})
+		}
+	}
+	return strings.Join(texts, "\n"), blocks, nil
+}
+
+// imageSource 将 OpenAI image_url 转换为 Anthropic 图片来源，支持 data URL 和普通 URL
+func imageSource(imageURL string) *anthropicSource {
+	if strings.HasPrefix(imageURL, "data:") {
+		header, data, found := strings.Cut(strings.TrimPrefix(imageURL, "data:"), ",")
+		if found {
+			return &anthropicSource{
+				Type:      "base64",
+				MediaType: strings.TrimSuffix(header, ";base64"),
+				Data:      data,
+			}
+		}
+	}
+	return &anthropicSource{Type: "url", URL: imageURL}
+}
+
+// toolUseBlock 将 OpenAI 函数调用转换为 Anthropic tool_use 内容块
+func toolUseBlock(id string, call openAIFunctionCall) anthropicBlock {
+	input := json.RawMessage(call.Arguments)
+	if !json.Valid(input) {
+		input = json.RawMessage(`{}`)
+	}
+	return anthropicBlock{Type: "tool_use", ID: id, Name: call.Name, Input: input}
+}
+
+// convertTool 将 OpenAI 函数定义转换为 Anthropic 工具定义
+func convertTool(fn openAIFunction) anthropicTool {
+	schema := fn.Parameters
+	if len(schema) == 0 || string(schema) == "null" {
+		schema = json.RawMessage(`{"type":"object","properties":{}}`)
+	}
+	return anthropicTool{Name: fn.Name, Description: fn.Description, InputSchema: schema}
+}
+
+// convertToolChoice 转换 tool_choice / function_call，第二个返回值表示是否禁用工具
+func convertToolChoice(raw json.RawMessage) (interface{}, bool) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, false
+	}
+
+	var mode string
+	if err := json.Unmarshal(raw, &mode); err == nil {
+		switch mode {
+		case "none":
+			return nil, true
+		case "required":
+			return map[string]string{"type": "any"}, false
+		default:
+			return map[string]string{"type": "auto"}, false
+		}
+	}
+
+	var named struct {
+		Name     string `json:"name"`
+		Function struct {
+			Name string `json:"name"`
+		} `json:"function"`
+	}
+	if err := json.Unmarshal(raw, &named); err == nil {
+		name := named.Function.Name
+		if name == "" {
+			name = named.Name
+		}
+		if name != "" {
+			return map[string]string{"type": "tool", "name": name}, false
+		}
+	}
+	return map[string]string{"type": "auto"}, false
+}
+
+// appendMessage 追加消息，Anthropic 要求 user/assistant 交替出现，因此合并相邻的同角色消息
+func appendMessage(messages []anthropicMessage, role string, blocks []anthropicBlock) []anthropicMessage {
+	if len(blocks) == 0 {
+		return messages
+	}
+	if n := len(messages); n > 0 && messages[n-1].Role == role {
+		messages[n-1].Content = append(messages[n-1].Content, blocks...)
+		return messages
+	}
+	return append(messages, anthropicMessage{Role: role, Content: blocks})
+}
+
+// AnthropicToOpenAIResponse 将 Anthropic Messages 响应体（包括错误响应）转换为 OpenAI Chat Completions 格式
+// usage 被转换为 prompt_tokens/completion_tokens/total_tokens，供 TokenParser 统一解析
+func AnthropicToOpenAIResponse(body []byte) ([]byte, error) {
+	var resp anthropicResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("invalid anthropic response: %w", err)
+	}
+
+	if resp.Type == "error" && resp.Error != nil {
+		return json.Marshal(map[string]interface{}{
+			"error": map[string]interface{}{
+				"message": resp.Error.Message,
+				"type":    resp.Error.Type,
+				"code":    resp.Error.Type,
+			},
+		})
+	}
+
+	message := map[string]interface{}{"role": "assistant"}
+	var texts []string
+	var toolCalls []openAIToolCall
+	for _, block := range resp.Content {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "tool_use":
+			args := string(block.Input)
+			if args == "" {
+				args = "{}"
+			}
+			toolCalls = append(toolCalls, openAIToolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: block.Name, Arguments: args},
+			})
+		}
+	}
+	if len(texts) > 0 {
+		message["content"] = strings.Join(texts, "")
+	} else {
+		message["content"] = nil
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":      resp.ID,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   resp.Model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       message,
+			"finish_reason": finishReason(resp.StopReason),
+		}},
+		"usage": resp.Usage.toOpenAI(),
+	})
+}
+
+// finishReason 将 Anthropic stop_reason 映射为 OpenAI finish_reason
+func finishReason(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "":
+		return ""
+	default:
+		return "stop"
+	}
+}
@@ -0,0 +1,225 @@
+package proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// decodeJSON 将 JSON 解析为通用结构，便于与期望值整体比较
+func decodeJSON(t *testing.T, data []byte) map[string]interface{} {
+	t.Helper()
+	var out map[string]interface{}
+	if err := json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("invalid JSON %s: %v", data, err)
+	}
+	return out
+}
+
+func TestOpenAIToAnthropicRequestChat(t *testing.T) {
+	body := []byte(`{
+		"model": "claude-sonnet",
+		"messages": [
+			{"role": "system", "content": "You are terse."},
+			{"role": "developer", "content": [{"type": "text", "text": "Answer in English."}]},
+			{"role": "user", "content": "Hi"},
+			{"role": "user", "content": [
+				{"type": "text", "text": "What is this?"},
+				{"type": "image_url", "image_url": {"url": "data:image/png;base64,iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": "A logo."}
+		],
+		"max_completion_tokens": 256,
+		"max_tokens": 100,
+		"top_p": 0.9,
+		"stop": "END",
+		"stream": true,
+		"user": "buyer-1"
+	}`)
+
+	translated, err := OpenAIToAnthropicRequest(body)
+	if err != nil {
+		t.Fatalf("OpenAIToAnthropicRequest() error = %v", err)
+	}
+
+	// system 和 developer 消息合并为顶层 system，相邻的 user 消息合并为一条
+	want := decodeJSON(t, []byte(`{
+		"model": "claude-sonnet",
+		"system": "You are terse.\n\nAnswer in English.",
+		"messages": [
+			{"role": "user", "content": [
+				{"type": "text", "text": "Hi"},
+				{"type": "text", "text": "What is this?"},
+				{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "iVBORw0KGgo="}}
+			]},
+			{"role": "assistant", "content": [{"type": "text", "text": "A logo."}]}
+		],
+		"max_tokens": 256,
+		"top_p": 0.9,
+		"stop_sequences": ["END"],
+		"stream": true,
+		"metadata": {"user_id": "buyer-1"}
+	}`))
+	if got := decodeJSON(t, translated); !reflect.DeepEqual(got, want) {
+		t.Errorf("translated request =\n%s\nwant\n%v", translated, want)
+	}
+}
+
+func TestOpenAIToAnthropicRequestTools(t *testing.T) {
+	body := []byte(`{
+		"model": "claude-sonnet",
+		"messages": [
+			{"role": "user", "content": "Weather in Paris?"},
+			{"role": "assistant", "content": null, "tool_calls": [
+				{"id": "call_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\":\"Paris\"}"}}
+			]},
+			{"role": "tool", "tool_call_id": "call_1", "content": "18C"}
+		],
+		"tools": [
+			{"type": "function", "function": {"name": "get_weather", "description": "Current weather", "parameters": {"type": "object", "properties": {"city": {"type": "string"}}}}},
+			{"type": "function", "function": {"name": "get_time"}}
+		],
+		"tool_choice": "required"
+	}`)
+
+	translated, err := OpenAIToAnthropicRequest(body)
+	if err != nil {
+		t.Fatalf("OpenAIToAnthropicRequest() error = %v", err)
+	}
+
+	want := decodeJSON(t, []byte(`{
+		"model": "claude-sonnet",
+		"messages": [
+			{"role": "user", "content": [{"type": "text", "text": "Weather in Paris?"}]},
+			{"role": "assistant", "content": [{"type": "tool_use", "id": "call_1", "name": "get_weather", "input": {"city": "Paris"}}]},
+			{"role": "user", "content": [{"type": "tool_result", "tool_use_id": "call_1", "content": "18C"}]}
+		],
+		"max_tokens": 4096,
+		"tools": [
+			{"name": "get_weather", "description": "Current weather", "input_schema": {"type": "object", "properties": {"city": {"type": "string"}}}},
+			{"name": "get_time", "input_schema": {"type": "object", "properties": {}}}
+		],
+		"tool_choice": {"type": "any"}
+	}`))
+	if got := decodeJSON(t, translated); !reflect.DeepEqual(got, want) {
+		t.Errorf("translated request =\n%s\nwant\n%v", translated, want)
+	}
+}
+
+func TestOpenAIToAnthropicRequestToolChoice(t *testing.T) {
+	tests := map[string]string{
+		`"auto"`: `{"type":"auto"}`,
+		`{"type":"function","function":{"name":"get_weather"}}`: `{"name":"get_weather","type":"tool"}`,
+	}
+	for choice, want := range tests {
+		body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],
+			"tools":[{"type":"function","function":{"name":"get_weather"}}],"tool_choice":` + choice + `}`)
+		translated, err := OpenAIToAnthropicRequest(body)
+		if err != nil {
+			t.Fatalf("tool_choice %s: error = %v", choice, err)
+		}
+		got, _ := json.Marshal(decodeJSON(t, translated)["tool_choice"])
+		if string(got) != want {
+			t.Errorf("tool_choice %s translated to %s, want %s", choice, got, want)
+		}
+	}
+
+	// "none" 通过不传 tools 实现
+	translated, err := OpenAIToAnthropicRequest([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],
+		"tools":[{"type":"function","function":{"name":"get_weather"}}],"tool_choice":"none"}`))
+	if err != nil {
+		t.Fatalf("tool_choice none: error = %v", err)
+	}
+	if got := decodeJSON(t, translated); got["tools"] != nil || got["tool_choice"] != nil {
+		t.Errorf("tool_choice none translated to %s, want no tools", translated)
+	}
+}
+
+func TestOpenAIToAnthropicRequestClampsTemperature(t *testing.T) {
+	tests := map[string]float64{
+		"0":   0,
+		"0.7": 0.7,
+		"1":   1,
+		"1.6": 1,
+		"2":   1,
+	}
+	for temperature, want := range tests {
+		translated, err := OpenAIToAnthropicRequest([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}],"temperature":` + temperature + `}`))
+		if err != nil {
+			t.Fatalf("temperature %s: error = %v", temperature, err)
+		}
+		if got := decodeJSON(t, translated)["temperature"]; got != want {
+			t.Errorf("temperature %s translated to %v, want %v", temperature, got, want)
+		}
+	}
+
+	translated, _ := OpenAIToAnthropicRequest([]byte(`{"model":"m","messages":[{"role":"user","content":"hi"}]}`))
+	if _, ok := decodeJSON(t, translated)["temperature"]; ok {
+		t.Errorf("temperature set without one in the request: %s", translated)
+	}
+}
+
+func TestOpenAIToAnthropicRequestRejectsUnknownRole(t *testing.T) {
+	if _, err := OpenAIToAnthropicRequest([]byte(`{"model":"m","messages":[{"role":"critic","content":"hi"}]}`)); err == nil {
+		t.Errorf("OpenAIToAnthropicRequest() accepted an unknown role")
+	}
+}
+
+func TestAnthropicToOpenAIResponse(t *testing.T) {
+	body := []byte(`{
+		"id": "msg_1",
+		"type": "message",
+		"model": "claude-sonnet",
+		"content": [
+			{"type": "text", "text": "Checking "},
+			{"type": "text", "text": "the weather."},
+			{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"city": "Paris"}}
+		],
+		"stop_reason": "tool_use",
+		"usage": {"input_tokens": 10, "output_tokens": 5, "cache_creation_input_tokens": 3, "cache_read_input_tokens": 20}
+	}`)
+
+	translated, err := AnthropicToOpenAIResponse(body)
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAIResponse() error = %v", err)
+	}
+	got := decodeJSON(t, translated)
+	delete(got, "created")
+
+	// prompt_tokens 包含缓存读写的 token，缓存命中的 token 记在 cached_tokens 中
+	want := decodeJSON(t, []byte(`{
+		"id": "msg_1",
+		"object": "chat.completion",
+		"model": "claude-sonnet",
+		"choices": [{
+			"index": 0,
+			"message": {
+				"role": "assistant",
+				"content": "Checking the weather.",
+				"tool_calls": [{"id": "toolu_1", "type": "function", "function": {"name": "get_weather", "arguments": "{\"city\": \"Paris\"}"}}]
+			},
+			"finish_reason": "tool_calls"
+		}],
+		"usage": {
+			"prompt_tokens": 33,
+			"completion_tokens": 5,
+			"total_tokens": 38,
+			"prompt_tokens_details": {"cached_tokens": 20},
+			"cache_creation_input_tokens": 3
+		}
+	}`))
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("translated response =\n%s\nwant\n%v", translated, want)
+	}
+}
+
+func TestAnthropicToOpenAIResponseError(t *testing.T) {
+	translated, err := AnthropicToOpenAIResponse([]byte(`{"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`))
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAIResponse() error = %v", err)
+	}
+	want := decodeJSON(t, []byte(`{"error":{"message":"Overloaded","type":"overloaded_error","code":"overloaded_error"}}`))
+	if got := decodeJSON(t, translated); !reflect.DeepEqual(got, want) {
+		t.Errorf("translated error = %s, want %v", translated, want)
+	}
+}
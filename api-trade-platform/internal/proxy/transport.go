@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 上游传输层的默认配置
+const (
+	defaultConnectTimeout        = 10 * time.Second
+	defaultResponseHeaderTimeout = 10 * time.Minute
+	defaultIdleConnTimeout       = 90 * time.Second
+	defaultMaxIdleConnsPerHost   = 64
+	defaultTLSHandshakeTimeout   = 10 * time.Second
+	tcpKeepAlive                 = 30 * time.Second
+)
+
+// TransportConfig 上游连接的超时和连接池配置，零值字段使用默认值
+type TransportConfig struct {
+	ConnectTimeout        time.Duration // 建立 TCP 连接的超时时间
+	ResponseHeaderTimeout time.Duration // 发送请求后等待响应头的超时时间（不限制流式响应体的传输时间）
+	IdleConnTimeout       time.Duration // keep-alive 空闲连接在连接池中保留的时间
+	MaxIdleConnsPerHost   int           // 每个上游主机保留的最大空闲连接数
+}
+
+// withDefaults 用 defaults 填充未设置的字段
+func (tc TransportConfig) withDefaults(defaults TransportConfig) TransportConfig {
+	if tc.ConnectTimeout <= 0 {
+		tc.ConnectTimeout = defaults.ConnectTimeout
+	}
+	if tc.ResponseHeaderTimeout <= 0 {
+		tc.ResponseHeaderTimeout = defaults.ResponseHeaderTimeout
+	}
+	if tc.IdleConnTimeout <= 0 {
+		tc.IdleConnTimeout = defaults.IdleConnTimeout
+	}
+	if tc.MaxIdleConnsPerHost <= 0 {
+		tc.MaxIdleConnsPerHost = defaults.MaxIdleConnsPerHost
+	}
+	return tc
+}
+
+// TransportPool 按配置复用上游 HTTP 客户端
+// 配置相同的服务共享同一个 http.Transport 及其 keep-alive 连接池，避免每个请求重新建立连接。
+// 客户端不设置整体超时：流式响应可能持续很久，请求的取消由调用方传入的 context 控制。
+type TransportPool struct {
+	defaults TransportConfig
+
+	mu      sync.Mutex
+	clients map[TransportConfig]*http.Client
+}
+
+// NewTransportPool 创建上游传输池，defaults 中未设置的字段使用内置默认值
+func NewTransportPool(defaults TransportConfig) *TransportPool {
+	return &TransportPool{
+		defaults: defaults.withDefaults(TransportConfig{
+			ConnectTimeout:        defaultConnectTimeout,
+			ResponseHeaderTimeout: defaultResponseHeaderTimeout,
+			IdleConnTimeout:       defaultIdleConnTimeout,
+			MaxIdleConnsPerHost:   defaultMaxIdleConnsPerHost,
+		}),
+		clients: make(map[TransportConfig]*http.Client),
+	}
+}
+
+// Client 获取指定配置对应的共享客户端
+func (p *TransportPool) Client(config TransportConfig) *http.Client {
+	config = config.withDefaults(p.defaults)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	client, ok := p.clients[config]
+	if !ok {
+		client = &http.Client{Transport: newTransport(config)}
+		p.clients[config] = client
+	}
+	return client
+}
+
+// CloseIdleConnections 关闭所有连接池中的空闲连接
+func (p *TransportPool) CloseIdleConnections() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, client := range p.clients {
+		client.CloseIdleConnections()
+	}
+}
+
+// newTransport 按配置创建上游 http.Transport
+func newTransport(config TransportConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   config.ConnectTimeout,
+		KeepAlive: tcpKeepAlive,
+	}
+
+	return &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          0, // 不限制总空闲连接数，由每个主机的上限控制
+		MaxIdleConnsPerHost:   config.MaxIdleConnsPerHost,
+		IdleConnTimeout:       config.IdleConnTimeout,
+		TLSHandshakeTimeout:   defaultTLSHandshakeTimeout,
+		ResponseHeaderTimeout: config.ResponseHeaderTimeout,
+		ExpectContinueTimeout: time.Second,
+	}
+}
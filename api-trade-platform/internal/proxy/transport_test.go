@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchmarkBurstSize 每轮同时发出的请求数，模拟一批买家同时调用同一个上游
+const benchmarkBurstSize = 32
+
+// benchmarkUpstreamLatency 模拟上游的处理时间，使同一轮的请求同时占用多个连接
+const benchmarkUpstreamLatency = time.Millisecond
+
+// newBenchmarkUpstream 启动模拟上游，并统计上游收到的新连接数
+func newBenchmarkUpstream(b *testing.B) (*httptest.Server, *int64) {
+	b.Helper()
+	var newConns int64
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(benchmarkUpstreamLatency)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt64(&newConns, 1)
+		}
+	}
+	server.Start()
+	b.Cleanup(server.Close)
+	return server, &newConns
+}
+
+// runBurstBenchmark 每次迭代并发发出一轮请求并等待全部完成，报告每个请求新建的上游连接数
+func runBurstBenchmark(b *testing.B, newClient func() *http.Client) {
+	server, newConns := newBenchmarkUpstream(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var wg sync.WaitGroup
+		for j := 0; j < benchmarkBurstSize; j++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				resp, err := newClient().Get(server.URL)
+				if err != nil {
+					b.Error(err)
+					return
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}()
+		}
+		wg.Wait()
+	}
+	b.StopTimer()
+
+	requests := float64(b.N * benchmarkBurstSize)
+	b.ReportMetric(float64(atomic.LoadInt64(newConns))/requests, "conns/req")
+	b.ReportMetric(requests/b.Elapsed().Seconds(), "req/s")
+}
+
+// BenchmarkClientPerRequest 复现旧的做法：每个请求创建一个新的 http.Client
+// 新客户端使用默认 Transport，每个主机只保留 2 个空闲连接，每轮突发请求的大部分连接都要重新建立
+func BenchmarkClientPerRequest(b *testing.B) {
+	defer http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+
+	runBurstBenchmark(b, func() *http.Client {
+		return &http.Client{Timeout: 10 * time.Minute}
+	})
+}
+
+// BenchmarkTransportPool 使用 TransportPool 的共享客户端，keep-alive 连接池可以容纳整轮突发请求
+func BenchmarkTransportPool(b *testing.B) {
+	pool := NewTransportPool(TransportConfig{})
+	defer pool.CloseIdleConnections()
+
+	runBurstBenchmark(b, func() *http.Client {
+		return pool.Client(TransportConfig{})
+	})
+}
+
+func TestTransportPoolSharesClientsPerConfig(t *testing.T) {
+	pool := NewTransportPool(TransportConfig{ConnectTimeout: 3 * time.Second})
+
+	defaultClient := pool.Client(TransportConfig{})
+	if pool.Client(TransportConfig{ConnectTimeout: 3 * time.Second}) != defaultClient {
+		t.Error("explicit default config should reuse the default client")
+	}
+
+	custom := pool.Client(TransportConfig{ResponseHeaderTimeout: 30 * time.Second})
+	if custom == defaultClient {
+		t.Error("different timeouts should get a separate client")
+	}
+	if custom.Timeout != 0 {
+		t.Errorf("client timeout = %v, want none so streams are bounded by the request context", custom.Timeout)
+	}
+
+	transport := custom.Transport.(*http.Transport)
+	if transport.ResponseHeaderTimeout != 30*time.Second {
+		t.Errorf("ResponseHeaderTimeout = %v, want 30s", transport.ResponseHeaderTimeout)
+	}
+	if transport.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d, want %d", transport.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	}
+}
@@ -0,0 +1,241 @@
+package redis
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// CircuitState 熔断器状态
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"    // 正常放行
+	CircuitOpen     CircuitState = "open"      // 熔断中，直接拒绝请求
+	CircuitHalfOpen CircuitState = "half_open" // 冷却结束，放行少量探测请求
+)
+
+// 熔断器键格式：服务ID + 上游ID（使用服务原始端点时上游ID为0）
+const CircuitBreakerKeyUpstream = "circuit_breaker:service:%d:upstream:%d"
+
+// circuitStateTTL 熔断器状态的过期时间，每次写入时刷新，避免长期不用的上游残留状态
+const circuitStateTTL = time.Hour
+
+// CircuitBreakerConfig 熔断器配置
+type CircuitBreakerConfig struct {
+	FailureThreshold    int64         // 连续失败多少次后熔断
+	Cooldown            time.Duration // 熔断持续时间，结束后进入半开状态
+	HalfOpenMaxRequests int64         // 半开状态下同时放行的探测请求数，全部成功后恢复
+}
+
+// CircuitBreaker 基于 Redis 的熔断器，所有平台实例共享同一份状态
+// 状态保存在哈希中：state、failures（连续失败次数）、opened_at（进入当前状态的毫秒时间戳）、
+// inflight（半开状态下已放行的探测请求数）、successes（半开状态下探测成功次数）。
+type CircuitBreaker struct {
+	redisClient *RedisClient
+	config      CircuitBreakerConfig
+}
+
+// NewCircuitBreaker 创建熔断器实例
+func NewCircuitBreaker(redisClient *RedisClient, config CircuitBreakerConfig) *CircuitBreaker {
+	if config.HalfOpenMaxRequests <= 0 {
+		config.HalfOpenMaxRequests = 1
+	}
+	return &CircuitBreaker{
+		redisClient: redisClient,
+		config:      config,
+	}
+}
+
+// UpstreamCircuitKey 获取服务上游对应的熔断器键
+func UpstreamCircuitKey(serviceID, upstreamID int64) string {
+	return fmt.Sprintf(CircuitBreakerKeyUpstream, serviceID, upstreamID)
+}
+
+// Allow 判断是否放行一次请求
+// 返回值：(是否放行, 被拒绝时建议的重试等待时间, 错误)
+// 半开状态下放行的请求占用一个探测名额，调用方最终必须调用 RecordSuccess、RecordFailure 或 Release 之一。
+func (cb *CircuitBreaker) Allow(key string) (bool, time.Duration, error) {
+	return cb.allowAt(key, time.Now())
+}
+
+// allowAt 在 now 时刻判断是否放行一次请求
+func (cb *CircuitBreaker) allowAt(key string, now time.Time) (bool, time.Duration, error) {
+	// 熔断冷却结束后转为半开状态并放行有限的探测请求；
+	// 半开状态下探测请求迟迟没有结果（例如实例退出）时，再经过一个冷却期重新放行探测
+	luaScript := `
+		local key = KEYS[1]
+		local now = tonumber(ARGV[1])
+		local cooldown = tonumber(ARGV[2])
+		local half_open_max = tonumber(ARGV[3])
+		local ttl = tonumber(ARGV[4])
+
+		local state = redis.call('HGET', key, 'state')
+		if not state or state == 'closed' then
+			return {1, 0}
+		end
+
+		local opened_at = tonumber(redis.call('HGET', key, 'opened_at') or '0')
+		local elapsed = now - opened_at
+
+		if state == 'open' then
+			if elapsed < cooldown then
+				return {0, cooldown - elapsed}
+			end
+			redis.call('HSET', key, 'state', 'half_open', 'opened_at', now, 'inflight', 1, 'successes', 0)
+			redis.call('PEXPIRE', key, ttl)
+			return {1, 0}
+		end
+
+		local inflight = tonumber(redis.call('HGET', key, 'inflight') or '0')
+		if inflight < half_open_max then
+			redis.call('HINCRBY', key, 'inflight', 1)
+			return {1, 0}
+		end
+		if elapsed >= cooldown then
+			redis.call('HSET', key, 'opened_at', now, 'inflight', 1, 'successes', 0)
+			redis.call('PEXPIRE', key, ttl)
+			return {1, 0}
+		end
+		return {0, cooldown - elapsed}
+	`
+
+	result, err := cb.redisClient.client.Eval(
+		cb.redisClient.ctx,
+		luaScript,
+		[]string{key},
+		now.UnixMilli(),
+		cb.config.Cooldown.Milliseconds(),
+		cb.config.HalfOpenMaxRequests,
+		circuitStateTTL.Milliseconds(),
+	).Result()
+	if err != nil {
+		return true, 0, fmt.Errorf("circuit breaker check failed: %w", err)
+	}
+
+	resultSlice, ok := result.([]interface{})
+	if !ok || len(resultSlice) != 2 {
+		return true, 0, fmt.Errorf("unexpected circuit breaker result format")
+	}
+
+	allowed := resultSlice[0].(int64) == 1
+	retryAfter := time.Duration(resultSlice[1].(int64)) * time.Millisecond
+
+	return allowed, retryAfter, nil
+}
+
+// RecordSuccess 记录一次成功的请求
+// 关闭状态下清零连续失败次数；半开状态下探测全部成功后恢复为关闭状态
+func (cb *CircuitBreaker) RecordSuccess(key string) error {
+	luaScript := `
+		local key = KEYS[1]
+		local half_open_max = tonumber(ARGV[1])
+
+		local state = redis.call('HGET', key, 'state')
+		if state == 'half_open' then
+			local successes = redis.call('HINCRBY', key, 'successes', 1)
+			if successes >= half_open_max then
+				redis.call('DEL', key)
+			end
+		elseif state == 'closed' then
+			redis.call('DEL', key)
+		end
+		return 1
+	`
+
+	if err := cb.redisClient.client.Eval(
+		cb.redisClient.ctx,
+		luaScript,
+		[]string{key},
+		cb.config.HalfOpenMaxRequests,
+	).Err(); err != nil {
+		return fmt.Errorf("failed to record circuit breaker success: %w", err)
+	}
+	return nil
+}
+
+// Release 归还一次被放行但没有发往上游的请求（例如被限流或余额不足）占用的探测名额，不计入成功或失败
+func (cb *CircuitBreaker) Release(key string) error {
+	luaScript := `
+		local key = KEYS[1]
+
+		if redis.call('HGET', key, 'state') ~= 'half_open' then
+			return 0
+		end
+		local inflight = tonumber(redis.call('HGET', key, 'inflight') or '0')
+		if inflight > 0 then
+			redis.call('HINCRBY', key, 'inflight', -1)
+		end
+		return 1
+	`
+
+	if err := cb.redisClient.client.Eval(cb.redisClient.ctx, luaScript, []string{key}).Err(); err != nil {
+		return fmt.Errorf("failed to release circuit breaker probe: %w", err)
+	}
+	return nil
+}
+
+// RecordFailure 记录一次失败的请求
+// 关闭状态下连续失败达到阈值时熔断；半开状态下任一探测失败立即重新熔断
+func (cb *CircuitBreaker) RecordFailure(key string) error {
+	return cb.recordFailureAt(key, time.Now())
+}
+
+// recordFailureAt 在 now 时刻记录一次失败的请求
+func (cb *CircuitBreaker) recordFailureAt(key string, now time.Time) error {
+	luaScript := `
+		local key = KEYS[1]
+		local now = tonumber(ARGV[1])
+		local threshold = tonumber(ARGV[2])
+		local ttl = tonumber(ARGV[3])
+
+		local state = redis.call('HGET', key, 'state')
+		if state == 'open' then
+			return 0
+		end
+		if state == 'half_open' then
+			redis.call('HSET', key, 'state', 'open', 'opened_at', now, 'inflight', 0, 'successes', 0)
+			redis.call('PEXPIRE', key, ttl)
+			return 1
+		end
+
+		local failures = redis.call('HINCRBY', key, 'failures', 1)
+		if failures >= threshold then
+			redis.call('HSET', key, 'state', 'open', 'opened_at', now)
+		else
+			redis.call('HSET', key, 'state', 'closed')
+		end
+		redis.call('PEXPIRE', key, ttl)
+		return 1
+	`
+
+	if err := cb.redisClient.client.Eval(
+		cb.redisClient.ctx,
+		luaScript,
+		[]string{key},
+		now.UnixMilli(),
+		cb.config.FailureThreshold,
+		circuitStateTTL.Milliseconds(),
+	).Err(); err != nil {
+		return fmt.Errorf("failed to record circuit breaker failure: %w", err)
+	}
+	return nil
+}
+
+// GetState 获取熔断器当前状态（不会触发状态转换）
+func (cb *CircuitBreaker) GetState(key string) (CircuitState, error) {
+	state, err := cb.redisClient.HGet(key, "state")
+	if err != nil {
+		if err == redis.Nil {
+			return CircuitClosed, nil
+		}
+		return CircuitClosed, fmt.Errorf("failed to get circuit breaker state: %w", err)
+	}
+	return CircuitState(state), nil
+}
+
+// Reset 重置熔断器为关闭状态
+func (cb *CircuitBreaker) Reset(key string) error {
+	return cb.redisClient.Del(key)
+}
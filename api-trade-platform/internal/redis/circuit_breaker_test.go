@@ -0,0 +1,143 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestCircuitBreaker 创建连接到进程内 miniredis 的熔断器
+func newTestCircuitBreaker(t *testing.T, config CircuitBreakerConfig) *CircuitBreaker {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewCircuitBreaker(&RedisClient{client: client, ctx: context.Background()}, config)
+}
+
+// expectAllow 在 at 时刻检查一次请求并核对是否放行
+func expectAllow(t *testing.T, cb *CircuitBreaker, key string, at time.Time, allowed bool, retryAfter time.Duration) {
+	t.Helper()
+	gotAllowed, gotRetryAfter, err := cb.allowAt(key, at)
+	if err != nil {
+		t.Fatalf("allowAt() error = %v", err)
+	}
+	if gotAllowed != allowed || gotRetryAfter != retryAfter {
+		t.Fatalf("allowAt(+%s) = (%v, %s), want (%v, %s)", at.Sub(testEpoch), gotAllowed, gotRetryAfter, allowed, retryAfter)
+	}
+}
+
+// expectState 核对熔断器当前状态
+func expectState(t *testing.T, cb *CircuitBreaker, key string, want CircuitState) {
+	t.Helper()
+	state, err := cb.GetState(key)
+	if err != nil {
+		t.Fatalf("GetState() error = %v", err)
+	}
+	if state != want {
+		t.Fatalf("state = %s, want %s", state, want)
+	}
+}
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	cb := newTestCircuitBreaker(t, CircuitBreakerConfig{FailureThreshold: 3, Cooldown: 10 * time.Second})
+	key := UpstreamCircuitKey(1, 2)
+
+	expectAllow(t, cb, key, testEpoch, true, 0)
+	cb.recordFailureAt(key, testEpoch)
+	cb.recordFailureAt(key, testEpoch)
+	expectState(t, cb, key, CircuitClosed)
+
+	// 成功清零连续失败次数
+	cb.RecordSuccess(key)
+	cb.recordFailureAt(key, testEpoch)
+	cb.recordFailureAt(key, testEpoch)
+	expectAllow(t, cb, key, testEpoch, true, 0)
+
+	cb.recordFailureAt(key, testEpoch)
+	expectState(t, cb, key, CircuitOpen)
+	expectAllow(t, cb, key, testEpoch.Add(4*time.Second), false, 6*time.Second)
+}
+
+func TestCircuitBreakerHalfOpenProbeSuccessCloses(t *testing.T) {
+	cb := newTestCircuitBreaker(t, CircuitBreakerConfig{FailureThreshold: 1, Cooldown: 10 * time.Second})
+	key := UpstreamCircuitKey(1, 2)
+
+	cb.recordFailureAt(key, testEpoch)
+	expectState(t, cb, key, CircuitOpen)
+
+	// 冷却结束后只放行一个探测请求
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), true, 0)
+	expectState(t, cb, key, CircuitHalfOpen)
+	expectAllow(t, cb, key, testEpoch.Add(11*time.Second), false, 9*time.Second)
+
+	cb.RecordSuccess(key)
+	expectState(t, cb, key, CircuitClosed)
+	expectAllow(t, cb, key, testEpoch.Add(11*time.Second), true, 0)
+}
+
+func TestCircuitBreakerHalfOpenProbeFailureReopens(t *testing.T) {
+	cb := newTestCircuitBreaker(t, CircuitBreakerConfig{FailureThreshold: 1, Cooldown: 10 * time.Second})
+	key := UpstreamCircuitKey(1, 2)
+
+	cb.recordFailureAt(key, testEpoch)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), true, 0)
+
+	// 探测失败立即重新熔断，并重新开始冷却
+	cb.recordFailureAt(key, testEpoch.Add(12*time.Second))
+	expectState(t, cb, key, CircuitOpen)
+	expectAllow(t, cb, key, testEpoch.Add(15*time.Second), false, 7*time.Second)
+	expectAllow(t, cb, key, testEpoch.Add(22*time.Second), true, 0)
+	expectState(t, cb, key, CircuitHalfOpen)
+}
+
+func TestCircuitBreakerHalfOpenNeedsEveryProbeToSucceed(t *testing.T) {
+	cb := newTestCircuitBreaker(t, CircuitBreakerConfig{FailureThreshold: 1, Cooldown: 10 * time.Second, HalfOpenMaxRequests: 2})
+	key := UpstreamCircuitKey(1, 2)
+
+	cb.recordFailureAt(key, testEpoch)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), true, 0)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), true, 0)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), false, 10*time.Second)
+
+	cb.RecordSuccess(key)
+	expectState(t, cb, key, CircuitHalfOpen)
+	cb.RecordSuccess(key)
+	expectState(t, cb, key, CircuitClosed)
+}
+
+func TestCircuitBreakerReleaseReturnsProbeSlot(t *testing.T) {
+	cb := newTestCircuitBreaker(t, CircuitBreakerConfig{FailureThreshold: 1, Cooldown: 10 * time.Second})
+	key := UpstreamCircuitKey(1, 2)
+
+	cb.recordFailureAt(key, testEpoch)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), true, 0)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), false, 10*time.Second)
+
+	// 探测请求没有发往上游时归还名额，下一个请求可以继续探测，状态保持半开
+	if err := cb.Release(key); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	expectState(t, cb, key, CircuitHalfOpen)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), true, 0)
+
+	// 关闭状态下归还名额不改变状态
+	cb.RecordSuccess(key)
+	cb.Release(key)
+	expectState(t, cb, key, CircuitClosed)
+}
+
+func TestCircuitBreakerStaleProbeIsRetried(t *testing.T) {
+	cb := newTestCircuitBreaker(t, CircuitBreakerConfig{FailureThreshold: 1, Cooldown: 10 * time.Second})
+	key := UpstreamCircuitKey(1, 2)
+
+	cb.recordFailureAt(key, testEpoch)
+	expectAllow(t, cb, key, testEpoch.Add(10*time.Second), true, 0)
+
+	// 探测请求迟迟没有结果（例如实例退出）时，再经过一个冷却期重新放行探测
+	expectAllow(t, cb, key, testEpoch.Add(19*time.Second), false, 1*time.Second)
+	expectAllow(t, cb, key, testEpoch.Add(20*time.Second), true, 0)
+}
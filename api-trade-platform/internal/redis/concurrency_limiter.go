@@ -0,0 +1,160 @@
+package redis
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 并发限制键格式，有序集合中每个成员是一个租约，分数为租约过期时间(毫秒)
+const (
+	ConcurrencyKeyService    = "concurrency:service:%d"       // 服务所有买家合计
+	ConcurrencyKeySubscriber = "concurrency:subscriber:%d:%d" // 单个买家调用特定服务
+	ConcurrencyKeyAPIKey     = "concurrency:api_key:%d"       // 单个平台密钥
+)
+
+// ConcurrencyLimit 一个同时进行中请求数的上限
+type ConcurrencyLimit struct {
+	Key   string
+	Limit int64
+}
+
+// ConcurrencyLease 一次请求在若干并发限制中占用的名额
+// 请求进行期间在后台定期续期，实例退出等原因未释放的租约在过期后自动失效。
+type ConcurrencyLease struct {
+	Keys []string
+	ID   string
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// ConcurrencyLimiter 基于 Redis 租约的分布式并发限制，所有平台实例共享同一份计数
+type ConcurrencyLimiter struct {
+	redisClient *RedisClient
+	leaseTTL    time.Duration
+}
+
+// NewConcurrencyLimiter 创建并发限制实例，leaseTTL 为租约未续期时的存活时间
+func NewConcurrencyLimiter(redisClient *RedisClient, leaseTTL time.Duration) *ConcurrencyLimiter {
+	if leaseTTL <= 0 {
+		leaseTTL = time.Minute
+	}
+	return &ConcurrencyLimiter{
+		redisClient: redisClient,
+		leaseTTL:    leaseTTL,
+	}
+}
+
+// leaseSeq 与时间戳一起组成租约ID，避免同一时刻的请求互相覆盖
+var leaseSeq uint64
+
+// acquireLeaseScript 清理过期租约后，所有限制都有空余名额时才同时占用；
+// 返回 {1, 0} 表示成功，{0, i} 表示第 i 个限制（从 1 开始）已满
+var acquireLeaseScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	local ttl = tonumber(ARGV[2])
+	for i, key in ipairs(KEYS) do
+		redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+		if redis.call('ZCARD', key) >= tonumber(ARGV[3 + i]) then
+			return {0, i}
+		end
+	end
+	for _, key in ipairs(KEYS) do
+		redis.call('ZADD', key, now + ttl, ARGV[3])
+		redis.call('PEXPIRE', key, ttl)
+	end
+	return {1, 0}
+`)
+
+// renewLeaseScript 延长仍然存在的租约，已过期被清理的租约不会重新加入
+var renewLeaseScript = redis.NewScript(`
+	local expires = tonumber(ARGV[1]) + tonumber(ARGV[2])
+	for _, key in ipairs(KEYS) do
+		if redis.call('ZSCORE', key, ARGV[3]) then
+			redis.call('ZADD', key, expires, ARGV[3])
+			redis.call('PEXPIRE', key, ARGV[2])
+		end
+	end
+	return 1
+`)
+
+// Acquire 尝试在所有限制中占用一个名额，成功时租约开始在后台续期，直到调用 Release
+// 名额不足时返回 nil 租约以及已满的限制在 limits 中的下标。
+func (cl *ConcurrencyLimiter) Acquire(limits []ConcurrencyLimit) (*ConcurrencyLease, int, error) {
+	if len(limits) == 0 {
+		return nil, -1, nil
+	}
+
+	id := fmt.Sprintf("%d-%d", time.Now().UnixNano(), atomic.AddUint64(&leaseSeq, 1))
+	keys := make([]string, len(limits))
+	args := []interface{}{time.Now().UnixMilli(), cl.leaseTTL.Milliseconds(), id}
+	for i, limit := range limits {
+		keys[i] = limit.Key
+		args = append(args, limit.Limit)
+	}
+
+	result, err := acquireLeaseScript.Run(cl.redisClient.ctx, cl.redisClient.client, keys, args...).Result()
+	if err != nil {
+		return nil, -1, fmt.Errorf("concurrency limit check failed: %w", err)
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return nil, -1, fmt.Errorf("unexpected concurrency limit result format")
+	}
+	if acquired, _ := values[0].(int64); acquired != 1 {
+		blocked, _ := values[1].(int64)
+		return nil, int(blocked) - 1, nil
+	}
+
+	lease := &ConcurrencyLease{Keys: keys, ID: id, stop: make(chan struct{})}
+	go cl.keepAlive(lease)
+	return lease, -1, nil
+}
+
+// keepAlive 每隔三分之一个租约时长续期一次，直到租约被释放
+func (cl *ConcurrencyLimiter) keepAlive(lease *ConcurrencyLease) {
+	ticker := time.NewTicker(cl.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lease.stop:
+			return
+		case <-ticker.C:
+		}
+		err := renewLeaseScript.Run(cl.redisClient.ctx, cl.redisClient.client, lease.Keys,
+			time.Now().UnixMilli(), cl.leaseTTL.Milliseconds(), lease.ID).Err()
+		if err != nil {
+			fmt.Printf("Failed to renew concurrency lease %s: %v\n", lease.ID, err)
+		}
+	}
+}
+
+// Release 释放租约占用的名额，重复释放不会出错
+func (cl *ConcurrencyLimiter) Release(lease *ConcurrencyLease) error {
+	if lease == nil {
+		return nil
+	}
+
+	released := false
+	lease.stopOnce.Do(func() {
+		close(lease.stop)
+		released = true
+	})
+	if !released {
+		return nil
+	}
+
+	pipe := cl.redisClient.client.Pipeline()
+	for _, key := range lease.Keys {
+		pipe.ZRem(cl.redisClient.ctx, key, lease.ID)
+	}
+	if _, err := pipe.Exec(cl.redisClient.ctx); err != nil {
+		return fmt.Errorf("failed to release concurrency lease: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// rateLimiterDegraded 通过 expvar 发布的降级状态，1 表示正在使用进程内限流
+var rateLimiterDegraded = expvar.NewInt("rate_limiter_degraded")
+
+// FallbackRateLimiter 优先使用基于 Redis 的限流，Redis 出错时自动降级为进程内限流，
+// 后台探测到 Redis 恢复后再切换回 Redis。降级期间各实例独立计数，按较保守的限制执行。
+type FallbackRateLimiter struct {
+	primary       *RateLimiter // 为 nil 表示未配置 Redis，始终使用进程内限流
+	redisClient   *RedisClient
+	local         *LocalRateLimiter
+	probeInterval time.Duration
+	degraded      atomic.Bool
+}
+
+// NewFallbackRateLimiter 创建可降级的限流器，redisClient 为 nil 时只使用进程内限流
+// startDegraded 为 true 表示启动时 Redis 不可用，在探测到 Redis 恢复前使用进程内限流。
+func NewFallbackRateLimiter(redisClient *RedisClient, local *LocalRateLimiter, probeInterval time.Duration, startDegraded bool) *FallbackRateLimiter {
+	f := &FallbackRateLimiter{
+		redisClient:   redisClient,
+		local:         local,
+		probeInterval: probeInterval,
+	}
+	if redisClient != nil {
+		f.primary = NewRateLimiter(redisClient)
+	}
+	if f.primary == nil || startDegraded {
+		f.degraded.Store(true)
+		rateLimiterDegraded.Set(1)
+	}
+	return f
+}
+
+// Degraded 判断当前是否在使用进程内限流
+func (f *FallbackRateLimiter) Degraded() bool {
+	return f.degraded.Load()
+}
+
+// markDegraded Redis 出错时切换到进程内限流
+func (f *FallbackRateLimiter) markDegraded(err error) {
+	if f.degraded.CompareAndSwap(false, true) {
+		rateLimiterDegraded.Set(1)
+		fmt.Printf("Redis rate limiter unavailable, falling back to in-process limits: %v\n", err)
+	}
+}
+
+// Run 定期探测 Redis，降级期间 Redis 恢复后切换回 Redis 限流，ctx 取消时退出
+func (f *FallbackRateLimiter) Run(ctx context.Context) {
+	if f.primary == nil || f.probeInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(f.probeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !f.degraded.Load() {
+			continue
+		}
+		if err := f.redisClient.Ping(); err != nil {
+			continue
+		}
+		if f.degraded.CompareAndSwap(true, false) {
+			rateLimiterDegraded.Set(0)
+			fmt.Printf("Redis rate limiter recovered, switching back from in-process limits\n")
+		}
+	}
+}
+
+// Allow 检查并记录一次请求
+func (f *FallbackRateLimiter) Allow(key string, config RateLimitConfig) (*RateLimitResult, error) {
+	if !f.degraded.Load() {
+		result, err := f.primary.Allow(key, config)
+		if err == nil {
+			return result, nil
+		}
+		f.markDegraded(err)
+	}
+	return f.local.Allow(key, config)
+}
+
+// AllowAll 检查一次请求涉及的所有限流，全部放行时才记录请求
+func (f *FallbackRateLimiter) AllowAll(checks []RateLimitCheck) (*RateLimitResult, error) {
+	if !f.degraded.Load() {
+		result, err := f.primary.AllowAll(checks)
+		if err == nil {
+			return result, nil
+		}
+		f.markDegraded(err)
+	}
+	return f.local.AllowAll(checks)
+}
+
+// check 执行一次 Redis 限流检查，出错时降级并改用进程内限流
+func (f *FallbackRateLimiter) check(primary, local func() (bool, int64, error)) (bool, int64, error) {
+	if !f.degraded.Load() {
+		allowed, remaining, err := primary()
+		if err == nil {
+			return allowed, remaining, nil
+		}
+		f.markDegraded(err)
+	}
+	return local()
+}
+
+// CheckUserRateLimit 检查用户限流
+func (f *FallbackRateLimiter) CheckUserRateLimit(userID int64, config RateLimitConfig) (bool, int64, error) {
+	return f.check(
+		func() (bool, int64, error) { return f.primary.CheckUserRateLimit(userID, config) },
+		func() (bool, int64, error) { return f.local.CheckUserRateLimit(userID, config) })
+}
+
+// CheckAPIKeyRateLimit 检查API密钥限流
+func (f *FallbackRateLimiter) CheckAPIKeyRateLimit(apiKey string, config RateLimitConfig) (bool, int64, error) {
+	return f.check(
+		func() (bool, int64, error) { return f.primary.CheckAPIKeyRateLimit(apiKey, config) },
+		func() (bool, int64, error) { return f.local.CheckAPIKeyRateLimit(apiKey, config) })
+}
+
+// CheckServiceRateLimit 检查服务限流
+func (f *FallbackRateLimiter) CheckServiceRateLimit(serviceID int64, config RateLimitConfig) (bool, int64, error) {
+	return f.check(
+		func() (bool, int64, error) { return f.primary.CheckServiceRateLimit(serviceID, config) },
+		func() (bool, int64, error) { return f.local.CheckServiceRateLimit(serviceID, config) })
+}
+
+// CheckIPRateLimit 检查IP限流
+func (f *FallbackRateLimiter) CheckIPRateLimit(ip string, config RateLimitConfig) (bool, int64, error) {
+	return f.check(
+		func() (bool, int64, error) { return f.primary.CheckIPRateLimit(ip, config) },
+		func() (bool, int64, error) { return f.local.CheckIPRateLimit(ip, config) })
+}
+
+// CheckUserAPIRateLimit 检查用户对特定API的限流
+func (f *FallbackRateLimiter) CheckUserAPIRateLimit(userID, serviceID int64, config RateLimitConfig) (bool, int64, error) {
+	return f.check(
+		func() (bool, int64, error) { return f.primary.CheckUserAPIRateLimit(userID, serviceID, config) },
+		func() (bool, int64, error) { return f.local.CheckUserAPIRateLimit(userID, serviceID, config) })
+}
+
+// ReserveTokens 在所有预算中预留 tokens 个 token，Redis 出错时在进程内预留
+func (f *FallbackRateLimiter) ReserveTokens(limits []TokenLimit, tokens int64, window time.Duration) (*TokenReservation, *RateLimitResult, error) {
+	if !f.degraded.Load() {
+		reservation, result, err := f.primary.ReserveTokens(limits, tokens, window)
+		if err == nil {
+			return reservation, result, nil
+		}
+		f.markDegraded(err)
+	}
+	return f.local.ReserveTokens(limits, tokens, window)
+}
+
+// ReconcileTokens 在预留所在的限流器中修正预留的 token
+// 降级前在 Redis 中的预留在降级期间无法修正，随窗口过期自动释放。
+func (f *FallbackRateLimiter) ReconcileTokens(reservation *TokenReservation, actualTokens int64) error {
+	if reservation == nil {
+		return nil
+	}
+	if reservation.local {
+		return f.local.ReconcileTokens(reservation, actualTokens)
+	}
+	if f.degraded.Load() {
+		return nil
+	}
+	if err := f.primary.ReconcileTokens(reservation, actualTokens); err != nil {
+		f.markDegraded(err)
+		return err
+	}
+	return nil
+}
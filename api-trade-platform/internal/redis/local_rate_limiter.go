@@ -0,0 +1,239 @@
+package redis
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// localSweepInterval 进程内限流器清理已完全恢复配额的键的间隔
+const localSweepInterval = time.Minute
+
+// LocalRateLimiter 进程内的 GCRA（通用信元速率算法）限流器，在 Redis 不可用时作为降级方案
+// 每个键只保存一个理论到达时间（TAT），配额按 Window/Limit 的速率匀速恢复，允许最多 Burst（默认为 Limit）的突发，与 RateLimiter 的算法一致。
+// 状态只在本实例内有效，因此按 limitPercent 缩小每个限制，使多个实例合计仍接近原有限制。
+type LocalRateLimiter struct {
+	mu           sync.Mutex
+	limitPercent int64
+	tat          map[string]time.Time
+	lastSweep    time.Time
+}
+
+// NewLocalRateLimiter 创建进程内限流器，limitPercent 为本实例执行的限制占原限制的百分比（1-100）
+func NewLocalRateLimiter(limitPercent int) *LocalRateLimiter {
+	if limitPercent <= 0 || limitPercent > 100 {
+		limitPercent = 100
+	}
+	return &LocalRateLimiter{
+		limitPercent: int64(limitPercent),
+		tat:          make(map[string]time.Time),
+		lastSweep:    time.Now(),
+	}
+}
+
+// scaledLimit 按百分比缩小限制，至少保留 1
+func (l *LocalRateLimiter) scaledLimit(limit int64) int64 {
+	scaled := limit * l.limitPercent / 100
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
+// gcraCheck 计算在键上记入 cost 个单位后的状态，不修改状态；调用方需持有 l.mu
+// 配额以 window/limit 的间隔恢复，最多累积 burst 个。返回新的理论到达时间和结果，cost 超过 burst 本身时 ResetAfter 为 -1。
+func (l *LocalRateLimiter) gcraCheck(key string, limit, burst, cost int64, window time.Duration, now time.Time) (time.Time, *RateLimitResult) {
+	interval := window / time.Duration(limit)
+	tolerance := interval * time.Duration(burst)
+	tat := l.tat[key]
+	if tat.Before(now) {
+		tat = now
+	}
+	newTAT := tat.Add(interval * time.Duration(cost))
+
+	if newTAT.Sub(now) > tolerance {
+		resetAfter := newTAT.Sub(now) - tolerance
+		if cost > burst {
+			resetAfter = -1
+		}
+		return tat, &RateLimitResult{
+			Allowed:    false,
+			Limit:      limit,
+			Remaining:  int64((tolerance - tat.Sub(now)) / interval),
+			ResetAfter: resetAfter,
+		}
+	}
+	return newTAT, &RateLimitResult{
+		Allowed:    true,
+		Limit:      limit,
+		Remaining:  int64((tolerance - newTAT.Sub(now)) / interval),
+		ResetAfter: newTAT.Sub(now),
+	}
+}
+
+// sweep 删除配额已完全恢复的键，避免长期不用的键占用内存；调用方需持有 l.mu
+func (l *LocalRateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < localSweepInterval {
+		return
+	}
+	for key, tat := range l.tat {
+		if !tat.After(now) {
+			delete(l.tat, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Allow 检查并记录一次请求，放行时 ResetAfter 为配额完全恢复所需的时间，拒绝时为下一次请求可以放行前的等待时间
+func (l *LocalRateLimiter) Allow(key string, config RateLimitConfig) (*RateLimitResult, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	newTAT, result := l.gcraCheck(key, l.scaledLimit(config.Limit), l.scaledLimit(config.burst()), 1, config.Window, now)
+	if result.Allowed {
+		l.tat[key] = newTAT
+	}
+	return result, nil
+}
+
+// AllowAll 检查一次请求涉及的所有限流，全部放行时才记录请求，语义与 RateLimiter.AllowAll 相同
+func (l *LocalRateLimiter) AllowAll(checks []RateLimitCheck) (*RateLimitResult, error) {
+	if len(checks) == 0 {
+		return nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	newTATs := make([]time.Time, len(checks))
+	var binding *RateLimitResult
+	for i, check := range checks {
+		config := check.Config
+		newTAT, result := l.gcraCheck(check.Key, l.scaledLimit(config.Limit), l.scaledLimit(config.burst()), 1, config.Window, now)
+		if !result.Allowed {
+			return result, nil
+		}
+		newTATs[i] = newTAT
+		if binding == nil || result.Remaining < binding.Remaining {
+			binding = result
+		}
+	}
+	for i, check := range checks {
+		l.tat[check.Key] = newTATs[i]
+	}
+	return binding, nil
+}
+
+// checkRateLimit 通用限流检查方法
+func (l *LocalRateLimiter) checkRateLimit(key string, config RateLimitConfig) (bool, int64, error) {
+	result, err := l.Allow(key, config)
+	if err != nil {
+		return false, 0, err
+	}
+	return result.Allowed, result.Remaining, nil
+}
+
+// CheckUserRateLimit 检查用户限流
+func (l *LocalRateLimiter) CheckUserRateLimit(userID int64, config RateLimitConfig) (bool, int64, error) {
+	return l.checkRateLimit(fmt.Sprintf(RateLimitKeyUser, userID), config)
+}
+
+// CheckAPIKeyRateLimit 检查API密钥限流
+func (l *LocalRateLimiter) CheckAPIKeyRateLimit(apiKey string, config RateLimitConfig) (bool, int64, error) {
+	return l.checkRateLimit(fmt.Sprintf(RateLimitKeyAPIKey, apiKey), config)
+}
+
+// CheckServiceRateLimit 检查服务限流
+func (l *LocalRateLimiter) CheckServiceRateLimit(serviceID int64, config RateLimitConfig) (bool, int64, error) {
+	return l.checkRateLimit(fmt.Sprintf(RateLimitKeyService, serviceID), config)
+}
+
+// CheckIPRateLimit 检查IP限流
+func (l *LocalRateLimiter) CheckIPRateLimit(ip string, config RateLimitConfig) (bool, int64, error) {
+	return l.checkRateLimit(fmt.Sprintf(RateLimitKeyIP, ip), config)
+}
+
+// CheckUserAPIRateLimit 检查用户对特定API的限流
+func (l *LocalRateLimiter) CheckUserAPIRateLimit(userID, serviceID int64, config RateLimitConfig) (bool, int64, error) {
+	return l.checkRateLimit(fmt.Sprintf(RateLimitKeyUserAPI, userID, serviceID), config)
+}
+
+// localReservationSeq 进程内 token 预留的序号
+var localReservationSeq uint64
+
+// ReserveTokens 在所有预算中预留 tokens 个 token，任一预算不足时都不预留，语义与 RateLimiter.ReserveTokens 相同
+func (l *LocalRateLimiter) ReserveTokens(limits []TokenLimit, tokens int64, window time.Duration) (*TokenReservation, *RateLimitResult, error) {
+	if len(limits) == 0 {
+		return nil, nil, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	newTATs := make([]time.Time, len(limits))
+	var binding *RateLimitResult
+	for i, limit := range limits {
+		scaled := l.scaledLimit(limit.Limit)
+		newTAT, result := l.gcraCheck(limit.Key, scaled, scaled, tokens, window, now)
+		if !result.Allowed {
+			return nil, result, nil
+		}
+		newTATs[i] = newTAT
+		if binding == nil || result.Remaining < binding.Remaining {
+			binding = result
+		}
+	}
+
+	reservation := &TokenReservation{
+		Member:    fmt.Sprintf("%d:local-%d", tokens, atomic.AddUint64(&localReservationSeq, 1)),
+		Tokens:    tokens,
+		local:     true,
+		intervals: make([]time.Duration, len(limits)),
+	}
+	for i, limit := range limits {
+		l.tat[limit.Key] = newTATs[i]
+		reservation.Keys = append(reservation.Keys, limit.Key)
+		reservation.intervals[i] = window / time.Duration(l.scaledLimit(limit.Limit))
+	}
+	return reservation, binding, nil
+}
+
+// ReconcileTokens 按实际用量修正预留的 token：多预留的部分归还配额，超出预留的部分继续占用配额
+func (l *LocalRateLimiter) ReconcileTokens(reservation *TokenReservation, actualTokens int64) error {
+	if reservation == nil || actualTokens == reservation.Tokens {
+		return nil
+	}
+	if actualTokens < 0 {
+		actualTokens = 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	delta := actualTokens - reservation.Tokens
+	for i, key := range reservation.Keys {
+		tat, ok := l.tat[key]
+		if !ok {
+			continue
+		}
+		if delta > 0 && tat.Before(now) {
+			tat = now
+		}
+		tat = tat.Add(reservation.intervals[i] * time.Duration(delta))
+		if tat.Before(now) {
+			tat = now
+		}
+		l.tat[key] = tat
+	}
+	return nil
+}
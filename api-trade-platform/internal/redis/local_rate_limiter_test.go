@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLocalRateLimiterScalesLimit(t *testing.T) {
+	l := NewLocalRateLimiter(50)
+	config := RateLimitConfig{Limit: 4, Window: time.Minute}
+
+	for i := 0; i < 2; i++ {
+		result, _ := l.Allow("k", config)
+		if !result.Allowed {
+			t.Fatalf("request %d rejected, want allowed", i+1)
+		}
+	}
+	result, _ := l.Allow("k", config)
+	if result.Allowed {
+		t.Fatalf("request over scaled limit allowed")
+	}
+	if result.Limit != 2 || result.ResetAfter <= 0 || result.ResetAfter > 30*time.Second {
+		t.Errorf("rejected result = %+v, want limit 2 and reset within one interval", result)
+	}
+	if result, _ := l.Allow("other", config); !result.Allowed {
+		t.Errorf("independent key rejected")
+	}
+}
+
+func TestLocalRateLimiterReserveAndReconcileTokens(t *testing.T) {
+	l := NewLocalRateLimiter(100)
+	limits := []TokenLimit{{Key: "a", Limit: 1000}, {Key: "b", Limit: 500}}
+
+	reservation, result, _ := l.ReserveTokens(limits, 400, time.Minute)
+	if reservation == nil || result.Remaining != 100 {
+		t.Fatalf("ReserveTokens() = %v, %+v, want reservation with 100 remaining", reservation, result)
+	}
+	if reservation, _, _ := l.ReserveTokens(limits, 200, time.Minute); reservation != nil {
+		t.Fatalf("ReserveTokens() over budget b succeeded")
+	}
+
+	// 实际只用了 100 个 token，多预留的 300 个归还后可以再预留
+	if err := l.ReconcileTokens(reservation, 100); err != nil {
+		t.Fatalf("ReconcileTokens() error = %v", err)
+	}
+	if reservation, result, _ := l.ReserveTokens(limits, 300, time.Minute); reservation == nil || result.Remaining != 100 {
+		t.Errorf("ReserveTokens() after reconcile = %v, %+v, want reservation with 100 remaining", reservation, result)
+	}
+}
+
+func TestFallbackRateLimiterWithoutRedis(t *testing.T) {
+	f := NewFallbackRateLimiter(nil, NewLocalRateLimiter(100), time.Second, false)
+	if !f.Degraded() {
+		t.Fatalf("limiter without Redis not degraded")
+	}
+	config := RateLimitConfig{Limit: 1, Window: time.Minute}
+	if allowed, _, err := f.CheckIPRateLimit("1.2.3.4", config); err != nil || !allowed {
+		t.Errorf("first request = %v, %v, want allowed", allowed, err)
+	}
+	if allowed, _, _ := f.CheckIPRateLimit("1.2.3.4", config); allowed {
+		t.Errorf("second request allowed, want limited")
+	}
+}
+
+func TestLocalRateLimiterAllowAllIsAllOrNothing(t *testing.T) {
+	l := NewLocalRateLimiter(100)
+	subscriber := RateLimitCheck{Key: "subscriber", Config: RateLimitConfig{Limit: 2, Window: time.Minute}}
+	service := RateLimitCheck{Key: "service", Config: RateLimitConfig{Limit: 1, Window: time.Minute}}
+
+	if result, _ := l.AllowAll([]RateLimitCheck{subscriber, service}); !result.Allowed || result.Limit != 1 {
+		t.Fatalf("first request = %+v, want allowed and bound by the service limit", result)
+	}
+	for i := 0; i < 3; i++ {
+		if result, _ := l.AllowAll([]RateLimitCheck{subscriber, service}); result.Allowed {
+			t.Fatalf("request %d allowed over the service limit", i+2)
+		}
+	}
+	// 被拒绝的请求没有占用买家的配额
+	if result, _ := l.Allow(subscriber.Key, subscriber.Config); !result.Allowed || result.Remaining != 0 {
+		t.Errorf("subscriber request = %+v, want the second of two allowed", result)
+	}
+}
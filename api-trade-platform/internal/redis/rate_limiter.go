@@ -2,7 +2,13 @@ package redis
 
 import (
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/go-redis/redis/v8"
 )
 
 // RateLimiter 限流服务
@@ -11,9 +17,19 @@ type RateLimiter struct {
 }
 
 // RateLimitConfig 限流配置
+// 按 GCRA（通用信元速率算法）限流：配额以 Window/Limit 的间隔匀速恢复，空闲后最多允许连续 Burst 次请求。
 type RateLimitConfig struct {
 	Limit  int64         // 限制次数
 	Window time.Duration // 时间窗口
+	Burst  int64         // 允许的突发请求数，0 表示等于 Limit
+}
+
+// burst 返回实际生效的突发请求数
+func (c RateLimitConfig) burst() int64 {
+	if c.Burst <= 0 {
+		return c.Limit
+	}
+	return c.Burst
 }
 
 // NewRateLimiter 创建限流服务实例
@@ -32,6 +48,16 @@ const (
 	RateLimitKeyUserAPI    = "rate_limit:user_api:%d:%d" // 用户对特定API的限流
 )
 
+// ServiceRateLimitKey 服务所有买家合计的限流键
+func ServiceRateLimitKey(serviceID int64) string {
+	return fmt.Sprintf(RateLimitKeyService, serviceID)
+}
+
+// SubscriberRateLimitKey 单个买家调用特定服务的限流键
+func SubscriberRateLimitKey(buyerUserID, serviceID int64) string {
+	return fmt.Sprintf(RateLimitKeyUserAPI, buyerUserID, serviceID)
+}
+
 // 预定义限流配置
 var (
 	// 用户登录限流：每分钟最多5次
@@ -89,86 +115,327 @@ func (r *RateLimiter) CheckUserAPIRateLimit(userID, serviceID int64, config Rate
 	return r.checkRateLimit(key, config)
 }
 
+// RateLimitChecker 限流检查接口，由基于 Redis 的 RateLimiter、进程内的 LocalRateLimiter
+// 以及 Redis 不可用时自动降级到进程内限流的 FallbackRateLimiter 实现
+type RateLimitChecker interface {
+	Allow(key string, config RateLimitConfig) (*RateLimitResult, error)
+	AllowAll(checks []RateLimitCheck) (*RateLimitResult, error)
+	CheckUserRateLimit(userID int64, config RateLimitConfig) (bool, int64, error)
+	CheckAPIKeyRateLimit(apiKey string, config RateLimitConfig) (bool, int64, error)
+	CheckServiceRateLimit(serviceID int64, config RateLimitConfig) (bool, int64, error)
+	CheckIPRateLimit(ip string, config RateLimitConfig) (bool, int64, error)
+	CheckUserAPIRateLimit(userID, serviceID int64, config RateLimitConfig) (bool, int64, error)
+	ReserveTokens(limits []TokenLimit, tokens int64, window time.Duration) (*TokenReservation, *RateLimitResult, error)
+	ReconcileTokens(reservation *TokenReservation, actualTokens int64) error
+}
+
+// RateLimitResult 单次限流检查的结果
+type RateLimitResult struct {
+	Allowed    bool          // 本次请求是否放行
+	Limit      int64         // 窗口内允许的请求数
+	Remaining  int64         // 放行本次请求后还能立即放行的请求数
+	ResetAfter time.Duration // 放行时为配额完全恢复还需的时间，拒绝时为下一次请求可以放行前需等待的时间
+}
+
+// RateLimitCheck 一次请求需要同时满足的一个限流
+type RateLimitCheck struct {
+	Key    string
+	Config RateLimitConfig
+}
+
+// gcraScript GCRA 限流：每个键只保存一个理论到达时间 TAT(毫秒，可带小数)，内存占用与限制大小无关
+// interval 为恢复一次请求所需的毫秒数，tolerance 为 burst * interval；TAT 超前当前时间不超过 tolerance 时放行。
+// 多个键时所有键都放行才同时记入，任一键拒绝时都不记入；返回拒绝的键或剩余最少的键的序号(从 1 开始)。
+var gcraScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+
+	local newTats = {}
+	local binding, bindingRemaining, bindingReset = 0, 0, 0
+	for i, key in ipairs(KEYS) do
+		local interval = tonumber(ARGV[2 * i])
+		local tolerance = tonumber(ARGV[2 * i + 1])
+
+		local stored = redis.pcall('GET', key)
+		if type(stored) == 'table' and stored.err then
+			-- 旧版滑动窗口留下的有序集合，直接覆盖
+			redis.call('DEL', key)
+			stored = false
+		end
+		local tat = tonumber(stored) or now
+		if tat < now then
+			tat = now
+		end
+
+		local newTat = tat + interval
+		if newTat - now > tolerance then
+			return {0, i, 0, math.ceil(newTat - now - tolerance)}
+		end
+
+		newTats[i] = newTat
+		local remaining = math.floor((tolerance - (newTat - now)) / interval)
+		if binding == 0 or remaining < bindingRemaining then
+			binding, bindingRemaining, bindingReset = i, remaining, math.ceil(newTat - now)
+		end
+	end
+
+	for i, key in ipairs(KEYS) do
+		redis.call('SET', key, string.format('%.3f', newTats[i]), 'PX', math.ceil(newTats[i] - now))
+	end
+	return {1, binding, bindingRemaining, bindingReset}
+`)
+
+// Allow 按 GCRA 检查并记录一次请求
+func (r *RateLimiter) Allow(key string, config RateLimitConfig) (*RateLimitResult, error) {
+	return r.allowAt(key, config, time.Now())
+}
+
+// allowAt 以 now 作为当前时间执行 Allow，时间精确到毫秒
+func (r *RateLimiter) allowAt(key string, config RateLimitConfig, now time.Time) (*RateLimitResult, error) {
+	return r.allowAllAt([]RateLimitCheck{{Key: key, Config: config}}, now)
+}
+
+// AllowAll 原子地按 GCRA 检查一次请求涉及的所有限流，全部放行时才在每个限流中记录请求
+// 返回拒绝请求的限流的结果，全部放行时返回剩余配额最少的限流的结果；没有限流时返回 nil。
+func (r *RateLimiter) AllowAll(checks []RateLimitCheck) (*RateLimitResult, error) {
+	return r.allowAllAt(checks, time.Now())
+}
+
+// allowAllAt 以 now 作为当前时间执行 AllowAll，时间精确到毫秒
+func (r *RateLimiter) allowAllAt(checks []RateLimitCheck, now time.Time) (*RateLimitResult, error) {
+	if len(checks) == 0 {
+		return nil, nil
+	}
+	keys := make([]string, len(checks))
+	args := []interface{}{now.UnixMilli()}
+	for i, check := range checks {
+		config := check.Config
+		if config.Limit <= 0 || config.Window <= 0 {
+			return nil, fmt.Errorf("invalid rate limit config: limit %d, window %s", config.Limit, config.Window)
+		}
+		interval := float64(config.Window.Milliseconds()) / float64(config.Limit)
+		keys[i] = check.Key
+		args = append(args, interval, interval*float64(config.burst()))
+	}
+
+	result, err := gcraScript.Run(r.redisClient.ctx, r.redisClient.client, keys, args...).Result()
+	if err != nil {
+		return nil, fmt.Errorf("rate limit check failed: %w", err)
+	}
+
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 4 {
+		return nil, fmt.Errorf("unexpected rate limit result format")
+	}
+	allowed, _ := values[0].(int64)
+	index, _ := values[1].(int64)
+	remaining, _ := values[2].(int64)
+	resetMs, _ := values[3].(int64)
+	if index < 1 || index > int64(len(checks)) {
+		return nil, fmt.Errorf("unexpected rate limit result format")
+	}
+
+	return &RateLimitResult{
+		Allowed:    allowed == 1,
+		Limit:      checks[index-1].Config.Limit,
+		Remaining:  remaining,
+		ResetAfter: time.Duration(resetMs) * time.Millisecond,
+	}, nil
+}
+
 // checkRateLimit 通用限流检查方法
 // 返回值：(是否允许, 剩余次数, 错误)
 func (r *RateLimiter) checkRateLimit(key string, config RateLimitConfig) (bool, int64, error) {
-	// 使用滑动窗口计数器算法
-	now := time.Now().Unix()
-	windowStart := now - int64(config.Window.Seconds())
-	
-	// Lua脚本实现原子性的限流检查和计数
-	luaScript := `
-		local key = KEYS[1]
-		local window_start = ARGV[1]
-		local now = ARGV[2]
-		local limit = tonumber(ARGV[3])
-		local window_size = tonumber(ARGV[4])
-		
-		-- 清理过期的记录
-		redis.call('ZREMRANGEBYSCORE', key, 0, window_start)
-		
-		-- 获取当前窗口内的请求数
-		local current_count = redis.call('ZCARD', key)
-		
-		-- 检查是否超过限制
-		if current_count < limit then
-			-- 添加当前请求
-			redis.call('ZADD', key, now, now)
-			-- 设置过期时间
-			redis.call('EXPIRE', key, window_size)
-			return {1, limit - current_count - 1}
-		else
-			return {0, 0}
+	result, err := r.Allow(key, config)
+	if err != nil {
+		return false, 0, err
+	}
+	return result.Allowed, result.Remaining, nil
+}
+
+// TPM 限流键前缀常量
+const (
+	TokenRateLimitKeyService      = "tpm:service:%d"                   // 服务所有买家合计
+	TokenRateLimitKeySubscriber   = "tpm:subscriber:%d:%d"             // 单个买家调用特定服务（卖家的订阅者预算与买家的服务预算共用）
+	TokenRateLimitKeyServiceModel = "tpm:service_model:%d:%s"          // 服务上的特定模型
+	TokenRateLimitKeyAPIKey       = "tpm:api_key:%d"                   // 单个平台密钥
+	TokenRateLimitKeyAPIKeyModel  = "tpm:api_key_model:%d:%s"          // 单个平台密钥上的特定模型
+	TokenRateLimitKeyBuyerModel   = "tpm:buyer_service_model:%d:%d:%s" // 买家在特定服务上的特定模型
+)
+
+// TokenLimit 一个每分钟 token 预算
+type TokenLimit struct {
+	Key   string
+	Limit int64
+}
+
+// TokenReservation 一次请求在若干 token 预算中预留的 token，拿到实际用量后通过 ReconcileTokens 修正
+type TokenReservation struct {
+	Keys   []string
+	Member string
+	Tokens int64
+
+	local     bool            // 由进程内限流器预留，只能在进程内修正
+	intervals []time.Duration // 进程内预留时每个预算恢复一个 token 所需的时间
+}
+
+// windowMemberSeq 与时间戳一起组成 token 预留的唯一ID，避免同一时刻的预留互相覆盖
+var windowMemberSeq uint64
+
+// tokenWindowScript 按 token 加权的滑动窗口：有序集合成员为 "<token数>:<唯一ID>"，分数为预留时间(毫秒)
+// 所有预算都能容纳本次预留时才同时记入；返回剩余最少（或超出）的预算及其重置时间，永远无法容纳时重置时间为 -1
+var tokenWindowScript = redis.NewScript(`
+	local now = tonumber(ARGV[1])
+	local window = tonumber(ARGV[2])
+	local tokens = tonumber(ARGV[3])
+	local member = ARGV[3] .. ':' .. ARGV[4]
+
+	local function weight(entry)
+		return tonumber(string.match(entry, '^(%d+):')) or 0
+	end
+
+	local binding, bindingRemaining, bindingReset = 0, -1, 0
+	for i, key in ipairs(KEYS) do
+		local limit = tonumber(ARGV[4 + i])
+		redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+		local entries = redis.call('ZRANGE', key, 0, -1, 'WITHSCORES')
+		local used = 0
+		for j = 1, #entries, 2 do
+			used = used + weight(entries[j])
 		end
-	`
-	
-	result, err := r.redisClient.client.Eval(
-		r.redisClient.ctx,
-		luaScript,
-		[]string{key},
-		windowStart,
-		now,
-		config.Limit,
-		int64(config.Window.Seconds()),
-	).Result()
-	
+
+		if used + tokens > limit then
+			local reset = -1
+			if tokens <= limit then
+				local freed = 0
+				for j = 1, #entries, 2 do
+					freed = freed + weight(entries[j])
+					if used - freed + tokens <= limit then
+						reset = tonumber(entries[j + 1]) + window - now
+						break
+					end
+				end
+			end
+			return {0, i, limit, math.max(limit - used, 0), reset}
+		end
+
+		local remaining = limit - used - tokens
+		if binding == 0 or remaining < bindingRemaining then
+			local reset = window
+			if entries[2] then
+				reset = tonumber(entries[2]) + window - now
+			end
+			binding, bindingRemaining, bindingReset = i, remaining, reset
+		end
+	end
+
+	for _, key in ipairs(KEYS) do
+		redis.call('ZADD', key, now, member)
+		redis.call('PEXPIRE', key, window)
+	end
+	return {1, binding, tonumber(ARGV[4 + binding]), bindingRemaining, bindingReset}
+`)
+
+// tokenReconcileScript 将预留的 token 数替换为实际用量，保留原预留时间；实际用量为 0 时直接释放
+var tokenReconcileScript = redis.NewScript(`
+	for _, key in ipairs(KEYS) do
+		local score = redis.call('ZSCORE', key, ARGV[1])
+		if score then
+			redis.call('ZREM', key, ARGV[1])
+			if tonumber(ARGV[3]) > 0 then
+				redis.call('ZADD', key, score, ARGV[2])
+			end
+		end
+	end
+	return 1
+`)
+
+// ReserveTokens 在所有预算中预留 tokens 个 token，任一预算不足时都不预留
+// 返回的结果描述剩余最少（或超出）的预算；不允许时 ResetAfter 为负数表示本次请求超过了预算本身。
+func (r *RateLimiter) ReserveTokens(limits []TokenLimit, tokens int64, window time.Duration) (*TokenReservation, *RateLimitResult, error) {
+	return r.reserveTokensAt(limits, tokens, window, time.Now())
+}
+
+// reserveTokensAt 在 now 时刻预留 token，now 由调用方传入便于测试
+func (r *RateLimiter) reserveTokensAt(limits []TokenLimit, tokens int64, window time.Duration, now time.Time) (*TokenReservation, *RateLimitResult, error) {
+	if len(limits) == 0 {
+		return nil, nil, nil
+	}
+
+	id := fmt.Sprintf("%d-%d", now.UnixNano(), atomic.AddUint64(&windowMemberSeq, 1))
+	keys := make([]string, len(limits))
+	args := []interface{}{now.UnixMilli(), window.Milliseconds(), tokens, id}
+	for i, limit := range limits {
+		keys[i] = limit.Key
+		args = append(args, limit.Limit)
+	}
+
+	result, err := tokenWindowScript.Run(r.redisClient.ctx, r.redisClient.client, keys, args...).Result()
 	if err != nil {
-		return false, 0, fmt.Errorf("rate limit check failed: %w", err)
+		return nil, nil, fmt.Errorf("token rate limit check failed: %w", err)
 	}
-	
-	resultSlice, ok := result.([]interface{})
-	if !ok || len(resultSlice) != 2 {
-		return false, 0, fmt.Errorf("unexpected rate limit result format")
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 5 {
+		return nil, nil, fmt.Errorf("unexpected token rate limit result format")
 	}
-	
-	allowed := resultSlice[0].(int64) == 1
-	remaining := resultSlice[1].(int64)
-	
-	return allowed, remaining, nil
+	allowed, _ := values[0].(int64)
+	limit, _ := values[2].(int64)
+	remaining, _ := values[3].(int64)
+	resetMs, _ := values[4].(int64)
+
+	check := &RateLimitResult{
+		Allowed:    allowed == 1,
+		Limit:      limit,
+		Remaining:  remaining,
+		ResetAfter: time.Duration(resetMs) * time.Millisecond,
+	}
+	if !check.Allowed {
+		return nil, check, nil
+	}
+	return &TokenReservation{Keys: keys, Member: fmt.Sprintf("%d:%s", tokens, id), Tokens: tokens}, check, nil
+}
+
+// ReconcileTokens 按实际用量修正预留的 token，预留已随窗口过期时不做任何操作
+func (r *RateLimiter) ReconcileTokens(reservation *TokenReservation, actualTokens int64) error {
+	if reservation == nil || actualTokens == reservation.Tokens {
+		return nil
+	}
+	if actualTokens < 0 {
+		actualTokens = 0
+	}
+
+	_, id, _ := strings.Cut(reservation.Member, ":")
+	member := fmt.Sprintf("%d:%s", actualTokens, id)
+	err := tokenReconcileScript.Run(r.redisClient.ctx, r.redisClient.client, reservation.Keys,
+		reservation.Member, member, actualTokens).Err()
+	if err != nil {
+		return fmt.Errorf("failed to reconcile token reservation: %w", err)
+	}
+	return nil
 }
 
-// GetRateLimitInfo 获取限流信息
+// GetRateLimitInfo 获取限流信息，返回尚未恢复的请求数和还能立即放行的请求数，不记录请求
 func (r *RateLimiter) GetRateLimitInfo(key string, config RateLimitConfig) (int64, int64, error) {
-	now := time.Now().Unix()
-	windowStart := now - int64(config.Window.Seconds())
-	
-	// 清理过期记录
-	if err := r.redisClient.client.ZRemRangeByScore(
-		r.redisClient.ctx,
-		key,
-		"0",
-		fmt.Sprintf("%d", windowStart),
-	).Err(); err != nil {
-		return 0, 0, fmt.Errorf("failed to clean expired records: %w", err)
+	if config.Limit <= 0 || config.Window <= 0 {
+		return 0, 0, fmt.Errorf("invalid rate limit config: limit %d, window %s", config.Limit, config.Window)
+	}
+	burst := config.burst()
+
+	stored, err := r.redisClient.client.Get(r.redisClient.ctx, key).Result()
+	if err == redis.Nil {
+		return 0, burst, nil
 	}
-	
-	// 获取当前计数
-	currentCount, err := r.redisClient.client.ZCard(r.redisClient.ctx, key).Result()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to get current count: %w", err)
+		return 0, 0, fmt.Errorf("failed to get rate limit state: %w", err)
 	}
-	
-	remaining := config.Limit - currentCount
+	tat, err := strconv.ParseFloat(stored, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to parse rate limit state: %w", err)
+	}
+
+	interval := float64(config.Window.Milliseconds()) / float64(config.Limit)
+	ahead := math.Max(tat-float64(time.Now().UnixMilli()), 0)
+	currentCount := int64(math.Ceil(ahead / interval))
+	remaining := int64(math.Floor((interval*float64(burst) - ahead) / interval))
 	if remaining < 0 {
 		remaining = 0
 	}
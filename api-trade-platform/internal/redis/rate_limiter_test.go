@@ -0,0 +1,213 @@
+package redis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// newTestRateLimiter 创建连接到进程内 miniredis 的限流器
+func newTestRateLimiter(t *testing.T) (*RateLimiter, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRateLimiter(&RedisClient{client: client, ctx: context.Background()}), mr
+}
+
+// expectResult 在 at 时刻检查一次请求并核对结果
+func expectResult(t *testing.T, r *RateLimiter, key string, config RateLimitConfig, at time.Time, allowed bool, remaining int64, resetAfter time.Duration) {
+	t.Helper()
+	result, err := r.allowAt(key, config, at)
+	if err != nil {
+		t.Fatalf("allowAt() error = %v", err)
+	}
+	if result.Allowed != allowed || result.Remaining != remaining || result.ResetAfter != resetAfter {
+		t.Fatalf("allowAt(+%s) = {allowed %v, remaining %d, reset %s}, want {allowed %v, remaining %d, reset %s}",
+			at.Sub(testEpoch), result.Allowed, result.Remaining, result.ResetAfter, allowed, remaining, resetAfter)
+	}
+}
+
+var testEpoch = time.UnixMilli(1_800_000_000_000)
+
+func TestRateLimiterGCRAAdmission(t *testing.T) {
+	r, _ := newTestRateLimiter(t)
+	config := RateLimitConfig{Limit: 3, Window: 3 * time.Second}
+	ms := time.Millisecond
+
+	// 同一毫秒内的请求分别计数，突发用完后按 1 秒的间隔恢复
+	expectResult(t, r, "k", config, testEpoch, true, 2, 1000*ms)
+	expectResult(t, r, "k", config, testEpoch, true, 1, 2000*ms)
+	expectResult(t, r, "k", config, testEpoch, true, 0, 3000*ms)
+	expectResult(t, r, "k", config, testEpoch, false, 0, 1000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(999*ms), false, 0, 1*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(1000*ms), true, 0, 3000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(1500*ms), false, 0, 500*ms)
+
+	// 空闲满一个窗口后恢复完整的突发
+	expectResult(t, r, "k", config, testEpoch.Add(5000*ms), true, 2, 1000*ms)
+}
+
+func TestRateLimiterBurst(t *testing.T) {
+	r, _ := newTestRateLimiter(t)
+	config := RateLimitConfig{Limit: 60, Window: time.Minute, Burst: 2}
+	ms := time.Millisecond
+
+	expectResult(t, r, "k", config, testEpoch, true, 1, 1000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(1*ms), true, 0, 1999*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(2*ms), false, 0, 998*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(1000*ms), true, 0, 2000*ms)
+	// 空闲很久也只累积 Burst 个请求
+	expectResult(t, r, "k", config, testEpoch.Add(time.Hour), true, 1, 1000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(time.Hour), true, 0, 2000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(time.Hour), false, 0, 1000*ms)
+}
+
+func TestRateLimiterFractionalInterval(t *testing.T) {
+	r, _ := newTestRateLimiter(t)
+	config := RateLimitConfig{Limit: 3, Window: time.Second}
+	ms := time.Millisecond
+
+	// 间隔为 333.333 毫秒，按毫秒小数累计，一个窗口之后恰好恢复 3 次
+	expectResult(t, r, "k", config, testEpoch, true, 2, 334*ms)
+	expectResult(t, r, "k", config, testEpoch, true, 1, 667*ms)
+	expectResult(t, r, "k", config, testEpoch, true, 0, 1000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(333*ms), false, 0, 1*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(334*ms), true, 0, 1000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(666*ms), false, 0, 1*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(667*ms), true, 0, 1000*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(999*ms), false, 0, 1*ms)
+	expectResult(t, r, "k", config, testEpoch.Add(1000*ms), true, 0, 1000*ms)
+}
+
+func TestRateLimiterConstantMemory(t *testing.T) {
+	r, mr := newTestRateLimiter(t)
+	config := RateLimitConfig{Limit: 1000, Window: time.Minute}
+
+	for i := 0; i < 500; i++ {
+		if _, err := r.allowAt("k", config, testEpoch); err != nil {
+			t.Fatalf("allowAt() error = %v", err)
+		}
+	}
+	if keyType := mr.Type("k"); keyType != "string" {
+		t.Errorf("key type = %q, want a single string value", keyType)
+	}
+	if ttl := mr.TTL("k"); ttl != 30*time.Second {
+		t.Errorf("key TTL = %s, want 30s until the state is fully recovered", ttl)
+	}
+}
+
+func TestRateLimiterReplacesLegacySortedSet(t *testing.T) {
+	r, mr := newTestRateLimiter(t)
+	if _, err := mr.ZAdd("k", 1, "legacy"); err != nil {
+		t.Fatalf("ZAdd() error = %v", err)
+	}
+
+	expectResult(t, r, "k", RateLimitConfig{Limit: 2, Window: 2 * time.Second}, testEpoch, true, 1, time.Second)
+}
+
+func TestRateLimiterAllowAllIsAllOrNothing(t *testing.T) {
+	r, _ := newTestRateLimiter(t)
+	subscriber := RateLimitCheck{Key: "subscriber", Config: RateLimitConfig{Limit: 3, Window: 3 * time.Second}}
+	service := RateLimitCheck{Key: "service", Config: RateLimitConfig{Limit: 1, Window: 3 * time.Second}}
+	checks := []RateLimitCheck{subscriber, service}
+
+	// 放行时返回剩余配额最少的限流
+	result, err := r.allowAllAt(checks, testEpoch)
+	if err != nil {
+		t.Fatalf("allowAllAt() error = %v", err)
+	}
+	if !result.Allowed || result.Limit != 1 || result.Remaining != 0 || result.ResetAfter != 3*time.Second {
+		t.Fatalf("first request = %+v, want allowed and bound by the service limit", result)
+	}
+
+	// 服务限流拒绝时买家自己的配额不被扣减
+	for i := 0; i < 3; i++ {
+		result, _ = r.allowAllAt(checks, testEpoch.Add(time.Duration(i)*time.Millisecond))
+		if result.Allowed || result.Limit != 1 {
+			t.Fatalf("request %d = %+v, want rejected by the service limit", i+2, result)
+		}
+	}
+	expectResult(t, r, "subscriber", subscriber.Config, testEpoch.Add(10*time.Millisecond), true, 1, 1990*time.Millisecond)
+
+	if result, err := r.AllowAll(nil); result != nil || err != nil {
+		t.Errorf("AllowAll(nil) = %+v, %v, want nil", result, err)
+	}
+}
+
+// expectReserve 在 at 时刻预留 tokens 个 token 并核对结果，返回预留
+func expectReserve(t *testing.T, r *RateLimiter, limits []TokenLimit, tokens int64, at time.Time, allowed bool, remaining int64, resetAfter time.Duration) *TokenReservation {
+	t.Helper()
+	reservation, result, err := r.reserveTokensAt(limits, tokens, time.Minute, at)
+	if err != nil {
+		t.Fatalf("reserveTokensAt() error = %v", err)
+	}
+	if result.Allowed != allowed || result.Remaining != remaining || result.ResetAfter != resetAfter || (reservation != nil) != allowed {
+		t.Fatalf("reserveTokensAt(%d, +%s) = {allowed %v, remaining %d, reset %s}, want {allowed %v, remaining %d, reset %s}",
+			tokens, at.Sub(testEpoch), result.Allowed, result.Remaining, result.ResetAfter, allowed, remaining, resetAfter)
+	}
+	return reservation
+}
+
+func TestRateLimiterReserveTokens(t *testing.T) {
+	r, _ := newTestRateLimiter(t)
+	limits := []TokenLimit{{Key: "tpm", Limit: 100}}
+
+	expectReserve(t, r, limits, 40, testEpoch, true, 60, time.Minute)
+	// 重置时间为最早的预留移出窗口的时间
+	expectReserve(t, r, limits, 50, testEpoch.Add(10*time.Second), true, 10, 50*time.Second)
+
+	// 超出预算时不记入，重置时间为释放足够 token 所需的时间
+	expectReserve(t, r, limits, 20, testEpoch.Add(20*time.Second), false, 10, 40*time.Second)
+	expectReserve(t, r, limits, 10, testEpoch.Add(20*time.Second), true, 0, 40*time.Second)
+	expectReserve(t, r, limits, 1, testEpoch.Add(20*time.Second), false, 0, 40*time.Second)
+
+	// 超过预算本身的请求永远无法放行
+	expectReserve(t, r, limits, 101, testEpoch.Add(20*time.Second), false, 0, -time.Millisecond)
+
+	// 最早的 40 个 token 移出窗口后恢复
+	expectReserve(t, r, limits, 41, testEpoch.Add(60*time.Second), false, 40, 10*time.Second)
+	expectReserve(t, r, limits, 40, testEpoch.Add(60*time.Second), true, 0, 10*time.Second)
+}
+
+func TestRateLimiterReserveTokensIsAllOrNothing(t *testing.T) {
+	r, _ := newTestRateLimiter(t)
+	service := TokenLimit{Key: "service", Limit: 1000}
+	subscriber := TokenLimit{Key: "subscriber", Limit: 100}
+
+	// 放行时返回剩余最少的预算
+	expectReserve(t, r, []TokenLimit{service, subscriber}, 80, testEpoch, true, 20, time.Minute)
+
+	// 订阅者预算不足时服务预算也不记入
+	expectReserve(t, r, []TokenLimit{service, subscriber}, 30, testEpoch, false, 20, time.Minute)
+	expectReserve(t, r, []TokenLimit{service}, 920, testEpoch, true, 0, time.Minute)
+}
+
+func TestRateLimiterReconcileTokens(t *testing.T) {
+	r, _ := newTestRateLimiter(t)
+	limits := []TokenLimit{{Key: "a", Limit: 100}, {Key: "b", Limit: 200}}
+
+	// 实际用量少于预留时归还多余的 token，保留原预留时间
+	first := expectReserve(t, r, limits, 60, testEpoch, true, 40, time.Minute)
+	if err := r.ReconcileTokens(first, 20); err != nil {
+		t.Fatalf("ReconcileTokens() error = %v", err)
+	}
+	expectReserve(t, r, limits, 0, testEpoch.Add(time.Second), true, 80, 59*time.Second)
+
+	// 实际用量多于预留时继续占用超出的部分
+	second := expectReserve(t, r, limits, 10, testEpoch.Add(time.Second), true, 70, 59*time.Second)
+	if err := r.ReconcileTokens(second, 70); err != nil {
+		t.Fatalf("ReconcileTokens() error = %v", err)
+	}
+	expectReserve(t, r, limits, 11, testEpoch.Add(2*time.Second), false, 10, 58*time.Second)
+
+	// 实际用量为 0 时释放全部预留
+	third := expectReserve(t, r, limits, 10, testEpoch.Add(2*time.Second), true, 0, 58*time.Second)
+	if err := r.ReconcileTokens(third, 0); err != nil {
+		t.Fatalf("ReconcileTokens() error = %v", err)
+	}
+	expectReserve(t, r, limits, 10, testEpoch.Add(2*time.Second), true, 0, 58*time.Second)
+}
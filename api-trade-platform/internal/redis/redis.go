@@ -16,6 +16,19 @@ type RedisClient struct {
 
 // NewRedisClient 创建新的Redis客户端
 func NewRedisClient(host, port, password string, db, poolSize int) (*RedisClient, error) {
+	client := NewLazyRedisClient(host, port, password, db, poolSize)
+	
+	// 测试连接
+	if err := client.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+
+	return client, nil
+}
+
+// NewLazyRedisClient 创建Redis客户端但不测试连接，连接在首次使用时建立
+// 用于启动时 Redis 不可用、需要在 Redis 恢复后自动重新使用的组件
+func NewLazyRedisClient(host, port, password string, db, poolSize int) *RedisClient {
 	addr := fmt.Sprintf("%s:%s", host, port)
 	
 	rdb := redis.NewClient(&redis.Options{
@@ -31,17 +44,10 @@ func NewRedisClient(host, port, password string, db, poolSize int) (*RedisClient
 		IdleTimeout:  300 * time.Second,
 	})
 
-	ctx := context.Background()
-	
-	// 测试连接
-	if err := rdb.Ping(ctx).Err(); err != nil {
-		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
-	}
-
 	return &RedisClient{
 		client: rdb,
-		ctx:    ctx,
-	}, nil
+		ctx:    context.Background(),
+	}
 }
 
 // GetClient 获取Redis客户端实例
@@ -20,13 +20,15 @@ func NewAPIServiceStore(store *Store) *APIServiceStore {
 func (as *APIServiceStore) CreateAPIService(service *model.APIService) error {
 	query := `
 		INSERT INTO api_services (seller_user_id, name, description, original_endpoint_url, 
-			encrypted_original_api_key, platform_proxy_prefix, is_active, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+			encrypted_original_api_key, platform_proxy_prefix, is_active, api_format, lb_strategy,
+			connect_timeout_ms, response_header_timeout_ms, idle_conn_timeout_ms, auth_scheme, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, 0), NULLIF($11, 0), NULLIF($12, 0), $13, NOW(), NOW())
 		RETURNING service_id, created_at, updated_at`
 
 	err := as.DB.QueryRow(query, service.SellerUserID, service.Name, service.Description,
 		service.OriginalEndpointURL, service.EncryptedOriginalAPIKey, service.PlatformProxyPrefix,
-		service.IsActive).Scan(&service.ServiceID, &service.CreatedAt, &service.UpdatedAt)
+		service.IsActive, service.APIFormat, service.LBStrategy, service.ConnectTimeoutMs,
+		service.ResponseHeaderTimeoutMs, service.IdleConnTimeoutMs, service.AuthScheme).Scan(&service.ServiceID, &service.CreatedAt, &service.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to create API service: %w", err)
 	}
@@ -41,7 +43,13 @@ func (as *APIServiceStore) GetAPIServicesBySellerID(sellerUserID int64) ([]*mode
 			COALESCE(pricing_model, 'per_call') as pricing_model,
 			COALESCE(price_per_call, 0.0) as price_per_call,
 			COALESCE(price_per_token, 0.0) as price_per_token,
-			created_at, updated_at
+			pricing_plan,
+			COALESCE(api_format, 'openai') as api_format,
+			COALESCE(lb_strategy, 'weighted_round_robin') as lb_strategy,
+			COALESCE(connect_timeout_ms, 0) as connect_timeout_ms,
+			COALESCE(response_header_timeout_ms, 0) as response_header_timeout_ms,
+			COALESCE(idle_conn_timeout_ms, 0) as idle_conn_timeout_ms,
+			auth_scheme, billing_policy, rate_limits, created_at, updated_at
 		FROM api_services WHERE seller_user_id = $1 ORDER BY created_at DESC`
 
 	rows, err := as.DB.Query(query, sellerUserID)
@@ -56,7 +64,9 @@ func (as *APIServiceStore) GetAPIServicesBySellerID(sellerUserID int64) ([]*mode
 		err := rows.Scan(&service.ServiceID, &service.SellerUserID, &service.Name,
 			&service.Description, &service.OriginalEndpointURL, &service.EncryptedOriginalAPIKey,
 			&service.PlatformProxyPrefix, &service.IsActive, &service.PricingModel,
-			&service.PricePerCall, &service.PricePerToken, &service.CreatedAt, &service.UpdatedAt)
+			&service.PricePerCall, &service.PricePerToken, &service.PricingPlan, &service.APIFormat, &service.LBStrategy,
+			&service.ConnectTimeoutMs, &service.ResponseHeaderTimeoutMs, &service.IdleConnTimeoutMs,
+			&service.AuthScheme, &service.BillingPolicy, &service.RateLimits, &service.CreatedAt, &service.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan API service: %w", err)
 		}
@@ -77,6 +87,7 @@ func (as *APIServiceStore) GetAllActiveAPIServices() ([]*model.APIService, error
 			COALESCE(s.price_per_call, 0.01) as price_per_call, 
 			COALESCE(s.pricing_model, 'per_call') as pricing_model, 
 			COALESCE(s.price_per_token, 0.01) as price_per_token, 
+			s.pricing_plan, s.billing_policy, s.rate_limits,
 			COALESCE(s.total_calls, 0) as total_calls, 
 			COUNT(DISTINCT pak.buyer_user_id) as subscriber_count, 
 			COALESCE(s.features, '') as features, 
@@ -87,7 +98,7 @@ func (as *APIServiceStore) GetAllActiveAPIServices() ([]*model.APIService, error
 		WHERE s.is_active = true
 		GROUP BY s.service_id, s.seller_user_id, s.name, s.description, s.original_endpoint_url, 
 			s.encrypted_original_api_key, s.platform_proxy_prefix, s.is_active, s.category, 
-			s.rating, s.review_count, s.price_per_call, s.pricing_model, s.price_per_token, 
+			s.rating, s.review_count, s.price_per_call, s.pricing_model, s.price_per_token, s.pricing_plan, s.billing_policy, s.rate_limits,
 			s.total_calls, s.features, s.documentation, s.created_at, s.updated_at
 		ORDER BY s.created_at DESC`
 
@@ -104,7 +115,7 @@ func (as *APIServiceStore) GetAllActiveAPIServices() ([]*model.APIService, error
 			&service.Description, &service.OriginalEndpointURL, &service.EncryptedOriginalAPIKey,
 			&service.PlatformProxyPrefix, &service.IsActive, 
 			&service.Category, &service.Rating, &service.ReviewCount, &service.PricePerCall, 
-			&service.PricingModel, &service.PricePerToken, &service.TotalCalls, 
+			&service.PricingModel, &service.PricePerToken, &service.PricingPlan, &service.BillingPolicy, &service.RateLimits, &service.TotalCalls, 
 			&service.SubscriberCount, &service.Features, &service.Documentation,
 			&service.CreatedAt, &service.UpdatedAt)
 		if err != nil {
@@ -124,14 +135,21 @@ func (as *APIServiceStore) GetAPIServiceByID(serviceID int64) (*model.APIService
 			COALESCE(pricing_model, 'per_call') as pricing_model,
 			COALESCE(price_per_call, 0.0) as price_per_call,
 			COALESCE(price_per_token, 0.0) as price_per_token,
-			created_at, updated_at
+			pricing_plan,
+			COALESCE(api_format, 'openai') as api_format,
+			COALESCE(lb_strategy, 'weighted_round_robin') as lb_strategy,
+			COALESCE(connect_timeout_ms, 0) as connect_timeout_ms,
+			COALESCE(response_header_timeout_ms, 0) as response_header_timeout_ms,
+			COALESCE(idle_conn_timeout_ms, 0) as idle_conn_timeout_ms,
+			auth_scheme, billing_policy, rate_limits, created_at, updated_at
 		FROM api_services WHERE service_id = $1`
 
 	err := as.DB.QueryRow(query, serviceID).Scan(&service.ServiceID, &service.SellerUserID,
 		&service.Name, &service.Description, &service.OriginalEndpointURL,
 		&service.EncryptedOriginalAPIKey, &service.PlatformProxyPrefix, &service.IsActive,
-		&service.PricingModel, &service.PricePerCall, &service.PricePerToken,
-		&service.CreatedAt, &service.UpdatedAt)
+		&service.PricingModel, &service.PricePerCall, &service.PricePerToken, &service.PricingPlan, &service.APIFormat,
+		&service.LBStrategy, &service.ConnectTimeoutMs, &service.ResponseHeaderTimeoutMs, &service.IdleConnTimeoutMs,
+		&service.AuthScheme, &service.BillingPolicy, &service.RateLimits, &service.CreatedAt, &service.UpdatedAt)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // 返回 nil, nil 而不是错误
@@ -157,12 +175,16 @@ func (as *APIServiceStore) UpdateAPIService(serviceID int64, service *model.APIS
 		UPDATE api_services 
 		SET name = $1, description = $2, original_endpoint_url = $3, 
 			encrypted_original_api_key = $4, is_active = $5, pricing_model = $6,
-			price_per_call = $7, price_per_token = $8, updated_at = NOW()
-		WHERE service_id = $9 AND seller_user_id = $10`
+			price_per_call = $7, price_per_token = $8, api_format = $9, lb_strategy = $10,
+			connect_timeout_ms = NULLIF($11, 0), response_header_timeout_ms = NULLIF($12, 0),
+			idle_conn_timeout_ms = NULLIF($13, 0), auth_scheme = $14, updated_at = NOW()
+		WHERE service_id = $15 AND seller_user_id = $16`
 
 	_, err := as.DB.Exec(query, service.Name, service.Description, service.OriginalEndpointURL,
 		service.EncryptedOriginalAPIKey, service.IsActive, service.PricingModel,
-		service.PricePerCall, service.PricePerToken, serviceID, service.SellerUserID)
+		service.PricePerCall, service.PricePerToken, service.APIFormat, service.LBStrategy,
+		service.ConnectTimeoutMs, service.ResponseHeaderTimeoutMs, service.IdleConnTimeoutMs, service.AuthScheme,
+		serviceID, service.SellerUserID)
 	if err != nil {
 		return fmt.Errorf("failed to update API service: %w", err)
 	}
@@ -203,13 +225,14 @@ func (as *APIServiceStore) DeleteAPIService(serviceID int64, sellerUserID int64)
 }
 
 // UpdateAPIPricing updates the pricing settings for an API service
-func (as *APIServiceStore) UpdateAPIPricing(serviceID int64, sellerUserID int64, pricingModel string, pricePerCall, pricePerToken float64) error {
+// pricingPlan 为 nil 时清除阶梯定价，服务按固定价格计费
+func (as *APIServiceStore) UpdateAPIPricing(serviceID int64, sellerUserID int64, pricingModel string, pricePerCall, pricePerToken float64, pricingPlan *model.PricingPlan) error {
 	query := `
 		UPDATE api_services 
-		SET pricing_model = $1, price_per_call = $2, price_per_token = $3, updated_at = NOW()
-		WHERE service_id = $4 AND seller_user_id = $5`
+		SET pricing_model = $1, price_per_call = $2, price_per_token = $3, pricing_plan = $4, updated_at = NOW()
+		WHERE service_id = $5 AND seller_user_id = $6`
 
-	result, err := as.DB.Exec(query, pricingModel, pricePerCall, pricePerToken, serviceID, sellerUserID)
+	result, err := as.DB.Exec(query, pricingModel, pricePerCall, pricePerToken, pricingPlan, serviceID, sellerUserID)
 	if err != nil {
 		return fmt.Errorf("failed to update API pricing: %w", err)
 	}
@@ -223,5 +246,53 @@ func (as *APIServiceStore) UpdateAPIPricing(serviceID int64, sellerUserID int64,
 		return fmt.Errorf("API service not found or not owned by user")
 	}
 
+	return nil
+}
+
+// UpdateBillingPolicy 更新服务的计费策略（可计费状态码、计费时限与 SLA），只影响之后的调用和未评估的月份
+func (as *APIServiceStore) UpdateBillingPolicy(serviceID int64, sellerUserID int64, policy model.BillingPolicy) error {
+	query := `
+		UPDATE api_services
+		SET billing_policy = $1, updated_at = NOW()
+		WHERE service_id = $2 AND seller_user_id = $3`
+
+	result, err := as.DB.Exec(query, policy, serviceID, sellerUserID)
+	if err != nil {
+		return fmt.Errorf("failed to update billing policy: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("API service not found or not owned by user")
+	}
+
+	return nil
+}
+
+// UpdateRateLimits 更新卖家为服务设置的代理限流（服务整体和每个订阅者的每分钟请求数）
+func (as *APIServiceStore) UpdateRateLimits(serviceID int64, sellerUserID int64, limits model.ServiceRateLimits) error {
+	query := `
+		UPDATE api_services
+		SET rate_limits = $1, updated_at = NOW()
+		WHERE service_id = $2 AND seller_user_id = $3`
+
+	result, err := as.DB.Exec(query, limits, serviceID, sellerUserID)
+	if err != nil {
+		return fmt.Errorf("failed to update rate limits: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+
+	if rowsAffected == 0 {
+		return fmt.Errorf("API service not found or not owned by user")
+	}
+
 	return nil
 }
\ No newline at end of file
@@ -0,0 +1,97 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+)
+
+// CommissionStore 处理平台抽成比例相关的数据库操作
+type CommissionStore struct {
+	*Store
+}
+
+// NewCommissionStore 创建抽成比例存储实例
+func NewCommissionStore(store *Store) *CommissionStore {
+	return &CommissionStore{Store: store}
+}
+
+// ResolveRateBps 获取服务适用的抽成比例：卖家比例优先，其次是服务分类比例，最后是全局比例
+// 没有任何匹配的配置时 found 为 false，调用方使用平台默认比例。
+func (cs *CommissionStore) ResolveRateBps(serviceID int64) (int, bool, error) {
+	query := `
+		SELECT cr.rate_bps
+		FROM api_services s
+		JOIN commission_rates cr ON (cr.scope = 'seller' AND cr.seller_user_id = s.seller_user_id)
+			OR (cr.scope = 'category' AND cr.category = s.category)
+			OR cr.scope = 'global'
+		WHERE s.service_id = $1
+		ORDER BY CASE cr.scope WHEN 'seller' THEN 0 WHEN 'category' THEN 1 ELSE 2 END
+		LIMIT 1`
+
+	var rateBps int
+	err := cs.DB.QueryRow(query, serviceID).Scan(&rateBps)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to resolve commission rate: %w", err)
+	}
+	return rateBps, true, nil
+}
+
+// ListRates 获取所有抽成比例配置
+func (cs *CommissionStore) ListRates() ([]*model.CommissionRate, error) {
+	query := `
+		SELECT rate_id, scope, seller_user_id, COALESCE(category, ''), rate_bps, created_at, updated_at
+		FROM commission_rates
+		ORDER BY CASE scope WHEN 'global' THEN 0 WHEN 'category' THEN 1 ELSE 2 END, category, seller_user_id`
+
+	rows, err := cs.DB.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commission rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []*model.CommissionRate
+	for rows.Next() {
+		rate := &model.CommissionRate{}
+		if err := rows.Scan(&rate.RateID, &rate.Scope, &rate.SellerUserID, &rate.Category, &rate.RateBps,
+			&rate.CreatedAt, &rate.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan commission rate: %w", err)
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+// UpsertRate 设置抽成比例，同一作用范围和对象已有配置时覆盖
+func (cs *CommissionStore) UpsertRate(rate *model.CommissionRate) error {
+	query := `
+		INSERT INTO commission_rates (scope, seller_user_id, category, rate_bps)
+		VALUES ($1, $2, NULLIF($3, ''), $4)
+		ON CONFLICT (scope, (COALESCE(seller_user_id, 0)), (COALESCE(category, '')))
+		DO UPDATE SET rate_bps = EXCLUDED.rate_bps
+		RETURNING rate_id, created_at, updated_at`
+
+	err := cs.DB.QueryRow(query, rate.Scope, rate.SellerUserID, rate.Category, rate.RateBps).
+		Scan(&rate.RateID, &rate.CreatedAt, &rate.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save commission rate: %w", err)
+	}
+	return nil
+}
+
+// DeleteRate 删除抽成比例配置，配置不存在时返回 false
+func (cs *CommissionStore) DeleteRate(rateID int64) (bool, error) {
+	result, err := cs.DB.Exec(`DELETE FROM commission_rates WHERE rate_id = $1`, rateID)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete commission rate: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
@@ -0,0 +1,158 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// FallbackChainStore 买家回退链的数据库操作
+type FallbackChainStore struct {
+	*Store
+}
+
+// NewFallbackChainStore 创建回退链存储实例
+func NewFallbackChainStore(store *Store) *FallbackChainStore {
+	return &FallbackChainStore{Store: store}
+}
+
+// fallbackChainColumns 回退链查询使用的列
+const fallbackChainColumns = `chain_id, buyer_user_id, name, COALESCE(model_id, ''), service_id,
+	service_ids, is_active, created_at, updated_at`
+
+// scanFallbackChain 从查询结果中扫描一条回退链记录
+func scanFallbackChain(scanner interface{ Scan(...interface{}) error }) (*model.FallbackChain, error) {
+	chain := &model.FallbackChain{}
+	var serviceID sql.NullInt64
+	var serviceIDs pq.Int64Array
+	err := scanner.Scan(&chain.ChainID, &chain.BuyerUserID, &chain.Name, &chain.ModelID, &serviceID,
+		&serviceIDs, &chain.IsActive, &chain.CreatedAt, &chain.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if serviceID.Valid {
+		chain.ServiceID = &serviceID.Int64
+	}
+	chain.ServiceIDs = []int64(serviceIDs)
+	return chain, nil
+}
+
+// CreateFallbackChain 创建回退链
+func (fc *FallbackChainStore) CreateFallbackChain(chain *model.FallbackChain) error {
+	query := `
+		INSERT INTO buyer_fallback_chains (buyer_user_id, name, model_id, service_id, service_ids,
+			is_active, created_at, updated_at)
+		VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, NOW(), NOW())
+		RETURNING chain_id, created_at, updated_at`
+
+	err := fc.DB.QueryRow(query, chain.BuyerUserID, chain.Name, chain.ModelID, chain.ServiceID,
+		pq.Array(chain.ServiceIDs), chain.IsActive).Scan(&chain.ChainID, &chain.CreatedAt, &chain.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create fallback chain: %w", err)
+	}
+	return nil
+}
+
+// UpdateFallbackChain 更新买家的回退链
+func (fc *FallbackChainStore) UpdateFallbackChain(chain *model.FallbackChain) error {
+	query := `
+		UPDATE buyer_fallback_chains
+		SET name = $1, model_id = NULLIF($2, ''), service_id = $3, service_ids = $4, is_active = $5, updated_at = NOW()
+		WHERE chain_id = $6 AND buyer_user_id = $7
+		RETURNING updated_at`
+
+	err := fc.DB.QueryRow(query, chain.Name, chain.ModelID, chain.ServiceID, pq.Array(chain.ServiceIDs),
+		chain.IsActive, chain.ChainID, chain.BuyerUserID).Scan(&chain.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("fallback chain not found")
+		}
+		return fmt.Errorf("failed to update fallback chain: %w", err)
+	}
+	return nil
+}
+
+// DeleteFallbackChain 删除买家的回退链
+func (fc *FallbackChainStore) DeleteFallbackChain(chainID, buyerUserID int64) error {
+	result, err := fc.DB.Exec(`DELETE FROM buyer_fallback_chains WHERE chain_id = $1 AND buyer_user_id = $2`,
+		chainID, buyerUserID)
+	if err != nil {
+		return fmt.Errorf("failed to delete fallback chain: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("fallback chain not found")
+	}
+	return nil
+}
+
+// GetFallbackChainByID 获取买家的指定回退链，不存在时返回 nil, nil
+func (fc *FallbackChainStore) GetFallbackChainByID(chainID, buyerUserID int64) (*model.FallbackChain, error) {
+	query := `SELECT ` + fallbackChainColumns + ` FROM buyer_fallback_chains WHERE chain_id = $1 AND buyer_user_id = $2`
+
+	chain, err := scanFallbackChain(fc.DB.QueryRow(query, chainID, buyerUserID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get fallback chain: %w", err)
+	}
+	return chain, nil
+}
+
+// GetFallbackChainsByBuyerID 获取买家的所有回退链
+func (fc *FallbackChainStore) GetFallbackChainsByBuyerID(buyerUserID int64) ([]*model.FallbackChain, error) {
+	query := `SELECT ` + fallbackChainColumns + ` FROM buyer_fallback_chains WHERE buyer_user_id = $1 ORDER BY created_at DESC`
+
+	rows, err := fc.DB.Query(query, buyerUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get fallback chains: %w", err)
+	}
+	defer rows.Close()
+
+	var chains []*model.FallbackChain
+	for rows.Next() {
+		chain, err := scanFallbackChain(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan fallback chain: %w", err)
+		}
+		chains = append(chains, chain)
+	}
+	return chains, nil
+}
+
+// FindChainForModel 查找买家为指定模型启用的回退链，不存在时返回 nil, nil
+func (fc *FallbackChainStore) FindChainForModel(buyerUserID int64, modelID string) (*model.FallbackChain, error) {
+	query := `SELECT ` + fallbackChainColumns + ` FROM buyer_fallback_chains
+		WHERE buyer_user_id = $1 AND model_id = $2 AND is_active = true`
+
+	chain, err := scanFallbackChain(fc.DB.QueryRow(query, buyerUserID, modelID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find fallback chain for model: %w", err)
+	}
+	return chain, nil
+}
+
+// FindChainForService 查找买家为指定服务启用的回退链，不存在时返回 nil, nil
+func (fc *FallbackChainStore) FindChainForService(buyerUserID, serviceID int64) (*model.FallbackChain, error) {
+	query := `SELECT ` + fallbackChainColumns + ` FROM buyer_fallback_chains
+		WHERE buyer_user_id = $1 AND service_id = $2 AND is_active = true`
+
+	chain, err := scanFallbackChain(fc.DB.QueryRow(query, buyerUserID, serviceID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find fallback chain for service: %w", err)
+	}
+	return chain, nil
+}
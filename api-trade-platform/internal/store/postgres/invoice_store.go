@@ -0,0 +1,425 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// InvoiceStore 处理买家发票和贷项通知单相关的数据库操作
+// 发票根据账单月份内创建的计费与退款分录生成草稿，开具后不可修改（数据库触发器同样拒绝修改），更正通过贷项通知单完成。
+type InvoiceStore struct {
+	*Store
+}
+
+// NewInvoiceStore 创建发票存储实例
+func NewInvoiceStore(store *Store) *InvoiceStore {
+	return &InvoiceStore{Store: store}
+}
+
+// InvoiceDefaults 生成发票草稿时写入的开票方信息和默认税率
+type InvoiceDefaults struct {
+	IssuerName  string
+	IssuerTaxID string
+	TaxRateBps  int
+}
+
+// invoiceColumns 查询发票时使用的列，与 scanInvoice 的顺序一致
+const invoiceColumns = `invoice_id, invoice_type, COALESCE(invoice_number, ''), buyer_user_id, original_invoice_id,
+	period_start, period_end, currency, issuer_name, issuer_tax_id, buyer_name, buyer_email,
+	subtotal_micros, discount_micros, tax_rate_bps, tax_micros, total_micros, status, notes,
+	COALESCE(payment_reference, ''), issued_at, paid_at, voided_at, created_at, updated_at`
+
+// scanInvoice 扫描 invoiceColumns 查询出的一行发票
+func scanInvoice(scanner interface{ Scan(...interface{}) error }) (*model.Invoice, error) {
+	invoice := &model.Invoice{}
+	err := scanner.Scan(&invoice.InvoiceID, &invoice.InvoiceType, &invoice.InvoiceNumber, &invoice.BuyerUserID,
+		&invoice.OriginalInvoiceID, &invoice.PeriodStart, &invoice.PeriodEnd, &invoice.Currency,
+		&invoice.IssuerName, &invoice.IssuerTaxID, &invoice.BuyerName, &invoice.BuyerEmail,
+		&invoice.SubtotalMicros, &invoice.DiscountMicros, &invoice.TaxRateBps, &invoice.TaxMicros,
+		&invoice.TotalMicros, &invoice.Status, &invoice.Notes, &invoice.PaymentReference,
+		&invoice.IssuedAt, &invoice.PaidAt, &invoice.VoidedAt, &invoice.CreatedAt, &invoice.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// insertInvoice 在事务中保存发票及其明细，发票已存在（唯一索引冲突）时返回 false
+func insertInvoice(tx *sql.Tx, invoice *model.Invoice) (bool, error) {
+	query := `
+		INSERT INTO invoices (invoice_type, buyer_user_id, original_invoice_id, period_start, period_end,
+			issuer_name, issuer_tax_id, buyer_name, buyer_email, subtotal_micros, discount_micros,
+			tax_rate_bps, tax_micros, total_micros, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		ON CONFLICT DO NOTHING
+		RETURNING invoice_id, currency, status, created_at, updated_at`
+
+	err := tx.QueryRow(query, invoice.InvoiceType, invoice.BuyerUserID, invoice.OriginalInvoiceID,
+		invoice.PeriodStart, invoice.PeriodEnd, invoice.IssuerName, invoice.IssuerTaxID, invoice.BuyerName,
+		invoice.BuyerEmail, invoice.SubtotalMicros, invoice.DiscountMicros, invoice.TaxRateBps,
+		invoice.TaxMicros, invoice.TotalMicros, invoice.Notes).
+		Scan(&invoice.InvoiceID, &invoice.Currency, &invoice.Status, &invoice.CreatedAt, &invoice.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create invoice: %w", err)
+	}
+
+	for _, item := range invoice.Items {
+		_, err := tx.Exec(`
+			INSERT INTO invoice_items (invoice_id, line_no, service_id, service_name, model_name, description,
+				call_count, total_tokens, charged_micros, refunded_micros, amount_micros)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			invoice.InvoiceID, item.LineNo, item.ServiceID, item.ServiceName, item.ModelName, item.Description,
+			item.CallCount, item.TotalTokens, item.ChargedMicros, item.RefundedMicros, item.AmountMicros)
+		if err != nil {
+			return false, fmt.Errorf("failed to create invoice item: %w", err)
+		}
+	}
+	return true, nil
+}
+
+// GenerateInvoices 为账单月份内有计费、退款或 SLA 补偿的买家生成发票草稿，已有未作废发票的买家保持不变，返回新生成的发票ID
+func (is *InvoiceStore) GenerateInvoices(periodStart, periodEnd time.Time, defaults InvoiceDefaults) ([]int64, error) {
+	buyersQuery := `
+		SELECT DISTINCT a.owner_user_id
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		JOIN ledger_accounts a ON a.account_id = p.account_id
+		WHERE a.account_type = 'buyer_wallet'
+			AND e.entry_type IN ('usage_charge', 'refund', 'sla_credit')
+			AND e.created_at >= $1 AND e.created_at < $2
+			AND NOT EXISTS (
+				SELECT 1 FROM invoices i
+				WHERE i.buyer_user_id = a.owner_user_id AND i.period_start = $1
+					AND i.invoice_type = 'invoice' AND i.status <> 'void'
+			)`
+
+	rows, err := is.DB.Query(buyersQuery, periodStart, periodEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find buyers for invoices: %w", err)
+	}
+	var buyerIDs []int64
+	for rows.Next() {
+		var buyerID int64
+		if err := rows.Scan(&buyerID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan buyer: %w", err)
+		}
+		buyerIDs = append(buyerIDs, buyerID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find buyers for invoices: %w", err)
+	}
+
+	var invoiceIDs []int64
+	for _, buyerID := range buyerIDs {
+		invoiceID, err := is.generateInvoice(buyerID, periodStart, periodEnd, defaults)
+		if err != nil {
+			return invoiceIDs, err
+		}
+		if invoiceID != 0 {
+			invoiceIDs = append(invoiceIDs, invoiceID)
+		}
+	}
+	return invoiceIDs, nil
+}
+
+// generateInvoice 按服务和模型汇总买家在账单月份内的分录并保存发票草稿，发票已存在时返回 0
+func (is *InvoiceStore) generateInvoice(buyerID int64, periodStart, periodEnd time.Time, defaults InvoiceDefaults) (int64, error) {
+	tx, err := is.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	invoice := &model.Invoice{
+		InvoiceType: model.InvoiceTypeInvoice,
+		BuyerUserID: buyerID,
+		PeriodStart: periodStart,
+		PeriodEnd:   periodEnd,
+		IssuerName:  defaults.IssuerName,
+		IssuerTaxID: defaults.IssuerTaxID,
+		TaxRateBps:  defaults.TaxRateBps,
+	}
+	err = tx.QueryRow(`SELECT username, email FROM users WHERE user_id = $1`, buyerID).
+		Scan(&invoice.BuyerName, &invoice.BuyerEmail)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to get invoice buyer: %w", err)
+	}
+
+	// 每条分录只有一笔买家钱包记账，计费和退款分录通过 reference_id 关联使用日志以取得模型和 token 数，SLA 补偿单独成行
+	itemsQuery := `
+		SELECT COALESCE(e.service_id, 0), COALESCE(s.name, ''), COALESCE(ul.model_name, ''),
+			COUNT(*) FILTER (WHERE e.entry_type = 'usage_charge'),
+			COALESCE(SUM(ul.total_tokens) FILTER (WHERE e.entry_type = 'usage_charge'), 0),
+			COALESCE(SUM(-p.amount_micros) FILTER (WHERE e.entry_type = 'usage_charge'), 0),
+			COALESCE(SUM(p.amount_micros) FILTER (WHERE e.entry_type IN ('refund', 'sla_credit')), 0)
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		JOIN ledger_accounts a ON a.account_id = p.account_id
+		LEFT JOIN usage_logs ul ON ul.log_id = e.reference_id AND e.entry_type IN ('usage_charge', 'refund')
+		LEFT JOIN api_services s ON s.service_id = e.service_id
+		WHERE a.account_type = 'buyer_wallet' AND a.owner_user_id = $1
+			AND e.entry_type IN ('usage_charge', 'refund', 'sla_credit')
+			AND e.created_at >= $2 AND e.created_at < $3
+		GROUP BY COALESCE(e.service_id, 0), s.name, COALESCE(ul.model_name, '')
+		ORDER BY 1, 3`
+
+	rows, err := tx.Query(itemsQuery, buyerID, periodStart, periodEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to summarize invoice: %w", err)
+	}
+	for rows.Next() {
+		var item model.InvoiceItem
+		if err := rows.Scan(&item.ServiceID, &item.ServiceName, &item.ModelName, &item.CallCount,
+			&item.TotalTokens, &item.ChargedMicros, &item.RefundedMicros); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan invoice item: %w", err)
+		}
+		item.LineNo = len(invoice.Items) + 1
+		item.AmountMicros = item.ChargedMicros - item.RefundedMicros
+		item.Description = invoiceItemDescription(item)
+		invoice.Items = append(invoice.Items, item)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to summarize invoice: %w", err)
+	}
+	invoice.ApplyTotals()
+
+	created, err := insertInvoice(tx, invoice)
+	if err != nil || !created {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit invoice: %w", err)
+	}
+	return invoice.InvoiceID, nil
+}
+
+// invoiceItemDescription 生成明细的说明，例如 "OpenAI Proxy - gpt-4o-mini"
+func invoiceItemDescription(item model.InvoiceItem) string {
+	name := item.ServiceName
+	if name == "" {
+		name = fmt.Sprintf("Service #%d", item.ServiceID)
+	}
+	if item.ModelName != "" {
+		name += " - " + item.ModelName
+	}
+	return name
+}
+
+// ListInvoices 获取发票列表（不含明细），buyerUserID 为 0 时不限买家，status 和 invoiceType 为空时不限，
+// includeDrafts 为 false 时不返回草稿和作废的草稿
+func (is *InvoiceStore) ListInvoices(buyerUserID int64, status, invoiceType string, includeDrafts bool) ([]*model.Invoice, error) {
+	query := `SELECT ` + invoiceColumns + `
+		FROM invoices
+		WHERE ($1::bigint = 0 OR buyer_user_id = $1) AND ($2::text = '' OR status = $2)
+			AND ($3::text = '' OR invoice_type = $3) AND ($4::boolean OR issued_at IS NOT NULL)
+		ORDER BY period_start DESC, invoice_id DESC`
+
+	rows, err := is.DB.Query(query, buyerUserID, status, invoiceType, includeDrafts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list invoices: %w", err)
+	}
+	defer rows.Close()
+
+	var invoices []*model.Invoice
+	for rows.Next() {
+		invoice, err := scanInvoice(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan invoice: %w", err)
+		}
+		invoices = append(invoices, invoice)
+	}
+	return invoices, rows.Err()
+}
+
+// GetInvoice 获取发票及其明细，不存在时返回 nil, nil
+func (is *InvoiceStore) GetInvoice(invoiceID int64) (*model.Invoice, error) {
+	query := `SELECT ` + invoiceColumns + ` FROM invoices WHERE invoice_id = $1`
+	invoice, err := scanInvoice(is.DB.QueryRow(query, invoiceID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get invoice: %w", err)
+	}
+
+	rows, err := is.DB.Query(`
+		SELECT line_no, service_id, service_name, model_name, description, call_count, total_tokens,
+			charged_micros, refunded_micros, amount_micros
+		FROM invoice_items
+		WHERE invoice_id = $1
+		ORDER BY line_no`, invoiceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invoice items: %w", err)
+	}
+	defer rows.Close()
+
+	invoice.Items = []model.InvoiceItem{}
+	for rows.Next() {
+		var item model.InvoiceItem
+		if err := rows.Scan(&item.LineNo, &item.ServiceID, &item.ServiceName, &item.ModelName, &item.Description,
+			&item.CallCount, &item.TotalTokens, &item.ChargedMicros, &item.RefundedMicros, &item.AmountMicros); err != nil {
+			return nil, fmt.Errorf("failed to scan invoice item: %w", err)
+		}
+		invoice.Items = append(invoice.Items, item)
+	}
+	return invoice, rows.Err()
+}
+
+// UpdateDraft 保存草稿的折扣、税率、备注和重新计算的金额，发票不是草稿时返回 false
+func (is *InvoiceStore) UpdateDraft(invoice *model.Invoice) (bool, error) {
+	result, err := is.DB.Exec(`
+		UPDATE invoices
+		SET discount_micros = $2, tax_rate_bps = $3, tax_micros = $4, total_micros = $5, notes = $6
+		WHERE invoice_id = $1 AND status = 'draft'`,
+		invoice.InvoiceID, invoice.DiscountMicros, invoice.TaxRateBps, invoice.TaxMicros, invoice.TotalMicros, invoice.Notes)
+	if err != nil {
+		return false, fmt.Errorf("failed to update invoice draft: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// IssueInvoice 开具草稿并分配连续编号（发票 INV-年份-序号，贷项通知单 CN-年份-序号），发票不是草稿时返回 false
+func (is *InvoiceStore) IssueInvoice(invoiceID int64) (bool, error) {
+	result, err := is.DB.Exec(`
+		UPDATE invoices
+		SET status = 'issued', issued_at = NOW(),
+			invoice_number = CASE WHEN invoice_type = 'invoice' THEN 'INV-' ELSE 'CN-' END
+				|| to_char(NOW() AT TIME ZONE 'UTC', 'YYYY') || '-'
+				|| lpad(nextval((CASE WHEN invoice_type = 'invoice' THEN 'invoice_number_seq' ELSE 'credit_note_number_seq' END)::regclass)::text, 6, '0')
+		WHERE invoice_id = $1 AND status = 'draft'`, invoiceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to issue invoice: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// MarkInvoicePaid 将已开具的发票标记为已支付，发票不是已开具状态时返回 false
+func (is *InvoiceStore) MarkInvoicePaid(invoiceID int64, paymentReference string) (bool, error) {
+	result, err := is.DB.Exec(`
+		UPDATE invoices
+		SET status = 'paid', payment_reference = $2, paid_at = NOW()
+		WHERE invoice_id = $1 AND status = 'issued'`, invoiceID, paymentReference)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark invoice paid: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// VoidInvoice 作废草稿，作废后可以为同一月份重新生成发票；发票不是草稿时返回 false
+func (is *InvoiceStore) VoidInvoice(invoiceID int64) (bool, error) {
+	result, err := is.DB.Exec(`
+		UPDATE invoices
+		SET status = 'void', voided_at = NOW()
+		WHERE invoice_id = $1 AND status = 'draft'`, invoiceID)
+	if err != nil {
+		return false, fmt.Errorf("failed to void invoice: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	return rowsAffected > 0, nil
+}
+
+// creditedMicros 获取发票所有未作废贷项通知单已冲减的不含税金额
+func creditedMicros(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, invoiceID int64) (int64, error) {
+	var credited int64
+	err := q.QueryRow(`
+		SELECT COALESCE(SUM(subtotal_micros - discount_micros), 0) FROM invoices
+		WHERE original_invoice_id = $1 AND status <> 'void'`, invoiceID).Scan(&credited)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get credited amount: %w", err)
+	}
+	return credited, nil
+}
+
+// GetCreditableMicros 获取发票还可以通过贷项通知单冲减的不含税金额
+func (is *InvoiceStore) GetCreditableMicros(invoice *model.Invoice) (int64, error) {
+	credited, err := creditedMicros(is.DB, invoice.InvoiceID)
+	if err != nil {
+		return 0, err
+	}
+	return invoice.NetMicros() - credited, nil
+}
+
+// CreateCreditNote 为已开具或已支付的发票创建贷项通知单草稿，按原发票税率计税
+// 锁定原发票以串行化同一发票的贷项通知单，冲减合计超过原发票折扣后金额时返回错误。
+func (is *InvoiceStore) CreateCreditNote(originalInvoiceID, amountMicros int64, reason string) (*model.Invoice, error) {
+	tx, err := is.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	original, err := scanInvoice(tx.QueryRow(`SELECT `+invoiceColumns+`
+		FROM invoices
+		WHERE invoice_id = $1 AND invoice_type = 'invoice' AND status IN ('issued', 'paid')
+		FOR UPDATE`, originalInvoiceID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("invoice %d is not an issued invoice", originalInvoiceID)
+		}
+		return nil, fmt.Errorf("failed to lock invoice: %w", err)
+	}
+
+	credited, err := creditedMicros(tx, originalInvoiceID)
+	if err != nil {
+		return nil, err
+	}
+	if creditable := original.NetMicros() - credited; amountMicros <= 0 || amountMicros > creditable {
+		return nil, fmt.Errorf("credit of %d exceeds the creditable amount %d", amountMicros, creditable)
+	}
+
+	reason = strings.TrimSpace(reason)
+	creditNote := &model.Invoice{
+		InvoiceType:       model.InvoiceTypeCreditNote,
+		BuyerUserID:       original.BuyerUserID,
+		OriginalInvoiceID: &original.InvoiceID,
+		PeriodStart:       original.PeriodStart,
+		PeriodEnd:         original.PeriodEnd,
+		IssuerName:        original.IssuerName,
+		IssuerTaxID:       original.IssuerTaxID,
+		BuyerName:         original.BuyerName,
+		BuyerEmail:        original.BuyerEmail,
+		TaxRateBps:        original.TaxRateBps,
+		Notes:             reason,
+		Items: []model.InvoiceItem{{
+			LineNo:       1,
+			Description:  fmt.Sprintf("Credit for %s: %s", original.InvoiceNumber, reason),
+			AmountMicros: amountMicros,
+		}},
+	}
+	creditNote.ApplyTotals()
+
+	if _, err := insertInvoice(tx, creditNote); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit credit note: %w", err)
+	}
+	return creditNote, nil
+}
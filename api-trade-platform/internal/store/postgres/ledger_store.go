@@ -0,0 +1,422 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// LedgerStore 处理复式记账账本相关的数据库操作
+// 分录只能追加：钱包充值、调用结算等资金变动在各自的事务中通过 postLedgerEntry 写入分录。
+type LedgerStore struct {
+	*Store
+}
+
+// NewLedgerStore 创建账本存储实例
+func NewLedgerStore(store *Store) *LedgerStore {
+	return &LedgerStore{Store: store}
+}
+
+// ledgerLine 待写入分录的一条记账，ownerUserID 为 0 表示平台账户
+type ledgerLine struct {
+	accountType  string
+	ownerUserID  int64
+	amountMicros int64
+}
+
+// ledgerAccountID 获取账户ID，账户不存在时创建
+func ledgerAccountID(tx *sql.Tx, accountType string, ownerUserID int64) (int64, error) {
+	var owner interface{}
+	if ownerUserID != 0 {
+		owner = ownerUserID
+	}
+
+	selectQuery := `SELECT account_id FROM ledger_accounts WHERE account_type = $1 AND COALESCE(owner_user_id, 0) = $2`
+	var accountID int64
+	err := tx.QueryRow(selectQuery, accountType, ownerUserID).Scan(&accountID)
+	if err == nil {
+		return accountID, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to get ledger account: %w", err)
+	}
+
+	// 并发创建同一账户时以先提交的为准
+	err = tx.QueryRow(`
+		INSERT INTO ledger_accounts (account_type, owner_user_id)
+		VALUES ($1, $2)
+		ON CONFLICT (account_type, (COALESCE(owner_user_id, 0))) DO NOTHING
+		RETURNING account_id`, accountType, owner).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		err = tx.QueryRow(selectQuery, accountType, ownerUserID).Scan(&accountID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to create ledger account: %w", err)
+	}
+	return accountID, nil
+}
+
+// postLedgerEntry 在事务中写入一条分录及其记账，金额为 0 的记账会被忽略
+// 各记账金额之和必须为 0，数据库在事务提交时也会再次校验。
+func postLedgerEntry(tx *sql.Tx, entry *model.LedgerEntry, lines []ledgerLine) error {
+	var sum int64
+	for _, line := range lines {
+		sum += line.amountMicros
+	}
+	if sum != 0 {
+		return fmt.Errorf("ledger entry %s is not balanced: postings sum to %d", entry.EntryType, sum)
+	}
+
+	query := `
+		INSERT INTO ledger_entries (entry_type, reference_id, service_id, description, created_by_user_id)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		RETURNING entry_id, created_at`
+	err := tx.QueryRow(query, entry.EntryType, entry.ReferenceID, entry.ServiceID, entry.Description, entry.CreatedByUserID).
+		Scan(&entry.EntryID, &entry.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create ledger entry: %w", err)
+	}
+
+	entry.Postings = entry.Postings[:0]
+	for _, line := range lines {
+		if line.amountMicros == 0 {
+			continue
+		}
+		accountID, err := ledgerAccountID(tx, line.accountType, line.ownerUserID)
+		if err != nil {
+			return err
+		}
+		posting := model.LedgerPosting{AccountID: accountID, AmountMicros: line.amountMicros}
+		err = tx.QueryRow(`
+			INSERT INTO ledger_postings (entry_id, account_id, amount_micros)
+			VALUES ($1, $2, $3)
+			RETURNING posting_id`, entry.EntryID, accountID, line.amountMicros).Scan(&posting.PostingID)
+		if err != nil {
+			return fmt.Errorf("failed to create ledger posting: %w", err)
+		}
+		entry.Postings = append(entry.Postings, posting)
+	}
+	return nil
+}
+
+// usageChargeLines 计费调用的记账：买家钱包支付全部费用，平台按抽成比例获得收入，其余计入卖家收入
+func usageChargeLines(charge *model.UsageCharge) []ledgerLine {
+	commission := charge.CommissionMicros()
+	return []ledgerLine{
+		{accountType: model.LedgerAccountBuyerWallet, ownerUserID: charge.BuyerUserID, amountMicros: -charge.AmountMicros},
+		{accountType: model.LedgerAccountSellerEarnings, ownerUserID: charge.SellerUserID, amountMicros: charge.AmountMicros - commission},
+		{accountType: model.LedgerAccountPlatformRevenue, amountMicros: commission},
+	}
+}
+
+// ListAccounts 获取账本账户及其余额，accountType 为空时返回所有类型
+func (ls *LedgerStore) ListAccounts(accountType string) ([]*model.LedgerAccount, error) {
+	query := `
+		SELECT a.account_id, a.account_type, a.owner_user_id, a.currency,
+			COALESCE(SUM(p.amount_micros), 0) AS balance_micros, a.created_at
+		FROM ledger_accounts a
+		LEFT JOIN ledger_postings p ON p.account_id = a.account_id
+		WHERE $1 = '' OR a.account_type = $1
+		GROUP BY a.account_id
+		ORDER BY a.account_type, a.owner_user_id NULLS FIRST`
+
+	rows, err := ls.DB.Query(query, accountType)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ledger accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var accounts []*model.LedgerAccount
+	for rows.Next() {
+		account := &model.LedgerAccount{}
+		if err := rows.Scan(&account.AccountID, &account.AccountType, &account.OwnerUserID, &account.Currency,
+			&account.BalanceMicros, &account.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger account: %w", err)
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// ListAccountEntries 获取记入某个账户的分录（包含分录的全部记账），按时间倒序
+func (ls *LedgerStore) ListAccountEntries(accountID int64, limit int) ([]*model.LedgerEntry, error) {
+	query := `
+		SELECT e.entry_id, e.entry_type, e.reference_id, e.service_id, COALESCE(e.description, ''), e.created_by_user_id, e.created_at,
+			p.posting_id, p.account_id, p.amount_micros
+		FROM (
+			SELECT DISTINCT e.entry_id
+			FROM ledger_entries e
+			JOIN ledger_postings p ON p.entry_id = e.entry_id
+			WHERE p.account_id = $1
+			ORDER BY e.entry_id DESC
+			LIMIT $2
+		) recent
+		JOIN ledger_entries e ON e.entry_id = recent.entry_id
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		ORDER BY e.entry_id DESC, p.posting_id`
+
+	rows, err := ls.DB.Query(query, accountID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*model.LedgerEntry
+	for rows.Next() {
+		entry := &model.LedgerEntry{}
+		var posting model.LedgerPosting
+		if err := rows.Scan(&entry.EntryID, &entry.EntryType, &entry.ReferenceID, &entry.ServiceID, &entry.Description,
+			&entry.CreatedByUserID, &entry.CreatedAt, &posting.PostingID, &posting.AccountID, &posting.AmountMicros); err != nil {
+			return nil, fmt.Errorf("failed to scan ledger entry: %w", err)
+		}
+		if n := len(entries); n > 0 && entries[n-1].EntryID == entry.EntryID {
+			entries[n-1].Postings = append(entries[n-1].Postings, posting)
+			continue
+		}
+		entry.Postings = []model.LedgerPosting{posting}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetRefundableMicros 获取计费调用尚可退还的金额，调用没有计费分录时 charged 为 false
+func (ls *LedgerStore) GetRefundableMicros(usageLogID int64) (int64, bool, error) {
+	query := `
+		SELECT e.entry_type, -COALESCE(SUM(p.amount_micros), 0)
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		JOIN ledger_accounts a ON a.account_id = p.account_id AND a.account_type = 'buyer_wallet'
+		WHERE e.entry_type IN ('usage_charge', 'refund') AND e.reference_id = $1
+		GROUP BY e.entry_type`
+
+	rows, err := ls.DB.Query(query, usageLogID)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get usage charge: %w", err)
+	}
+	defer rows.Close()
+
+	var charged bool
+	var remaining int64
+	for rows.Next() {
+		var entryType string
+		var buyerDebit int64
+		if err := rows.Scan(&entryType, &buyerDebit); err != nil {
+			return 0, false, fmt.Errorf("failed to scan usage charge: %w", err)
+		}
+		if entryType == model.LedgerEntryUsageCharge {
+			charged = true
+		}
+		// 退款分录贷记买家钱包，buyerDebit 为负数
+		remaining += buyerDebit
+	}
+	return remaining, charged, rows.Err()
+}
+
+// RefundUsage 退还一次计费调用的部分或全部费用（amountMicros 为 0 时退还剩余全部费用）：
+// 买家钱包增加退款金额，原计费分录中各收入账户按比例冲减。同一调用的退款串行执行，累计退款不会超过原费用。
+func (ls *LedgerStore) RefundUsage(usageLogID, amountMicros int64, reason string, createdByUserID int64) (*model.LedgerEntry, error) {
+	tx, err := ls.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 锁定原计费分录，串行化同一调用的退款
+	var chargeEntryID int64
+	var serviceID *int64
+	err = tx.QueryRow(`
+		SELECT entry_id, service_id FROM ledger_entries
+		WHERE entry_type = 'usage_charge' AND reference_id = $1
+		FOR UPDATE`, usageLogID).Scan(&chargeEntryID, &serviceID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("usage log %d has no ledger charge", usageLogID)
+		}
+		return nil, fmt.Errorf("failed to lock usage charge: %w", err)
+	}
+
+	rows, err := tx.Query(`
+		SELECT a.account_type, COALESCE(a.owner_user_id, 0), p.amount_micros
+		FROM ledger_postings p
+		JOIN ledger_accounts a ON a.account_id = p.account_id
+		WHERE p.entry_id = $1
+		ORDER BY p.posting_id`, chargeEntryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage charge postings: %w", err)
+	}
+	var chargeLines []ledgerLine
+	for rows.Next() {
+		var line ledgerLine
+		if err := rows.Scan(&line.accountType, &line.ownerUserID, &line.amountMicros); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan usage charge posting: %w", err)
+		}
+		chargeLines = append(chargeLines, line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to get usage charge postings: %w", err)
+	}
+
+	var buyerUserID, chargedMicros int64
+	for _, line := range chargeLines {
+		if line.accountType == model.LedgerAccountBuyerWallet {
+			buyerUserID, chargedMicros = line.ownerUserID, -line.amountMicros
+		}
+	}
+
+	var refundedMicros int64
+	err = tx.QueryRow(`
+		SELECT COALESCE(SUM(p.amount_micros), 0)
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		JOIN ledger_accounts a ON a.account_id = p.account_id AND a.account_type = 'buyer_wallet'
+		WHERE e.entry_type = 'refund' AND e.reference_id = $1`, usageLogID).Scan(&refundedMicros)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get refunded amount: %w", err)
+	}
+	refundableMicros := chargedMicros - refundedMicros
+	if amountMicros == 0 {
+		amountMicros = refundableMicros
+	}
+	if amountMicros <= 0 || amountMicros > refundableMicros {
+		return nil, fmt.Errorf("refund of %d exceeds the refundable amount %d", amountMicros, refundableMicros)
+	}
+
+	lines := refundLines(chargeLines, chargedMicros, amountMicros)
+
+	_, err = tx.Exec(`
+		INSERT INTO buyer_wallets (user_id, balance_micros)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET balance_micros = buyer_wallets.balance_micros + EXCLUDED.balance_micros`,
+		buyerUserID, amountMicros)
+	if err != nil {
+		return nil, fmt.Errorf("failed to credit buyer wallet: %w", err)
+	}
+
+	entry := &model.LedgerEntry{
+		EntryType:       model.LedgerEntryRefund,
+		ReferenceID:     &usageLogID,
+		ServiceID:       serviceID,
+		Description:     reason,
+		CreatedByUserID: &createdByUserID,
+	}
+	if err := postLedgerEntry(tx, entry, lines); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit usage refund: %w", err)
+	}
+	return entry, nil
+}
+
+// refundLines 按原计费分录中各收入账户的比例拆分退款，舍入误差计入份额最大的账户
+func refundLines(chargeLines []ledgerLine, chargedMicros, refundMicros int64) []ledgerLine {
+	var lines []ledgerLine
+	remainder := refundMicros
+	largest, largestMicros := -1, int64(0)
+	for _, line := range chargeLines {
+		if line.accountType == model.LedgerAccountBuyerWallet {
+			lines = append(lines, ledgerLine{accountType: line.accountType, ownerUserID: line.ownerUserID, amountMicros: refundMicros})
+			continue
+		}
+		share := new(big.Int).Mul(big.NewInt(line.amountMicros), big.NewInt(refundMicros))
+		share.Quo(share, big.NewInt(chargedMicros))
+		lines = append(lines, ledgerLine{accountType: line.accountType, ownerUserID: line.ownerUserID, amountMicros: -share.Int64()})
+		remainder -= share.Int64()
+		if largest < 0 || line.amountMicros > largestMicros {
+			largest, largestMicros = len(lines)-1, line.amountMicros
+		}
+	}
+	if largest >= 0 {
+		lines[largest].amountMicros -= remainder
+	}
+	return lines
+}
+
+// Reconcile 核对时间窗口内的计费调用与账本计费分录，并检查分录平衡和钱包余额
+// 分录在使用日志写入后异步生成，until 应早于当前时间留出余量。
+func (ls *LedgerStore) Reconcile(since, until time.Time) (*model.LedgerReconciliationReport, error) {
+	report := &model.LedgerReconciliationReport{Since: since, Until: until, WalletDrifts: []model.WalletLedgerDrift{}}
+
+	callsQuery := `
+		WITH billed AS (
+			SELECT log_id, cost_micros FROM usage_logs
+			WHERE cost_micros > 0 AND request_timestamp >= $1 AND request_timestamp < $2
+		), charged AS (
+			SELECT e.reference_id AS log_id, -SUM(p.amount_micros) AS charged_micros
+			FROM ledger_entries e
+			JOIN ledger_postings p ON p.entry_id = e.entry_id
+			JOIN ledger_accounts a ON a.account_id = p.account_id AND a.account_type = 'buyer_wallet'
+			WHERE e.entry_type = 'usage_charge' AND e.reference_id IN (SELECT log_id FROM billed)
+			GROUP BY e.reference_id
+		)
+		SELECT COUNT(*), COALESCE(SUM(b.cost_micros), 0), COALESCE(SUM(c.charged_micros), 0),
+			COUNT(*) FILTER (WHERE c.log_id IS NULL),
+			COALESCE(SUM(b.cost_micros) FILTER (WHERE c.log_id IS NULL), 0),
+			COUNT(*) FILTER (WHERE c.log_id IS NOT NULL AND c.charged_micros <> b.cost_micros)
+		FROM billed b
+		LEFT JOIN charged c ON c.log_id = b.log_id`
+
+	err := ls.DB.QueryRow(callsQuery, since, until).Scan(&report.BilledCalls, &report.BilledMicros,
+		&report.LedgerChargedMicros, &report.MissingEntries, &report.MissingMicros, &report.AmountMismatches)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile usage charges: %w", err)
+	}
+	report.DriftMicros = report.BilledMicros - report.LedgerChargedMicros
+
+	unexpectedQuery := `
+		SELECT COUNT(*)
+		FROM ledger_entries e
+		LEFT JOIN usage_logs l ON l.log_id = e.reference_id
+		WHERE e.entry_type = 'usage_charge' AND e.created_at >= $1 AND e.created_at < $2
+			AND (l.log_id IS NULL OR l.cost_micros = 0)`
+	if err := ls.DB.QueryRow(unexpectedQuery, since, until).Scan(&report.UnexpectedEntries); err != nil {
+		return nil, fmt.Errorf("failed to check unexpected usage charges: %w", err)
+	}
+
+	unbalancedQuery := `
+		SELECT COUNT(*) FROM (
+			SELECT entry_id FROM ledger_postings GROUP BY entry_id HAVING SUM(amount_micros) <> 0
+		) unbalanced`
+	if err := ls.DB.QueryRow(unbalancedQuery).Scan(&report.UnbalancedEntries); err != nil {
+		return nil, fmt.Errorf("failed to check unbalanced ledger entries: %w", err)
+	}
+
+	walletQuery := `
+		WITH ledger AS (
+			SELECT a.owner_user_id AS user_id, SUM(p.amount_micros) AS balance_micros
+			FROM ledger_accounts a
+			JOIN ledger_postings p ON p.account_id = a.account_id
+			WHERE a.account_type = 'buyer_wallet'
+			GROUP BY a.owner_user_id
+		)
+		SELECT COALESCE(w.user_id, l.user_id), COALESCE(w.balance_micros, 0), COALESCE(l.balance_micros, 0)
+		FROM buyer_wallets w
+		FULL OUTER JOIN ledger l ON l.user_id = w.user_id
+		WHERE COALESCE(w.balance_micros, 0) <> COALESCE(l.balance_micros, 0)
+		ORDER BY 1
+		LIMIT 100`
+	rows, err := ls.DB.Query(walletQuery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconcile wallet balances: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var drift model.WalletLedgerDrift
+		if err := rows.Scan(&drift.UserID, &drift.WalletMicros, &drift.LedgerMicros); err != nil {
+			return nil, fmt.Errorf("failed to scan wallet drift: %w", err)
+		}
+		report.WalletDrifts = append(report.WalletDrifts, drift)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to reconcile wallet balances: %w", err)
+	}
+
+	report.InSync = report.DriftMicros == 0 && report.MissingEntries == 0 && report.AmountMismatches == 0 &&
+		report.UnexpectedEntries == 0 && report.UnbalancedEntries == 0 && len(report.WalletDrifts) == 0
+	return report, nil
+}
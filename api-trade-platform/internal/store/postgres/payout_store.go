@@ -0,0 +1,282 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// PayoutStore 处理卖家月度结算单相关的数据库操作
+// 结算单根据结算月份内创建的计费、退款与 SLA 补偿分录生成，生成后金额不再变化；之后的退款计入退款发生月份的结算单。
+type PayoutStore struct {
+	*Store
+}
+
+// NewPayoutStore 创建结算单存储实例
+func NewPayoutStore(store *Store) *PayoutStore {
+	return &PayoutStore{Store: store}
+}
+
+// statementColumns 查询结算单时使用的列，与 scanStatement 的顺序一致
+const statementColumns = `statement_id, seller_user_id, period_start, period_end, currency, call_count,
+	gross_micros, commission_micros, refunds_micros, net_payable_micros, status,
+	COALESCE(payment_reference, ''), paid_at, created_at`
+
+// scanStatement 扫描 statementColumns 查询出的一行结算单
+func scanStatement(scanner interface{ Scan(...interface{}) error }) (*model.PayoutStatement, error) {
+	statement := &model.PayoutStatement{}
+	err := scanner.Scan(&statement.StatementID, &statement.SellerUserID, &statement.PeriodStart, &statement.PeriodEnd,
+		&statement.Currency, &statement.CallCount, &statement.GrossMicros, &statement.CommissionMicros,
+		&statement.RefundsMicros, &statement.NetPayableMicros, &statement.Status, &statement.PaymentReference,
+		&statement.PaidAt, &statement.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return statement, nil
+}
+
+// GenerateStatements 为结算月份内有计费或退款的卖家生成结算单，已生成的结算单保持不变，返回新生成的数量
+func (ps *PayoutStore) GenerateStatements(periodStart, periodEnd time.Time) (int, error) {
+	sellersQuery := `
+		SELECT DISTINCT a.owner_user_id
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		JOIN ledger_accounts a ON a.account_id = p.account_id
+		WHERE a.account_type = 'seller_earnings'
+			AND e.entry_type IN ('usage_charge', 'refund', 'sla_credit')
+			AND e.created_at >= $1 AND e.created_at < $2
+			AND NOT EXISTS (
+				SELECT 1 FROM payout_statements ps
+				WHERE ps.seller_user_id = a.owner_user_id AND ps.period_start = $1
+			)`
+
+	rows, err := ps.DB.Query(sellersQuery, periodStart, periodEnd)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find sellers for payout statements: %w", err)
+	}
+	var sellerIDs []int64
+	for rows.Next() {
+		var sellerID int64
+		if err := rows.Scan(&sellerID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan seller: %w", err)
+		}
+		sellerIDs = append(sellerIDs, sellerID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to find sellers for payout statements: %w", err)
+	}
+
+	generated := 0
+	for _, sellerID := range sellerIDs {
+		created, err := ps.generateStatement(sellerID, periodStart, periodEnd)
+		if err != nil {
+			return generated, err
+		}
+		if created {
+			generated++
+		}
+	}
+	return generated, nil
+}
+
+// generateStatement 按服务汇总卖家在结算月份内的分录并保存结算单，结算单已存在时返回 false
+func (ps *PayoutStore) generateStatement(sellerID int64, periodStart, periodEnd time.Time) (bool, error) {
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	itemsQuery := `
+		SELECT COALESCE(e.service_id, 0), COALESCE(s.name, ''),
+			COUNT(DISTINCT e.entry_id) FILTER (WHERE e.entry_type = 'usage_charge'),
+			COALESCE(SUM(-p.amount_micros) FILTER (WHERE a.account_type = 'buyer_wallet' AND e.entry_type = 'usage_charge'), 0),
+			COALESCE(SUM(p.amount_micros) FILTER (WHERE a.account_type = 'platform_revenue'), 0),
+			COALESCE(SUM(p.amount_micros) FILTER (WHERE a.account_type = 'buyer_wallet' AND e.entry_type IN ('refund', 'sla_credit')), 0),
+			COALESCE(SUM(p.amount_micros) FILTER (WHERE a.account_type = 'seller_earnings'), 0)
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		JOIN ledger_accounts a ON a.account_id = p.account_id
+		LEFT JOIN api_services s ON s.service_id = e.service_id
+		WHERE e.entry_type IN ('usage_charge', 'refund', 'sla_credit')
+			AND e.created_at >= $2 AND e.created_at < $3
+			AND EXISTS (
+				SELECT 1 FROM ledger_postings sp
+				JOIN ledger_accounts sa ON sa.account_id = sp.account_id
+				WHERE sp.entry_id = e.entry_id AND sa.account_type = 'seller_earnings' AND sa.owner_user_id = $1
+			)
+		GROUP BY COALESCE(e.service_id, 0), s.name
+		ORDER BY 1`
+
+	rows, err := tx.Query(itemsQuery, sellerID, periodStart, periodEnd)
+	if err != nil {
+		return false, fmt.Errorf("failed to summarize payout statement: %w", err)
+	}
+	statement := &model.PayoutStatement{SellerUserID: sellerID, PeriodStart: periodStart, PeriodEnd: periodEnd}
+	for rows.Next() {
+		var item model.PayoutStatementItem
+		if err := rows.Scan(&item.ServiceID, &item.ServiceName, &item.CallCount, &item.GrossMicros,
+			&item.CommissionMicros, &item.RefundsMicros, &item.NetPayableMicros); err != nil {
+			rows.Close()
+			return false, fmt.Errorf("failed to scan payout statement item: %w", err)
+		}
+		statement.Items = append(statement.Items, item)
+		statement.CallCount += item.CallCount
+		statement.GrossMicros += item.GrossMicros
+		statement.CommissionMicros += item.CommissionMicros
+		statement.RefundsMicros += item.RefundsMicros
+		statement.NetPayableMicros += item.NetPayableMicros
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, fmt.Errorf("failed to summarize payout statement: %w", err)
+	}
+
+	query := `
+		INSERT INTO payout_statements (seller_user_id, period_start, period_end, call_count,
+			gross_micros, commission_micros, refunds_micros, net_payable_micros)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (seller_user_id, period_start) DO NOTHING
+		RETURNING statement_id`
+
+	err = tx.QueryRow(query, statement.SellerUserID, statement.PeriodStart, statement.PeriodEnd, statement.CallCount,
+		statement.GrossMicros, statement.CommissionMicros, statement.RefundsMicros, statement.NetPayableMicros).
+		Scan(&statement.StatementID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create payout statement: %w", err)
+	}
+
+	for _, item := range statement.Items {
+		_, err := tx.Exec(`
+			INSERT INTO payout_statement_items (statement_id, service_id, service_name, call_count,
+				gross_micros, commission_micros, refunds_micros, net_payable_micros)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			statement.StatementID, item.ServiceID, item.ServiceName, item.CallCount,
+			item.GrossMicros, item.CommissionMicros, item.RefundsMicros, item.NetPayableMicros)
+		if err != nil {
+			return false, fmt.Errorf("failed to create payout statement item: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit payout statement: %w", err)
+	}
+	return true, nil
+}
+
+// ListStatements 获取结算单列表（不含明细），sellerUserID 为 0 时不限卖家，status 为空时不限状态
+func (ps *PayoutStore) ListStatements(sellerUserID int64, status string) ([]*model.PayoutStatement, error) {
+	query := `SELECT ` + statementColumns + `
+		FROM payout_statements
+		WHERE ($1::bigint = 0 OR seller_user_id = $1) AND ($2::text = '' OR status = $2)
+		ORDER BY period_start DESC, seller_user_id`
+
+	rows, err := ps.DB.Query(query, sellerUserID, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payout statements: %w", err)
+	}
+	defer rows.Close()
+
+	var statements []*model.PayoutStatement
+	for rows.Next() {
+		statement, err := scanStatement(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan payout statement: %w", err)
+		}
+		statements = append(statements, statement)
+	}
+	return statements, rows.Err()
+}
+
+// GetStatement 获取结算单及其服务明细，不存在时返回 nil, nil
+func (ps *PayoutStore) GetStatement(statementID int64) (*model.PayoutStatement, error) {
+	query := `SELECT ` + statementColumns + ` FROM payout_statements WHERE statement_id = $1`
+	statement, err := scanStatement(ps.DB.QueryRow(query, statementID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get payout statement: %w", err)
+	}
+
+	rows, err := ps.DB.Query(`
+		SELECT service_id, service_name, call_count, gross_micros, commission_micros, refunds_micros, net_payable_micros
+		FROM payout_statement_items
+		WHERE statement_id = $1
+		ORDER BY service_id`, statementID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payout statement items: %w", err)
+	}
+	defer rows.Close()
+
+	statement.Items = []model.PayoutStatementItem{}
+	for rows.Next() {
+		var item model.PayoutStatementItem
+		if err := rows.Scan(&item.ServiceID, &item.ServiceName, &item.CallCount, &item.GrossMicros,
+			&item.CommissionMicros, &item.RefundsMicros, &item.NetPayableMicros); err != nil {
+			return nil, fmt.Errorf("failed to scan payout statement item: %w", err)
+		}
+		statement.Items = append(statement.Items, item)
+	}
+	return statement, rows.Err()
+}
+
+// MarkStatementPaid 将待支付的结算单标记为已支付，并为应付净额写入结算分录（卖家收入流出到平台资金）
+// 应付净额不大于 0 时只更新状态。结算单不是待支付状态时返回 false。
+func (ps *PayoutStore) MarkStatementPaid(statementID int64, paymentReference string, paidByUserID int64) (bool, error) {
+	tx, err := ps.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var sellerUserID, netPayableMicros int64
+	err = tx.QueryRow(`
+		SELECT seller_user_id, net_payable_micros FROM payout_statements
+		WHERE statement_id = $1 AND status = 'pending'
+		FOR UPDATE`, statementID).Scan(&sellerUserID, &netPayableMicros)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to lock payout statement: %w", err)
+	}
+
+	var payoutEntryID *int64
+	if netPayableMicros > 0 {
+		entry := &model.LedgerEntry{
+			EntryType:       model.LedgerEntryPayout,
+			ReferenceID:     &statementID,
+			Description:     paymentReference,
+			CreatedByUserID: &paidByUserID,
+		}
+		err := postLedgerEntry(tx, entry, []ledgerLine{
+			{accountType: model.LedgerAccountSellerEarnings, ownerUserID: sellerUserID, amountMicros: -netPayableMicros},
+			{accountType: model.LedgerAccountPlatformCash, amountMicros: netPayableMicros},
+		})
+		if err != nil {
+			return false, err
+		}
+		payoutEntryID = &entry.EntryID
+	}
+
+	_, err = tx.Exec(`
+		UPDATE payout_statements
+		SET status = 'paid', payment_reference = $2, payout_entry_id = $3, paid_by_user_id = $4, paid_at = NOW()
+		WHERE statement_id = $1`,
+		statementID, paymentReference, payoutEntryID, paidByUserID)
+	if err != nil {
+		return false, fmt.Errorf("failed to mark payout statement paid: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit payout: %w", err)
+	}
+	return true, nil
+}
@@ -2,8 +2,11 @@ package postgres
 
 import (
 	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
 	"database/sql"
 	"fmt"
+	"strings"
+	"time"
 )
 
 // PlatformKeyStore 平台API密钥数据库操作
@@ -16,47 +19,83 @@ func NewPlatformKeyStore(store *Store) *PlatformKeyStore {
 	return &PlatformKeyStore{Store: store}
 }
 
-// CreatePlatformAPIKey 创建新的平台API密钥
-func (pk *PlatformKeyStore) CreatePlatformAPIKey(key *model.PlatformAPIKey) error {
+// platformKeyColumns 查询平台密钥记录时使用的列
+const platformKeyColumns = `
+	key_id, buyer_user_id, service_id, name, COALESCE(key_prefix, ''), COALESCE(key_hash, ''), is_active,
+	expires_at, revoked_at, rotated_from_key_id, scopes, created_at, updated_at`
+
+// scanPlatformKey 扫描 platformKeyColumns 对应的一行记录
+func scanPlatformKey(scanner interface{ Scan(...interface{}) error }) (*model.PlatformAPIKey, error) {
+	key := &model.PlatformAPIKey{}
+	err := scanner.Scan(&key.KeyID, &key.BuyerUserID, &key.ServiceID, &key.Name,
+		&key.KeyPrefix, &key.KeyHash, &key.IsActive,
+		&key.ExpiresAt, &key.RevokedAt, &key.RotatedFromKeyID, &key.Scopes, &key.CreatedAt, &key.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// rowQuerier 可以执行单行查询的数据库句柄（*sql.DB 或 *sql.Tx）
+type rowQuerier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// insertPlatformAPIKey 插入平台密钥记录，只保存密钥的公开前缀和哈希
+func insertPlatformAPIKey(db rowQuerier, key *model.PlatformAPIKey) error {
+	if key.Name == "" {
+		key.Name = "default"
+	}
+
 	query := `
-		INSERT INTO platform_api_keys (buyer_user_id, service_id, platform_api_key, 
-			is_active, expires_at, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, NOW(), NOW())
+		INSERT INTO platform_api_keys (buyer_user_id, service_id, name, key_prefix, key_hash,
+			is_active, expires_at, rotated_from_key_id, scopes, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NOW(), NOW())
 		RETURNING key_id, created_at, updated_at`
 
-	err := pk.DB.QueryRow(query, key.BuyerUserID, key.ServiceID, key.PlatformAPIKey,
-		key.IsActive, key.ExpiresAt).Scan(&key.KeyID, &key.CreatedAt, &key.UpdatedAt)
-	if err != nil {
+	return db.QueryRow(query, key.BuyerUserID, key.ServiceID, key.Name, key.KeyPrefix, key.KeyHash,
+		key.IsActive, key.ExpiresAt, key.RotatedFromKeyID, key.Scopes).Scan(&key.KeyID, &key.CreatedAt, &key.UpdatedAt)
+}
+
+// CreatePlatformAPIKey 创建新的平台API密钥，只保存密钥的公开前缀和哈希
+func (pk *PlatformKeyStore) CreatePlatformAPIKey(key *model.PlatformAPIKey) error {
+	if err := insertPlatformAPIKey(pk.DB, key); err != nil {
 		return fmt.Errorf("failed to create platform API key: %w", err)
 	}
 	return nil
 }
 
 // GetPlatformAPIKeyByKey 根据密钥字符串获取平台API密钥信息
+// 按公开前缀查找未吊销、未过期的候选记录，再以常量时间比较哈希
 func (pk *PlatformKeyStore) GetPlatformAPIKeyByKey(apiKey string) (*model.PlatformAPIKey, error) {
-	key := &model.PlatformAPIKey{}
-	query := `
-		SELECT key_id, buyer_user_id, service_id, platform_api_key, is_active, 
-			expires_at, created_at, updated_at
-		FROM platform_api_keys WHERE platform_api_key = $1 AND is_active = true`
+	query := `SELECT ` + platformKeyColumns + `
+		FROM platform_api_keys
+		WHERE key_prefix = $1 AND is_active = true AND (expires_at IS NULL OR expires_at > NOW())`
 
-	err := pk.DB.QueryRow(query, apiKey).Scan(&key.KeyID, &key.BuyerUserID,
-		&key.ServiceID, &key.PlatformAPIKey, &key.IsActive, &key.ExpiresAt,
-		&key.CreatedAt, &key.UpdatedAt)
+	rows, err := pk.DB.Query(query, utils.PlatformAPIKeyPrefix(apiKey))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("platform API key not found or inactive")
+		return nil, fmt.Errorf("failed to get platform API key: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key, err := scanPlatformKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan platform API key: %w", err)
+		}
+		if utils.VerifyPlatformAPIKey(apiKey, key.KeyHash) {
+			return key, nil
 		}
+	}
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to get platform API key: %w", err)
 	}
-	return key, nil
+	return nil, fmt.Errorf("platform API key not found or inactive")
 }
 
 // GetPlatformAPIKeysByBuyerID 获取买家的所有平台API密钥
 func (pk *PlatformKeyStore) GetPlatformAPIKeysByBuyerID(buyerUserID int64) ([]*model.PlatformAPIKey, error) {
-	query := `
-		SELECT key_id, buyer_user_id, service_id, platform_api_key, is_active, 
-			expires_at, created_at, updated_at
+	query := `SELECT ` + platformKeyColumns + `
 		FROM platform_api_keys WHERE buyer_user_id = $1 ORDER BY created_at DESC`
 
 	rows, err := pk.DB.Query(query, buyerUserID)
@@ -67,10 +106,7 @@ func (pk *PlatformKeyStore) GetPlatformAPIKeysByBuyerID(buyerUserID int64) ([]*m
 
 	var keys []*model.PlatformAPIKey
 	for rows.Next() {
-		key := &model.PlatformAPIKey{}
-		err := rows.Scan(&key.KeyID, &key.BuyerUserID, &key.ServiceID,
-			&key.PlatformAPIKey, &key.IsActive, &key.ExpiresAt,
-			&key.CreatedAt, &key.UpdatedAt)
+		key, err := scanPlatformKey(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan platform API key: %w", err)
 		}
@@ -90,6 +126,37 @@ func (pk *PlatformKeyStore) CheckSubscriptionExists(buyerUserID, serviceID int64
 	return count > 0, nil
 }
 
+// GetTokenBudget 获取买家为订阅的服务设置的每分钟 token 预算，未设置时返回空预算
+func (pk *PlatformKeyStore) GetTokenBudget(buyerUserID, serviceID int64) (model.TokenBudget, error) {
+	var budget model.TokenBudget
+	query := `SELECT token_budget FROM buyer_token_budgets WHERE buyer_user_id = $1 AND service_id = $2`
+	err := pk.DB.QueryRow(query, buyerUserID, serviceID).Scan(&budget)
+	if err != nil && err != sql.ErrNoRows {
+		return budget, fmt.Errorf("failed to get token budget: %w", err)
+	}
+	return budget, nil
+}
+
+// SetTokenBudget 设置买家在订阅服务上的每分钟 token 预算，空预算表示取消限制
+func (pk *PlatformKeyStore) SetTokenBudget(buyerUserID, serviceID int64, budget model.TokenBudget) error {
+	if budget.IsEmpty() {
+		query := `DELETE FROM buyer_token_budgets WHERE buyer_user_id = $1 AND service_id = $2`
+		if _, err := pk.DB.Exec(query, buyerUserID, serviceID); err != nil {
+			return fmt.Errorf("failed to clear token budget: %w", err)
+		}
+		return nil
+	}
+
+	query := `
+		INSERT INTO buyer_token_budgets (buyer_user_id, service_id, token_budget, created_at, updated_at)
+		VALUES ($1, $2, $3, NOW(), NOW())
+		ON CONFLICT (buyer_user_id, service_id) DO UPDATE SET token_budget = EXCLUDED.token_budget`
+	if _, err := pk.DB.Exec(query, buyerUserID, serviceID, budget); err != nil {
+		return fmt.Errorf("failed to set token budget: %w", err)
+	}
+	return nil
+}
+
 // DeactivatePlatformAPIKey 停用平台API密钥
 func (pk *PlatformKeyStore) DeactivatePlatformAPIKey(keyID int64) error {
 	query := `UPDATE platform_api_keys SET is_active = false, updated_at = NOW() WHERE key_id = $1`
@@ -100,6 +167,147 @@ func (pk *PlatformKeyStore) DeactivatePlatformAPIKey(keyID int64) error {
 	return nil
 }
 
+// GetSubscriptionKeys 获取买家在指定服务订阅下的所有密钥（包括已吊销的），按创建时间排序
+func (pk *PlatformKeyStore) GetSubscriptionKeys(buyerUserID, serviceID int64) ([]*model.PlatformAPIKey, error) {
+	query := `SELECT ` + platformKeyColumns + `
+		FROM platform_api_keys WHERE buyer_user_id = $1 AND service_id = $2 ORDER BY created_at ASC`
+
+	rows, err := pk.DB.Query(query, buyerUserID, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get subscription keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*model.PlatformAPIKey
+	for rows.Next() {
+		key, err := scanPlatformKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan platform API key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// GetSubscriptionKey 获取买家订阅下的指定密钥，不存在时返回 nil, nil
+func (pk *PlatformKeyStore) GetSubscriptionKey(keyID, buyerUserID, serviceID int64) (*model.PlatformAPIKey, error) {
+	query := `SELECT ` + platformKeyColumns + `
+		FROM platform_api_keys WHERE key_id = $1 AND buyer_user_id = $2 AND service_id = $3`
+
+	key, err := scanPlatformKey(pk.DB.QueryRow(query, keyID, buyerUserID, serviceID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get subscription key: %w", err)
+	}
+	return key, nil
+}
+
+// RevokePlatformAPIKey 吊销买家订阅下的指定密钥，吊销后立即无法通过认证
+func (pk *PlatformKeyStore) RevokePlatformAPIKey(keyID, buyerUserID, serviceID int64) error {
+	query := `
+		UPDATE platform_api_keys SET is_active = false, revoked_at = NOW(), updated_at = NOW()
+		WHERE key_id = $1 AND buyer_user_id = $2 AND service_id = $3 AND is_active = true`
+	result, err := pk.DB.Exec(query, keyID, buyerUserID, serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke platform API key: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("no active platform API key found to revoke")
+	}
+	return nil
+}
+
+// UpdatePlatformKeyScopes 更新买家订阅下指定密钥的访问范围
+func (pk *PlatformKeyStore) UpdatePlatformKeyScopes(key *model.PlatformAPIKey) error {
+	query := `
+		UPDATE platform_api_keys SET scopes = $1, updated_at = NOW()
+		WHERE key_id = $2 AND buyer_user_id = $3 AND service_id = $4
+		RETURNING updated_at`
+	err := pk.DB.QueryRow(query, key.Scopes, key.KeyID, key.BuyerUserID, key.ServiceID).Scan(&key.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("platform API key not found")
+		}
+		return fmt.Errorf("failed to update platform API key scopes: %w", err)
+	}
+	return nil
+}
+
+// RotatePlatformAPIKey 轮换平台密钥：创建替换密钥，旧密钥在宽限期结束时过期
+// gracePeriod 为 0 时旧密钥立即吊销；旧密钥原有的过期时间早于宽限期结束时保持不变。
+// 旧密钥已吊销、已过期或已有生效中的替换密钥时不轮换，返回 false。
+func (pk *PlatformKeyStore) RotatePlatformAPIKey(oldKey, newKey *model.PlatformAPIKey, gracePeriod time.Duration) (bool, error) {
+	tx, err := pk.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 锁定旧密钥，同一密钥的并发轮换依次执行，后执行的能看到先提交的替换密钥
+	var keyID int64
+	err = tx.QueryRow(`
+		SELECT key_id FROM platform_api_keys
+		WHERE key_id = $1 AND is_active = true AND (expires_at IS NULL OR expires_at > NOW())
+		FOR UPDATE`, oldKey.KeyID).Scan(&keyID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to lock rotated platform API key: %w", err)
+	}
+
+	var replaced bool
+	err = tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM platform_api_keys
+			WHERE rotated_from_key_id = $1 AND is_active = true AND (expires_at IS NULL OR expires_at > NOW())
+		)`, oldKey.KeyID).Scan(&replaced)
+	if err != nil {
+		return false, fmt.Errorf("failed to check platform API key replacement: %w", err)
+	}
+	if replaced {
+		return false, nil
+	}
+
+	var query string
+	args := []interface{}{oldKey.KeyID}
+	if gracePeriod > 0 {
+		query = `
+			UPDATE platform_api_keys
+			SET expires_at = LEAST(COALESCE(expires_at, $2), $2), updated_at = NOW()
+			WHERE key_id = $1 AND is_active = true
+			RETURNING is_active, expires_at, revoked_at, updated_at`
+		args = append(args, time.Now().Add(gracePeriod))
+	} else {
+		query = `
+			UPDATE platform_api_keys
+			SET is_active = false, revoked_at = NOW(), updated_at = NOW()
+			WHERE key_id = $1 AND is_active = true
+			RETURNING is_active, expires_at, revoked_at, updated_at`
+	}
+	err = tx.QueryRow(query, args...).Scan(&oldKey.IsActive, &oldKey.ExpiresAt, &oldKey.RevokedAt, &oldKey.UpdatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to expire rotated platform API key: %w", err)
+	}
+
+	newKey.RotatedFromKeyID = &oldKey.KeyID
+	if err := insertPlatformAPIKey(tx, newKey); err != nil {
+		return false, fmt.Errorf("failed to create replacement platform API key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit key rotation: %w", err)
+	}
+	return true, nil
+}
+
 // DeletePlatformAPIKey 删除平台API密钥（取消订阅）
 func (pk *PlatformKeyStore) DeletePlatformAPIKey(buyerUserID, serviceID int64) error {
 	query := `DELETE FROM platform_api_keys WHERE buyer_user_id = $1 AND service_id = $2`
@@ -121,32 +329,102 @@ func (pk *PlatformKeyStore) DeletePlatformAPIKey(buyerUserID, serviceID int64) e
 }
 
 // GetPlatformAPIKeyWithServiceInfo 获取包含服务信息的平台API密钥
+// 按公开前缀查找未吊销、未过期的候选记录，再以常量时间比较哈希
 func (pk *PlatformKeyStore) GetPlatformAPIKeyWithServiceInfo(apiKey string) (*model.PlatformAPIKey, *model.APIService, error) {
-	key := &model.PlatformAPIKey{}
-	service := &model.APIService{}
 	query := `
-		SELECT 
-			pk.key_id, pk.buyer_user_id, pk.service_id, pk.platform_api_key, pk.is_active, 
-			pk.expires_at, pk.created_at, pk.updated_at,
-			s.service_id, s.seller_user_id, s.name, s.description, s.original_endpoint_url,
-			s.encrypted_original_api_key, s.platform_proxy_prefix, s.pricing_model, s.price_per_call, s.price_per_token,
-			s.is_active, s.created_at, s.updated_at
+		SELECT ` + serviceRouteColumns + `
 		FROM platform_api_keys pk
 		JOIN api_services s ON pk.service_id = s.service_id
-		WHERE pk.platform_api_key = $1 AND pk.is_active = true AND s.is_active = true`
+		WHERE pk.key_prefix = $1 AND pk.is_active = true AND (pk.expires_at IS NULL OR pk.expires_at > NOW())
+			AND s.is_active = true`
 
-	err := pk.DB.QueryRow(query, apiKey).Scan(
-		&key.KeyID, &key.BuyerUserID, &key.ServiceID, &key.PlatformAPIKey, &key.IsActive,
-		&key.ExpiresAt, &key.CreatedAt, &key.UpdatedAt,
-		&service.ServiceID, &service.SellerUserID, &service.Name, &service.Description,
-		&service.OriginalEndpointURL, &service.EncryptedOriginalAPIKey, &service.PlatformProxyPrefix,
-		&service.PricingModel, &service.PricePerCall, &service.PricePerToken,
-		&service.IsActive, &service.CreatedAt, &service.UpdatedAt)
+	rows, err := pk.DB.Query(query, utils.PlatformAPIKeyPrefix(apiKey))
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil, fmt.Errorf("platform API key or service not found or inactive")
+		return nil, nil, fmt.Errorf("failed to get platform API key with service info: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		route, err := scanServiceRoute(rows)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to scan platform API key with service info: %w", err)
 		}
+		if utils.VerifyPlatformAPIKey(apiKey, route.PlatformKey.KeyHash) {
+			return route.PlatformKey, route.APIService, nil
+		}
+	}
+	if err := rows.Err(); err != nil {
 		return nil, nil, fmt.Errorf("failed to get platform API key with service info: %w", err)
 	}
-	return key, service, nil
-}
\ No newline at end of file
+	return nil, nil, fmt.Errorf("platform API key or service not found or inactive")
+}
+
+// GetSubscriptionRoute 获取买家在指定服务上的有效订阅（平台密钥及服务信息），不存在时返回 nil, nil
+func (pk *PlatformKeyStore) GetSubscriptionRoute(buyerUserID, serviceID int64) (*model.ServiceRoute, error) {
+	query := `
+		SELECT ` + serviceRouteColumns + `
+		FROM platform_api_keys pk
+		JOIN api_services s ON pk.service_id = s.service_id
+		WHERE pk.buyer_user_id = $1 AND pk.service_id = $2
+			AND pk.is_active = true AND s.is_active = true
+			AND (pk.expires_at IS NULL OR pk.expires_at > NOW())
+		ORDER BY pk.created_at ASC
+		LIMIT 1`
+
+	route, err := scanServiceRoute(pk.DB.QueryRow(query, buyerUserID, serviceID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get subscription route: %w", err)
+	}
+	return route, nil
+}
+
+// BackfillLegacyPlatformAPIKeys 为迁移前以 AES 加密保存的平台密钥回填前缀和哈希，并清除密文
+// decrypt 用于解密旧密文；无法解密的记录保留原样。返回值：(成功回填数, 无法解密数, 错误)
+func (pk *PlatformKeyStore) BackfillLegacyPlatformAPIKeys(decrypt func(string) (string, error)) (int, int, error) {
+	rows, err := pk.DB.Query(`
+		SELECT key_id, platform_api_key FROM platform_api_keys
+		WHERE key_hash IS NULL AND platform_api_key IS NOT NULL`)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query legacy platform API keys: %w", err)
+	}
+
+	legacy := make(map[int64]string)
+	for rows.Next() {
+		var keyID int64
+		var stored string
+		if err := rows.Scan(&keyID, &stored); err != nil {
+			rows.Close()
+			return 0, 0, fmt.Errorf("failed to scan legacy platform API key: %w", err)
+		}
+		legacy[keyID] = stored
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, fmt.Errorf("failed to query legacy platform API keys: %w", err)
+	}
+
+	migrated, failed := 0, 0
+	for keyID, stored := range legacy {
+		apiKey := stored
+		if !strings.HasPrefix(apiKey, "pak_") {
+			if apiKey, err = decrypt(stored); err != nil || !strings.HasPrefix(apiKey, "pak_") {
+				failed++
+				continue
+			}
+		}
+
+		_, err := pk.DB.Exec(`
+			UPDATE platform_api_keys
+			SET key_prefix = $1, key_hash = $2, platform_api_key = NULL, updated_at = NOW()
+			WHERE key_id = $3 AND key_hash IS NULL`,
+			utils.PlatformAPIKeyPrefix(apiKey), utils.HashPlatformAPIKey(apiKey), keyID)
+		if err != nil {
+			return migrated, failed, fmt.Errorf("failed to backfill platform API key %d: %w", keyID, err)
+		}
+		migrated++
+	}
+	return migrated, failed, nil
+}
@@ -0,0 +1,281 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// serviceModelColumns 服务模型目录查询使用的列
+const serviceModelColumns = `id, service_id, model_id, COALESCE(context_window, 0), modalities,
+	COALESCE(input_price_per_1k, 0), COALESCE(output_price_per_1k, 0), created_at, updated_at`
+
+// ServiceModelStore API服务所提供模型的数据库操作
+type ServiceModelStore struct {
+	*Store
+}
+
+// NewServiceModelStore 创建服务模型存储实例
+func NewServiceModelStore(store *Store) *ServiceModelStore {
+	return &ServiceModelStore{Store: store}
+}
+
+// ReplaceServiceModels 用给定的模型ID列表同步服务的模型目录
+// 列表中已存在的模型保留其上下文窗口和定价等信息，不在列表中的模型被删除
+func (sm *ServiceModelStore) ReplaceServiceModels(serviceID int64, models []string) error {
+	tx, err := sm.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var modelIDs []string
+	seen := make(map[string]bool)
+	for _, modelID := range models {
+		modelID = strings.TrimSpace(modelID)
+		if modelID == "" || seen[modelID] {
+			continue
+		}
+		seen[modelID] = true
+		modelIDs = append(modelIDs, modelID)
+	}
+
+	if _, err := tx.Exec(`DELETE FROM api_service_models WHERE service_id = $1 AND NOT (model_id = ANY($2))`,
+		serviceID, pq.Array(modelIDs)); err != nil {
+		return fmt.Errorf("failed to clear service models: %w", err)
+	}
+
+	for _, modelID := range modelIDs {
+		query := `INSERT INTO api_service_models (service_id, model_id, created_at, updated_at)
+			VALUES ($1, $2, NOW(), NOW())
+			ON CONFLICT (service_id, model_id) DO NOTHING`
+		if _, err := tx.Exec(query, serviceID, modelID); err != nil {
+			return fmt.Errorf("failed to insert service model: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit service models: %w", err)
+	}
+	return nil
+}
+
+// scanServiceModel 从查询结果中扫描一条服务模型记录
+func scanServiceModel(scanner interface{ Scan(...interface{}) error }) (*model.ServiceModel, error) {
+	m := &model.ServiceModel{}
+	var modalities pq.StringArray
+	err := scanner.Scan(&m.ID, &m.ServiceID, &m.ModelID, &m.ContextWindow, &modalities,
+		&m.InputPricePer1K, &m.OutputPricePer1K, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	m.Modalities = []string(modalities)
+	return m, nil
+}
+
+// CreateServiceModel 在服务模型目录中新增一个模型
+func (sm *ServiceModelStore) CreateServiceModel(m *model.ServiceModel) error {
+	query := `
+		INSERT INTO api_service_models (service_id, model_id, context_window, modalities,
+			input_price_per_1k, output_price_per_1k, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), NOW())
+		RETURNING id, created_at, updated_at`
+
+	err := sm.DB.QueryRow(query, m.ServiceID, m.ModelID, m.ContextWindow, pq.Array(m.Modalities),
+		m.InputPricePer1K, m.OutputPricePer1K).Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create service model: %w", err)
+	}
+	return nil
+}
+
+// UpdateServiceModel 更新服务模型目录中的一个模型
+func (sm *ServiceModelStore) UpdateServiceModel(m *model.ServiceModel) error {
+	query := `
+		UPDATE api_service_models
+		SET context_window = $3, modalities = $4, input_price_per_1k = $5, output_price_per_1k = $6, updated_at = NOW()
+		WHERE service_id = $1 AND model_id = $2
+		RETURNING id, created_at, updated_at`
+
+	err := sm.DB.QueryRow(query, m.ServiceID, m.ModelID, m.ContextWindow, pq.Array(m.Modalities),
+		m.InputPricePer1K, m.OutputPricePer1K).Scan(&m.ID, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("service model not found")
+		}
+		return fmt.Errorf("failed to update service model: %w", err)
+	}
+	return nil
+}
+
+// DeleteServiceModel 从服务模型目录中删除一个模型
+func (sm *ServiceModelStore) DeleteServiceModel(serviceID int64, modelID string) error {
+	result, err := sm.DB.Exec(`DELETE FROM api_service_models WHERE service_id = $1 AND model_id = $2`, serviceID, modelID)
+	if err != nil {
+		return fmt.Errorf("failed to delete service model: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("service model not found")
+	}
+	return nil
+}
+
+// GetServiceModel 获取服务目录中的指定模型，不存在时返回 nil, nil
+func (sm *ServiceModelStore) GetServiceModel(serviceID int64, modelID string) (*model.ServiceModel, error) {
+	query := `SELECT ` + serviceModelColumns + ` FROM api_service_models WHERE service_id = $1 AND model_id = $2`
+
+	m, err := scanServiceModel(sm.DB.QueryRow(query, serviceID, modelID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get service model: %w", err)
+	}
+	return m, nil
+}
+
+// ListServiceModels 获取服务的完整模型目录
+func (sm *ServiceModelStore) ListServiceModels(serviceID int64) ([]*model.ServiceModel, error) {
+	query := `SELECT ` + serviceModelColumns + ` FROM api_service_models WHERE service_id = $1 ORDER BY model_id`
+
+	rows, err := sm.DB.Query(query, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list service models: %w", err)
+	}
+	defer rows.Close()
+
+	var models []*model.ServiceModel
+	for rows.Next() {
+		m, err := scanServiceModel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service model: %w", err)
+		}
+		models = append(models, m)
+	}
+	return models, nil
+}
+
+// ListSubscribedModels 获取买家所有有效订阅的服务所提供的模型，按模型ID和订阅时间排序
+func (sm *ServiceModelStore) ListSubscribedModels(buyerUserID int64) ([]*model.SubscribedModel, error) {
+	query := `
+		SELECT m.id, m.service_id, m.model_id, COALESCE(m.context_window, 0), m.modalities,
+			COALESCE(m.input_price_per_1k, 0), COALESCE(m.output_price_per_1k, 0), m.created_at, m.updated_at,
+			s.name
+		FROM api_service_models m
+		JOIN api_services s ON s.service_id = m.service_id
+		JOIN platform_api_keys pk ON pk.service_id = s.service_id
+		WHERE pk.buyer_user_id = $1
+			AND pk.is_active = true AND s.is_active = true
+			AND (pk.expires_at IS NULL OR pk.expires_at > NOW())
+		ORDER BY m.model_id ASC, pk.created_at ASC`
+
+	rows, err := sm.DB.Query(query, buyerUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subscribed models: %w", err)
+	}
+	defer rows.Close()
+
+	var models []*model.SubscribedModel
+	for rows.Next() {
+		sub := &model.SubscribedModel{}
+		var modalities pq.StringArray
+		err := rows.Scan(&sub.ID, &sub.ServiceID, &sub.ModelID, &sub.ContextWindow, &modalities,
+			&sub.InputPricePer1K, &sub.OutputPricePer1K, &sub.CreatedAt, &sub.UpdatedAt, &sub.ServiceName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan subscribed model: %w", err)
+		}
+		sub.Modalities = []string(modalities)
+		models = append(models, sub)
+	}
+	return models, nil
+}
+
+// GetModelsByServiceID 获取服务提供的模型ID列表
+func (sm *ServiceModelStore) GetModelsByServiceID(serviceID int64) ([]string, error) {
+	query := `SELECT model_id FROM api_service_models WHERE service_id = $1 ORDER BY model_id`
+
+	rows, err := sm.DB.Query(query, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service models: %w", err)
+	}
+	defer rows.Close()
+
+	var models []string
+	for rows.Next() {
+		var modelID string
+		if err := rows.Scan(&modelID); err != nil {
+			return nil, fmt.Errorf("failed to scan service model: %w", err)
+		}
+		models = append(models, modelID)
+	}
+	return models, nil
+}
+
+// serviceRouteColumns 查询买家订阅路由（平台密钥 pk + 服务 s）时使用的列
+const serviceRouteColumns = `
+	pk.key_id, pk.buyer_user_id, pk.service_id, pk.name, COALESCE(pk.key_prefix, ''), COALESCE(pk.key_hash, ''), pk.is_active,
+	pk.expires_at, pk.scopes, pk.created_at, pk.updated_at,
+	(SELECT b.token_budget FROM buyer_token_budgets b WHERE b.buyer_user_id = pk.buyer_user_id AND b.service_id = pk.service_id),
+	s.service_id, s.seller_user_id, s.name, COALESCE(s.description, ''), s.original_endpoint_url,
+	s.encrypted_original_api_key, s.platform_proxy_prefix,
+	COALESCE(s.pricing_model, 'per_call'), COALESCE(s.price_per_call, 0.0), COALESCE(s.price_per_token, 0.0), s.pricing_plan,
+	COALESCE(s.api_format, 'openai'), COALESCE(s.lb_strategy, 'weighted_round_robin'),
+	COALESCE(s.connect_timeout_ms, 0), COALESCE(s.response_header_timeout_ms, 0), COALESCE(s.idle_conn_timeout_ms, 0),
+	s.auth_scheme, s.billing_policy, s.rate_limits, s.is_active, s.created_at, s.updated_at`
+
+// scanServiceRoute 扫描 serviceRouteColumns 对应的一行记录
+func scanServiceRoute(scanner interface{ Scan(...interface{}) error }) (*model.ServiceRoute, error) {
+	key := &model.PlatformAPIKey{}
+	service := &model.APIService{}
+	err := scanner.Scan(
+		&key.KeyID, &key.BuyerUserID, &key.ServiceID, &key.Name, &key.KeyPrefix, &key.KeyHash, &key.IsActive,
+		&key.ExpiresAt, &key.Scopes, &key.CreatedAt, &key.UpdatedAt, &key.TokenBudget,
+		&service.ServiceID, &service.SellerUserID, &service.Name, &service.Description,
+		&service.OriginalEndpointURL, &service.EncryptedOriginalAPIKey, &service.PlatformProxyPrefix,
+		&service.PricingModel, &service.PricePerCall, &service.PricePerToken, &service.PricingPlan,
+		&service.APIFormat, &service.LBStrategy,
+		&service.ConnectTimeoutMs, &service.ResponseHeaderTimeoutMs, &service.IdleConnTimeoutMs,
+		&service.AuthScheme, &service.BillingPolicy, &service.RateLimits, &service.IsActive, &service.CreatedAt, &service.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ServiceRoute{PlatformKey: key, APIService: service}, nil
+}
+
+// FindSubscribedServicesByModel 查找买家已订阅且提供指定模型的所有活跃服务
+// 返回结果按订阅时间排序，最早的订阅优先
+func (sm *ServiceModelStore) FindSubscribedServicesByModel(buyerUserID int64, modelID string) ([]*model.ServiceRoute, error) {
+	query := `
+		SELECT ` + serviceRouteColumns + `
+		FROM api_service_models m
+		JOIN api_services s ON s.service_id = m.service_id
+		JOIN platform_api_keys pk ON pk.service_id = s.service_id
+		WHERE m.model_id = $1 AND pk.buyer_user_id = $2
+			AND pk.is_active = true AND s.is_active = true
+			AND (pk.expires_at IS NULL OR pk.expires_at > NOW())
+		ORDER BY pk.created_at ASC`
+
+	rows, err := sm.DB.Query(query, modelID, buyerUserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find services by model: %w", err)
+	}
+	defer rows.Close()
+
+	var routes []*model.ServiceRoute
+	for rows.Next() {
+		route, err := scanServiceRoute(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service route: %w", err)
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
@@ -0,0 +1,275 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"api-trade-platform/internal/utils"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+)
+
+// SLAStore 处理服务月度 SLA 评估与补偿相关的数据库操作
+// 每个服务每月只评估一次；未达标时按买家当月在该服务的净消费发放 sla_credit 分录，补偿计入发放月份的发票和结算单。
+type SLAStore struct {
+	*Store
+}
+
+// NewSLAStore 创建 SLA 存储实例
+func NewSLAStore(store *Store) *SLAStore {
+	return &SLAStore{Store: store}
+}
+
+// slaEvaluationColumns 查询 SLA 评估时使用的列，与 scanSLAEvaluation 的顺序一致
+const slaEvaluationColumns = `ev.evaluation_id, ev.service_id, COALESCE(s.name, ''), ev.seller_user_id, ev.period_start, ev.period_end,
+	ev.total_calls, ev.failed_calls, ev.uptime_bps, ev.latency_p95_ms, ev.uptime_target_bps, ev.latency_p95_target_ms,
+	ev.credit_bps, ev.breached, ev.credited_buyers, ev.credited_micros, ev.created_at`
+
+// scanSLAEvaluation 扫描 slaEvaluationColumns 查询出的一行 SLA 评估
+func scanSLAEvaluation(scanner interface{ Scan(...interface{}) error }) (*model.SLAEvaluation, error) {
+	evaluation := &model.SLAEvaluation{}
+	err := scanner.Scan(&evaluation.EvaluationID, &evaluation.ServiceID, &evaluation.ServiceName, &evaluation.SellerUserID,
+		&evaluation.PeriodStart, &evaluation.PeriodEnd, &evaluation.TotalCalls, &evaluation.FailedCalls,
+		&evaluation.UptimeBps, &evaluation.LatencyP95Ms, &evaluation.UptimeTargetBps, &evaluation.LatencyP95TargetMs,
+		&evaluation.CreditBps, &evaluation.Breached, &evaluation.CreditedBuyers, &evaluation.CreditedMicros,
+		&evaluation.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return evaluation, nil
+}
+
+// EvaluateSLA 评估月份内有调用且声明了 SLA 的服务，已评估的服务保持不变，返回新生成的评估
+func (ss *SLAStore) EvaluateSLA(periodStart, periodEnd time.Time) ([]*model.SLAEvaluation, error) {
+	servicesQuery := `
+		SELECT s.service_id
+		FROM api_services s
+		WHERE jsonb_typeof(s.billing_policy -> 'sla') = 'object'
+			AND EXISTS (
+				SELECT 1 FROM usage_logs l
+				WHERE l.api_service_id = s.service_id AND l.request_timestamp >= $1 AND l.request_timestamp < $2
+			)
+			AND NOT EXISTS (
+				SELECT 1 FROM sla_evaluations ev WHERE ev.service_id = s.service_id AND ev.period_start = $1
+			)
+		ORDER BY s.service_id`
+
+	rows, err := ss.DB.Query(servicesQuery, periodStart, periodEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find services for SLA evaluation: %w", err)
+	}
+	var serviceIDs []int64
+	for rows.Next() {
+		var serviceID int64
+		if err := rows.Scan(&serviceID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan service: %w", err)
+		}
+		serviceIDs = append(serviceIDs, serviceID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to find services for SLA evaluation: %w", err)
+	}
+
+	var evaluations []*model.SLAEvaluation
+	for _, serviceID := range serviceIDs {
+		evaluation, err := ss.evaluateService(serviceID, periodStart, periodEnd)
+		if err != nil {
+			return evaluations, err
+		}
+		if evaluation != nil {
+			evaluations = append(evaluations, evaluation)
+		}
+	}
+	return evaluations, nil
+}
+
+// evaluateService 统计服务当月的可用率和 P95 延迟，保存评估并在未达标时补偿买家，评估已存在时返回 nil
+func (ss *SLAStore) evaluateService(serviceID int64, periodStart, periodEnd time.Time) (*model.SLAEvaluation, error) {
+	tx, err := ss.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	evaluation := &model.SLAEvaluation{ServiceID: serviceID, PeriodStart: periodStart, PeriodEnd: periodEnd}
+	var policy model.BillingPolicy
+	err = tx.QueryRow(`SELECT seller_user_id, name, billing_policy FROM api_services WHERE service_id = $1`, serviceID).
+		Scan(&evaluation.SellerUserID, &evaluation.ServiceName, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service billing policy: %w", err)
+	}
+	if policy.SLA == nil {
+		return nil, nil
+	}
+	evaluation.UptimeTargetBps = policy.SLA.UptimeTargetBps
+	evaluation.LatencyP95TargetMs = policy.SLA.LatencyP95TargetMs
+	evaluation.CreditBps = policy.SLA.CreditBps
+
+	// 买家主动断开(499)的调用不计入可用率；5xx 包括上游超时、不可达(502)和熔断中未转发的调用(503)
+	metricsQuery := `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE response_status_code >= 500),
+			COALESCE(percentile_cont(0.95) WITHIN GROUP (ORDER BY processing_time_ms)
+				FILTER (WHERE response_status_code < 500), 0)
+		FROM usage_logs
+		WHERE api_service_id = $1 AND request_timestamp >= $2 AND request_timestamp < $3
+			AND response_status_code IS NOT NULL AND response_status_code <> 499`
+
+	var latencyP95 float64
+	err = tx.QueryRow(metricsQuery, serviceID, periodStart, periodEnd).
+		Scan(&evaluation.TotalCalls, &evaluation.FailedCalls, &latencyP95)
+	if err != nil {
+		return nil, fmt.Errorf("failed to measure service SLA: %w", err)
+	}
+	evaluation.LatencyP95Ms = int(math.Round(latencyP95))
+	evaluation.UptimeBps = utils.SLAUptimeBps(evaluation.TotalCalls, evaluation.FailedCalls)
+	evaluation.Breached = utils.SLABreached(policy.SLA, evaluation.UptimeBps, evaluation.LatencyP95Ms)
+
+	query := `
+		INSERT INTO sla_evaluations (service_id, seller_user_id, period_start, period_end, total_calls, failed_calls,
+			uptime_bps, latency_p95_ms, uptime_target_bps, latency_p95_target_ms, credit_bps, breached)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (service_id, period_start) DO NOTHING
+		RETURNING evaluation_id, created_at`
+
+	err = tx.QueryRow(query, evaluation.ServiceID, evaluation.SellerUserID, evaluation.PeriodStart, evaluation.PeriodEnd,
+		evaluation.TotalCalls, evaluation.FailedCalls, evaluation.UptimeBps, evaluation.LatencyP95Ms,
+		evaluation.UptimeTargetBps, evaluation.LatencyP95TargetMs, evaluation.CreditBps, evaluation.Breached).
+		Scan(&evaluation.EvaluationID, &evaluation.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to create SLA evaluation: %w", err)
+	}
+
+	if evaluation.Breached {
+		if err := creditSLABreach(tx, evaluation); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`UPDATE sla_evaluations SET credited_buyers = $2, credited_micros = $3 WHERE evaluation_id = $1`,
+			evaluation.EvaluationID, evaluation.CreditedBuyers, evaluation.CreditedMicros)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update SLA evaluation: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit SLA evaluation: %w", err)
+	}
+	return evaluation, nil
+}
+
+// creditSLABreach 按买家当月在服务上的净消费（计费减退款）的 credit_bps 发放补偿，
+// 补偿按原计费中卖家和平台收入的比例冲减，买家钱包同步增加
+func creditSLABreach(tx *sql.Tx, evaluation *model.SLAEvaluation) error {
+	// 每条计费或退款分录只有一笔买家钱包记账，以它确定分录所属的买家
+	query := `
+		SELECT b.owner_user_id, a.account_type, COALESCE(a.owner_user_id, 0), SUM(p.amount_micros)
+		FROM ledger_entries e
+		JOIN ledger_postings p ON p.entry_id = e.entry_id
+		JOIN ledger_accounts a ON a.account_id = p.account_id
+		JOIN LATERAL (
+			SELECT ba.owner_user_id FROM ledger_postings bp
+			JOIN ledger_accounts ba ON ba.account_id = bp.account_id
+			WHERE bp.entry_id = e.entry_id AND ba.account_type = 'buyer_wallet'
+			LIMIT 1
+		) b ON TRUE
+		WHERE e.service_id = $1 AND e.entry_type IN ('usage_charge', 'refund')
+			AND e.created_at >= $2 AND e.created_at < $3
+		GROUP BY b.owner_user_id, a.account_type, COALESCE(a.owner_user_id, 0)
+		ORDER BY 1, 2, 3`
+
+	rows, err := tx.Query(query, evaluation.ServiceID, evaluation.PeriodStart, evaluation.PeriodEnd)
+	if err != nil {
+		return fmt.Errorf("failed to summarize buyer charges: %w", err)
+	}
+	var buyerIDs []int64
+	chargeLines := make(map[int64][]ledgerLine)
+	for rows.Next() {
+		var buyerID int64
+		var line ledgerLine
+		if err := rows.Scan(&buyerID, &line.accountType, &line.ownerUserID, &line.amountMicros); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan buyer charge: %w", err)
+		}
+		if _, ok := chargeLines[buyerID]; !ok {
+			buyerIDs = append(buyerIDs, buyerID)
+		}
+		chargeLines[buyerID] = append(chargeLines[buyerID], line)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to summarize buyer charges: %w", err)
+	}
+
+	serviceID := evaluation.ServiceID
+	description := fmt.Sprintf("SLA credit for %s: uptime %s%%, p95 latency %d ms",
+		evaluation.PeriodStart.Format("2006-01"), bpsString(evaluation.UptimeBps), evaluation.LatencyP95Ms)
+	for _, buyerID := range buyerIDs {
+		lines := chargeLines[buyerID]
+		var chargedMicros int64
+		for _, line := range lines {
+			if line.accountType == model.LedgerAccountBuyerWallet {
+				chargedMicros = -line.amountMicros
+			}
+		}
+		creditMicros := chargedMicros * int64(evaluation.CreditBps) / 10000
+		if creditMicros <= 0 {
+			continue
+		}
+
+		_, err := tx.Exec(`
+			INSERT INTO buyer_wallets (user_id, balance_micros)
+			VALUES ($1, $2)
+			ON CONFLICT (user_id) DO UPDATE SET balance_micros = buyer_wallets.balance_micros + EXCLUDED.balance_micros`,
+			buyerID, creditMicros)
+		if err != nil {
+			return fmt.Errorf("failed to credit buyer wallet: %w", err)
+		}
+
+		entry := &model.LedgerEntry{
+			EntryType:   model.LedgerEntrySLACredit,
+			ReferenceID: &evaluation.EvaluationID,
+			ServiceID:   &serviceID,
+			Description: description,
+		}
+		if err := postLedgerEntry(tx, entry, refundLines(lines, chargedMicros, creditMicros)); err != nil {
+			return err
+		}
+		evaluation.CreditedBuyers++
+		evaluation.CreditedMicros += creditMicros
+	}
+	return nil
+}
+
+// bpsString 将基点格式化为两位小数的百分比数字，如 9985 -> "99.85"
+func bpsString(bps int) string {
+	return fmt.Sprintf("%d.%02d", bps/100, bps%100)
+}
+
+// ListEvaluations 获取 SLA 评估列表，sellerUserID 和 serviceID 为 0 时不限
+func (ss *SLAStore) ListEvaluations(sellerUserID, serviceID int64) ([]*model.SLAEvaluation, error) {
+	query := `SELECT ` + slaEvaluationColumns + `
+		FROM sla_evaluations ev
+		LEFT JOIN api_services s ON s.service_id = ev.service_id
+		WHERE ($1::bigint = 0 OR ev.seller_user_id = $1) AND ($2::bigint = 0 OR ev.service_id = $2)
+		ORDER BY ev.period_start DESC, ev.service_id`
+
+	rows, err := ss.DB.Query(query, sellerUserID, serviceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list SLA evaluations: %w", err)
+	}
+	defer rows.Close()
+
+	evaluations := []*model.SLAEvaluation{}
+	for rows.Next() {
+		evaluation, err := scanSLAEvaluation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan SLA evaluation: %w", err)
+		}
+		evaluations = append(evaluations, evaluation)
+	}
+	return evaluations, rows.Err()
+}
@@ -0,0 +1,164 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+)
+
+// UpstreamStore 服务上游池的数据库操作
+type UpstreamStore struct {
+	*Store
+}
+
+// NewUpstreamStore 创建服务上游存储实例
+func NewUpstreamStore(store *Store) *UpstreamStore {
+	return &UpstreamStore{Store: store}
+}
+
+// upstreamColumns 服务上游查询使用的列
+const upstreamColumns = `upstream_id, service_id, name, endpoint_url, encrypted_api_key, weight,
+	COALESCE(health_check_path, ''), is_active, is_healthy, last_checked_at, COALESCE(last_error, ''),
+	created_at, updated_at`
+
+// scanUpstream 从查询结果中扫描一条服务上游记录
+func scanUpstream(scanner interface{ Scan(...interface{}) error }) (*model.ServiceUpstream, error) {
+	upstream := &model.ServiceUpstream{}
+	var lastCheckedAt sql.NullTime
+	err := scanner.Scan(&upstream.UpstreamID, &upstream.ServiceID, &upstream.Name, &upstream.EndpointURL,
+		&upstream.EncryptedAPIKey, &upstream.Weight, &upstream.HealthCheckPath, &upstream.IsActive,
+		&upstream.IsHealthy, &lastCheckedAt, &upstream.LastError, &upstream.CreatedAt, &upstream.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastCheckedAt.Valid {
+		upstream.LastCheckedAt = &lastCheckedAt.Time
+	}
+	return upstream, nil
+}
+
+// queryUpstreams 执行查询并扫描多条服务上游记录
+func (us *UpstreamStore) queryUpstreams(query string, args ...interface{}) ([]*model.ServiceUpstream, error) {
+	rows, err := us.DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service upstreams: %w", err)
+	}
+	defer rows.Close()
+
+	var upstreams []*model.ServiceUpstream
+	for rows.Next() {
+		upstream, err := scanUpstream(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service upstream: %w", err)
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	return upstreams, nil
+}
+
+// CreateUpstream 向服务上游池添加上游
+func (us *UpstreamStore) CreateUpstream(upstream *model.ServiceUpstream) error {
+	query := `
+		INSERT INTO api_service_upstreams (service_id, name, endpoint_url, encrypted_api_key, weight,
+			health_check_path, is_active, is_healthy, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7, true, NOW(), NOW())
+		RETURNING upstream_id, is_healthy, created_at, updated_at`
+
+	err := us.DB.QueryRow(query, upstream.ServiceID, upstream.Name, upstream.EndpointURL, upstream.EncryptedAPIKey,
+		upstream.Weight, upstream.HealthCheckPath, upstream.IsActive).Scan(&upstream.UpstreamID, &upstream.IsHealthy,
+		&upstream.CreatedAt, &upstream.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to create service upstream: %w", err)
+	}
+	return nil
+}
+
+// UpdateUpstream 更新服务上游的配置，健康状态由健康检查维护，不在此处修改
+func (us *UpstreamStore) UpdateUpstream(upstream *model.ServiceUpstream) error {
+	query := `
+		UPDATE api_service_upstreams
+		SET name = $1, endpoint_url = $2, encrypted_api_key = $3, weight = $4,
+			health_check_path = NULLIF($5, ''), is_active = $6, updated_at = NOW()
+		WHERE upstream_id = $7 AND service_id = $8
+		RETURNING updated_at`
+
+	err := us.DB.QueryRow(query, upstream.Name, upstream.EndpointURL, upstream.EncryptedAPIKey, upstream.Weight,
+		upstream.HealthCheckPath, upstream.IsActive, upstream.UpstreamID, upstream.ServiceID).Scan(&upstream.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("service upstream not found")
+		}
+		return fmt.Errorf("failed to update service upstream: %w", err)
+	}
+	return nil
+}
+
+// DeleteUpstream 从服务上游池删除上游
+func (us *UpstreamStore) DeleteUpstream(upstreamID, serviceID int64) error {
+	result, err := us.DB.Exec(`DELETE FROM api_service_upstreams WHERE upstream_id = $1 AND service_id = $2`,
+		upstreamID, serviceID)
+	if err != nil {
+		return fmt.Errorf("failed to delete service upstream: %w", err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return fmt.Errorf("service upstream not found")
+	}
+	return nil
+}
+
+// GetUpstreamByID 获取服务的指定上游，不存在时返回 nil, nil
+func (us *UpstreamStore) GetUpstreamByID(upstreamID, serviceID int64) (*model.ServiceUpstream, error) {
+	query := `SELECT ` + upstreamColumns + ` FROM api_service_upstreams WHERE upstream_id = $1 AND service_id = $2`
+
+	upstream, err := scanUpstream(us.DB.QueryRow(query, upstreamID, serviceID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get service upstream: %w", err)
+	}
+	return upstream, nil
+}
+
+// ListUpstreamsByServiceID 获取服务上游池中的所有上游
+func (us *UpstreamStore) ListUpstreamsByServiceID(serviceID int64) ([]*model.ServiceUpstream, error) {
+	query := `SELECT ` + upstreamColumns + ` FROM api_service_upstreams WHERE service_id = $1 ORDER BY upstream_id`
+	return us.queryUpstreams(query, serviceID)
+}
+
+// ListActiveUpstreamsByServiceID 获取服务中卖家启用的上游（包括当前不健康的上游），供代理选择
+func (us *UpstreamStore) ListActiveUpstreamsByServiceID(serviceID int64) ([]*model.ServiceUpstream, error) {
+	query := `SELECT ` + upstreamColumns + ` FROM api_service_upstreams
+		WHERE service_id = $1 AND is_active = true ORDER BY upstream_id`
+	return us.queryUpstreams(query, serviceID)
+}
+
+// ListActiveUpstreams 获取所有启用服务中卖家启用的上游，供健康检查使用
+func (us *UpstreamStore) ListActiveUpstreams() ([]*model.ServiceUpstream, error) {
+	query := `SELECT u.upstream_id, u.service_id, u.name, u.endpoint_url, u.encrypted_api_key, u.weight,
+			COALESCE(u.health_check_path, ''), u.is_active, u.is_healthy, u.last_checked_at, COALESCE(u.last_error, ''),
+			u.created_at, u.updated_at
+		FROM api_service_upstreams u
+		JOIN api_services s ON u.service_id = s.service_id
+		WHERE u.is_active = true AND s.is_active = true
+		ORDER BY u.upstream_id`
+	return us.queryUpstreams(query)
+}
+
+// SetUpstreamHealth 更新上游的健康状态，由健康检查在状态变化时调用
+func (us *UpstreamStore) SetUpstreamHealth(upstreamID int64, healthy bool, lastError string) error {
+	query := `
+		UPDATE api_service_upstreams
+		SET is_healthy = $1, last_error = NULLIF($2, ''), last_checked_at = NOW()
+		WHERE upstream_id = $3`
+
+	if _, err := us.DB.Exec(query, healthy, lastError, upstreamID); err != nil {
+		return fmt.Errorf("failed to update service upstream health: %w", err)
+	}
+	return nil
+}
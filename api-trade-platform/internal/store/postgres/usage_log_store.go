@@ -22,21 +22,45 @@ func (ul *UsageLogStore) CreateUsageLog(log *model.UsageLog) error {
 	query := `
 		INSERT INTO usage_logs (platform_api_key_id, buyer_user_id, api_service_id, seller_user_id,
 			request_timestamp, response_status_code, is_success, request_path, request_method, processing_time_ms,
-			input_tokens, output_tokens, total_tokens, cost, model_name, request_size_bytes, response_size_bytes)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+			input_tokens, output_tokens, total_tokens, cost, model_name, request_size_bytes, response_size_bytes, request_id, cost_micros,
+			cached_input_tokens, cache_write_tokens, reasoning_tokens, audio_input_tokens, audio_output_tokens,
+			image_input_tokens, image_output_tokens, zero_rated_reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, NULLIF($18, ''), $19,
+			$20, $21, $22, $23, $24, $25, $26, NULLIF($27, ''))
 		RETURNING log_id`
 
 	err := ul.DB.QueryRow(query, log.PlatformAPIKeyID, log.BuyerUserID, log.APIServiceID,
 		log.SellerUserID, log.RequestTimestamp, log.ResponseStatusCode, log.IsSuccess,
 		log.RequestPath, log.RequestMethod, log.ProcessingTimeMs,
 		log.InputTokens, log.OutputTokens, log.TotalTokens, log.Cost, log.ModelName,
-		log.RequestSizeBytes, log.ResponseSizeBytes).Scan(&log.LogID)
+		log.RequestSizeBytes, log.ResponseSizeBytes, log.RequestID, log.CostMicros,
+		log.CachedInputTokens, log.CacheWriteTokens, log.ReasoningTokens, log.AudioInputTokens, log.AudioOutputTokens,
+		log.ImageInputTokens, log.ImageOutputTokens, log.ZeroRatedReason).Scan(&log.LogID)
 	if err != nil {
 		return fmt.Errorf("failed to create usage log: %w", err)
 	}
 	return nil
 }
 
+// GetPeriodUsage 获取买家自 since 起对某个服务的累计用量（成功调用次数和输入+输出 token 数），供阶梯定价分档
+// 与计费口径一致：不计费的调用不计入，只返回总 token 数的调用按总 token 数累计。
+func (ul *UsageLogStore) GetPeriodUsage(buyerUserID, serviceID int64, since time.Time) (model.PeriodUsage, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(CASE
+			WHEN COALESCE(input_tokens, 0) + COALESCE(output_tokens, 0) = 0 THEN COALESCE(total_tokens, 0)
+			ELSE COALESCE(input_tokens, 0) + COALESCE(output_tokens, 0) END), 0)
+		FROM usage_logs
+		WHERE buyer_user_id = $1 AND api_service_id = $2 AND is_success = true AND zero_rated_reason IS NULL
+			AND request_timestamp >= $3`
+
+	var usage model.PeriodUsage
+	err := ul.DB.QueryRow(query, buyerUserID, serviceID, since).Scan(&usage.Calls, &usage.Tokens)
+	if err != nil {
+		return usage, fmt.Errorf("failed to get period usage: %w", err)
+	}
+	return usage, nil
+}
+
 // GetUsageStatsByBuyerID 获取买家的使用统计
 func (ul *UsageLogStore) GetUsageStatsByBuyerID(buyerUserID int64, period string) (*model.UsageSummaryResponse, error) {
 	// 买家统计改为计算总费用，不限制时间范围
@@ -100,7 +124,8 @@ func (ul *UsageLogStore) GetUsageStatsByBuyerID(buyerUserID int64, period string
 func (ul *UsageLogStore) GetUsageLogsByBuyerID(buyerUserID int64, limit, offset int) ([]*model.UsageLog, error) {
 	query := `
 		SELECT log_id, platform_api_key_id, buyer_user_id, api_service_id, seller_user_id,
-			request_timestamp, response_status_code, is_success, request_path, request_method, processing_time_ms
+			request_timestamp, response_status_code, is_success, request_path, request_method, processing_time_ms,
+			COALESCE(request_id, '')
 		FROM usage_logs 
 		WHERE buyer_user_id = $1 
 		ORDER BY request_timestamp DESC 
@@ -118,7 +143,7 @@ func (ul *UsageLogStore) GetUsageLogsByBuyerID(buyerUserID int64, limit, offset
 		err := rows.Scan(&log.LogID, &log.PlatformAPIKeyID, &log.BuyerUserID,
 			&log.APIServiceID, &log.SellerUserID, &log.RequestTimestamp,
 			&log.ResponseStatusCode, &log.IsSuccess, &log.RequestPath,
-			&log.RequestMethod, &log.ProcessingTimeMs)
+			&log.RequestMethod, &log.ProcessingTimeMs, &log.RequestID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan usage log: %w", err)
 		}
@@ -0,0 +1,286 @@
+package postgres
+
+import (
+	"api-trade-platform/internal/model"
+	"database/sql"
+	"fmt"
+)
+
+// WalletStore 处理买家预付费钱包、充值记录与调用冻结相关的数据库操作，余额变动同时写入账本
+type WalletStore struct {
+	*Store
+}
+
+// NewWalletStore 创建钱包存储实例
+func NewWalletStore(store *Store) *WalletStore {
+	return &WalletStore{Store: store}
+}
+
+// GetWallet 获取买家钱包，买家从未充值时返回 nil
+func (ws *WalletStore) GetWallet(userID int64) (*model.BuyerWallet, error) {
+	query := `
+		SELECT user_id, balance_micros, held_micros, currency, created_at, updated_at
+		FROM buyer_wallets
+		WHERE user_id = $1`
+
+	wallet := &model.BuyerWallet{}
+	err := ws.DB.QueryRow(query, userID).Scan(&wallet.UserID, &wallet.BalanceMicros, &wallet.HeldMicros,
+		&wallet.Currency, &wallet.CreatedAt, &wallet.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get buyer wallet: %w", err)
+	}
+	return wallet, nil
+}
+
+// TopUp 记录一次充值、增加买家余额并写入充值分录，钱包不存在时自动创建
+// 同一买家的外部流水号已经入账时不重复充值，返回 false。
+func (ws *WalletStore) TopUp(topUp *model.WalletTopUp) (bool, error) {
+	tx, err := ws.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO wallet_topups (user_id, amount_micros, reference, note, created_by_user_id)
+		VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5)
+		ON CONFLICT (user_id, reference) WHERE reference IS NOT NULL DO NOTHING
+		RETURNING topup_id, created_at`
+
+	err = tx.QueryRow(query, topUp.UserID, topUp.AmountMicros, topUp.Reference, topUp.Note,
+		topUp.CreatedByUserID).Scan(&topUp.TopUpID, &topUp.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to create wallet top-up: %w", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO buyer_wallets (user_id, balance_micros)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET balance_micros = buyer_wallets.balance_micros + EXCLUDED.balance_micros`,
+		topUp.UserID, topUp.AmountMicros)
+	if err != nil {
+		return false, fmt.Errorf("failed to credit buyer wallet: %w", err)
+	}
+
+	// 充值资金进入平台，平台对买家的欠款（钱包余额）相应增加
+	entry := &model.LedgerEntry{
+		EntryType:       model.LedgerEntryTopUp,
+		ReferenceID:     &topUp.TopUpID,
+		Description:     topUp.Note,
+		CreatedByUserID: topUp.CreatedByUserID,
+	}
+	err = postLedgerEntry(tx, entry, []ledgerLine{
+		{accountType: model.LedgerAccountBuyerWallet, ownerUserID: topUp.UserID, amountMicros: topUp.AmountMicros},
+		{accountType: model.LedgerAccountPlatformCash, amountMicros: -topUp.AmountMicros},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit wallet top-up: %w", err)
+	}
+	return true, nil
+}
+
+// ListTopUps 获取买家的充值记录，按时间倒序
+func (ws *WalletStore) ListTopUps(userID int64, limit int) ([]*model.WalletTopUp, error) {
+	query := `
+		SELECT topup_id, user_id, amount_micros, COALESCE(reference, ''), COALESCE(note, ''), created_by_user_id, created_at
+		FROM wallet_topups
+		WHERE user_id = $1
+		ORDER BY created_at DESC, topup_id DESC
+		LIMIT $2`
+
+	rows, err := ws.DB.Query(query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wallet top-ups: %w", err)
+	}
+	defer rows.Close()
+
+	var topUps []*model.WalletTopUp
+	for rows.Next() {
+		topUp := &model.WalletTopUp{}
+		if err := rows.Scan(&topUp.TopUpID, &topUp.UserID, &topUp.AmountMicros, &topUp.Reference,
+			&topUp.Note, &topUp.CreatedByUserID, &topUp.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan wallet top-up: %w", err)
+		}
+		topUps = append(topUps, topUp)
+	}
+	return topUps, rows.Err()
+}
+
+// ReserveHold 在买家钱包中冻结一次调用的预估费用
+// 可用余额（余额 - 已冻结）不足时返回 false。检查与冻结在同一条 UPDATE 中完成，
+// 并发请求在钱包行锁上排队，不会超额冻结。
+func (ws *WalletStore) ReserveHold(hold *model.WalletHold) (bool, error) {
+	tx, err := ws.DB.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE buyer_wallets
+		SET held_micros = held_micros + $2
+		WHERE user_id = $1 AND balance_micros - held_micros >= $2`,
+		hold.UserID, hold.AmountMicros)
+	if err != nil {
+		return false, fmt.Errorf("failed to reserve wallet funds: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		return false, nil
+	}
+
+	query := `
+		INSERT INTO wallet_holds (user_id, service_id, request_id, amount_micros, expires_at)
+		VALUES ($1, $2, NULLIF($3, '')::uuid, $4, $5)
+		RETURNING hold_id, status, created_at`
+
+	err = tx.QueryRow(query, hold.UserID, hold.ServiceID, hold.RequestID, hold.AmountMicros,
+		hold.ExpiresAt).Scan(&hold.HoldID, &hold.Status, &hold.CreatedAt)
+	if err != nil {
+		return false, fmt.Errorf("failed to create wallet hold: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit wallet hold: %w", err)
+	}
+	return true, nil
+}
+
+// SettleUsage 结算一次调用：解除转发前的冻结，从买家余额中扣除实际费用，并写入计费分录
+// 费用为 0 时只释放冻结。冻结已被过期任务释放时仍扣除实际费用；已结算的冻结不会重复扣费。
+// 扣费不超过冻结金额加可用余额，不足的部分记为冻结的 shortfall_micros 并写回 charge.ShortfallMicros，
+// charge.AmountMicros 随之改为实际扣除的金额，余额不会被扣成负数。
+func (ws *WalletStore) SettleUsage(charge *model.UsageCharge) error {
+	tx, err := ws.DB.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var releaseMicros int64
+	if charge.HoldID != 0 {
+		query := `
+			SELECT amount_micros, status FROM wallet_holds
+			WHERE hold_id = $1 AND status IN ('held', 'expired')
+			FOR UPDATE`
+
+		var amountMicros int64
+		var previousStatus string
+		err = tx.QueryRow(query, charge.HoldID).Scan(&amountMicros, &previousStatus)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return nil
+			}
+			return fmt.Errorf("failed to get wallet hold: %w", err)
+		}
+
+		// 已过期的冻结在过期时已经解除，这里只扣除实际费用
+		if previousStatus == "held" {
+			releaseMicros = amountMicros
+		}
+	}
+
+	if charge.AmountMicros > 0 {
+		var availableMicros int64
+		err = tx.QueryRow(`
+			SELECT balance_micros - held_micros FROM buyer_wallets
+			WHERE user_id = $1
+			FOR UPDATE`, charge.BuyerUserID).Scan(&availableMicros)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("failed to get buyer wallet: %w", err)
+		}
+
+		// 本次冻结在扣费时一并解除，可以用来支付
+		payableMicros := availableMicros + releaseMicros
+		if payableMicros < 0 {
+			payableMicros = 0
+		}
+		if charge.AmountMicros > payableMicros {
+			charge.ShortfallMicros = charge.AmountMicros - payableMicros
+			charge.AmountMicros = payableMicros
+		}
+	}
+
+	if charge.HoldID != 0 {
+		_, err = tx.Exec(`
+			UPDATE wallet_holds
+			SET status = CASE WHEN $2::bigint > 0 OR $3::bigint > 0 THEN 'settled' ELSE 'released' END,
+				settled_micros = $2::bigint, shortfall_micros = $3::bigint,
+				usage_log_id = NULLIF($4::bigint, 0), settled_at = NOW()
+			WHERE hold_id = $1`,
+			charge.HoldID, charge.AmountMicros, charge.ShortfallMicros, charge.UsageLogID)
+		if err != nil {
+			return fmt.Errorf("failed to settle wallet hold: %w", err)
+		}
+	}
+
+	if releaseMicros == 0 && charge.AmountMicros == 0 {
+		return tx.Commit()
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO buyer_wallets (user_id, balance_micros)
+		VALUES ($1, 0 - $3::bigint)
+		ON CONFLICT (user_id) DO UPDATE
+		SET held_micros = buyer_wallets.held_micros - $2, balance_micros = buyer_wallets.balance_micros - $3::bigint`,
+		charge.BuyerUserID, releaseMicros, charge.AmountMicros)
+	if err != nil {
+		return fmt.Errorf("failed to settle buyer wallet: %w", err)
+	}
+
+	if charge.AmountMicros > 0 {
+		entry := &model.LedgerEntry{EntryType: model.LedgerEntryUsageCharge, ServiceID: &charge.ServiceID}
+		if charge.UsageLogID != 0 {
+			entry.ReferenceID = &charge.UsageLogID
+		}
+		if err := postLedgerEntry(tx, entry, usageChargeLines(charge)); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit usage settlement: %w", err)
+	}
+	return nil
+}
+
+// ReleaseExpiredHolds 释放超过有效期仍未结算的冻结（例如进程在请求中途退出），返回释放的冻结数量
+func (ws *WalletStore) ReleaseExpiredHolds() (int64, error) {
+	query := `
+		WITH expired AS (
+			UPDATE wallet_holds
+			SET status = 'expired', settled_at = NOW()
+			WHERE status = 'held' AND expires_at < NOW()
+			RETURNING user_id, amount_micros
+		), totals AS (
+			SELECT user_id, SUM(amount_micros) AS amount_micros, COUNT(*) AS holds
+			FROM expired
+			GROUP BY user_id
+		), released AS (
+			UPDATE buyer_wallets w
+			SET held_micros = w.held_micros - t.amount_micros
+			FROM totals t
+			WHERE w.user_id = t.user_id
+			RETURNING t.holds
+		)
+		SELECT COALESCE(SUM(holds), 0) FROM released`
+
+	var released int64
+	if err := ws.DB.QueryRow(query).Scan(&released); err != nil {
+		return 0, fmt.Errorf("failed to release expired wallet holds: %w", err)
+	}
+	return released, nil
+}
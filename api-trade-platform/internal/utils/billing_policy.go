@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"api-trade-platform/internal/model"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// maxBillableStatusCodes 计费策略中可声明的状态码条目上限
+const maxBillableStatusCodes = 50
+
+// NormalizeBillingPolicy 校验计费策略并规范化状态码写法（小写、去重），5xx 状态码不允许计费
+func NormalizeBillingPolicy(policy *model.BillingPolicy) error {
+	if len(policy.BillableStatusCodes) > maxBillableStatusCodes {
+		return fmt.Errorf("at most %d billable status codes are allowed", maxBillableStatusCodes)
+	}
+
+	seen := make(map[string]bool, len(policy.BillableStatusCodes))
+	codes := make([]string, 0, len(policy.BillableStatusCodes))
+	for _, code := range policy.BillableStatusCodes {
+		code = strings.ToLower(strings.TrimSpace(code))
+		class, exact, ok := parseStatusPattern(code)
+		if !ok {
+			return fmt.Errorf("invalid billable status code %q, expected e.g. 200 or 2xx", code)
+		}
+		if class == 5 || exact >= 500 {
+			return fmt.Errorf("status code %q cannot be billable: failed calls are never charged", code)
+		}
+		if !seen[code] {
+			seen[code] = true
+			codes = append(codes, code)
+		}
+	}
+	policy.BillableStatusCodes = codes
+	if len(codes) == 0 {
+		policy.BillableStatusCodes = nil
+	}
+
+	if policy.MaxBillableLatencyMs < 0 {
+		return fmt.Errorf("max_billable_latency_ms must not be negative")
+	}
+
+	if sla := policy.SLA; sla != nil {
+		if sla.UptimeTargetBps <= 0 || sla.UptimeTargetBps > 10000 {
+			return fmt.Errorf("sla uptime_target_bps must be between 1 and 10000")
+		}
+		if sla.LatencyP95TargetMs < 0 {
+			return fmt.Errorf("sla latency_p95_target_ms must not be negative")
+		}
+		if sla.CreditBps <= 0 || sla.CreditBps > 10000 {
+			return fmt.Errorf("sla credit_bps must be between 1 and 10000")
+		}
+	}
+	return nil
+}
+
+// parseStatusPattern 解析 "404" 或 "4xx" 形式的状态码，返回状态码类别或具体状态码
+func parseStatusPattern(code string) (class int, exact int, ok bool) {
+	if len(code) != 3 {
+		return 0, 0, false
+	}
+	if strings.HasSuffix(code, "xx") {
+		class = int(code[0] - '0')
+		return class, 0, class >= 1 && class <= 5
+	}
+	exact, err := strconv.Atoi(code)
+	if err != nil || exact < 100 || exact > 599 {
+		return 0, 0, false
+	}
+	return 0, exact, true
+}
+
+// IsBillableStatus 判断响应状态码是否可计费，策略未声明状态码时只有 2xx 计费，5xx 始终不计费
+func IsBillableStatus(policy model.BillingPolicy, statusCode int) bool {
+	if statusCode >= 500 || statusCode < 100 {
+		return false
+	}
+	if len(policy.BillableStatusCodes) == 0 {
+		return statusCode >= 200 && statusCode < 300
+	}
+	for _, code := range policy.BillableStatusCodes {
+		class, exact, ok := parseStatusPattern(code)
+		if !ok {
+			continue
+		}
+		if exact == statusCode || (class != 0 && statusCode/100 == class) {
+			return true
+		}
+	}
+	return false
+}
+
+// ZeroRatedReason 按计费策略判断调用是否不计费，返回不计费原因，可计费时返回空字符串
+func ZeroRatedReason(policy model.BillingPolicy, statusCode, processingTimeMs int) string {
+	if !IsBillableStatus(policy, statusCode) {
+		return model.ZeroRatedStatusNotBillable
+	}
+	if policy.MaxBillableLatencyMs > 0 && processingTimeMs > policy.MaxBillableLatencyMs {
+		return model.ZeroRatedLatencyExceeded
+	}
+	return ""
+}
+
+// SLAUptimeBps 计算可用率（基点），没有调用时视为 100%
+func SLAUptimeBps(totalCalls, failedCalls int64) int {
+	if totalCalls <= 0 {
+		return 10000
+	}
+	return int((totalCalls - failedCalls) * 10000 / totalCalls)
+}
+
+// SLABreached 判断月度可用率或 P95 延迟是否未达到 SLA 目标
+func SLABreached(sla *model.ServiceSLA, uptimeBps, latencyP95Ms int) bool {
+	if sla == nil {
+		return false
+	}
+	if uptimeBps < sla.UptimeTargetBps {
+		return true
+	}
+	return sla.LatencyP95TargetMs > 0 && latencyP95Ms > sla.LatencyP95TargetMs
+}
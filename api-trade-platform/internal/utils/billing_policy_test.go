@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"api-trade-platform/internal/model"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeBillingPolicy(t *testing.T) {
+	policy := model.BillingPolicy{BillableStatusCodes: []string{" 2XX", "404", "2xx"}}
+	if err := NormalizeBillingPolicy(&policy); err != nil {
+		t.Fatalf("NormalizeBillingPolicy() error = %v", err)
+	}
+	if want := []string{"2xx", "404"}; !reflect.DeepEqual(policy.BillableStatusCodes, want) {
+		t.Errorf("BillableStatusCodes = %v, want %v", policy.BillableStatusCodes, want)
+	}
+
+	invalid := []model.BillingPolicy{
+		{BillableStatusCodes: []string{"5xx"}},
+		{BillableStatusCodes: []string{"503"}},
+		{BillableStatusCodes: []string{"20"}},
+		{MaxBillableLatencyMs: -1},
+		{SLA: &model.ServiceSLA{UptimeTargetBps: 0, CreditBps: 1000}},
+		{SLA: &model.ServiceSLA{UptimeTargetBps: 9990, CreditBps: 20000}},
+	}
+	for _, p := range invalid {
+		p := p
+		if err := NormalizeBillingPolicy(&p); err == nil {
+			t.Errorf("NormalizeBillingPolicy(%+v) expected error", p)
+		}
+	}
+}
+
+func TestZeroRatedReason(t *testing.T) {
+	custom := model.BillingPolicy{BillableStatusCodes: []string{"2xx", "404"}, MaxBillableLatencyMs: 30000}
+
+	tests := []struct {
+		name   string
+		policy model.BillingPolicy
+		status int
+		ms     int
+		want   string
+	}{
+		{name: "default bills 2xx", status: 200, ms: 100, want: ""},
+		{name: "default does not bill 4xx", status: 429, ms: 100, want: model.ZeroRatedStatusNotBillable},
+		{name: "5xx never billable", policy: model.BillingPolicy{BillableStatusCodes: []string{"2xx"}}, status: 502, want: model.ZeroRatedStatusNotBillable},
+		{name: "declared exact code", policy: custom, status: 404, ms: 100, want: ""},
+		{name: "undeclared code", policy: custom, status: 400, ms: 100, want: model.ZeroRatedStatusNotBillable},
+		{name: "over latency limit", policy: custom, status: 200, ms: 30001, want: model.ZeroRatedLatencyExceeded},
+	}
+	for _, tt := range tests {
+		if got := ZeroRatedReason(tt.policy, tt.status, tt.ms); got != tt.want {
+			t.Errorf("%s: ZeroRatedReason() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSLABreached(t *testing.T) {
+	sla := &model.ServiceSLA{UptimeTargetBps: 9990, LatencyP95TargetMs: 3000, CreditBps: 1000}
+
+	if got := SLAUptimeBps(10000, 15); got != 9985 {
+		t.Errorf("SLAUptimeBps() = %d, want 9985", got)
+	}
+	if SLABreached(sla, 9990, 3000) {
+		t.Error("SLABreached() = true at exactly the targets")
+	}
+	if !SLABreached(sla, 9985, 1000) {
+		t.Error("SLABreached() = false with uptime below target")
+	}
+	if !SLABreached(sla, 10000, 3500) {
+		t.Error("SLABreached() = false with p95 latency above target")
+	}
+}
@@ -4,9 +4,13 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"io"
+	"strings"
 
 	"github.com/google/uuid"
 )
@@ -57,9 +61,37 @@ func DecryptAPIKey(ciphertext, key string) (string, error) {
 	return string(ciphertextBytes), nil
 }
 
-// GeneratePlatformAPIKey 生成平台API密钥
+// PlatformAPIKeyPrefixLength 平台API密钥公开前缀的长度（含 pak_）
+const PlatformAPIKeyPrefixLength = 12
+
+// GeneratePlatformAPIKey 生成平台API密钥：pak_ 加 64 位十六进制随机字符
 func GeneratePlatformAPIKey() string {
-	return "pak_" + uuid.New().String()
+	return "pak_" + strings.ReplaceAll(uuid.New().String()+uuid.New().String(), "-", "")
+}
+
+// PlatformAPIKeyPrefix 获取平台API密钥的公开前缀，用于查找密钥记录
+func PlatformAPIKeyPrefix(apiKey string) string {
+	if len(apiKey) < PlatformAPIKeyPrefixLength {
+		return apiKey
+	}
+	return apiKey[:PlatformAPIKeyPrefixLength]
+}
+
+// HashPlatformAPIKey 计算平台API密钥的 SHA-256 哈希（十六进制）
+// 密钥是高熵随机串，不需要加盐或慢哈希
+func HashPlatformAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyPlatformAPIKey 以常量时间比较密钥哈希
+func VerifyPlatformAPIKey(apiKey, keyHash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashPlatformAPIKey(apiKey)), []byte(keyHash)) == 1
+}
+
+// MaskPlatformAPIKey 根据公开前缀生成脱敏展示的密钥
+func MaskPlatformAPIKey(keyPrefix string) string {
+	return keyPrefix + "****************"
 }
 
 // GenerateServiceProxyPrefix 生成服务代理前缀
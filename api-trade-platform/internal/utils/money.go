@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+)
+
+// MicrosPerUSD 1 美元对应的微美元数，钱包金额均以微美元整数保存
+const MicrosPerUSD = 1000000
+
+// USDToMicros 将美元金额换算为微美元，四舍五入到最近的整数
+func USDToMicros(usd float64) int64 {
+	return int64(math.Round(usd * MicrosPerUSD))
+}
+
+// MicrosToUSD 将微美元换算为美元金额，仅用于展示
+func MicrosToUSD(micros int64) float64 {
+	return float64(micros) / MicrosPerUSD
+}
+
+// FormatMicros 将微美元格式化为精确的十进制金额字符串（6 位小数），用于导出文件，例如 -1500 为 "-0.001500"
+func FormatMicros(micros int64) string {
+	sign := ""
+	if micros < 0 {
+		sign = "-"
+		micros = -micros
+	}
+	return fmt.Sprintf("%s%d.%06d", sign, micros/MicrosPerUSD, micros%MicrosPerUSD)
+}
@@ -0,0 +1,374 @@
+package utils
+
+import (
+	"api-trade-platform/internal/model"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// tokensPerPriceUnit token 价格的计价单位（每百万 token）
+const tokensPerPriceUnit = 1000000
+
+// maxPricingTiers 定价计划最多允许的价格档数
+const maxPricingTiers = 20
+
+// BillingPeriodStart 返回 t 所在计费周期（UTC 自然月）的开始时间
+func BillingPeriodStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// FlatPricingPlan 将服务的固定价格字段转换为只有一档、没有免费额度的定价计划
+// per_token 的单价同时作为输入和输出 token 的价格，与原先按总 token 数计费一致。
+func FlatPricingPlan(pricingModel string, pricePerCall, pricePerToken float64) *model.PricingPlan {
+	if pricingModel == "per_token" {
+		perMillion := int64(math.Round(pricePerToken * MicrosPerUSD * tokensPerPriceUnit))
+		return &model.PricingPlan{
+			Unit: model.PricingUnitToken,
+			Mode: model.PricingModeGraduated,
+			Tiers: []model.PricingTier{{
+				InputPricePer1MMicros:  perMillion,
+				OutputPricePer1MMicros: perMillion,
+			}},
+		}
+	}
+	return &model.PricingPlan{
+		Unit:  model.PricingUnitCall,
+		Mode:  model.PricingModeGraduated,
+		Tiers: []model.PricingTier{{PricePerCallMicros: USDToMicros(pricePerCall)}},
+	}
+}
+
+// EffectivePricingPlan 返回服务实际生效的定价计划，未配置阶梯定价时由固定价格字段生成
+func EffectivePricingPlan(service *model.APIService) *model.PricingPlan {
+	if service.PricingPlan != nil {
+		return service.PricingPlan
+	}
+	return FlatPricingPlan(service.PricingModel, service.PricePerCall, service.PricePerToken)
+}
+
+// ModelPricingPlan 返回按模型计费时实际生效的定价计划
+// 模型设置了每1K token 价格时，以它替换服务定价计划各档的输入/输出价格，免费额度、分档和各类别的单独定价保持不变；
+// 模型某一侧价格为 0 时沿用服务价格。按次计费的服务改为只有一档的按 token 定价。
+func ModelPricingPlan(service *model.APIService, serviceModel *model.ServiceModel) *model.PricingPlan {
+	plan := EffectivePricingPlan(service)
+	if serviceModel == nil || !serviceModel.HasPricing() {
+		return plan
+	}
+
+	inputPrice := pricePer1KToPer1MMicros(serviceModel.InputPricePer1K)
+	outputPrice := pricePer1KToPer1MMicros(serviceModel.OutputPricePer1K)
+	if plan.Unit != model.PricingUnitToken {
+		return &model.PricingPlan{
+			Unit: model.PricingUnitToken,
+			Mode: model.PricingModeGraduated,
+			Tiers: []model.PricingTier{{
+				InputPricePer1MMicros:  inputPrice,
+				OutputPricePer1MMicros: outputPrice,
+			}},
+		}
+	}
+
+	modelPlan := *plan
+	modelPlan.Tiers = make([]model.PricingTier, len(plan.Tiers))
+	for i, tier := range plan.Tiers {
+		if inputPrice > 0 {
+			tier.InputPricePer1MMicros = inputPrice
+		}
+		if outputPrice > 0 {
+			tier.OutputPricePer1MMicros = outputPrice
+		}
+		modelPlan.Tiers[i] = tier
+	}
+	return &modelPlan
+}
+
+// pricePer1KToPer1MMicros 将每1K token 的美元价格换算为每百万 token 的微美元价格，四舍五入
+func pricePer1KToPer1MMicros(pricePer1K float64) int64 {
+	return int64(math.Round(pricePer1K * MicrosPerUSD * tokensPerPriceUnit / 1000))
+}
+
+// NormalizePricingPlan 校验定价计划并填充默认值
+func NormalizePricingPlan(plan *model.PricingPlan) error {
+	plan.Unit = strings.ToLower(strings.TrimSpace(plan.Unit))
+	if plan.Unit != model.PricingUnitCall && plan.Unit != model.PricingUnitToken {
+		return fmt.Errorf("unit must be call or token")
+	}
+
+	plan.Mode = strings.ToLower(strings.TrimSpace(plan.Mode))
+	switch plan.Mode {
+	case "":
+		plan.Mode = model.PricingModeGraduated
+	case model.PricingModeGraduated, model.PricingModeVolume:
+	default:
+		return fmt.Errorf("mode must be graduated or volume")
+	}
+
+	if plan.IncludedUnits < 0 {
+		return fmt.Errorf("included_units cannot be negative")
+	}
+	if len(plan.Tiers) == 0 || len(plan.Tiers) > maxPricingTiers {
+		return fmt.Errorf("a plan needs between 1 and %d tiers", maxPricingTiers)
+	}
+
+	var previousUpTo int64
+	for i, tier := range plan.Tiers {
+		last := i == len(plan.Tiers)-1
+		if last && tier.UpTo != 0 {
+			return fmt.Errorf("the last tier must be unbounded (up_to 0)")
+		}
+		if !last && tier.UpTo <= previousUpTo {
+			return fmt.Errorf("tier %d: up_to must be greater than the previous tier", i+1)
+		}
+		if tier.PricePerCallMicros < 0 || tier.InputPricePer1MMicros < 0 || tier.OutputPricePer1MMicros < 0 {
+			return fmt.Errorf("tier %d: prices cannot be negative", i+1)
+		}
+		categoryPrices := []*int64{
+			tier.CachedInputPricePer1MMicros, tier.CacheWritePricePer1MMicros, tier.ReasoningPricePer1MMicros,
+			tier.AudioInputPricePer1MMicros, tier.AudioOutputPricePer1MMicros,
+			tier.ImageInputPricePer1MMicros, tier.ImageOutputPricePer1MMicros,
+		}
+		hasCategoryPrice := false
+		for _, price := range categoryPrices {
+			if price != nil {
+				if *price < 0 {
+					return fmt.Errorf("tier %d: prices cannot be negative", i+1)
+				}
+				hasCategoryPrice = true
+			}
+		}
+		if plan.Unit == model.PricingUnitCall && (tier.InputPricePer1MMicros != 0 || tier.OutputPricePer1MMicros != 0 || hasCategoryPrice) {
+			return fmt.Errorf("tier %d: call plans only take price_per_call_micros", i+1)
+		}
+		if plan.Unit == model.PricingUnitToken && tier.PricePerCallMicros != 0 {
+			return fmt.Errorf("tier %d: token plans only take input and output token prices", i+1)
+		}
+		previousUpTo = tier.UpTo
+	}
+	return nil
+}
+
+// PricingPlanNeedsPeriodUsage 判断计算费用是否需要买家本周期的累计用量
+func PricingPlanNeedsPeriodUsage(plan *model.PricingPlan) bool {
+	return plan.IncludedUnits > 0 || len(plan.Tiers) > 1
+}
+
+// tokenCategory 按价格区分的 token 类别
+type tokenCategory int
+
+const (
+	textInputTokens tokenCategory = iota
+	cachedInputTokens
+	cacheWriteTokens
+	audioInputTokens
+	imageInputTokens
+	textOutputTokens
+	reasoningTokens
+	audioOutputTokens
+	imageOutputTokens
+)
+
+// tokenSegment 代表一次调用中同一类别的 token 数
+type tokenSegment struct {
+	category tokenCategory
+	units    int64
+}
+
+// tokenSegments 将一次调用的 token 用量拆分为各价格类别，输入类别排在输出类别之前
+// 普通文本输入/输出为总数减去单独计价的类别。
+func tokenSegments(usage *TokenUsage) []tokenSegment {
+	textInput := usage.InputTokens - usage.CachedInputTokens - usage.CacheWriteTokens - usage.AudioInputTokens - usage.ImageInputTokens
+	textOutput := usage.OutputTokens - usage.ReasoningTokens - usage.AudioOutputTokens - usage.ImageOutputTokens
+
+	segments := []tokenSegment{
+		{textInputTokens, int64(textInput)},
+		{cachedInputTokens, int64(usage.CachedInputTokens)},
+		{cacheWriteTokens, int64(usage.CacheWriteTokens)},
+		{audioInputTokens, int64(usage.AudioInputTokens)},
+		{imageInputTokens, int64(usage.ImageInputTokens)},
+		{textOutputTokens, int64(textOutput)},
+		{reasoningTokens, int64(usage.ReasoningTokens)},
+		{audioOutputTokens, int64(usage.AudioOutputTokens)},
+		{imageOutputTokens, int64(usage.ImageOutputTokens)},
+	}
+	for i := range segments {
+		if segments[i].units < 0 {
+			segments[i].units = 0
+		}
+	}
+	return segments
+}
+
+// tierTokenPrice 返回一档价格中某个 token 类别的每百万 token 价格，类别未单独定价时使用输入或输出价格
+func tierTokenPrice(tier model.PricingTier, category tokenCategory) int64 {
+	var override *int64
+	base := tier.InputPricePer1MMicros
+	switch category {
+	case cachedInputTokens:
+		override = tier.CachedInputPricePer1MMicros
+	case cacheWriteTokens:
+		override = tier.CacheWritePricePer1MMicros
+	case audioInputTokens:
+		override = tier.AudioInputPricePer1MMicros
+	case imageInputTokens:
+		override = tier.ImageInputPricePer1MMicros
+	case textOutputTokens:
+		base = tier.OutputPricePer1MMicros
+	case reasoningTokens:
+		base, override = tier.OutputPricePer1MMicros, tier.ReasoningPricePer1MMicros
+	case audioOutputTokens:
+		base, override = tier.OutputPricePer1MMicros, tier.AudioOutputPricePer1MMicros
+	case imageOutputTokens:
+		base, override = tier.OutputPricePer1MMicros, tier.ImageOutputPricePer1MMicros
+	}
+	if override != nil {
+		return *override
+	}
+	return base
+}
+
+// unitRange 代表计费周期内累计用量的一段区间 [start, end)
+type unitRange struct {
+	start, end int64
+}
+
+// overlap 返回两段区间重叠的单位数，end 为 0 表示无上限
+func (r unitRange) overlap(other unitRange) int64 {
+	start, end := r.start, r.end
+	if other.start > start {
+		start = other.start
+	}
+	if other.end != 0 && other.end < end {
+		end = other.end
+	}
+	if end <= start {
+		return 0
+	}
+	return end - start
+}
+
+// pricedRange 代表本次调用中按同一价格类别计费的一段累计用量
+type pricedRange struct {
+	unitRange
+	category tokenCategory
+}
+
+// PricingPlanCostMicros 按定价计划计算一次调用的费用（微美元）
+// prior 为本次调用之前买家本周期的累计用量，call 计划不使用 usage。token 计划中本次调用的 token 按
+// tokenSegments 的类别顺序计入累计用量，每个类别按所在档中该类别的价格计费；
+// 累计用量落在每月免费额度内的部分不计费。graduated 计划中跨档的用量分别按各档价格计费，
+// volume 计划中本次调用的全部计费用量按加上本次用量后累计用量所在档的价格计费。
+func PricingPlanCostMicros(plan *model.PricingPlan, prior model.PeriodUsage, usage *TokenUsage) int64 {
+	if len(plan.Tiers) == 0 {
+		return 0
+	}
+
+	var ranges []pricedRange
+	if plan.Unit == model.PricingUnitCall {
+		ranges = []pricedRange{{unitRange: unitRange{start: prior.Calls, end: prior.Calls + 1}}}
+	} else {
+		if usage == nil {
+			return 0
+		}
+		position := prior.Tokens
+		for _, segment := range tokenSegments(usage) {
+			if segment.units > 0 {
+				ranges = append(ranges, pricedRange{unitRange{position, position + segment.units}, segment.category})
+				position += segment.units
+			}
+		}
+		if len(ranges) == 0 {
+			return 0
+		}
+	}
+	billable := unitRange{start: plan.IncludedUnits}
+
+	total := new(big.Int)
+	if plan.Mode == model.PricingModeVolume {
+		lastUnit := ranges[len(ranges)-1].end - 1
+		tier := plan.Tiers[len(plan.Tiers)-1]
+		for _, t := range plan.Tiers {
+			if t.UpTo == 0 || lastUnit < t.UpTo {
+				tier = t
+				break
+			}
+		}
+		for _, r := range ranges {
+			addRangeCost(total, plan.Unit, tier, r, r.overlap(billable))
+		}
+	} else {
+		var lower int64
+		for _, tier := range plan.Tiers {
+			tierRange := unitRange{start: lower, end: tier.UpTo}
+			if tierRange.start < billable.start {
+				tierRange.start = billable.start
+			}
+			for _, r := range ranges {
+				addRangeCost(total, plan.Unit, tier, r, r.overlap(tierRange))
+			}
+			lower = tier.UpTo
+		}
+	}
+
+	if plan.Unit == model.PricingUnitToken {
+		// 每百万 token 价格换算为微美元，四舍五入
+		total.Add(total, big.NewInt(tokensPerPriceUnit/2))
+		total.Quo(total, big.NewInt(tokensPerPriceUnit))
+	}
+	if !total.IsInt64() {
+		return math.MaxInt64
+	}
+	return total.Int64()
+}
+
+// addRangeCost 将一段用量中 units 个单位按一档价格的费用累加到 total
+func addRangeCost(total *big.Int, unit string, tier model.PricingTier, r pricedRange, units int64) {
+	if unit == model.PricingUnitCall {
+		addUnitsCost(total, units, tier.PricePerCallMicros)
+		return
+	}
+	addUnitsCost(total, units, tierTokenPrice(tier, r.category))
+}
+
+// addUnitsCost 将 units 个单位按单价 price 的费用累加到 total
+func addUnitsCost(total *big.Int, units, price int64) {
+	if units > 0 && price > 0 {
+		total.Add(total, new(big.Int).Mul(big.NewInt(units), big.NewInt(price)))
+	}
+}
+
+// PricingPlanMaxCostMicros 按定价计划中最高的价格预估一次调用的最高费用（不考虑免费额度），向上取整
+// 输入和输出 token 分别按所有档、所有输入或输出类别中最高的价格计算。
+func PricingPlanMaxCostMicros(plan *model.PricingPlan, inputTokens, outputTokens int64) int64 {
+	var maxCall, maxInput, maxOutput int64
+	for _, tier := range plan.Tiers {
+		if tier.PricePerCallMicros > maxCall {
+			maxCall = tier.PricePerCallMicros
+		}
+		for category := textInputTokens; category <= imageOutputTokens; category++ {
+			price := tierTokenPrice(tier, category)
+			if category < textOutputTokens && price > maxInput {
+				maxInput = price
+			}
+			if category >= textOutputTokens && price > maxOutput {
+				maxOutput = price
+			}
+		}
+	}
+	if plan.Unit == model.PricingUnitCall {
+		return maxCall
+	}
+
+	total := new(big.Int)
+	addUnitsCost(total, inputTokens, maxInput)
+	addUnitsCost(total, outputTokens, maxOutput)
+	total.Add(total, big.NewInt(tokensPerPriceUnit-1))
+	total.Quo(total, big.NewInt(tokensPerPriceUnit))
+	if !total.IsInt64() {
+		return math.MaxInt64
+	}
+	return total.Int64()
+}
@@ -0,0 +1,208 @@
+package utils
+
+import (
+	"api-trade-platform/internal/model"
+	"testing"
+)
+
+func TestPricingPlanCostMicros(t *testing.T) {
+	tokenTiers := []model.PricingTier{
+		{UpTo: 1000000, InputPricePer1MMicros: 2000000, OutputPricePer1MMicros: 8000000},
+		{InputPricePer1MMicros: 1000000, OutputPricePer1MMicros: 4000000},
+	}
+
+	tests := []struct {
+		name  string
+		plan  *model.PricingPlan
+		prior model.PeriodUsage
+		usage *TokenUsage
+		want  int64
+	}{
+		{
+			name:  "flat per call",
+			plan:  FlatPricingPlan("per_call", 0.01, 0),
+			prior: model.PeriodUsage{Calls: 500},
+			want:  10000,
+		},
+		{
+			name:  "flat per token matches total tokens times price",
+			plan:  FlatPricingPlan("per_token", 0, 0.000002),
+			usage: &TokenUsage{InputTokens: 1200, OutputTokens: 300},
+			want:  3000,
+		},
+		{
+			name:  "graduated within first tier",
+			plan:  &model.PricingPlan{Unit: model.PricingUnitToken, Mode: model.PricingModeGraduated, Tiers: tokenTiers},
+			usage: &TokenUsage{InputTokens: 1000, OutputTokens: 500},
+			want:  6000,
+		},
+		{
+			name:  "graduated call crossing the tier boundary",
+			plan:  &model.PricingPlan{Unit: model.PricingUnitToken, Mode: model.PricingModeGraduated, Tiers: tokenTiers},
+			prior: model.PeriodUsage{Tokens: 999000},
+			usage: &TokenUsage{InputTokens: 500, OutputTokens: 1000},
+			// 500 input + 500 output at tier 1, 500 output at tier 2
+			want: 1000 + 4000 + 2000,
+		},
+		{
+			name:  "volume prices the whole call at the reached tier",
+			plan:  &model.PricingPlan{Unit: model.PricingUnitToken, Mode: model.PricingModeVolume, Tiers: tokenTiers},
+			prior: model.PeriodUsage{Tokens: 999000},
+			usage: &TokenUsage{InputTokens: 500, OutputTokens: 1000},
+			want:  500 + 4000,
+		},
+		{
+			name: "included quota then overage",
+			plan: &model.PricingPlan{
+				Unit: model.PricingUnitCall, Mode: model.PricingModeGraduated, IncludedUnits: 100,
+				Tiers: []model.PricingTier{{PricePerCallMicros: 5000}},
+			},
+			prior: model.PeriodUsage{Calls: 99},
+			want:  0,
+		},
+		{
+			name: "first call beyond included quota",
+			plan: &model.PricingPlan{
+				Unit: model.PricingUnitCall, Mode: model.PricingModeGraduated, IncludedUnits: 100,
+				Tiers: []model.PricingTier{{PricePerCallMicros: 5000}},
+			},
+			prior: model.PeriodUsage{Calls: 100},
+			want:  5000,
+		},
+		{
+			name: "token call partly covered by included quota",
+			plan: &model.PricingPlan{
+				Unit: model.PricingUnitToken, Mode: model.PricingModeGraduated, IncludedUnits: 1000,
+				Tiers: []model.PricingTier{{InputPricePer1MMicros: 1000000, OutputPricePer1MMicros: 3000000}},
+			},
+			prior: model.PeriodUsage{Tokens: 800},
+			usage: &TokenUsage{InputTokens: 400, OutputTokens: 1000},
+			// 200 input tokens and all 1000 output tokens are billable
+			want: 200 + 3000,
+		},
+		{
+			name: "category prices with fallback to input and output prices",
+			plan: &model.PricingPlan{
+				Unit: model.PricingUnitToken, Mode: model.PricingModeGraduated,
+				Tiers: []model.PricingTier{{
+					InputPricePer1MMicros: 2000000, OutputPricePer1MMicros: 8000000,
+					CachedInputPricePer1MMicros: int64Ptr(500000), CacheWritePricePer1MMicros: int64Ptr(2500000),
+				}},
+			},
+			usage: &TokenUsage{InputTokens: 4000, CachedInputTokens: 2000, CacheWriteTokens: 1000, OutputTokens: 1000, ReasoningTokens: 400},
+			// 1000 text input, 2000 cached, 1000 cache write, 1000 output including reasoning at the output price
+			want: 2000 + 1000 + 2500 + 8000,
+		},
+		{
+			name: "categories keep their price across a tier boundary",
+			plan: &model.PricingPlan{
+				Unit: model.PricingUnitToken, Mode: model.PricingModeGraduated,
+				Tiers: []model.PricingTier{
+					{UpTo: 1000, InputPricePer1MMicros: 4000000, OutputPricePer1MMicros: 4000000, ReasoningPricePer1MMicros: int64Ptr(10000000)},
+					{InputPricePer1MMicros: 2000000, OutputPricePer1MMicros: 2000000, ReasoningPricePer1MMicros: int64Ptr(6000000)},
+				},
+			},
+			usage: &TokenUsage{InputTokens: 500, OutputTokens: 1000, ReasoningTokens: 600},
+			// 500 input + 400 text output in tier 1, reasoning 100 in tier 1 and 500 in tier 2
+			want: 2000 + 1600 + 1000 + 3000,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := PricingPlanCostMicros(tt.plan, tt.prior, tt.usage)
+			if got != tt.want {
+				t.Errorf("cost = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+func TestPricingPlanMaxCostMicros(t *testing.T) {
+	plan := &model.PricingPlan{
+		Unit: model.PricingUnitToken,
+		Tiers: []model.PricingTier{
+			{UpTo: 1000, InputPricePer1MMicros: 1000000, OutputPricePer1MMicros: 2000000},
+			{InputPricePer1MMicros: 3000000, OutputPricePer1MMicros: 1000000},
+		},
+	}
+	if got := PricingPlanMaxCostMicros(plan, 1000, 1000); got != 5000 {
+		t.Errorf("max cost = %d, want 5000", got)
+	}
+}
+
+func TestModelPricingPlan(t *testing.T) {
+	cachedPrice := int64(500000)
+	tiered := &model.APIService{PricingPlan: &model.PricingPlan{
+		Unit:          model.PricingUnitToken,
+		Mode:          model.PricingModeGraduated,
+		IncludedUnits: 100,
+		Tiers: []model.PricingTier{
+			{UpTo: 1000, InputPricePer1MMicros: 2000000, OutputPricePer1MMicros: 8000000, CachedInputPricePer1MMicros: &cachedPrice},
+			{InputPricePer1MMicros: 1000000, OutputPricePer1MMicros: 4000000},
+		},
+	}}
+	// 每1K 0.003 美元 = 每百万 3,000,000 微美元
+	serviceModel := &model.ServiceModel{InputPricePer1K: 0.003, OutputPricePer1K: 0.015}
+
+	plan := ModelPricingPlan(tiered, serviceModel)
+	if plan.IncludedUnits != 100 || len(plan.Tiers) != 2 || plan.Tiers[0].UpTo != 1000 {
+		t.Fatalf("model plan lost the service's quota or tiers: %+v", plan)
+	}
+	for i, tier := range plan.Tiers {
+		if tier.InputPricePer1MMicros != 3000000 || tier.OutputPricePer1MMicros != 15000000 {
+			t.Errorf("tier %d prices = %d/%d, want 3000000/15000000", i+1, tier.InputPricePer1MMicros, tier.OutputPricePer1MMicros)
+		}
+	}
+	if tiered.PricingPlan.Tiers[0].InputPricePer1MMicros != 2000000 {
+		t.Errorf("ModelPricingPlan modified the service's plan")
+	}
+
+	// 前 100 个 token 免费，其后 800 个文本输入、100 个缓存输入按第一档，输出跨入第二档
+	usage := &TokenUsage{InputTokens: 1000, CachedInputTokens: 100, OutputTokens: 200}
+	want := int64((800*3000000 + 100*500000 + 200*15000000 + 500000) / 1000000)
+	if got := PricingPlanCostMicros(plan, model.PeriodUsage{}, usage); got != want {
+		t.Errorf("model plan cost = %d, want %d", got, want)
+	}
+
+	perCall := &model.APIService{PricingModel: "per_call", PricePerCall: 0.01}
+	plan = ModelPricingPlan(perCall, serviceModel)
+	if got := PricingPlanCostMicros(plan, model.PeriodUsage{}, &TokenUsage{InputTokens: 1000, OutputTokens: 1000}); got != 18000 {
+		t.Errorf("per call service with model pricing cost = %d, want 18000", got)
+	}
+
+	if got := ModelPricingPlan(perCall, &model.ServiceModel{}); got.Unit != model.PricingUnitCall {
+		t.Errorf("model without pricing should keep the service plan, got %+v", got)
+	}
+}
+
+func TestNormalizePricingPlan(t *testing.T) {
+	valid := &model.PricingPlan{
+		Unit:  "Token",
+		Tiers: []model.PricingTier{{UpTo: 1000, InputPricePer1MMicros: 1}, {InputPricePer1MMicros: 1}},
+	}
+	if err := NormalizePricingPlan(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if valid.Unit != model.PricingUnitToken || valid.Mode != model.PricingModeGraduated {
+		t.Errorf("defaults not applied: unit=%q mode=%q", valid.Unit, valid.Mode)
+	}
+
+	invalid := []*model.PricingPlan{
+		{Unit: "request", Tiers: []model.PricingTier{{}}},
+		{Unit: model.PricingUnitCall},
+		{Unit: model.PricingUnitCall, Tiers: []model.PricingTier{{UpTo: 10, PricePerCallMicros: 1}}},
+		{Unit: model.PricingUnitCall, Tiers: []model.PricingTier{{UpTo: 10}, {UpTo: 5}, {}}},
+		{Unit: model.PricingUnitCall, Tiers: []model.PricingTier{{InputPricePer1MMicros: 1}}},
+		{Unit: model.PricingUnitToken, Mode: "tiered", Tiers: []model.PricingTier{{}}},
+	}
+	for i, plan := range invalid {
+		if err := NormalizePricingPlan(plan); err == nil {
+			t.Errorf("plan %d: expected validation error", i)
+		}
+	}
+}
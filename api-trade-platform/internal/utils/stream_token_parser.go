@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// maxStreamEventBytes bounds the memory kept for a single SSE line or event. Real usage
+// events are a few hundred bytes; larger events are discarded instead of buffered.
+const maxStreamEventBytes = 1 << 20
+
+// ErrStreamEventTooLarge is returned by Result when an event had to be discarded because it
+// exceeded maxStreamEventBytes, so the usage of the stream cannot be trusted
+var ErrStreamEventTooLarge = errors.New("stream event exceeds the maximum size, token usage not parsed")
+
+// StreamUsageParser incrementally consumes a text/event-stream body and accumulates token usage.
+// It implements io.Writer so the proxy can tee the upstream stream into it while forwarding
+// chunks to the buyer; only the current partial line and event are kept in memory, each bounded
+// by maxStreamEventBytes.
+//
+// Supported usage reports:
+//   - OpenAI: final chunk sent when stream_options.include_usage is set ({"usage": {...}})
+//   - Anthropic: message_start (message.usage.input_tokens) and message_delta (usage.output_tokens)
+//   - Gemini: usageMetadata attached to streamed GenerateContentResponse chunks
+type StreamUsageParser struct {
+	tokenParser *TokenParser
+	modelName   string
+	streamModel string
+
+	pending    []byte   // bytes of an incomplete line
+	eventType  string   // value of the current "event:" field
+	dataLines  []string // "data:" lines of the current event
+	eventBytes int      // total size of dataLines
+
+	skipLine  bool // drop bytes up to the next newline, the rest of an oversized line
+	skipEvent bool // drop lines up to the next blank line, the rest of an oversized event
+	oversized bool // an event was discarded, the usage of the stream is unparsed
+
+	usage     usageFields
+	sawUsage  bool
+	bytesRead int
+}
+
+// NewStreamUsageParser creates an incremental parser. modelName is the model extracted from the
+// request; when it is empty or "unknown" the model reported by the stream is used instead.
+func (tp *TokenParser) NewStreamUsageParser(modelName string) *StreamUsageParser {
+	return &StreamUsageParser{
+		tokenParser: tp,
+		modelName:   modelName,
+		usage:       usageFields{},
+	}
+}
+
+// Write feeds a chunk of the event stream into the parser. It never returns an error so it is
+// safe to use as the tee side of a proxy copy.
+func (p *StreamUsageParser) Write(chunk []byte) (int, error) {
+	p.bytesRead += len(chunk)
+	p.pending = append(p.pending, chunk...)
+
+	if p.skipLine {
+		idx := bytes.IndexByte(p.pending, '\n')
+		if idx < 0 {
+			p.pending = nil
+			return len(chunk), nil
+		}
+		p.pending = p.pending[idx+1:]
+		p.skipLine = false
+	}
+
+	for {
+		idx := bytes.IndexByte(p.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(p.pending[:idx]), "\r")
+		p.pending = p.pending[idx+1:]
+		p.processLine(line)
+	}
+
+	// Drop the consumed prefix so the backing array does not keep growing
+	if len(p.pending) == 0 {
+		p.pending = nil
+	}
+	if len(p.pending) > maxStreamEventBytes {
+		p.pending = nil
+		p.skipLine = true
+		p.discardEvent()
+	}
+
+	return len(chunk), nil
+}
+
+// BytesRead returns the number of stream bytes fed into the parser
+func (p *StreamUsageParser) BytesRead() int {
+	return p.bytesRead
+}
+
+// Result flushes any buffered event and returns the accumulated token usage
+func (p *StreamUsageParser) Result() (*TokenUsage, error) {
+	if len(p.pending) > 0 {
+		p.processLine(strings.TrimSuffix(string(p.pending), "\r"))
+		p.pending = nil
+	}
+	p.dispatchEvent()
+
+	if p.oversized {
+		return nil, ErrStreamEventTooLarge
+	}
+
+	usage := p.usage.tokenUsage()
+	if !p.sawUsage || (usage.InputTokens == 0 && usage.OutputTokens == 0) {
+		return nil, fmt.Errorf("no token usage found in stream")
+	}
+
+	usage.ModelName = p.modelName
+	if usage.ModelName == "" || usage.ModelName == "unknown" {
+		if p.streamModel != "" {
+			usage.ModelName = p.streamModel
+		}
+	}
+
+	usage.Cost = p.tokenParser.calculateCost(usage.InputTokens, usage.OutputTokens, usage.ModelName)
+
+	return &usage, nil
+}
+
+// processLine handles a single SSE line; a blank line terminates the current event
+func (p *StreamUsageParser) processLine(line string) {
+	if p.skipEvent {
+		p.skipEvent = line != ""
+		return
+	}
+
+	switch {
+	case line == "":
+		p.dispatchEvent()
+	case strings.HasPrefix(line, ":"):
+		// SSE comment / keep-alive
+	case strings.HasPrefix(line, "data:"):
+		data := strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")
+		p.eventBytes += len(data)
+		if p.eventBytes > maxStreamEventBytes {
+			p.discardEvent()
+			return
+		}
+		p.dataLines = append(p.dataLines, data)
+	case strings.HasPrefix(line, "event:"):
+		p.eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+	}
+}
+
+// dispatchEvent parses the data of the current event and merges any usage it carries
+func (p *StreamUsageParser) dispatchEvent() {
+	if len(p.dataLines) == 0 {
+		p.eventType = ""
+		return
+	}
+	data := strings.TrimSpace(strings.Join(p.dataLines, "\n"))
+	eventType := p.eventType
+	p.dataLines = p.dataLines[:0]
+	p.eventBytes = 0
+	p.eventType = ""
+
+	if data == "" || data == "[DONE]" {
+		return
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return
+	}
+	if eventType == "" {
+		eventType, _ = event["type"].(string)
+	}
+
+	switch eventType {
+	case "message_start":
+		// Anthropic: input tokens are reported once at the start of the message
+		if message, ok := event["message"].(map[string]interface{}); ok {
+			p.captureModel(message["model"])
+			p.mergeUsage(message["usage"])
+		}
+		return
+	case "message_delta":
+		// Anthropic: cumulative output tokens
+		p.mergeUsage(event["usage"])
+		return
+	}
+
+	// OpenAI chunk (usage is null except in the final include_usage chunk)
+	p.captureModel(event["model"])
+	p.mergeUsage(event["usage"])
+
+	// Gemini GenerateContentResponse chunk
+	p.captureModel(event["modelVersion"])
+	p.mergeUsage(event["usageMetadata"])
+}
+
+// discardEvent drops the current event after a line or the event itself exceeded
+// maxStreamEventBytes; the rest of the event is skipped and the usage is reported as unparsed
+func (p *StreamUsageParser) discardEvent() {
+	p.dataLines = nil
+	p.eventBytes = 0
+	p.eventType = ""
+	p.skipEvent = true
+	p.oversized = true
+}
+
+// mergeUsage merges the counts of an OpenAI or Anthropic usage object or a Gemini usageMetadata
+// object; later events carry cumulative values and overwrite earlier ones
+func (p *StreamUsageParser) mergeUsage(raw interface{}) {
+	if p.usage.collect("", raw) {
+		p.sawUsage = true
+	}
+}
+
+// captureModel remembers the first model name reported by the stream
+func (p *StreamUsageParser) captureModel(raw interface{}) {
+	if model, ok := raw.(string); ok && model != "" && p.streamModel == "" {
+		p.streamModel = model
+	}
+}
@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamUsageParser(t *testing.T) {
+	tests := []struct {
+		name         string
+		fixture      string
+		requestModel string
+		wantErr      bool
+		wantInput    int
+		wantOutput   int
+		wantTotal    int
+		wantModel    string
+	}{
+		{
+			name:         "openai include_usage final chunk",
+			fixture:      "openai_include_usage.sse",
+			requestModel: "gpt-4o-mini",
+			wantInput:    19,
+			wantOutput:   10,
+			wantTotal:    29,
+			wantModel:    "gpt-4o-mini",
+		},
+		{
+			name:         "openai stream without include_usage",
+			fixture:      "openai_without_usage.sse",
+			requestModel: "gpt-3.5-turbo",
+			wantErr:      true,
+		},
+		{
+			name:         "anthropic message_start and message_delta",
+			fixture:      "anthropic_messages.sse",
+			requestModel: "unknown",
+			wantInput:    25,
+			wantOutput:   15,
+			wantTotal:    40,
+			wantModel:    "claude-3-haiku-20240307",
+		},
+		{
+			name:         "anthropic with CRLF line endings and comments",
+			fixture:      "anthropic_crlf.sse",
+			requestModel: "claude-3-sonnet",
+			wantInput:    120,
+			wantOutput:   256,
+			wantTotal:    376,
+			wantModel:    "claude-3-sonnet",
+		},
+		{
+			name:         "gemini usageMetadata",
+			fixture:      "gemini_stream.sse",
+			requestModel: "",
+			wantInput:    8,
+			wantOutput:   12,
+			wantTotal:    20,
+			wantModel:    "gemini-1.5-flash-002",
+		},
+	}
+
+	// 同一份流按不同大小切块输入，结果必须一致
+	chunkSizes := []int{1, 7, 64, 1 << 20}
+
+	for _, tt := range tests {
+		stream, err := os.ReadFile(filepath.Join("testdata", tt.fixture))
+		if err != nil {
+			t.Fatalf("failed to read fixture %s: %v", tt.fixture, err)
+		}
+
+		for _, size := range chunkSizes {
+			tp := NewTokenParser()
+			parser := tp.NewStreamUsageParser(tt.requestModel)
+			for start := 0; start < len(stream); start += size {
+				end := start + size
+				if end > len(stream) {
+					end = len(stream)
+				}
+				if n, err := parser.Write(stream[start:end]); err != nil || n != end-start {
+					t.Fatalf("%s (chunk %d): Write() = %d, %v", tt.name, size, n, err)
+				}
+			}
+
+			usage, err := parser.Result()
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("%s (chunk %d): expected error, got usage %+v", tt.name, size, usage)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s (chunk %d): unexpected error: %v", tt.name, size, err)
+			}
+
+			if usage.InputTokens != tt.wantInput || usage.OutputTokens != tt.wantOutput || usage.TotalTokens != tt.wantTotal {
+				t.Errorf("%s (chunk %d): tokens = %d/%d/%d, want %d/%d/%d", tt.name, size,
+					usage.InputTokens, usage.OutputTokens, usage.TotalTokens,
+					tt.wantInput, tt.wantOutput, tt.wantTotal)
+			}
+			if usage.ModelName != tt.wantModel {
+				t.Errorf("%s (chunk %d): model = %q, want %q", tt.name, size, usage.ModelName, tt.wantModel)
+			}
+			if wantCost := tp.calculateCost(tt.wantInput, tt.wantOutput, tt.wantModel); usage.Cost != wantCost {
+				t.Errorf("%s (chunk %d): cost = %v, want %v", tt.name, size, usage.Cost, wantCost)
+			}
+			if parser.BytesRead() != len(stream) {
+				t.Errorf("%s (chunk %d): BytesRead() = %d, want %d", tt.name, size, parser.BytesRead(), len(stream))
+			}
+		}
+	}
+}
+
+func TestParseStreamTokenUsageMatchesIncrementalParser(t *testing.T) {
+	stream, err := os.ReadFile(filepath.Join("testdata", "anthropic_messages.sse"))
+	if err != nil {
+		t.Fatalf("failed to read fixture: %v", err)
+	}
+
+	tp := NewTokenParser()
+	usage, err := tp.ParseStreamTokenUsage(stream, "claude-3-haiku")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage.InputTokens != 25 || usage.OutputTokens != 15 || usage.ModelName != "claude-3-haiku" {
+		t.Errorf("unexpected usage: %+v", usage)
+	}
+	if want := tp.calculateCost(25, 15, "claude-3-haiku"); usage.Cost != want {
+		t.Errorf("cost = %v, want %v", usage.Cost, want)
+	}
+}
+
+func TestStreamUsageParserDiscardsOversizedEvents(t *testing.T) {
+	usageEvent := "data: {\"usage\":{\"prompt_tokens\":3,\"completion_tokens\":2,\"total_tokens\":5}}\n\n"
+	line := strings.Repeat("x", 64*1024)
+	streams := map[string]string{
+		// 没有换行的超长行
+		"oversized line": "data: " + strings.Repeat(line, 48) + "\n\n" + usageEvent,
+		// 每行都不长，但整个事件超过上限
+		"oversized event": strings.Repeat("data: "+line+"\n", 48) + "\n" + usageEvent,
+	}
+
+	for name, stream := range streams {
+		parser := NewTokenParser().NewStreamUsageParser("gpt-4o-mini")
+		for start := 0; start < len(stream); start += 32 * 1024 {
+			end := start + 32*1024
+			if end > len(stream) {
+				end = len(stream)
+			}
+			parser.Write([]byte(stream[start:end]))
+			if len(parser.pending) > maxStreamEventBytes || parser.eventBytes > maxStreamEventBytes {
+				t.Fatalf("%s: buffered %d line bytes and %d event bytes, want at most %d",
+					name, len(parser.pending), parser.eventBytes, maxStreamEventBytes)
+			}
+		}
+
+		if usage, err := parser.Result(); !errors.Is(err, ErrStreamEventTooLarge) {
+			t.Errorf("%s: Result() = %+v, %v, want ErrStreamEventTooLarge", name, usage, err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxTokensPerMinute 每分钟 token 预算可设置的上限
+const MaxTokensPerMinute = 1000000000
+
+// maxModelTokenBudgets 按模型设置的 token 预算条目上限
+const maxModelTokenBudgets = 100
+
+// NormalizeTokenBudget 校验每分钟 token 预算并规范化按模型设置的预算（去除模型名首尾空格），0 表示不限
+func NormalizeTokenBudget(tokensPerMinute int, modelTokensPerMinute map[string]int) (map[string]int, error) {
+	if tokensPerMinute < 0 || tokensPerMinute > MaxTokensPerMinute {
+		return nil, fmt.Errorf("tokens_per_minute must be between 0 and %d", MaxTokensPerMinute)
+	}
+	if len(modelTokensPerMinute) > maxModelTokenBudgets {
+		return nil, fmt.Errorf("at most %d model token budgets are allowed", maxModelTokenBudgets)
+	}
+
+	normalized := make(map[string]int, len(modelTokensPerMinute))
+	for modelID, limit := range modelTokensPerMinute {
+		modelID = strings.TrimSpace(modelID)
+		if modelID == "" {
+			return nil, fmt.Errorf("model_tokens_per_minute contains an empty model")
+		}
+		if limit <= 0 || limit > MaxTokensPerMinute {
+			return nil, fmt.Errorf("tokens per minute for model %q must be between 1 and %d", modelID, MaxTokensPerMinute)
+		}
+		if _, exists := normalized[modelID]; exists {
+			return nil, fmt.Errorf("model %q has more than one token budget", modelID)
+		}
+		normalized[modelID] = limit
+	}
+	if len(normalized) == 0 {
+		return nil, nil
+	}
+	return normalized, nil
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeTokenBudget(t *testing.T) {
+	models, err := NormalizeTokenBudget(100000, map[string]int{" gpt-4o ": 50000})
+	if err != nil {
+		t.Fatalf("NormalizeTokenBudget() error = %v", err)
+	}
+	if want := map[string]int{"gpt-4o": 50000}; !reflect.DeepEqual(models, want) {
+		t.Errorf("NormalizeTokenBudget() models = %v, want %v", models, want)
+	}
+
+	if models, err := NormalizeTokenBudget(0, map[string]int{}); err != nil || models != nil {
+		t.Errorf("NormalizeTokenBudget() with no budgets = %v, %v, want nil, nil", models, err)
+	}
+
+	invalid := []struct {
+		tpm    int
+		models map[string]int
+	}{
+		{tpm: -1},
+		{tpm: MaxTokensPerMinute + 1},
+		{models: map[string]int{"": 1000}},
+		{models: map[string]int{"gpt-4o": 0}},
+		{models: map[string]int{"gpt-4o": 1000, " gpt-4o": 2000}},
+	}
+	for _, tt := range invalid {
+		if _, err := NormalizeTokenBudget(tt.tpm, tt.models); err == nil {
+			t.Errorf("NormalizeTokenBudget(%d, %v) expected error", tt.tpm, tt.models)
+		}
+	}
+}
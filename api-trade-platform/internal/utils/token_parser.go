@@ -8,13 +8,118 @@ import (
 	"strings"
 )
 
-// TokenUsage represents token usage information
+// TokenUsage represents token usage information.
+// InputTokens and OutputTokens are totals; the category counts below are subsets of them that
+// vendors bill at different prices (e.g. cached input is cheaper, reasoning is billed as output).
 type TokenUsage struct {
-	InputTokens  int     `json:"input_tokens"`
-	OutputTokens int     `json:"output_tokens"`
-	TotalTokens  int     `json:"total_tokens"`
-	ModelName    string  `json:"model_name"`
-	Cost         float64 `json:"cost"`
+	InputTokens       int     `json:"input_tokens"`                  // all input tokens, including cached, cache-write, audio and image input
+	OutputTokens      int     `json:"output_tokens"`                 // all output tokens, including reasoning, audio and image output
+	TotalTokens       int     `json:"total_tokens"`
+	CachedInputTokens int     `json:"cached_input_tokens,omitempty"` // input tokens read from the prompt cache
+	CacheWriteTokens  int     `json:"cache_write_tokens,omitempty"`  // input tokens written to the prompt cache (Anthropic)
+	ReasoningTokens   int     `json:"reasoning_tokens,omitempty"`    // reasoning / thinking output tokens
+	AudioInputTokens  int     `json:"audio_input_tokens,omitempty"`
+	AudioOutputTokens int     `json:"audio_output_tokens,omitempty"`
+	ImageInputTokens  int     `json:"image_input_tokens,omitempty"`
+	ImageOutputTokens int     `json:"image_output_tokens,omitempty"`
+	ModelName         string  `json:"model_name"`
+	Cost              float64 `json:"cost"`
+}
+
+// usageFields holds raw vendor usage counts keyed by field name before they are normalized into
+// TokenUsage. Nested detail objects are flattened as "parent.child" and Gemini modality lists
+// ([{"modality": "AUDIO", "tokenCount": 5}]) as "parent.AUDIO".
+type usageFields map[string]int
+
+// collect merges the non-zero counts of a usage object into f; later values overwrite earlier
+// ones because streamed usage reports are cumulative. It reports whether any count was found.
+func (f usageFields) collect(prefix string, raw interface{}) bool {
+	data, ok := raw.(map[string]interface{})
+	if !ok {
+		return false
+	}
+
+	found := false
+	for name, value := range data {
+		switch v := value.(type) {
+		case float64:
+			if v > 0 {
+				f[prefix+name] = int(v)
+				found = true
+			}
+		case map[string]interface{}:
+			if f.collect(prefix+name+".", v) {
+				found = true
+			}
+		case []interface{}:
+			for _, item := range v {
+				detail, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				modality, _ := detail["modality"].(string)
+				count, _ := detail["tokenCount"].(float64)
+				if modality != "" && count > 0 {
+					f[prefix+name+"."+strings.ToUpper(modality)] = int(count)
+					found = true
+				}
+			}
+		}
+	}
+	return found
+}
+
+// first returns the first non-zero count among the given field names
+func (f usageFields) first(names ...string) int {
+	for _, name := range names {
+		if value := f[name]; value > 0 {
+			return value
+		}
+	}
+	return 0
+}
+
+// tokenUsage normalizes OpenAI (Chat Completions and Responses), Anthropic and Gemini usage fields.
+//   - OpenAI: prompt/input tokens include cached, audio and image tokens, which are broken out in
+//     *_tokens_details; completion/output tokens include reasoning and audio tokens.
+//   - Anthropic: input_tokens excludes cache reads and writes, so they are added to the input total.
+//   - Gemini: promptTokenCount includes cachedContentTokenCount; thoughtsTokenCount is billed as
+//     output but not included in candidatesTokenCount.
+func (f usageFields) tokenUsage() TokenUsage {
+	var usage TokenUsage
+
+	if f.first("promptTokenCount", "candidatesTokenCount", "totalTokenCount") > 0 {
+		usage.InputTokens = f["promptTokenCount"]
+		usage.CachedInputTokens = f["cachedContentTokenCount"]
+		usage.ReasoningTokens = f["thoughtsTokenCount"]
+		usage.OutputTokens = f["candidatesTokenCount"] + usage.ReasoningTokens
+		usage.AudioInputTokens = f["promptTokensDetails.AUDIO"]
+		usage.ImageInputTokens = f["promptTokensDetails.IMAGE"]
+		usage.AudioOutputTokens = f["candidatesTokensDetails.AUDIO"]
+		usage.ImageOutputTokens = f["candidatesTokensDetails.IMAGE"]
+		usage.TotalTokens = f["totalTokenCount"]
+	} else {
+		usage.CacheWriteTokens = f["cache_creation_input_tokens"]
+		usage.CachedInputTokens = f.first("cache_read_input_tokens", "prompt_tokens_details.cached_tokens", "input_tokens_details.cached_tokens")
+		if promptTokens, ok := f["prompt_tokens"]; ok {
+			usage.InputTokens = promptTokens
+		} else {
+			usage.InputTokens = f["input_tokens"] + f["cache_creation_input_tokens"] + f["cache_read_input_tokens"]
+		}
+		usage.OutputTokens = f.first("completion_tokens", "output_tokens")
+		usage.ReasoningTokens = f.first("completion_tokens_details.reasoning_tokens", "output_tokens_details.reasoning_tokens")
+		usage.AudioInputTokens = f.first("prompt_tokens_details.audio_tokens", "input_tokens_details.audio_tokens")
+		usage.ImageInputTokens = f.first("prompt_tokens_details.image_tokens", "input_tokens_details.image_tokens")
+		usage.AudioOutputTokens = f.first("completion_tokens_details.audio_tokens", "output_tokens_details.audio_tokens")
+		usage.ImageOutputTokens = f.first("completion_tokens_details.image_tokens", "output_tokens_details.image_tokens")
+		usage.TotalTokens = f["total_tokens"]
+	}
+
+	// total_tokens may be missing or reported before the final output count
+	if usage.TotalTokens < usage.InputTokens+usage.OutputTokens {
+		usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+	}
+	return usage
 }
 
 // TokenParser handles parsing token usage from different AI API responses
@@ -79,6 +184,14 @@ func (tp *TokenParser) ParseTokenUsage(responseBody []byte, modelName string) (*
 	return tp.parseWithRegex(responseBody, modelName)
 }
 
+// ParseStreamTokenUsage extracts token usage from a complete SSE (text/event-stream) body.
+// It is a convenience wrapper around StreamUsageParser for callers that already hold the stream.
+func (tp *TokenParser) ParseStreamTokenUsage(streamBody []byte, modelName string) (*TokenUsage, error) {
+	parser := tp.NewStreamUsageParser(modelName)
+	parser.Write(streamBody)
+	return parser.Result()
+}
+
 // parseJSONResponse parses token usage from JSON response
 func (tp *TokenParser) parseJSONResponse(responseBody []byte, modelName string) (*TokenUsage, error) {
 	var response map[string]interface{}
@@ -86,35 +199,14 @@ func (tp *TokenParser) parseJSONResponse(responseBody []byte, modelName string)
 		return nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
 
-	usage := &TokenUsage{ModelName: modelName}
+	// OpenAI and Anthropic report "usage", Gemini reports "usageMetadata"
+	fields := usageFields{}
+	fields.collect("", response["usage"])
+	fields.collect("", response["usageMetadata"])
 
-	// OpenAI format
-	if usageData, ok := response["usage"].(map[string]interface{}); ok {
-		if promptTokens, ok := usageData["prompt_tokens"].(float64); ok {
-			usage.InputTokens = int(promptTokens)
-		}
-		if completionTokens, ok := usageData["completion_tokens"].(float64); ok {
-			usage.OutputTokens = int(completionTokens)
-		}
-		if totalTokens, ok := usageData["total_tokens"].(float64); ok {
-			usage.TotalTokens = int(totalTokens)
-		}
-	}
-
-	// Anthropic Claude format
-	if usageData, ok := response["usage"].(map[string]interface{}); ok {
-		if inputTokens, ok := usageData["input_tokens"].(float64); ok {
-			usage.InputTokens = int(inputTokens)
-		}
-		if outputTokens, ok := usageData["output_tokens"].(float64); ok {
-			usage.OutputTokens = int(outputTokens)
-		}
-	}
-
-	// Calculate total if not provided
-	if usage.TotalTokens == 0 {
-		usage.TotalTokens = usage.InputTokens + usage.OutputTokens
-	}
+	normalized := fields.tokenUsage()
+	usage := &normalized
+	usage.ModelName = modelName
 
 	// Calculate cost
 	usage.Cost = tp.calculateCost(usage.InputTokens, usage.OutputTokens, modelName)
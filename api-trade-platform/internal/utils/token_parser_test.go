@@ -0,0 +1,47 @@
+package utils
+
+import "testing"
+
+func TestParseTokenUsageCategories(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want TokenUsage
+	}{
+		{
+			name: "openai cached and reasoning details",
+			body: `{"model":"o3-mini","usage":{"prompt_tokens":1200,"completion_tokens":300,"total_tokens":1500,` +
+				`"prompt_tokens_details":{"cached_tokens":1024,"audio_tokens":0},` +
+				`"completion_tokens_details":{"reasoning_tokens":256}}}`,
+			want: TokenUsage{InputTokens: 1200, OutputTokens: 300, TotalTokens: 1500, CachedInputTokens: 1024, ReasoningTokens: 256},
+		},
+		{
+			name: "anthropic input includes cache reads and writes",
+			body: `{"model":"claude","usage":{"input_tokens":50,"output_tokens":40,` +
+				`"cache_creation_input_tokens":200,"cache_read_input_tokens":1000}}`,
+			want: TokenUsage{InputTokens: 1250, OutputTokens: 40, TotalTokens: 1290, CachedInputTokens: 1000, CacheWriteTokens: 200},
+		},
+		{
+			name: "gemini usage metadata with modalities",
+			body: `{"modelVersion":"gemini-2.5-flash","usageMetadata":{"promptTokenCount":900,"candidatesTokenCount":100,` +
+				`"thoughtsTokenCount":50,"totalTokenCount":1050,"cachedContentTokenCount":300,` +
+				`"promptTokensDetails":[{"modality":"TEXT","tokenCount":600},{"modality":"IMAGE","tokenCount":300}]}}`,
+			want: TokenUsage{InputTokens: 900, OutputTokens: 150, TotalTokens: 1050, CachedInputTokens: 300, ReasoningTokens: 50, ImageInputTokens: 300},
+		},
+	}
+
+	parser := NewTokenParser()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parser.ParseTokenUsage([]byte(tt.body), "unknown")
+			if err != nil {
+				t.Fatalf("ParseTokenUsage returned error: %v", err)
+			}
+			got.ModelName = ""
+			got.Cost = 0
+			if *got != tt.want {
+				t.Errorf("usage = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}